@@ -0,0 +1,653 @@
+// Package backtest evaluates trading strategies offline, replaying
+// historical candles or trades of a market.
+//
+// A Backtest simulates an account in a market. It implements conn.Trading,
+// so a strategy places and follows its orders with the same calls it would
+// make to a conn.Client. Orders are filled against the replayed data:
+//
+//   - an order crossing the current ask or bid when created is executed at
+//     once as a taker, at the close price moved by the slippage, never
+//     beyond its own price.
+//   - the rest of the orders are executed as makers at their own price when
+//     a later candle reaches it, the ask candle for buys and the bid candle
+//     for sells, or when a later trade is made at that price or better. A
+//     candle executes the whole order, a trade executes up to its amount.
+//
+// Fees are charged in the received currency, as CryptoMarket does.
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+	"github.com/cryptomkt/cryptomkt-go/requests"
+)
+
+// timeLayout is the layout of the dates of the simulated orders, the same
+// used by CryptoMarket.
+const timeLayout = "2006-01-02T15:04:05.999999"
+
+// ErrNotEnoughBalance is given when the balance does not cover an order.
+var ErrNotEnoughBalance = errors.New("error from the server side: not_enough_balance")
+
+// A Strategy is fed the replayed market data, and places its orders
+// through the given conn.Trading. OnTicker is called before each candle or
+// trade, with a ticker at its prices. OnOrderUpdate is called each time one
+// of its orders is executed, in whole or in part. An error stops the
+// backtest.
+type Strategy interface {
+	OnTicker(trading conn.Trading, ticker conn.Ticker) error
+	OnCandle(trading conn.Trading, bar Bar) error
+	OnTrade(trading conn.Trading, trade conn.TradeData) error
+	OnOrderUpdate(trading conn.Trading, order conn.Order) error
+}
+
+type wallet struct {
+	available float64
+	balance   float64
+}
+
+// simOrder is a simulated order with its amounts as numbers.
+type simOrder struct {
+	order     conn.Order
+	seq       int
+	price     float64
+	remaining float64
+	executed  float64
+	// cost is the amount of the quote currency paid or received.
+	cost float64
+}
+
+// A Backtest simulates an account in a market, filling its orders against
+// historical data.
+type Backtest struct {
+	market   string
+	base     string
+	quote    string
+	initial  map[string]float64
+	wallets  map[string]*wallet
+	orders   map[string]*simOrder
+	seq      int
+	makerFee float64
+	takerFee float64
+	slippage float64
+
+	now           time.Time
+	bid           float64
+	ask           float64
+	started       bool
+	updates       []conn.Order
+	fills         []Fill
+	equity        []EquityPoint
+	initialEquity float64
+}
+
+// New builds a backtest of a market, as "ETHCLP", starting with the given
+// balances per currency, as {"CLP": 100000}.
+func New(market string, balances map[string]float64) *Backtest {
+	bt := &Backtest{
+		market:  market,
+		initial: make(map[string]float64),
+	}
+	if len(market) > 3 {
+		bt.base, bt.quote = market[:len(market)-3], market[len(market)-3:]
+	}
+	for currency, amount := range balances {
+		bt.initial[currency] = amount
+	}
+	bt.reset()
+	return bt
+}
+
+// SetFees sets the maker and taker fees, as fractions like Account.Rate,
+// e.g. 0.0039.
+func (bt *Backtest) SetFees(maker, taker float64) {
+	bt.makerFee = maker
+	bt.takerFee = taker
+}
+
+// SetFeesFromRate sets the fees of an account, as given by GetAccount.
+func (bt *Backtest) SetFeesFromRate(rate conn.Rate) error {
+	maker, taker, err := rate.Fees()
+	if err != nil {
+		return err
+	}
+	bt.SetFees(maker, taker)
+	return nil
+}
+
+// SetSlippage sets the fraction of the price lost by the taker executions,
+// e.g. 0.001 buys at 0.1% over the ask and sells at 0.1% under the bid.
+func (bt *Backtest) SetSlippage(slippage float64) {
+	bt.slippage = slippage
+}
+
+func (bt *Backtest) reset() {
+	bt.wallets = make(map[string]*wallet)
+	for currency, amount := range bt.initial {
+		bt.wallets[currency] = &wallet{available: amount, balance: amount}
+	}
+	bt.orders = make(map[string]*simOrder)
+	bt.seq = 0
+	bt.now = time.Time{}
+	bt.bid, bt.ask = 0, 0
+	bt.started = false
+	bt.updates = nil
+	bt.fills = nil
+	bt.equity = nil
+}
+
+// RunCandles replays the bars, the oldest first, and reports the results.
+// Each run starts again from the initial balances.
+func (bt *Backtest) RunCandles(strategy Strategy, bars []Bar) (*Report, error) {
+	if bt.base == "" {
+		return nil, fmt.Errorf("invalid market %s", bt.market)
+	}
+	bt.reset()
+	for _, bar := range bars {
+		bt.now = bar.Time
+		bt.matchBar(bar)
+		bt.bid, bt.ask = bar.Bid.Close, bar.Ask.Close
+		bt.start()
+		if err := bt.flush(strategy); err != nil {
+			return nil, err
+		}
+		if err := bt.tick(strategy, bar.Ask.Close, bar.Bid.Close, bar.Mid()); err != nil {
+			return nil, err
+		}
+		if err := strategy.OnCandle(bt, bar); err != nil {
+			return nil, err
+		}
+		if err := bt.flush(strategy); err != nil {
+			return nil, err
+		}
+		bt.record(bar.Mid())
+	}
+	return bt.report(), nil
+}
+
+// RunTrades replays the trades of the market, sorting them from the oldest,
+// and reports the results. Each run starts again from the initial balances.
+func (bt *Backtest) RunTrades(strategy Strategy, trades []conn.TradeData) (*Report, error) {
+	if bt.base == "" {
+		return nil, fmt.Errorf("invalid market %s", bt.market)
+	}
+	sorted := make([]conn.TradeData, 0, len(trades))
+	for _, trade := range trades {
+		if trade.Market == "" || trade.Market == bt.market {
+			sorted = append(sorted, trade)
+		}
+	}
+	if err := SortTrades(sorted); err != nil {
+		return nil, err
+	}
+	bt.reset()
+	for _, trade := range sorted {
+		t, _ := execution.ParseTime(trade.Timestamp)
+		price, err := strconv.ParseFloat(trade.Price, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trade price %q: %s", trade.Price, err)
+		}
+		amount, err := strconv.ParseFloat(trade.Amount, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trade amount %q: %s", trade.Amount, err)
+		}
+		bt.now = t
+		bt.matchTrade(price, amount)
+		bt.bid, bt.ask = price, price
+		bt.start()
+		if err := bt.flush(strategy); err != nil {
+			return nil, err
+		}
+		if err := bt.tick(strategy, price, price, price); err != nil {
+			return nil, err
+		}
+		if err := strategy.OnTrade(bt, trade); err != nil {
+			return nil, err
+		}
+		if err := bt.flush(strategy); err != nil {
+			return nil, err
+		}
+		bt.record(price)
+	}
+	return bt.report(), nil
+}
+
+// start values the initial balances at the first prices replayed.
+func (bt *Backtest) start() {
+	if bt.started {
+		return
+	}
+	bt.started = true
+	bt.initialEquity = bt.value((bt.bid + bt.ask) / 2)
+}
+
+// tick gives the strategy a ticker at the current prices, and the order
+// updates it causes.
+func (bt *Backtest) tick(strategy Strategy, ask, bid, last float64) error {
+	ticker := conn.Ticker{
+		Ask:       formatFloat(ask),
+		Bid:       formatFloat(bid),
+		LastPrice: formatFloat(last),
+		Market:    bt.market,
+		Timestamp: bt.now.Format(timeLayout),
+	}
+	if err := strategy.OnTicker(bt, ticker); err != nil {
+		return err
+	}
+	return bt.flush(strategy)
+}
+
+// flush delivers the pending order updates to the strategy, including the
+// ones caused by the orders created while handling them.
+func (bt *Backtest) flush(strategy Strategy) error {
+	for len(bt.updates) > 0 {
+		update := bt.updates[0]
+		bt.updates = bt.updates[1:]
+		if err := strategy.OnOrderUpdate(bt, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// active returns the active orders by price-time priority, the buys and
+// then the sells.
+func (bt *Backtest) active() []*simOrder {
+	var active []*simOrder
+	for _, o := range bt.orders {
+		if o.order.Status == "active" {
+			active = append(active, o)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		a, b := active[i], active[j]
+		if a.order.Type != b.order.Type {
+			return a.order.Type == "buy"
+		}
+		if a.price != b.price {
+			if a.order.Type == "buy" {
+				return a.price > b.price
+			}
+			return a.price < b.price
+		}
+		return a.seq < b.seq
+	})
+	return active
+}
+
+// matchBar executes the active orders whose price was reached by the bar.
+func (bt *Backtest) matchBar(bar Bar) {
+	for _, o := range bt.active() {
+		if (o.order.Type == "buy" && bar.Ask.Low <= o.price) ||
+			(o.order.Type == "sell" && bar.Bid.High >= o.price) {
+			bt.fill(o, o.remaining, o.price, true)
+		}
+	}
+}
+
+// matchTrade executes the active orders whose price was reached by a trade,
+// up to the amount traded.
+func (bt *Backtest) matchTrade(price, amount float64) {
+	left := map[string]float64{"buy": amount, "sell": amount}
+	for _, o := range bt.active() {
+		if (o.order.Type == "buy" && price > o.price) ||
+			(o.order.Type == "sell" && price < o.price) {
+			continue
+		}
+		executed := math.Min(o.remaining, left[o.order.Type])
+		if executed <= 0 {
+			continue
+		}
+		left[o.order.Type] -= executed
+		bt.fill(o, executed, o.price, true)
+	}
+}
+
+func (bt *Backtest) wallet(currency string) *wallet {
+	w, ok := bt.wallets[currency]
+	if !ok {
+		w = &wallet{}
+		bt.wallets[currency] = w
+	}
+	return w
+}
+
+// fill executes an amount of an order at a price, moving the balances and
+// logging the execution.
+func (bt *Backtest) fill(o *simOrder, amount, price float64, maker bool) {
+	fee := bt.takerFee
+	if maker {
+		fee = bt.makerFee
+	}
+	var feeAmount float64
+	var feeCurrency string
+	if o.order.Type == "buy" {
+		quote := bt.wallet(bt.quote)
+		quote.balance -= amount * price
+		// the order locked its own price, the difference is released
+		quote.available += amount * (o.price - price)
+		feeAmount, feeCurrency = amount*fee, bt.base
+		base := bt.wallet(bt.base)
+		base.available += amount - feeAmount
+		base.balance += amount - feeAmount
+	} else {
+		bt.wallet(bt.base).balance -= amount
+		feeAmount, feeCurrency = amount*price*fee, bt.quote
+		quote := bt.wallet(bt.quote)
+		quote.available += amount*price - feeAmount
+		quote.balance += amount*price - feeAmount
+	}
+	o.remaining = round8(o.remaining - amount)
+	o.executed = round8(o.executed + amount)
+	o.cost += amount * price
+
+	avgPrice := o.cost / o.executed
+	now := bt.now.Format(timeLayout)
+	o.order.Amount.Remaining = formatFloat(o.remaining)
+	o.order.Amount.Executed = formatFloat(o.executed)
+	o.order.ExecutionPrice = formatFloat(avgPrice)
+	o.order.AvgExecutionPrice = int(math.Round(avgPrice))
+	o.order.UpdatedAt = now
+	if o.remaining <= 0 {
+		o.order.Status = "executed"
+		o.order.ExecutedAt = now
+	}
+	bt.fills = append(bt.fills, Fill{
+		OrderId:     o.order.Id,
+		Time:        bt.now,
+		Type:        o.order.Type,
+		Price:       price,
+		Amount:      amount,
+		Fee:         feeAmount,
+		FeeCurrency: feeCurrency,
+		Maker:       maker,
+	})
+	update := o.order
+	update.SetClient(bt)
+	bt.updates = append(bt.updates, update)
+}
+
+// takerPrice is the price of a taker execution of the given type, at the
+// current prices moved by the slippage.
+func (bt *Backtest) takerPrice(orderType string) float64 {
+	if orderType == "buy" {
+		return bt.ask * (1 + bt.slippage)
+	}
+	return bt.bid * (1 - bt.slippage)
+}
+
+// value is the value of the account in the quote currency, valuing the
+// base currency at the given price.
+func (bt *Backtest) value(price float64) float64 {
+	return bt.wallet(bt.quote).balance + bt.wallet(bt.base).balance*price
+}
+
+func (bt *Backtest) record(price float64) {
+	bt.equity = append(bt.equity, EquityPoint{Time: bt.now, Equity: bt.value(price)})
+}
+
+// Balances returns the balance of each currency.
+func (bt *Backtest) Balances() map[string]float64 {
+	balances := make(map[string]float64)
+	for currency, w := range bt.wallets {
+		balances[currency] = round8(w.balance)
+	}
+	return balances
+}
+
+// argsMap applies the arguments, checking the required ones are given.
+func argsMap(caller string, required []string, arguments ...args.Argument) (map[string]string, error) {
+	req := requests.NewReq(required)
+	for _, argument := range arguments {
+		if err := argument(req); err != nil {
+			return nil, fmt.Errorf("Error in %s: argument error: %s", caller, err)
+		}
+	}
+	if err := req.AssertRequired(); err != nil {
+		return nil, fmt.Errorf("Error in %s: required arguments not meeted:%s", caller, err)
+	}
+	return req.GetArguments(), nil
+}
+
+func (bt *Backtest) checkMarket(market string) error {
+	if market != bt.market {
+		return fmt.Errorf("error from the server side: market %s is not simulated", market)
+	}
+	return nil
+}
+
+// CreateOrder creates a simulated order, executing at once the part that
+// crosses the current prices.
+func (bt *Backtest) CreateOrder(arguments ...args.Argument) (*conn.Order, error) {
+	argsMap, err := argsMap("CreateOrder", []string{"amount", "market", "price", "type"}, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	if err := bt.checkMarket(argsMap["market"]); err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseFloat(argsMap["amount"], 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("error from the server side: invalid_amount")
+	}
+	price, err := strconv.ParseFloat(argsMap["price"], 64)
+	if err != nil || price <= 0 {
+		return nil, errors.New("error from the server side: invalid_price")
+	}
+	orderType := argsMap["type"]
+	if orderType == "buy" {
+		err = bt.lock(bt.quote, amount*price)
+	} else {
+		err = bt.lock(bt.base, amount)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bt.seq++
+	now := bt.now.Format(timeLayout)
+	o := &simOrder{
+		order: conn.Order{
+			Id:     "B" + strconv.Itoa(bt.seq),
+			Status: "active",
+			Type:   orderType,
+			Price:  argsMap["price"],
+			Amount: conn.Amount{
+				Original:  argsMap["amount"],
+				Remaining: argsMap["amount"],
+				Executed:  "0",
+			},
+			Market:    bt.market,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		seq:       bt.seq,
+		price:     price,
+		remaining: amount,
+	}
+	bt.orders[o.order.Id] = o
+	if orderType == "buy" && bt.ask > 0 && price >= bt.ask {
+		bt.fill(o, amount, math.Min(price, bt.takerPrice("buy")), false)
+	}
+	if orderType == "sell" && bt.bid > 0 && price <= bt.bid {
+		bt.fill(o, amount, math.Max(price, bt.takerPrice("sell")), false)
+	}
+	return bt.copyOrder(o), nil
+}
+
+func (bt *Backtest) lock(currency string, amount float64) error {
+	w := bt.wallet(currency)
+	if w.available < amount-1e-9 {
+		return ErrNotEnoughBalance
+	}
+	w.available -= amount
+	return nil
+}
+
+func (bt *Backtest) copyOrder(o *simOrder) *conn.Order {
+	copied := o.order
+	copied.SetClient(bt)
+	return &copied
+}
+
+func (bt *Backtest) find(caller string, arguments ...args.Argument) (*simOrder, error) {
+	argsMap, err := argsMap(caller, []string{"id"}, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := bt.orders[argsMap["id"]]
+	if !ok {
+		return nil, errors.New("error from the server side: order_not_found")
+	}
+	return o, nil
+}
+
+// CancelOrder cancels an active simulated order, releasing its balance.
+func (bt *Backtest) CancelOrder(arguments ...args.Argument) (*conn.Order, error) {
+	o, err := bt.find("CancelOrder", arguments...)
+	if err != nil {
+		return nil, err
+	}
+	if o.order.Status != "active" {
+		return nil, errors.New("error from the server side: order_not_active")
+	}
+	if o.order.Type == "buy" {
+		bt.wallet(bt.quote).available += o.remaining * o.price
+	} else {
+		bt.wallet(bt.base).available += o.remaining
+	}
+	o.order.Status = "cancelled"
+	o.order.UpdatedAt = bt.now.Format(timeLayout)
+	return bt.copyOrder(o), nil
+}
+
+// GetOrderStatus gives the state of a simulated order.
+func (bt *Backtest) GetOrderStatus(arguments ...args.Argument) (*conn.Order, error) {
+	o, err := bt.find("GetOrderStatus", arguments...)
+	if err != nil {
+		return nil, err
+	}
+	return bt.copyOrder(o), nil
+}
+
+func (bt *Backtest) listOrders(caller string, arguments ...args.Argument) ([]conn.Order, error) {
+	argsMap, err := argsMap(caller, []string{"market"}, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	if err := bt.checkMarket(argsMap["market"]); err != nil {
+		return nil, err
+	}
+	var sorted []*simOrder
+	for _, o := range bt.orders {
+		if caller == "GetActiveOrders" && o.order.Status == "active" {
+			sorted = append(sorted, o)
+		}
+		// cancelled orders partially executed are executed orders too
+		if caller == "GetExecutedOrders" && o.order.Status != "active" && o.executed > 0 {
+			sorted = append(sorted, o)
+		}
+	}
+	// newest first, as given by CryptoMarket
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].seq > sorted[j].seq
+	})
+	orders := make([]conn.Order, len(sorted))
+	for i, o := range sorted {
+		orders[i] = *bt.copyOrder(o)
+	}
+	return orders, nil
+}
+
+// GetActiveOrders gives the active simulated orders, in a single page.
+func (bt *Backtest) GetActiveOrders(arguments ...args.Argument) (*conn.OrderList, error) {
+	orders, err := bt.listOrders("GetActiveOrders", arguments...)
+	if err != nil {
+		return nil, err
+	}
+	return &conn.OrderList{Data: orders}, nil
+}
+
+// GetActiveOrdersAllPages gives the active simulated orders.
+func (bt *Backtest) GetActiveOrdersAllPages(arguments ...args.Argument) ([]conn.Order, error) {
+	return bt.listOrders("GetActiveOrders", arguments...)
+}
+
+// GetExecutedOrders gives the executed simulated orders, in a single page.
+func (bt *Backtest) GetExecutedOrders(arguments ...args.Argument) (*conn.OrderList, error) {
+	orders, err := bt.listOrders("GetExecutedOrders", arguments...)
+	if err != nil {
+		return nil, err
+	}
+	return &conn.OrderList{Data: orders}, nil
+}
+
+// GetExecutedOrdersAllPages gives the executed simulated orders.
+func (bt *Backtest) GetExecutedOrdersAllPages(arguments ...args.Argument) ([]conn.Order, error) {
+	return bt.listOrders("GetExecutedOrders", arguments...)
+}
+
+// GetInstant estimates an instant order at the current prices, with the
+// slippage and without fees.
+func (bt *Backtest) GetInstant(arguments ...args.Argument) (*conn.Instant, error) {
+	argsMap, err := argsMap("GetInstant", []string{"market", "type", "amount"}, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	if err := bt.checkMarket(argsMap["market"]); err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseFloat(argsMap["amount"], 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("error from the server side: invalid_amount")
+	}
+	if bt.ask == 0 {
+		return nil, errors.New("no prices replayed yet")
+	}
+	price := bt.takerPrice(argsMap["type"])
+	if argsMap["type"] == "buy" {
+		return &conn.Instant{Obtained: amount, Required: amount * price}, nil
+	}
+	return &conn.Instant{Obtained: amount * price, Required: amount}, nil
+}
+
+// CreateInstant executes an instant order of the amount of the base
+// currency as a taker, at the current prices moved by the slippage. It is
+// kept as an executed order.
+func (bt *Backtest) CreateInstant(arguments ...args.Argument) error {
+	instant, err := bt.GetInstant(arguments...)
+	if err != nil {
+		return err
+	}
+	argsMap, _ := argsMap("CreateInstant", nil, arguments...)
+	orderType := argsMap["type"]
+	amount, price := instant.Obtained, instant.Required/instant.Obtained
+	if orderType == "sell" {
+		amount, price = instant.Required, instant.Obtained/instant.Required
+	}
+	_, err = bt.CreateOrder(
+		args.Market(bt.market),
+		args.Type(orderType),
+		args.Amount(formatFloat(amount)),
+		args.Price(formatFloat(price)),
+	)
+	return err
+}
+
+// Backtest implements conn.Trading.
+var _ conn.Trading = (*Backtest)(nil)
+
+func round8(val float64) float64 {
+	return math.Round(val*1e8) / 1e8
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(round8(val), 'f', -1, 64)
+}
@@ -0,0 +1,295 @@
+package backtest
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// funcStrategy is a strategy built from functions, nil ones do nothing.
+type funcStrategy struct {
+	onTicker      func(trading conn.Trading, ticker conn.Ticker) error
+	onCandle      func(trading conn.Trading, bar Bar) error
+	onTrade       func(trading conn.Trading, trade conn.TradeData) error
+	onOrderUpdate func(trading conn.Trading, order conn.Order) error
+}
+
+func (f *funcStrategy) OnTicker(trading conn.Trading, ticker conn.Ticker) error {
+	if f.onTicker == nil {
+		return nil
+	}
+	return f.onTicker(trading, ticker)
+}
+
+func (f *funcStrategy) OnCandle(trading conn.Trading, bar Bar) error {
+	if f.onCandle == nil {
+		return nil
+	}
+	return f.onCandle(trading, bar)
+}
+
+func (f *funcStrategy) OnTrade(trading conn.Trading, trade conn.TradeData) error {
+	if f.onTrade == nil {
+		return nil
+	}
+	return f.onTrade(trading, trade)
+}
+
+func (f *funcStrategy) OnOrderUpdate(trading conn.Trading, order conn.Order) error {
+	if f.onOrderUpdate == nil {
+		return nil
+	}
+	return f.onOrderUpdate(trading, order)
+}
+
+var start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func bar(hour int, ask, bid OHLC) Bar {
+	return Bar{Time: start.Add(time.Duration(hour) * time.Hour), Ask: ask, Bid: bid}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestRunCandles(t *testing.T) {
+	bars := []Bar{
+		bar(0, OHLC{101, 102, 100, 101}, OHLC{99, 100, 98, 99}),
+		bar(1, OHLC{101, 101, 94, 96}, OHLC{99, 95, 93, 94}),
+		bar(2, OHLC{96, 106, 104, 105}, OHLC{94, 111, 94, 104}),
+	}
+	var tickers []conn.Ticker
+	strategy := &funcStrategy{
+		onTicker: func(trading conn.Trading, ticker conn.Ticker) error {
+			tickers = append(tickers, ticker)
+			return nil
+		},
+		onCandle: func(trading conn.Trading, bar Bar) error {
+			var err error
+			switch bar.Time.Hour() {
+			case 0:
+				_, err = trading.CreateOrder(args.Market("ETHCLP"), args.Type("buy"), args.Amount("1"), args.Price("95"))
+			case 2:
+				// crosses the ask, executed at once
+				_, err = trading.CreateOrder(args.Market("ETHCLP"), args.Type("buy"), args.Amount("0.5"), args.Price("200"))
+			}
+			return err
+		},
+		onOrderUpdate: func(trading conn.Trading, order conn.Order) error {
+			if order.Type == "buy" && order.Price == "95" && order.Status == "executed" {
+				_, err := trading.CreateOrder(args.Market("ETHCLP"), args.Type("sell"), args.Amount("0.99"), args.Price("110"))
+				return err
+			}
+			return nil
+		},
+	}
+	bt := New("ETHCLP", map[string]float64{"CLP": 1000})
+	bt.SetFees(0.01, 0.02)
+	report, err := bt.RunCandles(strategy, bars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tickers) != 3 || tickers[0].Bid != "99" || tickers[0].Ask != "101" || tickers[0].LastPrice != "100" {
+		t.Errorf("expected a ticker per candle, got %v", tickers)
+	}
+	if len(report.Fills) != 3 {
+		t.Fatalf("expected 3 fills, got %v", report.Fills)
+	}
+	expected := []Fill{
+		{OrderId: "B1", Type: "buy", Price: 95, Amount: 1, Fee: 0.01, FeeCurrency: "ETH", Maker: true},
+		{OrderId: "B2", Type: "sell", Price: 110, Amount: 0.99, Fee: 1.089, FeeCurrency: "CLP", Maker: true},
+		{OrderId: "B3", Type: "buy", Price: 105, Amount: 0.5, Fee: 0.01, FeeCurrency: "ETH", Maker: false},
+	}
+	for i, fill := range report.Fills {
+		e := expected[i]
+		if fill.OrderId != e.OrderId || fill.Type != e.Type || fill.Price != e.Price ||
+			fill.Amount != e.Amount || !near(fill.Fee, e.Fee) || fill.FeeCurrency != e.FeeCurrency || fill.Maker != e.Maker {
+			t.Errorf("fill %d: expected %+v, got %+v", i, e, fill)
+		}
+	}
+	if !near(report.Balances["CLP"], 960.311) || !near(report.Balances["ETH"], 0.49) {
+		t.Errorf("unexpected balances %v", report.Balances)
+	}
+	if report.InitialEquity != 1000 || !near(report.FinalEquity, 1011.516) {
+		t.Errorf("unexpected equity %v to %v", report.InitialEquity, report.FinalEquity)
+	}
+	if !near(report.Return, 0.011516) || !near(report.MaxDrawdown, 0.00095) {
+		t.Errorf("unexpected return %v and drawdown %v", report.Return, report.MaxDrawdown)
+	}
+	if !near(report.Fees, 3.089) {
+		t.Errorf("expected 3.089 in fees, got %v", report.Fees)
+	}
+	if len(report.Equity) != 3 || !report.End.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("unexpected equity curve %v", report.Equity)
+	}
+}
+
+func TestRunTrades(t *testing.T) {
+	// newest first, as given by GetTrades
+	trades := []conn.TradeData{
+		{Price: "100", Amount: "0.3", Tid: "3", Timestamp: "2020-01-01T12:02:00.000000", Market: "ETHCLP"},
+		{Price: "98", Amount: "0.4", Tid: "2", Timestamp: "2020-01-01T12:01:00.000000", Market: "ETHCLP"},
+		{Price: "101", Amount: "1", Tid: "1", Timestamp: "2020-01-01T12:00:00.000000", Market: "ETHCLP"},
+	}
+	var id string
+	strategy := &funcStrategy{
+		onTrade: func(trading conn.Trading, trade conn.TradeData) error {
+			switch trade.Tid {
+			case "1":
+				order, err := trading.CreateOrder(args.Market("ETHCLP"), args.Type("buy"), args.Amount("1"), args.Price("99"))
+				if err != nil {
+					return err
+				}
+				id = order.Id
+			case "3":
+				order, err := trading.GetOrderStatus(args.Id(id))
+				if err != nil {
+					return err
+				}
+				if order.Amount.Remaining != "0.6" || order.Status != "active" {
+					t.Errorf("the order should be partially executed, got %+v", order)
+				}
+				executed, err := trading.GetExecutedOrdersAllPages(args.Market("ETHCLP"))
+				if err != nil || len(executed) != 0 {
+					t.Errorf("the order is not executed yet, got %v, %v", executed, err)
+				}
+				if _, err := trading.CancelOrder(args.Id(id)); err != nil {
+					return err
+				}
+				executed, err = trading.GetExecutedOrdersAllPages(args.Market("ETHCLP"))
+				if err != nil || len(executed) != 1 {
+					t.Errorf("a cancelled order partially executed is executed, got %v, %v", executed, err)
+				}
+				// the rest of the balance was released
+				_, err = trading.CreateOrder(args.Market("ETHCLP"), args.Type("buy"), args.Amount("9.604"), args.Price("50"))
+				return err
+			}
+			return nil
+		},
+	}
+	bt := New("ETHCLP", map[string]float64{"CLP": 1000})
+	report, err := bt.RunTrades(strategy, trades)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Fills) != 1 || report.Fills[0].Amount != 0.4 || report.Fills[0].Price != 99 {
+		t.Errorf("expected a fill of 0.4 at 99, got %v", report.Fills)
+	}
+	if !near(report.Balances["CLP"], 960.4) || report.Balances["ETH"] != 0.4 {
+		t.Errorf("unexpected balances %v", report.Balances)
+	}
+}
+
+func TestNotEnoughBalance(t *testing.T) {
+	strategy := &funcStrategy{
+		onCandle: func(trading conn.Trading, bar Bar) error {
+			_, err := trading.CreateOrder(args.Market("ETHCLP"), args.Type("sell"), args.Amount("1"), args.Price("100"))
+			return err
+		},
+	}
+	bt := New("ETHCLP", map[string]float64{"CLP": 1000})
+	_, err := bt.RunCandles(strategy, []Bar{bar(0, OHLC{101, 101, 101, 101}, OHLC{99, 99, 99, 99})})
+	if err != ErrNotEnoughBalance {
+		t.Errorf("expected not enough balance, got %v", err)
+	}
+}
+
+func TestInstant(t *testing.T) {
+	strategy := &funcStrategy{
+		onCandle: func(trading conn.Trading, bar Bar) error {
+			instant, err := trading.GetInstant(args.Market("ETHCLP"), args.Type("buy"), args.Amount("2"))
+			if err != nil {
+				return err
+			}
+			if !near(instant.Required, 202) || instant.Obtained != 2 {
+				t.Errorf("unexpected instant %v", instant)
+			}
+			return trading.CreateInstant(args.Market("ETHCLP"), args.Type("buy"), args.Amount("2"))
+		},
+	}
+	bt := New("ETHCLP", map[string]float64{"CLP": 1000})
+	bt.SetSlippage(0.01)
+	report, err := bt.RunCandles(strategy, []Bar{bar(0, OHLC{100, 100, 100, 100}, OHLC{99, 99, 99, 99})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(report.Balances["CLP"], 798) || report.Balances["ETH"] != 2 {
+		t.Errorf("unexpected balances %v", report.Balances)
+	}
+}
+
+func TestLoadPrices(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prices.json")
+	data := `{"status":"success","pagination":{"limit":20},"data":{
+		"ask":[{"candle_id":2,"open_price":"101","hight_price":"103","close_price":"102","low_price":"100","volume_sum":"3","candle_date":"2020-01-01 01:00:00"},
+		       {"candle_id":1,"open_price":"100","hight_price":"102","close_price":"101","low_price":"99","volume_sum":"2","candle_date":"2020-01-01 00:00:00"}],
+		"bid":[{"candle_id":2,"open_price":"99","hight_price":"101","close_price":"100","low_price":"98","volume_sum":"3","candle_date":"2020-01-01 01:00:00"}]}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prices, err := LoadPrices(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bars, err := BarsFromPrices(prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bars) != 2 || !bars[0].Time.Equal(start) {
+		t.Fatalf("expected 2 bars from the oldest, got %v", bars)
+	}
+	if bars[0].Bid != bars[0].Ask {
+		t.Errorf("a missing bid candle should take the ask prices, got %+v", bars[0])
+	}
+	if bars[1].Ask.High != 103 || bars[1].Bid.Close != 100 || bars[1].Volume != 3 {
+		t.Errorf("unexpected bar %+v", bars[1])
+	}
+}
+
+func TestLoadTrades(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trades.json")
+	data := `[{"market_taker":"buy","price":"100","amount":"1","tid":"2","timestamp":"2020-01-01T12:01:00.000000","market":"ETHCLP"},
+		{"market_taker":"sell","price":"99","amount":"1","tid":"1","timestamp":"2020-01-01T12:00:00.000000","market":"ETHCLP"}]`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	trades, err := LoadTrades(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 2 || trades[0].Tid != "1" || trades[1].MarketTaker != "buy" {
+		t.Errorf("unexpected trades %v", trades)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	equity := []EquityPoint{
+		{start, 100},
+		{start.Add(24 * time.Hour), 120},
+		{start.Add(48 * time.Hour), 90},
+		{start.Add(72 * time.Hour), 110},
+	}
+	if dd := MaxDrawdown(equity); dd != 0.25 {
+		t.Errorf("expected a drawdown of 0.25, got %v", dd)
+	}
+	// daily returns of 0.2, -0.25 and 0.2222
+	returns := []float64{0.2, -0.25, 110.0/90 - 1}
+	mean := (returns[0] + returns[1] + returns[2]) / 3
+	var variance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	expected := mean / math.Sqrt(variance/2) * math.Sqrt(365)
+	if sharpe := Sharpe(equity); !near(sharpe, expected) {
+		t.Errorf("expected a sharpe of %v, got %v", expected, sharpe)
+	}
+	if sharpe := Sharpe(equity[:2]); sharpe != 0 {
+		t.Errorf("a short curve should have no sharpe, got %v", sharpe)
+	}
+}
@@ -0,0 +1,180 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+)
+
+// OHLC are the open, high, low and close prices of a candle.
+type OHLC struct {
+	Open  float64
+	High  float64
+	Low   float64
+	Close float64
+}
+
+// A Bar joins the ask and bid candles of a period, as given by GetPrices.
+type Bar struct {
+	Time   time.Time
+	Ask    OHLC
+	Bid    OHLC
+	Volume float64
+}
+
+// Mid is the price between the ask and the bid at the close of the bar.
+func (b Bar) Mid() float64 {
+	return (b.Ask.Close + b.Bid.Close) / 2
+}
+
+func parseCandle(candle conn.Candle) (OHLC, time.Time, float64, error) {
+	t, err := execution.ParseTime(candle.CandleDate)
+	if err != nil {
+		return OHLC{}, time.Time{}, 0, err
+	}
+	var ohlc OHLC
+	var volume float64
+	fields := []struct {
+		val  string
+		dest *float64
+	}{
+		{candle.OpenPrice, &ohlc.Open},
+		{candle.HightPrice, &ohlc.High},
+		{candle.LowPrice, &ohlc.Low},
+		{candle.ClosePrice, &ohlc.Close},
+		{candle.VolumeSum, &volume},
+	}
+	for _, field := range fields {
+		if field.val == "" {
+			continue
+		}
+		*field.dest, err = strconv.ParseFloat(field.val, 64)
+		if err != nil {
+			return OHLC{}, time.Time{}, 0, fmt.Errorf("invalid candle %d: %s", candle.CandleId, err)
+		}
+	}
+	return ohlc, t, volume, nil
+}
+
+// BarsFromPrices joins the ask and bid candles of the same date in bars,
+// the oldest first. A candle missing in one of the sides takes the prices
+// of the other side.
+func BarsFromPrices(prices conn.DataPrices) ([]Bar, error) {
+	bars := make(map[time.Time]*Bar)
+	hasAsk := make(map[time.Time]bool)
+	hasBid := make(map[time.Time]bool)
+	bar := func(t time.Time) *Bar {
+		if _, ok := bars[t]; !ok {
+			bars[t] = &Bar{Time: t}
+		}
+		return bars[t]
+	}
+	for _, candle := range prices.Ask {
+		ohlc, t, volume, err := parseCandle(candle)
+		if err != nil {
+			return nil, err
+		}
+		bar(t).Ask = ohlc
+		bar(t).Volume = volume
+		hasAsk[t] = true
+	}
+	for _, candle := range prices.Bid {
+		ohlc, t, volume, err := parseCandle(candle)
+		if err != nil {
+			return nil, err
+		}
+		bar(t).Bid = ohlc
+		if !hasAsk[t] {
+			bar(t).Volume = volume
+		}
+		hasBid[t] = true
+	}
+	result := make([]Bar, 0, len(bars))
+	for t, b := range bars {
+		if !hasAsk[t] {
+			b.Ask = b.Bid
+		}
+		if !hasBid[t] {
+			b.Bid = b.Ask
+		}
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result, nil
+}
+
+// LoadPrices reads candles from a json file, either saved from the response
+// of the prices endpoint, or with the ask and bid candles at the top level.
+func LoadPrices(path string) (conn.DataPrices, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conn.DataPrices{}, fmt.Errorf("error reading prices: %s", err)
+	}
+	var file struct {
+		Data *conn.DataPrices
+		conn.DataPrices
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return conn.DataPrices{}, fmt.Errorf("error parsing prices %s: %s", path, err)
+	}
+	if file.Data != nil {
+		return *file.Data, nil
+	}
+	return file.DataPrices, nil
+}
+
+// LoadTrades reads trades from a json file, either saved from the response
+// of the trades endpoint, or as a list of trades. The trades are given
+// sorted by time, the oldest first.
+func LoadTrades(path string) ([]conn.TradeData, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trades: %s", err)
+	}
+	var trades []conn.TradeData
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &trades)
+	} else {
+		var file struct {
+			Data []conn.TradeData
+		}
+		err = json.Unmarshal(data, &file)
+		trades = file.Data
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing trades %s: %s", path, err)
+	}
+	return trades, SortTrades(trades)
+}
+
+// SortTrades sorts trades by time, the oldest first, as CryptoMarket gives
+// them the newest first.
+func SortTrades(trades []conn.TradeData) error {
+	times := make(map[string]time.Time)
+	for _, trade := range trades {
+		t, err := execution.ParseTime(trade.Timestamp)
+		if err != nil {
+			return err
+		}
+		times[trade.Timestamp] = t
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		ti, tj := times[trades[i].Timestamp], times[trades[j].Timestamp]
+		if ti.Equal(tj) {
+			idI, _ := strconv.Atoi(trades[i].Tid)
+			idJ, _ := strconv.Atoi(trades[j].Tid)
+			return idI < idJ
+		}
+		return ti.Before(tj)
+	})
+	return nil
+}
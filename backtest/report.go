@@ -0,0 +1,126 @@
+package backtest
+
+import (
+	"math"
+	"time"
+)
+
+// A Fill is an execution of an order in a backtest.
+type Fill struct {
+	OrderId string
+	Time    time.Time
+	Type    string
+	Price   float64
+	Amount  float64
+	// Fee is charged in the received currency, FeeCurrency.
+	Fee         float64
+	FeeCurrency string
+	Maker       bool
+}
+
+// An EquityPoint is the value of the account, in the quote currency, after
+// a candle or a trade was replayed.
+type EquityPoint struct {
+	Time   time.Time
+	Equity float64
+}
+
+// A Report holds the results of a backtest.
+type Report struct {
+	Start time.Time
+	End   time.Time
+	// InitialEquity is the value of the initial balances at the first
+	// prices replayed, in the quote currency.
+	InitialEquity float64
+	FinalEquity   float64
+	// Return is the fraction won or lost, e.g. 0.05 for 5%.
+	Return float64
+	// MaxDrawdown is the largest fall of the equity from a previous peak,
+	// as a fraction of the peak.
+	MaxDrawdown float64
+	// Sharpe is the annualized sharpe ratio of the returns between equity
+	// points, with a risk free rate of zero.
+	Sharpe float64
+	// Fees are the fees paid, valued in the quote currency.
+	Fees     float64
+	Fills    []Fill
+	Equity   []EquityPoint
+	Balances map[string]float64
+}
+
+func (bt *Backtest) report() *Report {
+	report := &Report{
+		InitialEquity: bt.initialEquity,
+		Fills:         bt.fills,
+		Equity:        bt.equity,
+		Balances:      bt.Balances(),
+	}
+	if len(bt.equity) > 0 {
+		report.Start = bt.equity[0].Time
+		report.End = bt.equity[len(bt.equity)-1].Time
+		report.FinalEquity = bt.equity[len(bt.equity)-1].Equity
+	}
+	if report.InitialEquity != 0 {
+		report.Return = report.FinalEquity/report.InitialEquity - 1
+	}
+	for _, fill := range bt.fills {
+		if fill.FeeCurrency == bt.quote {
+			report.Fees += fill.Fee
+		} else {
+			report.Fees += fill.Fee * fill.Price
+		}
+	}
+	report.MaxDrawdown = MaxDrawdown(bt.equity)
+	report.Sharpe = Sharpe(bt.equity)
+	return report
+}
+
+// MaxDrawdown is the largest fall of an equity curve from a previous peak,
+// as a fraction of the peak.
+func MaxDrawdown(equity []EquityPoint) float64 {
+	var peak, drawdown float64
+	for _, point := range equity {
+		if point.Equity > peak {
+			peak = point.Equity
+		}
+		if peak > 0 && (peak-point.Equity)/peak > drawdown {
+			drawdown = (peak - point.Equity) / peak
+		}
+	}
+	return drawdown
+}
+
+// Sharpe is the annualized sharpe ratio of the returns between the points
+// of an equity curve, with a risk free rate of zero. The returns are
+// annualized by the average time between points.
+func Sharpe(equity []EquityPoint) float64 {
+	if len(equity) < 3 {
+		return 0
+	}
+	returns := make([]float64, 0, len(equity)-1)
+	for i := 1; i < len(equity); i++ {
+		if equity[i-1].Equity == 0 {
+			continue
+		}
+		returns = append(returns, equity[i].Equity/equity[i-1].Equity-1)
+	}
+	if len(returns) < 2 {
+		return 0
+	}
+	var mean float64
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+	var variance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(returns)-1))
+	elapsed := equity[len(equity)-1].Time.Sub(equity[0].Time)
+	if stddev == 0 || elapsed <= 0 {
+		return 0
+	}
+	periodsPerYear := float64(365*24*time.Hour) * float64(len(equity)-1) / float64(elapsed)
+	return mean / stddev * math.Sqrt(periodsPerYear)
+}
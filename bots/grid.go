@@ -0,0 +1,377 @@
+// Package bots implements trading bots as strategies, to be run by a
+// strategy.Runtime in historical, paper or live mode.
+package bots
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/strategy"
+)
+
+// A Grid keeps a ladder of orders at evenly spaced prices of a range: buys
+// under the price and sells over it. When an order is executed the opposite
+// order is placed one level away, a sell one level up for an executed buy,
+// and a buy one level down for an executed sell.
+//
+// The orders of the grid are recognized by their price and amount, so a
+// grid recovers its state on restart from the orders of the market. Orders
+// at other prices or of other amounts are left alone.
+type Grid struct {
+	strategy.Base
+
+	market          string
+	amount          float64
+	levels          []float64
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mu      sync.Mutex
+	started bool
+	// orders are the active orders of the grid, by id.
+	orders map[string]gridOrder
+	// recovered are the orders adopted by Recover, whose updates are not
+	// given by the runtime, and lastRefresh when they were last checked.
+	recovered   map[string]bool
+	lastRefresh time.Time
+}
+
+// gridOrder is an active order of a grid at one of its levels.
+type gridOrder struct {
+	level     int
+	orderType string
+}
+
+// NewGrid builds a grid of the given number of levels from lower to upper,
+// both included, placing orders of the given amount.
+func NewGrid(market string, lower, upper float64, levels int, amount float64) (*Grid, error) {
+	if levels < 2 {
+		return nil, errors.New("a grid needs at least 2 levels")
+	}
+	if lower <= 0 || upper <= lower {
+		return nil, fmt.Errorf("invalid grid range %v to %v", lower, upper)
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid grid amount %v", amount)
+	}
+	g := &Grid{
+		market:          market,
+		amount:          amount,
+		levels:          make([]float64, levels),
+		refreshInterval: time.Minute,
+		now:             time.Now,
+		orders:          make(map[string]gridOrder),
+		recovered:       make(map[string]bool),
+	}
+	step := (upper - lower) / float64(levels-1)
+	for i := range g.levels {
+		g.levels[i] = round8(lower + step*float64(i))
+	}
+	return g, nil
+}
+
+// SetRefreshInterval sets how often the orders adopted by Recover are
+// checked, a minute by default.
+func (g *Grid) SetRefreshInterval(interval time.Duration) {
+	g.refreshInterval = interval
+}
+
+// Levels returns the prices of the grid, from the lowest.
+func (g *Grid) Levels() []float64 {
+	levels := make([]float64, len(g.levels))
+	copy(levels, g.levels)
+	return levels
+}
+
+// Orders returns the ids of the active orders of the grid.
+func (g *Grid) Orders() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ids := make([]string, 0, len(g.orders))
+	for id := range g.orders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// level returns the level of a price, or -1 if it is not a price of the grid.
+func (g *Grid) level(price string) int {
+	p, err := strconv.ParseFloat(price, 64)
+	if err != nil {
+		return -1
+	}
+	for i, level := range g.levels {
+		if math.Abs(p-level) <= 1e-9*level {
+			return i
+		}
+	}
+	return -1
+}
+
+// owns tells if an order belongs to the grid, by its market, price and
+// original amount.
+func (g *Grid) owns(order conn.Order) (int, bool) {
+	if order.Market != "" && order.Market != g.market {
+		return -1, false
+	}
+	amount, err := strconv.ParseFloat(order.Amount.Original, 64)
+	if err != nil || math.Abs(amount-g.amount) > 1e-9 {
+		return -1, false
+	}
+	i := g.level(order.Price)
+	return i, i >= 0
+}
+
+// Start places the ladder around the given price: a buy at each level under
+// it and a sell at each level over it.
+func (g *Grid) Start(trading conn.Trading, price float64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.started = true
+	for i, level := range g.levels {
+		orderType := ""
+		if level < price {
+			orderType = "buy"
+		} else if level > price {
+			orderType = "sell"
+		}
+		if orderType == "" || g.hasOrder(i) {
+			continue
+		}
+		if err := g.place(trading, i, orderType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Grid) hasOrder(level int) bool {
+	for _, o := range g.orders {
+		if o.level == level {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Grid) place(trading conn.Trading, level int, orderType string) error {
+	order, err := trading.CreateOrder(
+		args.Market(g.market),
+		args.Type(orderType),
+		args.Amount(formatFloat(g.amount)),
+		args.Price(formatFloat(g.levels[level])))
+	if err != nil {
+		return fmt.Errorf("error placing the %s of level %s: %s", orderType, formatFloat(g.levels[level]), err)
+	}
+	if order.Status == "active" {
+		g.orders[order.Id] = gridOrder{level: level, orderType: orderType}
+		return nil
+	}
+	// executed at once
+	return g.executed(trading, level, orderType)
+}
+
+// executed places the opposite order of an executed one.
+func (g *Grid) executed(trading conn.Trading, level int, orderType string) error {
+	if orderType == "buy" && level+1 < len(g.levels) && !g.hasOrder(level+1) {
+		return g.place(trading, level+1, "sell")
+	}
+	if orderType == "sell" && level > 0 && !g.hasOrder(level-1) {
+		return g.place(trading, level-1, "buy")
+	}
+	return nil
+}
+
+// Recover adopts the active orders of the grid in the market, and places
+// the opposite of the executed orders whose opposite was never placed, as
+// when the bot stopped between an execution and the next order. Without
+// active orders of the grid, as after Stop, it places nothing and the grid
+// is started again.
+func (g *Grid) Recover(trading conn.Trading) error {
+	active, err := trading.GetActiveOrdersAllPages(args.Market(g.market))
+	if err != nil {
+		return fmt.Errorf("error getting the active orders: %s", err)
+	}
+	executed, err := trading.GetExecutedOrdersAllPages(args.Market(g.market))
+	if err != nil {
+		return fmt.Errorf("error getting the executed orders: %s", err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, order := range active {
+		if level, ok := g.owns(order); ok {
+			g.orders[order.Id] = gridOrder{level: level, orderType: order.Type}
+			g.recovered[order.Id] = true
+		}
+	}
+	g.lastRefresh = g.now()
+	if len(g.orders) == 0 {
+		return nil
+	}
+	// the last execution at each level
+	last := make(map[int]conn.Order)
+	for _, order := range executed {
+		level, ok := g.owns(order)
+		if !ok || order.Status != "executed" {
+			continue
+		}
+		if previous, ok := last[level]; !ok || executedAt(order) > executedAt(previous) {
+			last[level] = order
+		}
+	}
+	levels := make([]int, 0, len(last))
+	for level := range last {
+		levels = append(levels, level)
+	}
+	// the oldest executions first, as they were missed first
+	sort.Slice(levels, func(i, j int) bool {
+		return executedAt(last[levels[i]]) < executedAt(last[levels[j]])
+	})
+	for _, level := range levels {
+		order := last[level]
+		target := level + 1
+		if order.Type == "sell" {
+			target = level - 1
+		}
+		if target < 0 || target >= len(g.levels) || g.hasOrder(target) {
+			continue
+		}
+		// the opposite was placed and executed after this order
+		if opposite, ok := last[target]; ok && opposite.CreatedAt >= executedAt(order) {
+			continue
+		}
+		if err := g.executed(trading, level, order.Type); err != nil {
+			return err
+		}
+	}
+	g.started = true
+	return nil
+}
+
+func executedAt(order conn.Order) string {
+	if order.ExecutedAt != "" {
+		return order.ExecutedAt
+	}
+	return order.UpdatedAt
+}
+
+// OnTicker recovers the grid on the first ticker, starting the ladder at
+// the last price if there were no orders of the grid. Afterwards it checks
+// the recovered orders, as they are not followed by the runtime.
+func (g *Grid) OnTicker(trading conn.Trading, ticker conn.Ticker) error {
+	g.mu.Lock()
+	started := g.started
+	g.mu.Unlock()
+	if !started {
+		if err := g.Recover(trading); err != nil {
+			return err
+		}
+		g.mu.Lock()
+		started = g.started
+		g.mu.Unlock()
+		if started {
+			return nil
+		}
+		price, err := strconv.ParseFloat(ticker.LastPrice, 64)
+		if err != nil {
+			return fmt.Errorf("invalid last price %q: %s", ticker.LastPrice, err)
+		}
+		return g.Start(trading, price)
+	}
+	return g.refreshRecovered(trading)
+}
+
+// refreshRecovered looks for the recovered orders no longer active, at
+// most once per refresh interval.
+func (g *Grid) refreshRecovered(trading conn.Trading) error {
+	g.mu.Lock()
+	var ids []string
+	for id := range g.recovered {
+		ids = append(ids, id)
+	}
+	due := len(ids) > 0 && g.now().Sub(g.lastRefresh) >= g.refreshInterval
+	if due {
+		g.lastRefresh = g.now()
+	}
+	g.mu.Unlock()
+	if !due {
+		return nil
+	}
+	sort.Strings(ids)
+	active, err := trading.GetActiveOrdersAllPages(args.Market(g.market))
+	if err != nil {
+		return fmt.Errorf("error getting the active orders: %s", err)
+	}
+	stillActive := make(map[string]bool)
+	for _, order := range active {
+		stillActive[order.Id] = true
+	}
+	for _, id := range ids {
+		if stillActive[id] {
+			continue
+		}
+		order, err := trading.GetOrderStatus(args.Id(id))
+		if err != nil {
+			return fmt.Errorf("error refreshing order %s: %s", id, err)
+		}
+		if err := g.OnOrderUpdate(trading, *order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnOrderUpdate places the opposite order when an order of the grid is
+// executed. Each execution is handled once.
+func (g *Grid) OnOrderUpdate(trading conn.Trading, order conn.Order) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	o, ok := g.orders[order.Id]
+	if !ok || order.Status == "active" {
+		return nil
+	}
+	delete(g.orders, order.Id)
+	delete(g.recovered, order.Id)
+	if order.Status != "executed" {
+		return nil
+	}
+	return g.executed(trading, o.level, o.orderType)
+}
+
+// Stop cancels the active orders of the grid.
+func (g *Grid) Stop(trading conn.Trading) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var errs []string
+	for id := range g.orders {
+		if _, err := trading.CancelOrder(args.Id(id)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", id, err))
+			continue
+		}
+		delete(g.orders, id)
+		delete(g.recovered, id)
+	}
+	g.started = false
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("error cancelling the grid orders: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func round8(val float64) float64 {
+	return math.Round(val*1e8) / 1e8
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(round8(val), 'f', -1, 64)
+}
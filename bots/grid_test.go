@@ -0,0 +1,237 @@
+package bots
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/backtest"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/strategy"
+)
+
+var start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// bar builds an hourly bar with a spread of 2 around the close, reaching
+// the given low and high.
+func bar(hour int, low, close, high float64) strategy.Bar {
+	return strategy.Bar{
+		Time: start.Add(time.Duration(hour) * time.Hour),
+		Ask:  backtest.OHLC{Open: close + 1, High: high + 1, Low: low + 1, Close: close + 1},
+		Bid:  backtest.OHLC{Open: close - 1, High: high - 1, Low: low - 1, Close: close - 1},
+	}
+}
+
+// activePrices returns the types of the active orders by price.
+func activePrices(t *testing.T, trading conn.Trading) map[string]string {
+	orders, err := trading.GetActiveOrdersAllPages(args.Market("ETHCLP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := make(map[string]string)
+	for _, order := range orders {
+		prices[order.Price] = order.Type
+	}
+	return prices
+}
+
+func TestGrid(t *testing.T) {
+	grid, err := NewGrid("ETHCLP", 90, 110, 5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels := grid.Levels(); !reflect.DeepEqual(levels, []float64{90, 95, 100, 105, 110}) {
+		t.Errorf("unexpected levels %v", levels)
+	}
+	bt := backtest.New("ETHCLP", map[string]float64{"CLP": 1000, "ETH": 2})
+	bars := []strategy.Bar{
+		bar(0, 100, 100, 100),
+		// the buy of 95 is executed, a sell of 100 is placed
+		bar(1, 94, 96, 97),
+		// the sell of 100 is executed, a buy of 95 is placed
+		bar(2, 96, 99, 101),
+	}
+	var afterFirst map[string]string
+	check := &checkpoint{Grid: grid, hour: 1, check: func(trading conn.Trading) {
+		afterFirst = activePrices(t, trading)
+	}}
+	report, err := bt.RunCandles(check, bars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"90": "buy", "100": "sell", "105": "sell", "110": "sell"}
+	if !reflect.DeepEqual(afterFirst, expected) {
+		t.Errorf("expected %v after the first execution, got %v", expected, afterFirst)
+	}
+	expected = map[string]string{"90": "buy", "95": "buy", "105": "sell", "110": "sell"}
+	if prices := activePrices(t, bt); !reflect.DeepEqual(prices, expected) {
+		t.Errorf("expected %v at the end, got %v", expected, prices)
+	}
+	if len(report.Fills) != 2 || report.Balances["CLP"] != 1005 {
+		t.Errorf("expected to win 5 in two fills, got %v, %v", report.Fills, report.Balances)
+	}
+
+	if err := grid.Stop(bt); err != nil {
+		t.Fatal(err)
+	}
+	if prices := activePrices(t, bt); len(prices) != 0 || len(grid.Orders()) != 0 {
+		t.Errorf("the grid should cancel its orders, left %v", prices)
+	}
+}
+
+// checkpoint runs a grid, calling check after the bar of the given hour.
+type checkpoint struct {
+	*Grid
+	hour  int
+	check func(trading conn.Trading)
+	// frozen gives the grid only the first ticker, as if the bot stopped
+	// right after starting.
+	frozen bool
+	seen   bool
+}
+
+func (c *checkpoint) OnTicker(trading conn.Trading, ticker conn.Ticker) error {
+	if c.frozen && c.seen {
+		return nil
+	}
+	c.seen = true
+	return c.Grid.OnTicker(trading, ticker)
+}
+
+func (c *checkpoint) OnCandle(trading conn.Trading, bar strategy.Bar) error {
+	if bar.Time.Hour() == c.hour && c.check != nil {
+		c.check(trading)
+	}
+	return nil
+}
+
+func (c *checkpoint) OnOrderUpdate(trading conn.Trading, order conn.Order) error {
+	if c.frozen {
+		return nil
+	}
+	return c.Grid.OnOrderUpdate(trading, order)
+}
+
+func TestGridRecover(t *testing.T) {
+	grid, _ := NewGrid("ETHCLP", 90, 110, 5, 1)
+	bt := backtest.New("ETHCLP", map[string]float64{"CLP": 1000, "ETH": 2})
+	// the executions are missed, so the sell of 100 is never placed
+	var recovered *Grid
+	check := &checkpoint{Grid: grid, hour: 1, frozen: true, check: func(trading conn.Trading) {
+		// an order out of the grid is left alone
+		if _, err := trading.CreateOrder(args.Market("ETHCLP"), args.Type("buy"), args.Amount("0.5"), args.Price("80")); err != nil {
+			t.Fatal(err)
+		}
+		recovered, _ = NewGrid("ETHCLP", 90, 110, 5, 1)
+		if err := recovered.Recover(trading); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	if _, err := bt.RunCandles(check, []strategy.Bar{bar(0, 100, 100, 100), bar(1, 94, 96, 97)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered.Orders()) != 4 {
+		t.Errorf("expected the 3 orders left and the missing sell, got %v", recovered.Orders())
+	}
+	expected := map[string]string{"80": "buy", "90": "buy", "100": "sell", "105": "sell", "110": "sell"}
+	if prices := activePrices(t, bt); !reflect.DeepEqual(prices, expected) {
+		t.Errorf("expected %v, got %v", expected, prices)
+	}
+
+	// recovering again places nothing more
+	again, _ := NewGrid("ETHCLP", 90, 110, 5, 1)
+	if err := again.Recover(bt); err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Orders()) != 4 || len(activePrices(t, bt)) != 5 {
+		t.Errorf("a second recovery should only adopt the orders, got %v", again.Orders())
+	}
+}
+
+func TestGridRestartAfterStop(t *testing.T) {
+	grid, _ := NewGrid("ETHCLP", 90, 110, 5, 1)
+	bt := backtest.New("ETHCLP", map[string]float64{"CLP": 1000, "ETH": 2})
+	var restarted map[string]string
+	check := &checkpoint{Grid: grid, hour: 1, check: func(trading conn.Trading) {
+		if err := grid.Stop(trading); err != nil {
+			t.Fatal(err)
+		}
+		// the executed buy of 95 should not leave a lone sell of 100
+		again, _ := NewGrid("ETHCLP", 90, 110, 5, 1)
+		if err := again.OnTicker(trading, conn.Ticker{LastPrice: "96"}); err != nil {
+			t.Fatal(err)
+		}
+		restarted = activePrices(t, trading)
+	}}
+	if _, err := bt.RunCandles(check, []strategy.Bar{bar(0, 100, 100, 100), bar(1, 94, 96, 97)}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"90": "buy", "95": "buy", "100": "sell", "105": "sell", "110": "sell"}
+	if !reflect.DeepEqual(restarted, expected) {
+		t.Errorf("expected the ladder rebuilt %v, got %v", expected, restarted)
+	}
+}
+
+// countingTrading counts the listings of the active orders.
+type countingTrading struct {
+	conn.Trading
+	listings int
+}
+
+func (c *countingTrading) GetActiveOrdersAllPages(arguments ...args.Argument) ([]conn.Order, error) {
+	c.listings++
+	return c.Trading.GetActiveOrdersAllPages(arguments...)
+}
+
+func TestGridRefreshInterval(t *testing.T) {
+	grid, _ := NewGrid("ETHCLP", 90, 110, 5, 1)
+	bt := backtest.New("ETHCLP", map[string]float64{"CLP": 1000, "ETH": 2})
+	var own, recovered int
+	check := &checkpoint{Grid: grid, hour: 1, check: func(trading conn.Trading) {
+		counting := &countingTrading{Trading: trading}
+		ticker := conn.Ticker{LastPrice: "96"}
+		// the orders placed by the grid are updated by the runtime
+		for i := 0; i < 3; i++ {
+			if err := grid.OnTicker(counting, ticker); err != nil {
+				t.Fatal(err)
+			}
+		}
+		own = counting.listings
+
+		again, _ := NewGrid("ETHCLP", 90, 110, 5, 1)
+		now := start
+		again.now = func() time.Time { return now }
+		if err := again.Recover(trading); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			if err := again.OnTicker(counting, ticker); err != nil {
+				t.Fatal(err)
+			}
+			now = now.Add(30 * time.Second)
+		}
+		recovered = counting.listings - own
+	}}
+	if _, err := bt.RunCandles(check, []strategy.Bar{bar(0, 100, 100, 100), bar(1, 94, 96, 97)}); err != nil {
+		t.Fatal(err)
+	}
+	if own != 0 {
+		t.Errorf("the orders of the grid should not be listed, got %d listings", own)
+	}
+	if recovered != 1 {
+		t.Errorf("expected one listing in 90 seconds, got %d", recovered)
+	}
+}
+
+func TestNewGridErrors(t *testing.T) {
+	if _, err := NewGrid("ETHCLP", 90, 110, 1, 1); err == nil {
+		t.Errorf("a grid of one level should fail")
+	}
+	if _, err := NewGrid("ETHCLP", 110, 90, 5, 1); err == nil {
+		t.Errorf("an inverted range should fail")
+	}
+	if _, err := NewGrid("ETHCLP", 90, 110, 5, 0); err == nil {
+		t.Errorf("a zero amount should fail")
+	}
+}
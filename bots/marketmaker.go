@@ -0,0 +1,390 @@
+package bots
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+	"github.com/cryptomkt/cryptomkt-go/strategy"
+)
+
+// BalanceGetter is the part of the client needed to read the balances.
+type BalanceGetter interface {
+	GetBalance() ([]conn.Balance, error)
+}
+
+// A MarketMaker quotes a buy and a sell order around the mid price of a
+// market, shifting both quotes against its inventory: when it holds more
+// than its target it lowers the quotes to sell more and buy less, and the
+// other way around.
+//
+// The quotes are refreshed, cancelling and placing them again, when the mid
+// moves more than the threshold or when one of them is executed. The mid is
+// read from the order book, or from the ticker without a book, and the
+// inventory from the balance of the base currency, or from the executions
+// since the start without a balance getter.
+type MarketMaker struct {
+	strategy.Base
+
+	// Market is the pair quoted, as "ETHCLP".
+	Market string
+	// Amount of each quote, in the base currency.
+	Amount float64
+	// Spread between the quotes, as a fraction of the mid, e.g. 0.004.
+	Spread float64
+	// MinSpread is the least spread quoted, whatever Spread is.
+	MinSpread float64
+	// Skew is the fraction of the mid the quotes are moved when the
+	// inventory is MaxPosition away from TargetInventory.
+	Skew float64
+	// TargetInventory is the amount of the base currency to hold.
+	TargetInventory float64
+	// MaxPosition is the largest distance from the target inventory. A
+	// side is quoted only when a full fill of it keeps the inventory within
+	// it.
+	MaxPosition float64
+	// Threshold is the move of the mid, as a fraction, that refreshes the
+	// quotes.
+	Threshold float64
+	// Tick is the price increment of the market, zero to round prices to
+	// 8 decimals. Buys are rounded down and sells up.
+	Tick float64
+	// Fee is the maker fee, as a fraction like Account.Rate, used in the
+	// PnL.
+	Fee float64
+	// StartInventory is the inventory at the start, used when there is no
+	// balance getter.
+	StartInventory float64
+
+	book     execution.BookGetter
+	balances BalanceGetter
+
+	mu      sync.Mutex
+	quotes  map[string]*conn.Order
+	seen    map[string]float64
+	lastMid float64
+	mark    float64
+	started bool
+	pnl     PnL
+}
+
+// PnL is the profit and loss of a market maker, in the quote currency.
+type PnL struct {
+	// Position is the inventory of the base currency, counting the one
+	// at the start.
+	Position float64
+	// AvgCost is the average price paid for the position, the inventory
+	// at the start valued at the first mid.
+	AvgCost float64
+	// Realized is the profit of the sells over the average cost.
+	Realized float64
+	// Unrealized is the position valued at the last mid over its cost.
+	Unrealized float64
+	// Volume is the amount of the base currency executed.
+	Volume float64
+	// Fills is the number of executions.
+	Fills int
+}
+
+// NewMarketMaker builds a market maker quoting the given amount, reading
+// the mid from book and the inventory from balances, both optional.
+func NewMarketMaker(market string, amount float64, book execution.BookGetter, balances BalanceGetter) (*MarketMaker, error) {
+	if _, err := baseCurrency(market); err != nil {
+		return nil, err
+	}
+	return &MarketMaker{
+		Market:      market,
+		Amount:      amount,
+		Spread:      0.004,
+		MinSpread:   0.002,
+		Skew:        0.002,
+		MaxPosition: 10 * amount,
+		Threshold:   0.001,
+		book:        book,
+		balances:    balances,
+		quotes:      make(map[string]*conn.Order),
+		seen:        make(map[string]float64),
+	}, nil
+}
+
+// baseCurrency returns the base currency of a market, as "ETH" of
+// "ETHCLP". Quote currencies are three letters long.
+func baseCurrency(market string) (string, error) {
+	if len(market) < 6 {
+		return "", fmt.Errorf("invalid market %s", market)
+	}
+	return market[:len(market)-3], nil
+}
+
+// Quotes returns the active quotes, by type.
+func (mm *MarketMaker) Quotes() map[string]conn.Order {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	quotes := make(map[string]conn.Order)
+	for orderType, order := range mm.quotes {
+		quotes[orderType] = *order
+	}
+	return quotes
+}
+
+// PnL returns the profit and loss, valuing the position at the last mid.
+func (mm *MarketMaker) PnL() PnL {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	pnl := mm.pnl
+	pnl.Unrealized = pnl.Position * (mm.mark - pnl.AvgCost)
+	return pnl
+}
+
+// mid reads the mid price from the book, or from the ticker.
+func (mm *MarketMaker) mid(ticker conn.Ticker) (mid, bestBid, bestAsk float64, err error) {
+	if mm.book != nil {
+		if bestBid, err = mm.best("buy"); err != nil {
+			return 0, 0, 0, err
+		}
+		if bestAsk, err = mm.best("sell"); err != nil {
+			return 0, 0, 0, err
+		}
+	} else {
+		if bestBid, err = strconv.ParseFloat(ticker.Bid, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid bid %q: %s", ticker.Bid, err)
+		}
+		if bestAsk, err = strconv.ParseFloat(ticker.Ask, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid ask %q: %s", ticker.Ask, err)
+		}
+	}
+	return (bestBid + bestAsk) / 2, bestBid, bestAsk, nil
+}
+
+func (mm *MarketMaker) best(bookType string) (float64, error) {
+	book, err := mm.book.GetBook(args.Market(mm.Market), args.Type(bookType))
+	if err != nil {
+		return 0, fmt.Errorf("error getting the %s book: %s", bookType, err)
+	}
+	if len(book.Data) == 0 {
+		return 0, fmt.Errorf("the %s book of %s is empty", bookType, mm.Market)
+	}
+	price, err := strconv.ParseFloat(book.Data[0].Price, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid book price %q: %s", book.Data[0].Price, err)
+	}
+	return price, nil
+}
+
+// inventory reads the balance of the base currency, or counts it from the
+// executions without a balance getter.
+func (mm *MarketMaker) inventory() (float64, error) {
+	if mm.balances == nil {
+		return mm.pnl.Position, nil
+	}
+	base, err := baseCurrency(mm.Market)
+	if err != nil {
+		return 0, err
+	}
+	balances, err := mm.balances.GetBalance()
+	if err != nil {
+		return 0, fmt.Errorf("error getting the balance: %s", err)
+	}
+	for _, balance := range balances {
+		if balance.Wallet == base {
+			amount, err := strconv.ParseFloat(balance.Balance, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid balance %q: %s", balance.Balance, err)
+			}
+			return amount, nil
+		}
+	}
+	return 0, nil
+}
+
+// OnTicker refreshes the quotes when the mid moved beyond the threshold, or
+// when a side is missing.
+func (mm *MarketMaker) OnTicker(trading conn.Trading, ticker conn.Ticker) error {
+	mid, bestBid, bestAsk, err := mm.mid(ticker)
+	if err != nil {
+		return err
+	}
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if !mm.started {
+		mm.pnl.Position = mm.StartInventory
+	}
+	inventory, err := mm.inventory()
+	if err != nil {
+		return err
+	}
+	if !mm.started {
+		mm.started = true
+		mm.pnl.Position = inventory
+		mm.pnl.AvgCost = mid
+	}
+	mm.mark = mid
+	moved := mm.lastMid == 0 || math.Abs(mid-mm.lastMid) > mm.Threshold*mm.lastMid
+	bid, ask := mm.quotePrices(mid, bestBid, bestAsk, inventory)
+	deviation := inventory - mm.TargetInventory
+	wanted := map[string]float64{}
+	// a full fill of a quote keeps the inventory within MaxPosition
+	if round8(deviation+mm.Amount) <= mm.MaxPosition {
+		wanted["buy"] = bid
+	}
+	if round8(deviation-mm.Amount) >= -mm.MaxPosition && inventory >= mm.Amount {
+		wanted["sell"] = ask
+	}
+	if !moved && mm.quoting(wanted) {
+		return nil
+	}
+	mm.lastMid = mid
+	// cancel-replace of both quotes
+	if err := mm.cancelQuotes(trading); err != nil {
+		return err
+	}
+	for _, orderType := range []string{"buy", "sell"} {
+		price, ok := wanted[orderType]
+		if !ok {
+			continue
+		}
+		order, err := trading.CreateOrder(
+			args.Market(mm.Market),
+			args.Type(orderType),
+			args.Amount(formatFloat(mm.Amount)),
+			args.Price(formatFloat(price)))
+		if err != nil {
+			return fmt.Errorf("error placing the %s quote: %s", orderType, err)
+		}
+		mm.seen[order.Id] = 0
+		mm.record(*order)
+		if order.Status == "active" {
+			mm.quotes[orderType] = order
+		}
+	}
+	return nil
+}
+
+// quoting tells if the active quotes are on the wanted sides.
+func (mm *MarketMaker) quoting(wanted map[string]float64) bool {
+	if len(mm.quotes) != len(wanted) {
+		return false
+	}
+	for orderType := range wanted {
+		if _, ok := mm.quotes[orderType]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// cancelQuotes cancels the quotes. A quote that can not be cancelled as it
+// is no longer active, executed before the cancel, is refreshed instead.
+func (mm *MarketMaker) cancelQuotes(trading conn.Trading) error {
+	for orderType, order := range mm.quotes {
+		cancelled, err := trading.CancelOrder(args.Id(order.Id))
+		if err != nil {
+			status, statusErr := trading.GetOrderStatus(args.Id(order.Id))
+			if statusErr != nil || status.Status == "active" {
+				return fmt.Errorf("error cancelling the %s quote %s: %s", orderType, order.Id, err)
+			}
+			cancelled = status
+		}
+		// what was executed before the cancel
+		mm.record(*cancelled)
+		delete(mm.quotes, orderType)
+	}
+	return nil
+}
+
+// quotePrices returns the prices of the quotes around the mid, moved by the
+// inventory, keeping the spread and never crossing the book.
+func (mm *MarketMaker) quotePrices(mid, bestBid, bestAsk, inventory float64) (float64, float64) {
+	spread := math.Max(mm.Spread, mm.MinSpread) * mid
+	var deviation float64
+	if mm.MaxPosition > 0 {
+		deviation = (inventory - mm.TargetInventory) / mm.MaxPosition
+		deviation = math.Max(-1, math.Min(1, deviation))
+	}
+	center := mid * (1 - mm.Skew*deviation)
+	bid := mm.roundDown(center - spread/2)
+	ask := mm.roundUp(center + spread/2)
+	// quotes stay passive
+	if bid >= bestAsk {
+		bid = bestBid
+	}
+	if ask <= bestBid {
+		ask = bestAsk
+	}
+	// rounding and clamping keep the minimum spread
+	if min := mm.MinSpread * mid; ask-bid < min {
+		ask = mm.roundUp(bid + min)
+	}
+	return bid, ask
+}
+
+func (mm *MarketMaker) roundDown(price float64) float64 {
+	if mm.Tick <= 0 {
+		return round8(price)
+	}
+	return round8(math.Floor(round8(price/mm.Tick)) * mm.Tick)
+}
+
+func (mm *MarketMaker) roundUp(price float64) float64 {
+	if mm.Tick <= 0 {
+		return round8(price)
+	}
+	return round8(math.Ceil(round8(price/mm.Tick)) * mm.Tick)
+}
+
+// OnOrderUpdate records the executions of the quotes, dropping the quotes
+// no longer active so they are placed again in the next ticker.
+func (mm *MarketMaker) OnOrderUpdate(trading conn.Trading, order conn.Order) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.record(order)
+	if quote, ok := mm.quotes[order.Type]; ok && quote.Id == order.Id && order.Status != "active" {
+		delete(mm.quotes, order.Type)
+	}
+	return nil
+}
+
+// record adds to the PnL what was executed of an order since last seen.
+func (mm *MarketMaker) record(order conn.Order) {
+	previous, ok := mm.seen[order.Id]
+	if !ok {
+		return
+	}
+	executed, _ := strconv.ParseFloat(order.Amount.Executed, 64)
+	delta := round8(executed - previous)
+	mm.seen[order.Id] = executed
+	if order.Status != "active" {
+		delete(mm.seen, order.Id)
+	}
+	if delta <= 0 {
+		return
+	}
+	// the price executed at, which may be better than the limit
+	priceText := order.ExecutionPrice
+	if priceText == "" {
+		priceText = order.Price
+	}
+	price, _ := strconv.ParseFloat(priceText, 64)
+	pnl := &mm.pnl
+	pnl.Volume += delta
+	pnl.Fills++
+	if order.Type == "buy" {
+		received := delta * (1 - mm.Fee)
+		pnl.AvgCost = (pnl.Position*pnl.AvgCost + delta*price) / (pnl.Position + received)
+		pnl.Position += received
+		return
+	}
+	pnl.Realized += delta*price*(1-mm.Fee) - delta*pnl.AvgCost
+	pnl.Position -= delta
+}
+
+// Stop cancels the quotes.
+func (mm *MarketMaker) Stop(trading conn.Trading) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return mm.cancelQuotes(trading)
+}
@@ -0,0 +1,257 @@
+package bots
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/backtest"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+	"github.com/cryptomkt/cryptomkt-go/requests"
+	"github.com/cryptomkt/cryptomkt-go/strategy"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func quotePrices(mm *MarketMaker) (string, string) {
+	quotes := mm.Quotes()
+	return quotes["buy"].Price, quotes["sell"].Price
+}
+
+// snapshots runs a market maker, keeping its quotes after each bar.
+type snapshots struct {
+	*MarketMaker
+	quotes []map[string]conn.Order
+}
+
+func (s *snapshots) OnCandle(trading conn.Trading, bar strategy.Bar) error {
+	s.quotes = append(s.quotes, s.Quotes())
+	return nil
+}
+
+func TestMarketMaker(t *testing.T) {
+	mm, err := NewMarketMaker("ETHCLP", 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.Spread = 0.02
+	mm.MinSpread = 0.01
+	mm.Skew = 0.01
+	mm.StartInventory = 1
+	mm.TargetInventory = 1
+	mm.MaxPosition = 2
+	mm.Threshold = 0.005
+	mm.Tick = 0.01
+	run := &snapshots{MarketMaker: mm}
+	bt := backtest.New("ETHCLP", map[string]float64{"CLP": 10000, "ETH": 1})
+	bars := []strategy.Bar{
+		// mid of 100
+		bar(0, 100, 100, 100),
+		// the mid moves less than the threshold, the quotes stay
+		bar(1, 100, 100.2, 100.4),
+		// the buy of 99 is executed and the mid falls to 98
+		bar(2, 97, 98, 99),
+	}
+	if _, err := bt.RunCandles(run, bars); err != nil {
+		t.Fatal(err)
+	}
+	first := run.quotes[0]
+	if first["buy"].Price != "99" || first["sell"].Price != "101" {
+		t.Errorf("expected quotes of 99 and 101, got %v", first)
+	}
+	if run.quotes[1]["buy"].Id != first["buy"].Id || run.quotes[1]["sell"].Id != first["sell"].Id {
+		t.Errorf("the quotes should not be refreshed under the threshold")
+	}
+	// the inventory is 1 over the target, half of the max position, so the
+	// center moves 0.5% down from 98 to 97.51
+	buy, sell := quotePrices(mm)
+	if buy != "96.53" || sell != "98.49" {
+		t.Errorf("expected quotes skewed to 96.53 and 98.49, got %s and %s", buy, sell)
+	}
+	pnl := mm.PnL()
+	if pnl.Position != 2 || pnl.AvgCost != 99.5 || pnl.Fills != 1 || !near(pnl.Unrealized, -3) {
+		t.Errorf("unexpected pnl %+v", pnl)
+	}
+	if err := mm.Stop(bt); err != nil {
+		t.Fatal(err)
+	}
+	if len(mm.Quotes()) != 0 {
+		t.Errorf("the quotes should be cancelled, left %v", mm.Quotes())
+	}
+}
+
+func TestMarketMakerLimits(t *testing.T) {
+	mm, err := NewMarketMaker("ETHCLP", 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.Spread = 0.001
+	mm.MinSpread = 0.01
+	mm.Skew = 0
+	mm.StartInventory = 2
+	mm.TargetInventory = 1
+	mm.MaxPosition = 1
+	bt := backtest.New("ETHCLP", map[string]float64{"CLP": 10000, "ETH": 2})
+	if _, err := bt.RunCandles(mm, []strategy.Bar{bar(0, 100, 100, 100)}); err != nil {
+		t.Fatal(err)
+	}
+	buy, sell := quotePrices(mm)
+	if buy != "" {
+		t.Errorf("at the max position only the sell should be quoted, got a buy at %s", buy)
+	}
+	if sell != "100.5" {
+		t.Errorf("the min spread should be kept, got a sell at %s", sell)
+	}
+}
+
+func TestMarketMakerBook(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	client := server.Client()
+	mm, err := NewMarketMaker("ETHCLP", 0.1, client, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.Spread = 0.01
+	mm.Tick = 1
+	if err := mm.OnTicker(client, conn.Ticker{}); err != nil {
+		t.Fatal(err)
+	}
+	// the book gives a mid of 150050, and the balance 2.5 ETH, far over
+	// the target with the default max position of 1
+	buy, sell := quotePrices(mm)
+	if buy != "" || sell == "" {
+		t.Errorf("expected only a sell, got %q and %q", buy, sell)
+	}
+	if pnl := mm.PnL(); pnl.Position != 2.5 || pnl.AvgCost != 150050 {
+		t.Errorf("unexpected pnl %+v", pnl)
+	}
+}
+
+func toMap(arguments ...args.Argument) map[string]string {
+	req := requests.NewEmptyReq()
+	for _, argument := range arguments {
+		argument(req)
+	}
+	return req.GetArguments()
+}
+
+// lateTrading is an exchange where the orders are executed before they can
+// be cancelled, unless cancels is set.
+type lateTrading struct {
+	conn.Trading
+	orders  map[string]conn.Order
+	cancels bool
+}
+
+func (l *lateTrading) CreateOrder(arguments ...args.Argument) (*conn.Order, error) {
+	order := conn.Order{Id: strconv.Itoa(len(l.orders) + 1), Status: "active", Market: "ETHCLP"}
+	values := toMap(arguments...)
+	order.Type, order.Price = values["type"], values["price"]
+	order.Amount.Original, order.Amount.Executed = values["amount"], "0"
+	l.orders[order.Id] = order
+	return &order, nil
+}
+
+func (l *lateTrading) CancelOrder(arguments ...args.Argument) (*conn.Order, error) {
+	if l.cancels {
+		order := l.orders[toMap(arguments...)["id"]]
+		order.Status = "cancelled"
+		return &order, nil
+	}
+	return nil, errors.New("error from the server side: order_not_active")
+}
+
+func (l *lateTrading) GetOrderStatus(arguments ...args.Argument) (*conn.Order, error) {
+	order := l.orders[toMap(arguments...)["id"]]
+	order.Status, order.Amount.Executed = "executed", order.Amount.Original
+	return &order, nil
+}
+
+func TestMarketMakerExecutedBeforeCancel(t *testing.T) {
+	mm, err := NewMarketMaker("ETHCLP", 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.StartInventory = 1
+	mm.TargetInventory = 1
+	trading := &lateTrading{orders: make(map[string]conn.Order)}
+	if err := mm.OnTicker(trading, conn.Ticker{Bid: "99", Ask: "101"}); err != nil {
+		t.Fatal(err)
+	}
+	// the mid moves, and both quotes were executed before the cancel
+	if err := mm.OnTicker(trading, conn.Ticker{Bid: "109", Ask: "111"}); err != nil {
+		t.Fatal(err)
+	}
+	if pnl := mm.PnL(); pnl.Fills != 2 || pnl.Volume != 2 || pnl.Position != 1 {
+		t.Errorf("expected both executions recorded, got %+v", pnl)
+	}
+	if quotes := mm.Quotes(); quotes["buy"].Id != "3" || quotes["sell"].Id != "4" {
+		t.Errorf("expected new quotes, got %v", quotes)
+	}
+}
+
+// countingBalances counts the reads of the balance.
+type countingBalances struct {
+	reads int
+}
+
+func (c *countingBalances) GetBalance() ([]conn.Balance, error) {
+	c.reads++
+	return []conn.Balance{{Wallet: "ETH", Balance: "1"}}, nil
+}
+
+func TestMarketMakerFirstTicker(t *testing.T) {
+	if _, err := NewMarketMaker("CLP", 1, nil, nil); err == nil {
+		t.Errorf("a market without base currency should fail")
+	}
+	balances := &countingBalances{}
+	mm, err := NewMarketMaker("ETHCLP", 1, nil, balances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trading := &lateTrading{orders: make(map[string]conn.Order)}
+	if err := mm.OnTicker(trading, conn.Ticker{Bid: "99", Ask: "101"}); err != nil {
+		t.Fatal(err)
+	}
+	if balances.reads != 1 || mm.PnL().Position != 1 {
+		t.Errorf("expected one read of the balance, got %d and %+v", balances.reads, mm.PnL())
+	}
+}
+
+func TestMarketMakerMaxPosition(t *testing.T) {
+	mm, err := NewMarketMaker("ETHCLP", 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.StartInventory = 1
+	mm.MaxPosition = 2
+	trading := &lateTrading{orders: make(map[string]conn.Order), cancels: true}
+	ticker := conn.Ticker{Bid: "99", Ask: "101"}
+	if err := mm.OnTicker(trading, ticker); err != nil {
+		t.Fatal(err)
+	}
+	buy, ok := mm.Quotes()["buy"]
+	if !ok {
+		t.Fatalf("a buy filling up to the max position should be quoted, got %v", mm.Quotes())
+	}
+	// the buy is executed under its limit, taking the inventory to the cap
+	buy.Status, buy.Amount.Executed, buy.ExecutionPrice = "executed", "1", "98"
+	if err := mm.OnOrderUpdate(trading, buy); err != nil {
+		t.Fatal(err)
+	}
+	if pnl := mm.PnL(); pnl.Position != 2 || pnl.AvgCost != 99 {
+		t.Errorf("expected the fill at its execution price, got %+v", pnl)
+	}
+	if err := mm.OnTicker(trading, ticker); err != nil {
+		t.Fatal(err)
+	}
+	if quotes := mm.Quotes(); len(quotes) != 1 || quotes["sell"].Id == "" {
+		t.Errorf("at the max position only the sell should be quoted, got %v", quotes)
+	}
+}
@@ -0,0 +1,425 @@
+// Package cassette records the http interactions of a client with
+// CryptoMarket in a file, and replays them later, for deterministic
+// regression tests.
+//
+// A Recorder is an http.RoundTripper that forwards the requests and keeps
+// each request and response, with the keys, the signature and the account
+// data redacted. A Replayer is an http.RoundTripper that answers the
+// requests with the recorded responses, without network. Cassettes are
+// saved as indented json, so they can be read and edited by hand.
+//
+//	recorder := cassette.NewRecorder(nil)
+//	client.SetHttpClient(&http.Client{Transport: recorder})
+//	... calls to the client ...
+//	recorder.Save("testdata/orders.json")
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the redacted values.
+const Redacted = "REDACTED"
+
+// RedactedHeaders are the headers whose values are never written.
+var RedactedHeaders = []string{"X-Mkt-Apikey", "X-Mkt-Signature"}
+
+// VolatileHeaders are the headers that change in every request. They are
+// not recorded, and are ignored when matching a request.
+var VolatileHeaders = []string{"X-Mkt-Timestamp", "User-Agent", "Accept-Encoding", "Content-Length"}
+
+// DefaultRedactedFields are the keys of the json responses and of the
+// request forms whose values are redacted, as they hold account data.
+var DefaultRedactedFields = []string{
+	"name", "email", "number", "description",
+	"address", "memo", "hash", "bank_account",
+	"voucher", "tracking_code", "refund_email",
+}
+
+// A Request is a recorded http request.
+type Request struct {
+	Method string `json:"method"`
+	// Path is the path of the url, with the query sorted by key.
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Form is the form of a post request.
+	Form map[string]string `json:"form,omitempty"`
+}
+
+// A Response is a recorded http response.
+type Response struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    Body              `json:"body"`
+}
+
+// An Interaction is a request and the response given to it.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// A Cassette is a list of interactions.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Body is the body of a response. It is written as json when it is valid
+// json, and as a string otherwise, so it stays readable in the file.
+type Body []byte
+
+// MarshalJSON writes the body as json, or as a json string.
+func (b Body) MarshalJSON() ([]byte, error) {
+	if json.Valid(b) && len(bytes.TrimSpace(b)) > 0 {
+		return bytes.TrimSpace(b), nil
+	}
+	return json.Marshal(string(b))
+}
+
+// UnmarshalJSON reads a body written by MarshalJSON.
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*b = Body(text)
+		return nil
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+// Load reads a cassette from a file.
+func Load(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cassette: %s", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing cassette %s: %s", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette in a file.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding cassette: %s", err)
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// redactor redacts the account data of the interactions.
+type redactor struct {
+	fields map[string]bool
+}
+
+func newRedactor(fields []string) *redactor {
+	r := &redactor{fields: make(map[string]bool)}
+	for _, field := range fields {
+		r.fields[field] = true
+	}
+	return r
+}
+
+// request converts an http request to its redacted record. The body of the
+// request is read and restored.
+func (r *redactor) request(req *http.Request) (Request, error) {
+	query := req.URL.Query()
+	for key := range query {
+		if r.fields[key] {
+			query.Set(key, Redacted)
+		}
+	}
+	path := req.URL.Path
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	record := Request{
+		Method:  req.Method,
+		Path:    path,
+		Headers: r.headers(req.Header),
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return Request{}, fmt.Errorf("error reading the request body: %s", err)
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return Request{}, fmt.Errorf("error parsing the request form: %s", err)
+		}
+		if len(form) > 0 {
+			record.Form = make(map[string]string)
+			for key := range form {
+				record.Form[key] = form.Get(key)
+				if r.fields[key] {
+					record.Form[key] = Redacted
+				}
+			}
+		}
+	}
+	return record, nil
+}
+
+// headers returns the headers to record, without the volatile ones.
+func (r *redactor) headers(header http.Header) map[string]string {
+	result := make(map[string]string)
+	for key := range header {
+		canonical := http.CanonicalHeaderKey(key)
+		if contains(VolatileHeaders, canonical) {
+			continue
+		}
+		result[canonical] = header.Get(key)
+		if contains(RedactedHeaders, canonical) {
+			result[canonical] = Redacted
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// body redacts the fields of a json body, other bodies are kept as they are.
+func (r *redactor) body(body []byte) []byte {
+	// numbers are kept as written, as float64 would change big ids and amounts
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return body
+	}
+	redacted, err := json.Marshal(r.value(data))
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func (r *redactor) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if r.fields[key] && value != nil {
+				v[key] = Redacted
+			} else {
+				v[key] = r.value(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = r.value(v[i])
+		}
+	}
+	return v
+}
+
+func contains(list []string, val string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, val) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Recorder is an http.RoundTripper that records the interactions made
+// through it.
+type Recorder struct {
+	transport http.RoundTripper
+	redactor  *redactor
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder builds a recorder forwarding the requests to the given
+// transport, http.DefaultTransport if nil. The DefaultRedactedFields
+// are redacted.
+func NewRecorder(transport http.RoundTripper) *Recorder {
+	return NewRecorderRedacting(transport, DefaultRedactedFields)
+}
+
+// NewRecorderRedacting builds a recorder that redacts the given fields
+// of the json responses and the request forms.
+func NewRecorderRedacting(transport http.RoundTripper, fields []string) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{
+		transport: transport,
+		redactor:  newRedactor(fields),
+	}
+}
+
+// RoundTrip forwards the request and records the interaction.
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	record, err := rec.redactor.request(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rec.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the response body: %s", err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	headers := make(map[string]string)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+	interaction := Interaction{
+		Request: record,
+		Response: Response{
+			Status:  resp.StatusCode,
+			Headers: headers,
+			Body:    Body(rec.redactor.body(body)),
+		},
+	}
+	rec.mu.Lock()
+	rec.cassette.Interactions = append(rec.cassette.Interactions, interaction)
+	rec.mu.Unlock()
+	return resp, nil
+}
+
+// Cassette returns a copy of the interactions recorded so far.
+func (rec *Recorder) Cassette() *Cassette {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	interactions := make([]Interaction, len(rec.cassette.Interactions))
+	copy(interactions, rec.cassette.Interactions)
+	return &Cassette{Interactions: interactions}
+}
+
+// Save writes the interactions recorded so far in a file.
+func (rec *Recorder) Save(path string) error {
+	return rec.Cassette().Save(path)
+}
+
+// A Replayer is an http.RoundTripper that answers with the interactions of
+// a cassette. Each interaction is used once, in the order they were
+// recorded, so repeated requests get the responses in the recorded order.
+type Replayer struct {
+	redactor *redactor
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewReplayer builds a replayer of the cassette, whose requests were
+// recorded redacting the DefaultRedactedFields.
+func NewReplayer(c *Cassette) *Replayer {
+	return NewReplayerRedacting(c, DefaultRedactedFields)
+}
+
+// NewReplayerRedacting builds a replayer of the cassette, whose requests
+// were recorded redacting the given fields.
+func NewReplayerRedacting(c *Cassette, fields []string) *Replayer {
+	return &Replayer{
+		redactor: newRedactor(fields),
+		cassette: c,
+		used:     make([]bool, len(c.Interactions)),
+	}
+}
+
+// RoundTrip answers the request with the first unused interaction matching
+// it. The method, path, query, form and the non volatile headers have to
+// be equal. Returns an error if no interaction matches.
+func (rep *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	record, err := rep.redactor.request(req)
+	if err != nil {
+		return nil, err
+	}
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	for i, interaction := range rep.cassette.Interactions {
+		if rep.used[i] || !matches(interaction.Request, record) {
+			continue
+		}
+		rep.used[i] = true
+		header := make(http.Header)
+		for key, value := range interaction.Response.Headers {
+			header.Set(key, value)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+			StatusCode:    interaction.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(interaction.Response.Body)),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette: no interaction left for %s %s", record.Method, record.Path)
+}
+
+// Unused returns the interactions that were not replayed.
+func (rep *Replayer) Unused() []Interaction {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	var unused []Interaction
+	for i, interaction := range rep.cassette.Interactions {
+		if !rep.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+func matches(recorded, req Request) bool {
+	if recorded.Method != req.Method || !samePath(recorded.Path, req.Path) {
+		return false
+	}
+	if !sameMap(recorded.Form, req.Form) {
+		return false
+	}
+	return sameMap(recorded.Headers, req.Headers)
+}
+
+// samePath compares two paths with their queries, regardless of the order
+// of the query, as the cassettes may be edited by hand.
+func samePath(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ua.Path == ub.Path && ua.Query().Encode() == ub.Query().Encode()
+}
+
+func sameMap(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := b[key]; !ok || value != a[key] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package cassette
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := conntest.NewServer("secret-key", "secret-secret", nil)
+	defer server.Close()
+	client := server.Client()
+	recorder := NewRecorder(nil)
+	client.SetHttpClient(&http.Client{Transport: recorder})
+
+	account, err := client.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := client.GetBook(args.Market("ETHCLP"), args.Type("buy"), args.Page(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := client.CreateOrder(args.Market("ETHCLP"), args.Type("buy"), args.Price("140000"), args.Amount("0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := client.GetOrderStatus(args.Id(order.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-key", "secret-secret", account.Email, account.Name, account.BankAccounts[0].Number} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("the cassette should not contain %q", secret)
+		}
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Interactions) != 4 {
+		t.Fatalf("expected 4 interactions, got %d", len(c.Interactions))
+	}
+	replayer := NewReplayer(c)
+	// the replaying client has other keys and points to the real api
+	replay := conn.NewClient("other-key", "other-secret")
+	replay.SetHttpClient(&http.Client{Transport: replayer})
+
+	replayedAccount, err := replay.GetAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayedAccount.Rate != account.Rate || replayedAccount.Email != Redacted {
+		t.Errorf("unexpected account %v", replayedAccount)
+	}
+	replayedBook, err := replay.GetBook(args.Market("ETHCLP"), args.Type("buy"), args.Page(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayedBook.Data) != len(book.Data) || replayedBook.Data[0] != book.Data[0] {
+		t.Errorf("unexpected book %v", replayedBook.Data)
+	}
+	replayedOrder, err := replay.CreateOrder(args.Amount("0.1"), args.Price("140000"), args.Type("buy"), args.Market("ETHCLP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayedOrder.Id != order.Id {
+		t.Errorf("expected order %s, got %s", order.Id, replayedOrder.Id)
+	}
+	replayedStatus, err := replay.GetOrderStatus(args.Id(order.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayedStatus.Status != status.Status {
+		t.Errorf("expected status %s, got %s", status.Status, replayedStatus.Status)
+	}
+	if unused := replayer.Unused(); len(unused) != 0 {
+		t.Errorf("expected every interaction to be used, left %v", unused)
+	}
+
+	// each interaction is replayed once
+	if _, err := replay.GetAccount(); err == nil {
+		t.Errorf("expected an error replaying an interaction twice")
+	}
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	replay := conn.NewClient("key", "secret")
+	replay.SetHttpClient(&http.Client{Transport: NewReplayer(&Cassette{})})
+	_, err := replay.GetMarkets()
+	if err == nil || !strings.Contains(err.Error(), "no interaction") {
+		t.Errorf("expected a no interaction error, got %v", err)
+	}
+}
+
+func TestBody(t *testing.T) {
+	tests := map[string]string{
+		`{"status":"success"}`: `{"status":"success"}`,
+		"not json":             `"not json"`,
+		"":                     `""`,
+	}
+	for body, expected := range tests {
+		data, err := Body(body).MarshalJSON()
+		if err != nil || string(data) != expected {
+			t.Errorf("expected %s, got %s, %v", expected, data, err)
+		}
+		var decoded Body
+		if err := decoded.UnmarshalJSON(data); err != nil || string(decoded) != body {
+			t.Errorf("expected %q, got %q, %v", body, decoded, err)
+		}
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	r := newRedactor([]string{"address"})
+	body := `{"data":[{"id":12345678901234567891,"amount":0.123456789012345678,"address":"abc"}]}`
+	expected := `{"data":[{"address":"` + Redacted + `","amount":0.123456789012345678,"id":12345678901234567891}]}`
+	if redacted := string(r.body([]byte(body))); redacted != expected {
+		t.Errorf("expected %s, got %s", expected, redacted)
+	}
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/dashboard"
+	"github.com/cryptomkt/cryptomkt-go/export"
+	"github.com/cryptomkt/cryptomkt-go/store"
+)
+
+// newLimiter builds the limiter of the commands that call the API
+// repeatedly.
+var newLimiter = conn.NewDefaultLimiter
+
+var commands = map[string]command{
+	"markets": {
+		usage: "",
+		help:  "list the markets",
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			return c.markets
+		},
+	},
+	"ticker": {
+		usage: "[market]",
+		help:  "show the ticker of a market, or of all of them",
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			return c.ticker
+		},
+	},
+	"book": {
+		usage: "[-page n] [-limit n] market buy|sell",
+		help:  "show a side of the order book of a market",
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			p := pageFlags(set)
+			return func(arguments []string) error { return c.book(p, arguments) }
+		},
+	},
+	"trades": {
+		usage: "[-start yyyy-mm-dd] [-end yyyy-mm-dd] [-page n] [-limit n] [-all] market",
+		help:  "list the trades of a market",
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			p := pageFlags(set)
+			start := set.String("start", "", "first `day` of the trades")
+			end := set.String("end", "", "last `day` of the trades")
+			return func(arguments []string) error { return c.trades(p, *start, *end, arguments) }
+		},
+	},
+	"prices": {
+		usage: "[-side ask|bid] [-page n] [-limit n] market timeframe",
+		help:  "list the candles of a market, the timeframe in minutes",
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			p := pageFlags(set)
+			side := set.String("side", "", "only the candles of a `side`, ask or bid")
+			return func(arguments []string) error { return c.prices(p, *side, arguments) }
+		},
+	},
+	"account": {
+		usage:   "[-banks]",
+		help:    "show the account, or its bank accounts",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			banks := set.Bool("banks", false, "list the bank accounts")
+			return func(arguments []string) error { return c.account(*banks, arguments) }
+		},
+	},
+	"balance": {
+		usage:   "",
+		help:    "show the balance of the wallets",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			return c.balance
+		},
+	},
+	"orders": {
+		usage: "active|executed [-page n] [-limit n] [-all] market\n" +
+			"       cryptomkt orders create market buy|sell amount price\n" +
+			"       cryptomkt orders cancel|status id",
+		help:    "list, create, cancel and follow orders",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			p := pageFlags(set)
+			return func(arguments []string) error { return c.orders(set, p, arguments) }
+		},
+	},
+	"instant": {
+		usage:   "quote|execute market buy|sell amount",
+		help:    "quote or execute an order in the instant exchange",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			return c.instant
+		},
+	},
+	"transactions": {
+		usage:   "[-page n] [-limit n] [-all] currency",
+		help:    "list the transactions of a wallet",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			p := pageFlags(set)
+			return func(arguments []string) error { return c.transactions(p, arguments) }
+		},
+	},
+	"transfer": {
+		usage:   "[-memo memo] currency amount address",
+		help:    "transfer crypto to an address",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			memo := set.String("memo", "", "`memo` of the transfer")
+			return func(arguments []string) error { return c.transfer(*memo, arguments) }
+		},
+	},
+	"deposit": {
+		usage:   "[-voucher file] [-date dd/mm/yyyy] [-tracking-code code] amount bank-account",
+		help:    "notify a deposit of local currency",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			voucher := set.String("voucher", "", "`file` of the voucher, in México, Brasil and the European Union")
+			date := set.String("date", "", "`date` of the deposit, in México")
+			code := set.String("tracking-code", "", "tracking `code` of the deposit, in México")
+			return func(arguments []string) error { return c.deposit(*voucher, *date, *code, arguments) }
+		},
+	},
+	"withdraw": {
+		usage:   "amount bank-account",
+		help:    "withdraw local currency to a bank account",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			return c.withdraw
+		},
+	},
+	"dashboard": {
+		usage:   "[-interval duration] [-depth n] market...",
+		help:    "follow markets, open orders and balances on the terminal",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			interval := set.Duration("interval", 10*time.Second, "`time` between refreshes")
+			depth := set.Int("depth", 10, "`number` of levels of the order book shown")
+			return func(arguments []string) error { return c.dashboard(*interval, *depth, arguments) }
+		},
+	},
+	"sync": {
+		usage:   "[-store file] [-full] [-interval duration] [market...]",
+		help:    "sync the executed orders and the transactions to a local store",
+		private: true,
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			path := set.String("store", "cryptomkt.store", "`file` of the store")
+			full := set.Bool("full", false, "read the whole history, to find changed and deleted records")
+			interval := set.Duration("interval", 0, "sync every `time` until interrupted, instead of once")
+			return func(arguments []string) error { return c.sync(*path, *full, *interval, arguments) }
+		},
+	},
+}
+
+// paging holds the pagination flags of a command.
+type paging struct {
+	page, limit *int
+	all         *bool
+}
+
+func pageFlags(set *flag.FlagSet) paging {
+	return paging{
+		page:  set.Int("page", -1, "`page` to show"),
+		limit: set.Int("limit", 0, "`number` of entries of a page, between 20 and 100"),
+		all:   set.Bool("all", false, "read all the pages, where supported"),
+	}
+}
+
+func (p paging) arguments() []args.Argument {
+	var arguments []args.Argument
+	if *p.page >= 0 {
+		arguments = append(arguments, args.Page(*p.page))
+	}
+	if *p.limit > 0 {
+		arguments = append(arguments, args.Limit(*p.limit))
+	}
+	return arguments
+}
+
+func (c *cli) markets(arguments []string) error {
+	if len(arguments) != 0 {
+		return errUsage
+	}
+	markets, err := c.client.GetMarkets()
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, len(markets))
+	for i, market := range markets {
+		rows[i] = []string{market}
+	}
+	return c.printRows(markets, []string{"market"}, rows...)
+}
+
+func (c *cli) ticker(arguments []string) error {
+	var tickerArgs []args.Argument
+	switch len(arguments) {
+	case 0:
+	case 1:
+		tickerArgs = append(tickerArgs, args.Market(arguments[0]))
+	default:
+		return errUsage
+	}
+	tickers, err := c.client.GetTicker(tickerArgs...)
+	if err != nil {
+		return err
+	}
+	return c.print(tickers, func(format export.Format) error {
+		w := export.NewTickerWriter(c.stdout, format)
+		if err := w.Write(tickers...); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+func (c *cli) book(p paging, arguments []string) error {
+	if len(arguments) != 2 {
+		return errUsage
+	}
+	bookArgs := append(p.arguments(), args.Market(arguments[0]), args.Type(arguments[1]))
+	book, err := c.client.GetBook(bookArgs...)
+	if err != nil {
+		return err
+	}
+	return c.print(book.Data, func(format export.Format) error {
+		w := export.NewBookWriter(c.stdout, format, arguments[0], arguments[1])
+		if err := w.Write(book.Data...); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+func (c *cli) trades(p paging, start, end string, arguments []string) error {
+	if len(arguments) != 1 {
+		return errUsage
+	}
+	tradesArgs := []args.Argument{args.Market(arguments[0])}
+	if start != "" {
+		tradesArgs = append(tradesArgs, args.Start(start))
+	}
+	if end != "" {
+		tradesArgs = append(tradesArgs, args.End(end))
+	}
+	var trades []conn.TradeData
+	if *p.all {
+		var err error
+		if trades, err = c.client.GetTradesAllPages(tradesArgs...); err != nil {
+			return err
+		}
+	} else {
+		page, err := c.client.GetTrades(append(tradesArgs, p.arguments()...)...)
+		if err != nil {
+			return err
+		}
+		trades = page.Data
+	}
+	return c.print(trades, func(format export.Format) error {
+		w := export.NewTradeWriter(c.stdout, format)
+		if err := w.Write(trades...); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+func (c *cli) prices(p paging, side string, arguments []string) error {
+	if len(arguments) != 2 || (side != "" && side != "ask" && side != "bid") {
+		return errUsage
+	}
+	market := arguments[0]
+	pricesArgs := append(p.arguments(), args.Market(market), args.Timeframe(arguments[1]))
+	prices, err := c.client.GetPrices(pricesArgs...)
+	if err != nil {
+		return err
+	}
+	data := prices.Data
+	switch side {
+	case "ask":
+		data.Bid = nil
+	case "bid":
+		data.Ask = nil
+	}
+	return c.print(data, func(format export.Format) error {
+		w := export.NewCandleWriter(c.stdout, format, market, "ask")
+		if err := w.Write(data.Ask...); err != nil {
+			return err
+		}
+		w.SetSide("bid")
+		if err := w.Write(data.Bid...); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+func (c *cli) account(banks bool, arguments []string) error {
+	if len(arguments) != 0 {
+		return errUsage
+	}
+	account, err := c.client.GetAccount()
+	if err != nil {
+		return err
+	}
+	if banks {
+		rows := make([][]string, len(account.BankAccounts))
+		for i, bank := range account.BankAccounts {
+			rows[i] = []string{strconv.Itoa(bank.Id), bank.Bank, bank.Description, bank.Country, bank.Number}
+		}
+		return c.printRows(account.BankAccounts, []string{"id", "bank", "description", "country", "number"}, rows...)
+	}
+	return c.printRows(account, []string{"name", "email", "market_maker", "market_taker"},
+		[]string{account.Name, account.Email, account.Rate.MarketMaker, account.Rate.MarketTaker})
+}
+
+func (c *cli) balance(arguments []string) error {
+	if len(arguments) != 0 {
+		return errUsage
+	}
+	balances, err := c.client.GetBalance()
+	if err != nil {
+		return err
+	}
+	return c.print(balances, func(format export.Format) error {
+		w := export.NewBalanceWriter(c.stdout, format)
+		if err := w.Write(balances...); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+func (c *cli) printOrders(orders ...conn.Order) error {
+	var value interface{} = orders
+	if len(orders) == 1 {
+		value = orders[0]
+	}
+	return c.print(value, func(format export.Format) error {
+		w := export.NewOrderWriter(c.stdout, format)
+		if err := w.Write(orders...); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// orders runs the action in the first argument, with the flags after it.
+func (c *cli) orders(set *flag.FlagSet, p paging, arguments []string) error {
+	if len(arguments) == 0 {
+		return errUsage
+	}
+	action := arguments[0]
+	if err := set.Parse(arguments[1:]); err != nil {
+		return errUsage
+	}
+	arguments = set.Args()
+	switch action {
+	case "active", "executed":
+		if len(arguments) != 1 {
+			return errUsage
+		}
+		market := args.Market(arguments[0])
+		var orders []conn.Order
+		var err error
+		switch {
+		case action == "active" && *p.all:
+			orders, err = c.client.GetActiveOrdersAllPages(market)
+		case action == "executed" && *p.all:
+			orders, err = c.client.GetExecutedOrdersAllPages(market)
+		default:
+			get := c.client.GetActiveOrders
+			if action == "executed" {
+				get = c.client.GetExecutedOrders
+			}
+			var list *conn.OrderList
+			if list, err = get(append(p.arguments(), market)...); err == nil {
+				orders = list.Data
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return c.printOrders(orders...)
+	case "create":
+		if len(arguments) != 4 {
+			return errUsage
+		}
+		market, side, amount, price := arguments[0], arguments[1], arguments[2], arguments[3]
+		if err := c.confirm("Create a %s order of %s at %s in %s?", side, amount, price, market); err != nil {
+			return err
+		}
+		order, err := c.client.CreateOrder(args.Market(market), args.Type(side), args.Amount(amount), args.Price(price))
+		if err != nil {
+			return err
+		}
+		return c.printOrders(*order)
+	case "cancel", "status":
+		if len(arguments) != 1 {
+			return errUsage
+		}
+		call := c.client.GetOrderStatus
+		if action == "cancel" {
+			call = c.client.CancelOrder
+		}
+		order, err := call(args.Id(arguments[0]))
+		if err != nil {
+			return err
+		}
+		return c.printOrders(*order)
+	}
+	return errUsage
+}
+
+func (c *cli) instant(arguments []string) error {
+	if len(arguments) != 4 {
+		return errUsage
+	}
+	action, market, side, amount := arguments[0], arguments[1], arguments[2], arguments[3]
+	instantArgs := []args.Argument{args.Market(market), args.Type(side), args.Amount(amount)}
+	switch action {
+	case "quote":
+		quote, err := c.client.GetInstant(instantArgs...)
+		if err != nil {
+			return err
+		}
+		return c.printRows(quote, []string{"market", "type", "amount", "obtained", "required"},
+			[]string{market, side, amount, formatFloat(quote.Obtained), formatFloat(quote.Required)})
+	case "execute":
+		if err := c.confirm("Execute an instant %s of %s in %s?", side, amount, market); err != nil {
+			return err
+		}
+		if err := c.client.CreateInstant(instantArgs...); err != nil {
+			return err
+		}
+		return c.done("instant " + side + " of " + amount + " in " + market + " executed")
+	}
+	return errUsage
+}
+
+func (c *cli) transactions(p paging, arguments []string) error {
+	if len(arguments) != 1 {
+		return errUsage
+	}
+	currency := arguments[0]
+	var transactions []conn.Transaction
+	if *p.all {
+		var err error
+		if transactions, err = c.client.GetAllTransactions(args.Currency(currency)); err != nil {
+			return err
+		}
+	} else {
+		list, err := c.client.GetTransactions(append(p.arguments(), args.Currency(currency))...)
+		if err != nil {
+			return err
+		}
+		transactions = list.Data
+	}
+	return c.print(transactions, func(format export.Format) error {
+		w := export.NewTransactionWriter(c.stdout, format, currency)
+		if err := w.Write(transactions...); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+func (c *cli) transfer(memo string, arguments []string) error {
+	if len(arguments) != 3 {
+		return errUsage
+	}
+	currency, amount, address := arguments[0], arguments[1], arguments[2]
+	if err := c.confirm("Transfer %s %s to %s?", amount, currency, address); err != nil {
+		return err
+	}
+	transferArgs := []args.Argument{args.Currency(currency), args.Amount(amount), args.Address(address)}
+	if memo != "" {
+		transferArgs = append(transferArgs, args.Memo(memo))
+	}
+	if err := c.client.Transfer(transferArgs...); err != nil {
+		return err
+	}
+	return c.done("transfer of " + amount + " " + currency + " to " + address + " requested")
+}
+
+func (c *cli) deposit(voucher, date, code string, arguments []string) error {
+	if len(arguments) != 2 {
+		return errUsage
+	}
+	amount, bank := arguments[0], arguments[1]
+	if err := c.confirm("Notify a deposit of %s to the bank account %s?", amount, bank); err != nil {
+		return err
+	}
+	depositArgs := []args.Argument{args.Amount(amount), args.BankAccount(bank)}
+	if voucher != "" {
+		depositArgs = append(depositArgs, args.Voucher(voucher))
+	}
+	if date != "" {
+		depositArgs = append(depositArgs, args.Date(date))
+	}
+	if code != "" {
+		depositArgs = append(depositArgs, args.TrackingCode(code))
+	}
+	if err := c.client.RequestDeposit(depositArgs...); err != nil {
+		return err
+	}
+	return c.done("deposit of " + amount + " notified")
+}
+
+func (c *cli) withdraw(arguments []string) error {
+	if len(arguments) != 2 {
+		return errUsage
+	}
+	amount, bank := arguments[0], arguments[1]
+	if err := c.confirm("Withdraw %s to the bank account %s?", amount, bank); err != nil {
+		return err
+	}
+	if err := c.client.RequestWithdrawal(args.Amount(amount), args.BankAccount(bank)); err != nil {
+		return err
+	}
+	return c.done("withdrawal of " + amount + " requested")
+}
+
+func (c *cli) dashboard(interval time.Duration, depth int, arguments []string) error {
+	if len(arguments) == 0 || interval <= 0 || depth <= 0 {
+		return errUsage
+	}
+	board := dashboard.New(c.client, arguments...)
+	board.Interval = interval
+	board.Depth = depth
+	board.SetLimiter(newLimiter())
+	// the keys are read from stdin, which the shell reads after
+	if file, ok := c.in.(*os.File); ok {
+		board.Terminal = file
+	}
+	return board.Run(c.stdin, c.stdout)
+}
+
+func (c *cli) sync(path string, full bool, interval time.Duration, arguments []string) error {
+	if path == "" || interval < 0 {
+		return errUsage
+	}
+	db, err := store.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	syncer := store.NewSyncer(db, c.client)
+	syncer.Markets = arguments
+	syncer.Full = full
+	syncer.SetLimiter(newLimiter())
+	if interval == 0 {
+		report, err := syncer.Sync()
+		if err != nil {
+			return err
+		}
+		return c.done(report.String())
+	}
+	done := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		<-interrupt
+		close(done)
+	}()
+	syncer.Run(interval, done, func(report *store.SyncReport, err error) {
+		if err != nil {
+			fmt.Fprintf(c.stderr, "cryptomkt: %s\n", err)
+			return
+		}
+		c.done(report.String())
+	})
+	return nil
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
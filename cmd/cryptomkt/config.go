@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// defaultConfigName is the name of the config file in the home directory.
+const defaultConfigName = ".cryptomkt.json"
+
+// config holds the credentials of the account.
+type config struct {
+	ApiKey    string `json:"api_key"`
+	ApiSecret string `json:"api_secret"`
+	// BaseUri is the uri of the api, to connect to another server.
+	BaseUri string `json:"base_uri"`
+}
+
+// loadConfig reads the config file at path, or at CRYPTOMKT_CONFIG, or the
+// default one if it exists, and then the environment, which takes
+// precedence.
+func loadConfig(path string, getenv func(string) string) (*config, error) {
+	c := &config{}
+	explicit := true
+	if path == "" {
+		path = getenv("CRYPTOMKT_CONFIG")
+	}
+	if path == "" {
+		explicit = false
+		if home := getenv("HOME"); home != "" {
+			path = filepath.Join(home, defaultConfigName)
+		}
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err) && !explicit:
+		case err != nil:
+			return nil, fmt.Errorf("error reading the config: %s", err)
+		default:
+			if err := json.Unmarshal(data, c); err != nil {
+				return nil, fmt.Errorf("error reading the config %s: %s", path, err)
+			}
+		}
+	}
+	if key := getenv("CRYPTOMKT_API_KEY"); key != "" {
+		c.ApiKey = key
+	}
+	if secret := getenv("CRYPTOMKT_API_SECRET"); secret != "" {
+		c.ApiSecret = secret
+	}
+	if uri := getenv("CRYPTOMKT_BASE_URI"); uri != "" {
+		c.BaseUri = uri
+	}
+	return c, nil
+}
@@ -0,0 +1,181 @@
+// Command cryptomkt calls CryptoMarket from the command line.
+//
+// Usage:
+//
+//	cryptomkt [-o table|json|csv|jsonl|tsv] [-config file] [-y] command [flags] [arguments]
+//
+// The credentials are read from the environment, CRYPTOMKT_API_KEY and
+// CRYPTOMKT_API_SECRET, or from a JSON config file with the keys api_key
+// and api_secret, by default .cryptomkt.json in the home directory. The
+// commands that move money ask for a confirmation, unless -y is given.
+//
+// Run cryptomkt help to list the commands, and cryptomkt shell to run them
+// interactively.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// errUsage is returned by a command called with the wrong arguments.
+var errUsage = errors.New("invalid arguments")
+
+// errCancelled is returned when a confirmation is refused.
+var errCancelled = errors.New("cancelled")
+
+// A command is a subcommand of the tool.
+type command struct {
+	// usage is the arguments of the command, after its name and flags.
+	usage string
+	help  string
+	// private commands need credentials.
+	private bool
+	// flags adds the flags of the command to set, and returns the function
+	// running it with the arguments left.
+	flags func(c *cli, set *flag.FlagSet) func(arguments []string) error
+}
+
+// cli is the state of a run of the tool.
+type cli struct {
+	in     io.Reader
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+
+	format     string
+	yes        bool
+	configPath string
+	config     *config
+	client     *conn.Client
+	// last is the result of the last command printed.
+	last interface{}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv))
+}
+
+// run runs the tool with the arguments given, and returns its exit code: 1
+// on errors, 2 on invalid arguments.
+func run(arguments []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string) int {
+	c := &cli{in: stdin, stdin: bufio.NewReader(stdin), stdout: stdout, stderr: stderr, getenv: getenv}
+	global := flag.NewFlagSet("cryptomkt", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.StringVar(&c.format, "o", "table", "output `format`: table, json, csv, jsonl or tsv")
+	global.StringVar(&c.configPath, "config", "", "config `file` with the credentials")
+	global.BoolVar(&c.yes, "y", false, "do not ask for confirmations")
+	global.Usage = func() { c.usage(global) }
+	if err := global.Parse(arguments); err != nil {
+		return 2
+	}
+	if !validFormat(c.format) {
+		fmt.Fprintf(stderr, "cryptomkt: unknown output format %q\n", c.format)
+		return 2
+	}
+	if global.NArg() == 0 || global.Arg(0) == "help" {
+		c.usage(global)
+		if global.NArg() == 0 {
+			return 2
+		}
+		return 0
+	}
+	return c.execute(global.Args())
+}
+
+// execute parses the flags of the command in the first argument and runs
+// it, and returns its exit code.
+func (c *cli) execute(arguments []string) int {
+	name := arguments[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(c.stderr, "cryptomkt: unknown command %q, run cryptomkt help\n", name)
+		return 2
+	}
+	set := flag.NewFlagSet("cryptomkt "+name, flag.ContinueOnError)
+	set.SetOutput(c.stderr)
+	set.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: cryptomkt %s %s\n\n%s\n", name, cmd.usage, cmd.help)
+		set.PrintDefaults()
+	}
+	runCommand := cmd.flags(c, set)
+	if err := set.Parse(arguments[1:]); err != nil {
+		return 2
+	}
+	if err := c.connect(cmd.private); err != nil {
+		fmt.Fprintf(c.stderr, "cryptomkt: %s\n", err)
+		return 1
+	}
+	err := runCommand(set.Args())
+	switch {
+	case err == errUsage:
+		set.Usage()
+		return 2
+	case err == errCancelled:
+		fmt.Fprintln(c.stderr, "cancelled")
+		return 1
+	case err != nil:
+		fmt.Fprintf(c.stderr, "cryptomkt: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// connect creates the client from the config the first time, and checks
+// there are credentials for the private commands.
+func (c *cli) connect(private bool) error {
+	if c.config == nil {
+		config, err := loadConfig(c.configPath, c.getenv)
+		if err != nil {
+			return err
+		}
+		c.config = config
+		c.client = conn.NewClient(config.ApiKey, config.ApiSecret)
+		if config.BaseUri != "" {
+			c.client.SetBaseUri(config.BaseUri)
+		}
+	}
+	if private && (c.config.ApiKey == "" || c.config.ApiSecret == "") {
+		return errors.New("no credentials, set CRYPTOMKT_API_KEY and CRYPTOMKT_API_SECRET or a config file")
+	}
+	return nil
+}
+
+func (c *cli) usage(global *flag.FlagSet) {
+	fmt.Fprintln(c.stderr, "usage: cryptomkt [flags] command [command flags] [arguments]")
+	fmt.Fprintln(c.stderr, "\nflags:")
+	global.PrintDefaults()
+	fmt.Fprintln(c.stderr, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  %-13s %s\n", name, commands[name].help)
+	}
+}
+
+// confirm asks for a confirmation of an action, and returns errCancelled if
+// it is not given.
+func (c *cli) confirm(format string, a ...interface{}) error {
+	if c.yes {
+		return nil
+	}
+	fmt.Fprintf(c.stderr, format+" [y/N] ", a...)
+	answer, _ := c.stdin.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errCancelled
+}
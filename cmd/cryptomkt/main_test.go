@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+// runWith runs the tool against server, and returns its exit code and
+// outputs.
+func runWith(server *conntest.Server, stdin string, arguments ...string) (int, string, string) {
+	env := map[string]string{
+		"CRYPTOMKT_API_KEY":    "key",
+		"CRYPTOMKT_API_SECRET": "secret",
+		"CRYPTOMKT_BASE_URI":   server.URL,
+	}
+	var stdout, stderr bytes.Buffer
+	code := run(arguments, strings.NewReader(stdin), &stdout, &stderr, func(name string) string { return env[name] })
+	return code, stdout.String(), stderr.String()
+}
+
+func TestOutputs(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	cases := []struct {
+		arguments []string
+		expected  string
+	}{
+		{[]string{"markets"}, "market\nETHCLP\nBTCCLP\nXLMCLP\n"},
+		{[]string{"-o", "csv", "balance"}, "wallet,available,balance\nCLP,1000000,1000000\nETH,2,2.5\nBTC,0.1,0.1\nXLM,1000,1000\n"},
+		{[]string{"-o", "jsonl", "orders", "executed", "ETHCLP"}, `"id":"M99"`},
+		{[]string{"-o", "json", "ticker", "ETHCLP"}, `"Bid": "150000"`},
+		{[]string{"book", "-limit", "20", "ETHCLP", "sell"}, "ETHCLP  sell  150100"},
+		{[]string{"-o", "csv", "transactions", "CLP"}, "CLP,1002,2,"},
+		{[]string{"account", "-banks"}, "Banco de Chile"},
+		{[]string{"instant", "quote", "ETHCLP", "buy", "150100"}, "obtained"},
+		{[]string{"dashboard", "-depth", "5", "ETHCLP"}, "* ETHCLP"},
+	}
+	for _, c := range cases {
+		code, stdout, stderr := runWith(server, "", c.arguments...)
+		if code != 0 {
+			t.Errorf("%v: exit code %d: %s", c.arguments, code, stderr)
+			continue
+		}
+		if !strings.Contains(stdout, c.expected) {
+			t.Errorf("%v: expected %q in the output, got:\n%s", c.arguments, c.expected, stdout)
+		}
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	create := []string{"orders", "create", "ETHCLP", "buy", "0.1", "140000"}
+
+	code, _, stderr := runWith(server, "n\n", create...)
+	if code != 1 || !strings.Contains(stderr, "Create a buy order of 0.1 at 140000 in ETHCLP?") || server.Calls("orders/create") != 0 {
+		t.Errorf("expected the order cancelled, got %d: %s", code, stderr)
+	}
+	code, stdout, _ := runWith(server, "y\n", create...)
+	if code != 0 || server.Calls("orders/create") != 1 || !strings.Contains(stdout, "active") {
+		t.Errorf("expected the order created, got %d: %s", code, stdout)
+	}
+	// no answer is no
+	if code, _, _ := runWith(server, "", "transfer", "ETH", "0.1", "0xabc"); code != 1 || server.Calls("transfer") != 0 {
+		t.Errorf("expected the transfer cancelled, got %d", code)
+	}
+	code, stdout, _ = runWith(server, "", append([]string{"-y", "-o", "json"}, "transfer", "-memo", "m", "ETH", "0.1", "0xabc")...)
+	if code != 0 || server.Calls("transfer") != 1 || !strings.Contains(stdout, `"status": "success"`) {
+		t.Errorf("expected the transfer made, got %d: %s", code, stdout)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	cases := []struct {
+		arguments []string
+		code      int
+	}{
+		{nil, 2},
+		{[]string{"help"}, 0},
+		{[]string{"unknown"}, 2},
+		{[]string{"-o", "xml", "markets"}, 2},
+		{[]string{"book", "ETHCLP"}, 2},
+		{[]string{"orders", "executed"}, 2},
+		{[]string{"dashboard", "-interval", "0s", "ETHCLP"}, 2},
+		{[]string{"book", "ETHCLP", "up"}, 1},
+		{[]string{"orders", "status", "M1"}, 1},
+	}
+	for _, c := range cases {
+		if code, _, stderr := runWith(server, "", c.arguments...); code != c.code {
+			t.Errorf("%v: expected the exit code %d, got %d: %s", c.arguments, c.code, code, stderr)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	defer func(limiter func() *conn.Limiter) { newLimiter = limiter }(newLimiter)
+	newLimiter = func() *conn.Limiter { return conn.NewLimiter(0) }
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "cryptomkt.store")
+	code, stdout, stderr := runWith(server, "", "sync", "-store", path, "ETHCLP")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if strings.HasPrefix(stdout, "0 added") || !strings.Contains(stdout, "added") {
+		t.Errorf("the first sync should add the history, got %q", stdout)
+	}
+	code, stdout, stderr = runWith(server, "", "sync", "-store", path, "-full", "ETHCLP")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "0 added, 0 changed, 0 deleted") {
+		t.Errorf("the second sync should find nothing new, got %q", stdout)
+	}
+	if code, _, _ := runWith(server, "", "sync", "-interval", "-1s"); code != 2 {
+		t.Errorf("a negative interval should be a usage error, got %d", code)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{"api_key": "key", "api_secret": "secret", "base_uri": "`+server.URL+`"}`), 0600)
+
+	var stdout, stderr bytes.Buffer
+	noEnv := func(string) string { return "" }
+	if code := run([]string{"-config", path, "balance"}, strings.NewReader(""), &stdout, &stderr, noEnv); code != 0 {
+		t.Errorf("expected the credentials of the config, got %d: %s", code, stderr.String())
+	}
+	// the home directory has no config
+	home := func(name string) string {
+		if name == "HOME" {
+			return dir
+		}
+		return ""
+	}
+	stderr.Reset()
+	if code := run([]string{"balance"}, strings.NewReader(""), &stdout, &stderr, home); code != 1 || !strings.Contains(stderr.String(), "no credentials") {
+		t.Errorf("expected no credentials, got %d: %s", code, stderr.String())
+	}
+	if code := run([]string{"-config", filepath.Join(dir, "missing.json"), "markets"}, strings.NewReader(""), &stdout, &stderr, noEnv); code != 1 {
+		t.Errorf("expected an error for a missing config, got %d", code)
+	}
+}
+
+func TestShell(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	script := strings.Join([]string{
+		"ticker ETHCLP",
+		"set price 140000",
+		"orders create $market buy 0.1 $price",
+		"n",
+		"orders create $market buy 0.1 $price",
+		"y",
+		"orders status $order",
+		"unset price",
+		"set",
+		"echo $price",
+		"output csv",
+		"balance",
+		"exit",
+		"markets",
+	}, "\n")
+	code, stdout, stderr := runWith(server, script, "shell")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if server.Calls("orders/create") != 1 || server.Calls("orders/status") != 1 || server.Calls("markets") != 0 {
+		t.Errorf("expected one order created and its status, got %d and %d", server.Calls("orders/create"), server.Calls("orders/status"))
+	}
+	for _, expected := range []string{"cryptomkt> ", "$ask 150100\n", "$market ETHCLP\n", "CLP,1000000,1000000\n"} {
+		if !strings.Contains(stdout, expected) {
+			t.Errorf("expected %q in the output, got:\n%s", expected, stdout)
+		}
+	}
+	for _, expected := range []string{"Create a buy order of 0.1 at 140000 in ETHCLP?", "cancelled", "unknown variable $price"} {
+		if !strings.Contains(stderr, expected) {
+			t.Errorf("expected %q in the errors, got:\n%s", expected, stderr)
+		}
+	}
+}
+
+func TestShellDashboard(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	env := map[string]string{
+		"CRYPTOMKT_API_KEY":    "key",
+		"CRYPTOMKT_API_SECRET": "secret",
+		"CRYPTOMKT_BASE_URI":   server.URL,
+	}
+	// the keys come as typed, q without a new line
+	in, typing := io.Pipe()
+	go func() {
+		for _, keys := range []string{"dashboard ETHCLP\n", "q", "\nmarkets\n", "exit\n"} {
+			io.WriteString(typing, keys)
+		}
+		typing.Close()
+	}()
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"shell"}, in, &stdout, &stderr, func(name string) string { return env[name] }); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "* ETHCLP") || !strings.Contains(stdout.String(), "XLMCLP\n") {
+		t.Errorf("expected the dashboard, then the markets, got:\n%s", stdout.String())
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	c := &cli{getenv: func(name string) string {
+		if name == "CRYPTOMKT_BASE_URI" {
+			return server.URL
+		}
+		return ""
+	}}
+	s := &session{c: c, vars: map[string]string{"order": "M1"}}
+	cases := []struct {
+		words    []string
+		word     string
+		expected []string
+	}{
+		{nil, "ou", []string{"output"}},
+		{[]string{"orders"}, "ex", []string{"executed"}},
+		{[]string{"orders"}, "c", []string{"create", "cancel"}},
+		{[]string{"orders", "create"}, "b", []string{"buy", "BTCCLP"}},
+		{[]string{"ticker"}, "eth", []string{"ETHCLP"}},
+		{[]string{"book"}, "-l", []string{"-limit"}},
+		{[]string{"orders", "status"}, "$", []string{"$order"}},
+	}
+	for _, test := range cases {
+		if candidates := s.complete(test.words, test.word); !reflect.DeepEqual(candidates, test.expected) {
+			t.Errorf("%v %q: expected %q, got %q", test.words, test.word, test.expected, candidates)
+		}
+	}
+
+	words, err := splitLine(`transfer -memo "a b" XLM $order 'G$x'`, s.vars)
+	if expected := []string{"transfer", "-memo", "a b", "XLM", "M1", "G$x"}; err != nil || !reflect.DeepEqual(words, expected) {
+		t.Errorf("expected %q, got %q and %v", expected, words, err)
+	}
+}
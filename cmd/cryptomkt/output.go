@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cryptomkt/cryptomkt-go/export"
+)
+
+// validFormat tells if format is json or one of the export formats.
+func validFormat(format string) bool {
+	if format == "json" {
+		return true
+	}
+	_, err := export.ParseFormat(format)
+	return err == nil
+}
+
+// print writes value as indented JSON when the output is json, or else
+// calls write with the export format of the output.
+func (c *cli) print(value interface{}, write func(format export.Format) error) error {
+	c.last = value
+	if c.format == "json" {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.stdout, string(data))
+		return err
+	}
+	format, err := export.ParseFormat(c.format)
+	if err != nil {
+		return err
+	}
+	return write(format)
+}
+
+// printRows writes value, or rows of columns in the export formats.
+func (c *cli) printRows(value interface{}, columns []string, rows ...[]string) error {
+	return c.print(value, func(format export.Format) error {
+		w := export.NewWriter(c.stdout, format, columns)
+		for _, row := range rows {
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	})
+}
+
+// done tells that a command without a result succeeded.
+func (c *cli) done(message string) error {
+	if c.format == "json" {
+		return c.print(map[string]string{"status": "success", "message": message}, nil)
+	}
+	_, err := fmt.Fprintln(c.stdout, message)
+	return err
+}
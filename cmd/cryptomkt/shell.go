@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/internal/term"
+)
+
+// defaultHistoryName is the name of the history file of the shell in the
+// home directory.
+const defaultHistoryName = ".cryptomkt_history"
+
+// maxHistory is the number of lines of history kept.
+const maxHistory = 1000
+
+// builtins are the commands of the shell, besides those of the tool.
+var builtins = map[string]string{
+	"help":    "list the commands, or show the usage of one",
+	"set":     "set a variable, or list them without arguments",
+	"unset":   "remove a variable",
+	"output":  "change the output format: table, json, csv, jsonl or tsv",
+	"history": "list the lines entered",
+	"exit":    "leave the shell",
+}
+
+// the shell is registered here, as it runs the other commands
+func init() {
+	commands["shell"] = command{
+		usage: "",
+		help:  "run commands interactively, with completion, history and variables",
+		flags: func(c *cli, set *flag.FlagSet) func([]string) error {
+			return c.shell
+		},
+	}
+}
+
+// session is the state of a shell.
+type session struct {
+	c      *cli
+	editor *term.Editor
+	vars   map[string]string
+	// markets are those completed, read once.
+	markets []string
+}
+
+func (c *cli) shell(arguments []string) error {
+	if len(arguments) != 0 {
+		return errUsage
+	}
+	s := &session{c: c, vars: make(map[string]string)}
+	s.editor = term.NewEditor(c.stdin, c.stdout)
+	s.editor.Prompt = "cryptomkt> "
+	s.editor.Complete = s.complete
+	if file, ok := c.in.(*os.File); ok {
+		s.editor.Terminal = file
+	}
+	history := historyPath(c.getenv)
+	s.editor.History = loadHistory(history)
+	for {
+		line, err := s.editor.ReadLine()
+		switch {
+		case err == term.ErrInterrupted:
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := appendHistory(history, line); err != nil {
+			fmt.Fprintf(c.stderr, "cryptomkt: %s\n", err)
+		}
+		if !s.run(line) {
+			return nil
+		}
+	}
+}
+
+// run runs a line, and returns false when the shell should be left.
+func (s *session) run(line string) bool {
+	c := s.c
+	words, err := splitLine(line, s.vars)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "cryptomkt: %s\n", err)
+		return true
+	}
+	if len(words) == 0 {
+		return true
+	}
+	switch name, arguments := words[0], words[1:]; name {
+	case "exit", "quit":
+		return false
+	case "help":
+		if len(arguments) == 0 {
+			s.help()
+		} else if _, ok := builtins[arguments[0]]; ok {
+			fmt.Fprintf(c.stdout, "%s: %s\n", arguments[0], builtins[arguments[0]])
+		} else {
+			c.execute([]string{arguments[0], "-h"})
+		}
+	case "set":
+		switch len(arguments) {
+		case 0:
+			names := make([]string, 0, len(s.vars))
+			for name := range s.vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(c.stdout, "$%s %s\n", name, s.vars[name])
+			}
+		case 2:
+			if !validVariable(arguments[0]) {
+				fmt.Fprintf(c.stderr, "cryptomkt: invalid variable name %q\n", arguments[0])
+				break
+			}
+			s.vars[arguments[0]] = arguments[1]
+		default:
+			fmt.Fprintln(c.stderr, "usage: set [name value]")
+		}
+	case "unset":
+		for _, name := range arguments {
+			delete(s.vars, strings.TrimPrefix(name, "$"))
+		}
+	case "output":
+		switch {
+		case len(arguments) == 0:
+			fmt.Fprintln(c.stdout, c.format)
+		case len(arguments) == 1 && validFormat(arguments[0]):
+			c.format = arguments[0]
+		default:
+			fmt.Fprintln(c.stderr, "usage: output [table|json|csv|jsonl|tsv]")
+		}
+	case "shell":
+		fmt.Fprintln(c.stderr, "cryptomkt: already in the shell")
+	case "history":
+		for i, line := range s.editor.History {
+			fmt.Fprintf(c.stdout, "%5d  %s\n", i+1, line)
+		}
+	default:
+		c.last = nil
+		if c.execute(words) == 0 {
+			s.record(c.last)
+		}
+	}
+	return true
+}
+
+func (s *session) help() {
+	fmt.Fprintln(s.c.stdout, "commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		if name != "shell" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.c.stdout, "  %-13s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintln(s.c.stdout, "\nshell commands:")
+	names = names[:0]
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.c.stdout, "  %-13s %s\n", name, builtins[name])
+	}
+	fmt.Fprintln(s.c.stdout, "\n$name is replaced by the variable name. The last results set $last, $order, $market, $transaction, $bid and $ask.")
+}
+
+// record sets the variables from the result of a command.
+func (s *session) record(value interface{}) {
+	switch v := value.(type) {
+	case conn.Order:
+		s.vars["order"], s.vars["market"], s.vars["last"] = v.Id, v.Market, v.Id
+	case []conn.Order:
+		if len(v) > 0 {
+			s.record(v[0])
+		}
+	case []conn.Transaction:
+		if len(v) > 0 {
+			s.vars["transaction"], s.vars["last"] = v[0].Id, v[0].Id
+		}
+	case []conn.Ticker:
+		if len(v) == 1 {
+			s.vars["market"], s.vars["bid"], s.vars["ask"] = v[0].Market, v[0].Bid, v[0].Ask
+		}
+	}
+}
+
+// complete returns the candidates for a word: the commands first, then
+// the flags, the words of the usage and the markets, or the variables.
+func (s *session) complete(words []string, word string) []string {
+	var candidates []string
+	switch {
+	case strings.HasPrefix(word, "$"):
+		for name := range s.vars {
+			candidates = append(candidates, "$"+name)
+		}
+	case len(words) == 0:
+		for name := range commands {
+			if name != "shell" {
+				candidates = append(candidates, name)
+			}
+		}
+		for name := range builtins {
+			candidates = append(candidates, name)
+		}
+	case words[0] == "help":
+		return s.complete(nil, word)
+	case words[0] == "output":
+		candidates = []string{"table", "json", "csv", "jsonl", "tsv"}
+	case words[0] == "unset":
+		for name := range s.vars {
+			candidates = append(candidates, name)
+		}
+	default:
+		cmd, ok := commands[words[0]]
+		if !ok {
+			return nil
+		}
+		if strings.HasPrefix(word, "-") {
+			set := flag.NewFlagSet(words[0], flag.ContinueOnError)
+			cmd.flags(s.c, set)
+			set.VisitAll(func(f *flag.Flag) {
+				candidates = append(candidates, "-"+f.Name)
+			})
+			break
+		}
+		candidates = append(usageWords(cmd.usage), s.marketNames()...)
+	}
+	var matching []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(word)) {
+			matching = append(matching, candidate)
+		}
+	}
+	return matching
+}
+
+// marketNames returns the markets, read the first time they are needed.
+func (s *session) marketNames() []string {
+	if s.markets == nil && s.c.connect(false) == nil {
+		if markets, err := s.c.client.GetMarkets(); err == nil {
+			s.markets = markets
+		}
+	}
+	return s.markets
+}
+
+// usageWords returns the words to type in the usage of a command: the
+// alternatives like buy|sell, and the actions of the other forms.
+func usageWords(usage string) []string {
+	fields := strings.FieldsFunc(usage, func(r rune) bool {
+		return r == ' ' || r == '\n' || r == '[' || r == ']'
+	})
+	var words []string
+	for i := 0; i < len(fields); i++ {
+		switch field := fields[i]; {
+		case field == "cryptomkt" && i+2 < len(fields):
+			words = append(words, strings.Split(fields[i+2], "|")...)
+			i += 2
+		case strings.Contains(field, "|"):
+			words = append(words, strings.Split(field, "|")...)
+		}
+	}
+	return words
+}
+
+// splitLine splits a line in words, separated by spaces unless quoted,
+// replacing the variables outside single quotes.
+func splitLine(line string, vars map[string]string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote, inWord = r, true
+		case quote == 0 && (r == ' ' || r == '\t'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '$' && quote != '\'':
+			j := i + 1
+			for j < len(runes) && isVariableRune(runes[j], j == i+1) {
+				j++
+			}
+			name := string(runes[i+1 : j])
+			if name == "" {
+				word.WriteRune(r)
+				inWord = true
+				break
+			}
+			val, ok := vars[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown variable $%s", name)
+			}
+			word.WriteString(val)
+			inWord = true
+			i = j - 1
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func isVariableRune(r rune, first bool) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || !first && r >= '0' && r <= '9'
+}
+
+func validVariable(name string) bool {
+	for i, r := range name {
+		if !isVariableRune(r, i == 0) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// historyPath returns the path of the history file, CRYPTOMKT_HISTORY or
+// the default one, or "" to keep no history.
+func historyPath(getenv func(string) string) string {
+	if path := getenv("CRYPTOMKT_HISTORY"); path != "" {
+		return path
+	}
+	if home := getenv("HOME"); home != "" {
+		return filepath.Join(home, defaultHistoryName)
+	}
+	return ""
+}
+
+// loadHistory reads the last lines of the history file, if any.
+func loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	return lines
+}
+
+// appendHistory adds a line to the history file.
+func appendHistory(path, line string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error saving the history: %s", err)
+	}
+	if _, err := fmt.Fprintln(file, line); err != nil {
+		file.Close()
+		return fmt.Errorf("error saving the history: %s", err)
+	}
+	return file.Close()
+}
@@ -17,7 +17,7 @@ type BookResponse struct {
 type Book struct {
 	args       map[string]string
 	pagination Pagination
-	client     *Client
+	client     MarketData
 	Data       []BookData
 }
 
@@ -25,7 +25,7 @@ func (book *Book) String() string {
 	var b bytes.Buffer
 	b.WriteString("Book{")
 	b.WriteString("\n\tclient:")
-	b.WriteString(book.client.String())
+	b.WriteString(fmt.Sprint(book.client))
 	b.WriteString("\n\tpagination:")
 	b.WriteString(book.pagination.String())
 	b.WriteString("\n\tdata:")
@@ -79,6 +79,11 @@ func (b *Book) GetNext() (*Book, error) {
 		args.Limit(b.pagination.Limit))
 }
 
+// SetClient sets the client used by the book to get other pages.
+func (b *Book) SetClient(client MarketData) {
+	b.client = client
+}
+
 // GetPage returns the actual page of the request.
 func (b *Book) GetPage() int {
 	return b.pagination.Page
@@ -32,6 +32,9 @@ func (client *Client) GetAccount() (*Account, error) {
 //
 // https://developers.cryptomkt.com/#obtener-balance
 func (client *Client) GetBalance() ([]Balance, error) {
+	if client.paper != nil {
+		return client.paperGetBalance()
+	}
 	resp, err := client.get("balance", requests.NewEmptyReq())
 	if err != nil {
 		return nil, fmt.Errorf("error making the request: %s", err)
@@ -94,6 +97,9 @@ func (client *Client) GetTransactions(arguments ...args.Argument) (*TransactionL
 //   - optional: Page (int), Limit (int)
 // https://developers.cryptomkt.com/#ordenes-activas
 func (client *Client) GetActiveOrders(arguments ...args.Argument) (*OrderList, error) {
+	if client.paper != nil {
+		return client.paperGetOrders("active_orders", arguments...)
+	}
 	required := []string{"market"}
 	req, err := makeReq(required, arguments...)
 	if err != nil {
@@ -129,6 +135,9 @@ func (client *Client) GetActiveOrders(arguments ...args.Argument) (*OrderList, e
 //   - optional: Page (int), Limit (int)
 // https://developers.cryptomkt.com/#ordenes-ejecutadas
 func (client *Client) GetExecutedOrders(arguments ...args.Argument) (*OrderList, error) {
+	if client.paper != nil {
+		return client.paperGetOrders("executed_orders", arguments...)
+	}
 	required := []string{"market"}
 	req, err := makeReq(required, arguments...)
 	if err != nil {
@@ -164,6 +173,9 @@ func (client *Client) GetExecutedOrders(arguments ...args.Argument) (*OrderList,
 //   - optional: none
 // https://developers.cryptomkt.com/#estado-de-orden
 func (client *Client) GetOrderStatus(arguments ...args.Argument) (*Order, error) {
+	if client.paper != nil {
+		return client.paperGetOrderStatus(arguments...)
+	}
 	required := []string{"id"}
 	resp, err := client.getReq("orders/status", "GetOrderStatus", required, arguments...)
 	if err != nil {
@@ -268,6 +280,9 @@ func (client *Client) GetInstant(arguments ...args.Argument) (*Instant, error) {
 //   - optional: none
 // https://developers.cryptomkt.com/#crear-orden
 func (client *Client) CreateOrder(arguments ...args.Argument) (*Order, error) {
+	if client.paper != nil {
+		return client.paperCreateOrder(arguments...)
+	}
 	required := []string{"amount", "market", "price", "type"}
 	resp, err := client.postReq("orders/create", "CreateOrder", required, arguments...)
 	if err != nil {
@@ -291,6 +306,9 @@ func (client *Client) CreateOrder(arguments ...args.Argument) (*Order, error) {
 //   - optional: none
 // https://developers.cryptomkt.com/#cancelar-una-orden
 func (client *Client) CancelOrder(arguments ...args.Argument) (*Order, error) {
+	if client.paper != nil {
+		return client.paperCancelOrder(arguments...)
+	}
 	required := []string{"id"}
 	resp, err := client.postReq("orders/cancel", "CancelOrder", required, arguments...)
 	if err != nil {
@@ -313,6 +331,9 @@ func (client *Client) CancelOrder(arguments ...args.Argument) (*Order, error) {
 //   - optional: none
 // https://developers.cryptomkt.com/#crear-orden-2
 func (client *Client) CreateInstant(arguments ...args.Argument) error {
+	if client.paper != nil {
+		return client.paperCreateInstant(arguments...)
+	}
 	required := []string{"market", "type", "amount"}
 	resp, err := client.postReq("orders/instant/create", "CreateInstant", required, arguments...)
 	if err != nil {
@@ -335,6 +356,9 @@ func (client *Client) CreateInstant(arguments ...args.Argument) error {
 //   - required only for México: Date (string dd/mm/yyyy), TrackingCode (string)
 // https://developers.cryptomkt.com/#notificar-deposito
 func (client *Client) RequestDeposit(arguments ...args.Argument) error {
+	if client.paper != nil {
+		return client.paperRequestDeposit(arguments...)
+	}
 	required := []string{"amount", "bank_account"}
 	resp, err := client.postReq("request/deposit", "RequestDeposit", required, arguments...)
 	if err != nil {
@@ -356,6 +380,9 @@ func (client *Client) RequestDeposit(arguments ...args.Argument) error {
 //   - optional: none
 // https://developers.cryptomkt.com/#notificar-retiro
 func (client *Client) RequestWithdrawal(arguments ...args.Argument) error {
+	if client.paper != nil {
+		return client.paperRequestWithdrawal(arguments...)
+	}
 	required := []string{"amount", "bank_account"}
 	resp, err := client.postReq("request/withdrawal", "RequestWithdrawal", required, arguments...)
 	if err != nil {
@@ -377,6 +404,9 @@ func (client *Client) RequestWithdrawal(arguments ...args.Argument) error {
 //   - optional: Memo (string)
 // https://developers.cryptomkt.com/#transferir
 func (client *Client) Transfer(arguments ...args.Argument) error {
+	if client.paper != nil {
+		return client.paperTransfer(arguments...)
+	}
 	required := []string{"address", "amount", "currency"}
 	resp, err := client.postReq("transfer", "Transfer", required, arguments...)
 	if err != nil {
@@ -31,6 +31,9 @@ var (
 type Client struct {
 	auth       *HMACAuth
 	httpClient *http.Client
+	baseUri    string
+	// paper holds the simulated account when in paper trading mode.
+	paper *paperExchange
 }
 
 func (client *Client) String() string {
@@ -42,10 +45,24 @@ func NewClient(apiKey, apiSecret string) *Client {
 	client := &Client{
 		auth:       newAuth(apiKey, apiSecret),
 		httpClient: &http.Client{},
+		baseUri:    baseApiUri,
 	}
 	return client
 }
 
+// SetBaseUri changes the uri the client connects to, which is
+// https://api.cryptomkt.com/ by default. Useful to connect the client
+// to a fake server in tests.
+func (client *Client) SetBaseUri(uri string) {
+	client.baseUri = uri
+}
+
+// SetHttpClient changes the http client used to make the requests, to
+// configure timeouts, proxies or a custom http.RoundTripper.
+func (client *Client) SetHttpClient(httpClient *http.Client) {
+	client.httpClient = httpClient
+}
+
 // runRequest makes the builded http request to cryptoMarket,
 // and read the response
 func (client *Client) runRequest(httpReq *http.Request) ([]byte, error) {
@@ -65,9 +82,9 @@ func (client *Client) runRequest(httpReq *http.Request) ([]byte, error) {
 // the needed arguments
 func (client *Client) getPublic(endpoint string, request *requests.Request) ([]byte, error) {
 	args := request.GetArguments()
-	u, err := url.Parse(baseApiUri)
+	u, err := url.Parse(client.baseUri)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing url %s: %v", baseApiUri, err)
+		return nil, fmt.Errorf("Error parsing url %s: %v", client.baseUri, err)
 	}
 	u.Path = path.Join(u.Path, apiVersion, endpoint)
 	httpReq, err := http.NewRequest("GET", u.String(), nil)
@@ -88,9 +105,9 @@ func (client *Client) getPublic(endpoint string, request *requests.Request) ([]b
 // the needed headers of the request for an authenticated communication.
 func (client *Client) get(endpoint string, request *requests.Request) ([]byte, error) {
 	args := request.GetArguments()
-	u, err := url.Parse(baseApiUri)
+	u, err := url.Parse(client.baseUri)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing url %s: %v", baseApiUri, err)
+		return nil, fmt.Errorf("Error parsing url %s: %v", client.baseUri, err)
 	}
 	u.Path = path.Join(u.Path, apiVersion, endpoint)
 	httpReq, err := http.NewRequest("GET", u.String(), nil)
@@ -117,9 +134,9 @@ func (client *Client) get(endpoint string, request *requests.Request) ([]byte, e
 func (client *Client) post(endpoint string, request *requests.Request) ([]byte, error) {
 	args := request.GetArguments()
 
-	u, err := url.Parse(baseApiUri)
+	u, err := url.Parse(client.baseUri)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing url %s: %v", baseApiUri, err)
+		return nil, fmt.Errorf("Error parsing url %s: %v", client.baseUri, err)
 	}
 	u.Path = path.Join(u.Path, apiVersion, endpoint)
 
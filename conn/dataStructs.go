@@ -2,6 +2,7 @@ package conn
 
 import (
 	"bytes"
+	"fmt"
 	"strconv"
 )
 
@@ -89,6 +90,19 @@ type Rate struct {
 	MarketTaker string `json:"market_taker"`
 }
 
+// Fees returns the maker and taker fees of the rate, as fractions.
+func (rate Rate) Fees() (maker, taker float64, err error) {
+	maker, err = strconv.ParseFloat(rate.MarketMaker, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid maker fee %q: %s", rate.MarketMaker, err)
+	}
+	taker, err = strconv.ParseFloat(rate.MarketTaker, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid taker fee %q: %s", rate.MarketTaker, err)
+	}
+	return maker, taker, nil
+}
+
 type BankAccount struct {
 	Id          int
 	Bank        string
@@ -0,0 +1,16 @@
+package conn
+
+import "testing"
+
+func TestRateFees(t *testing.T) {
+	maker, taker, err := Rate{MarketMaker: "0.0039", MarketTaker: "0.0068"}.Fees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maker != 0.0039 || taker != 0.0068 {
+		t.Errorf("unexpected fees %v and %v", maker, taker)
+	}
+	if _, _, err := (Rate{MarketMaker: "0.0039"}).Fees(); err == nil {
+		t.Error("a missing taker fee should fail")
+	}
+}
@@ -0,0 +1,53 @@
+package conn
+
+import (
+	"github.com/cryptomkt/cryptomkt-go/args"
+)
+
+// MarketData holds the public calls of CryptoMarket, the ones that read the
+// state of the markets.
+type MarketData interface {
+	GetMarkets() ([]string, error)
+	GetTicker(arguments ...args.Argument) ([]Ticker, error)
+	GetBook(arguments ...args.Argument) (*Book, error)
+	GetTrades(arguments ...args.Argument) (*Trades, error)
+	GetTradesAllPages(arguments ...args.Argument) ([]TradeData, error)
+	GetPrices(arguments ...args.Argument) (*Prices, error)
+}
+
+// Trading holds the calls that create, follow and cancel orders.
+type Trading interface {
+	GetActiveOrders(arguments ...args.Argument) (*OrderList, error)
+	GetActiveOrdersAllPages(arguments ...args.Argument) ([]Order, error)
+	GetExecutedOrders(arguments ...args.Argument) (*OrderList, error)
+	GetExecutedOrdersAllPages(arguments ...args.Argument) ([]Order, error)
+	GetOrderStatus(arguments ...args.Argument) (*Order, error)
+	GetInstant(arguments ...args.Argument) (*Instant, error)
+	CreateOrder(arguments ...args.Argument) (*Order, error)
+	CancelOrder(arguments ...args.Argument) (*Order, error)
+	CreateInstant(arguments ...args.Argument) error
+}
+
+// Wallet holds the calls about the account, its balances and the movements
+// of money in and out of it.
+type Wallet interface {
+	GetAccount() (*Account, error)
+	GetBalance() ([]Balance, error)
+	GetWallets() ([]Balance, error)
+	GetTransactions(arguments ...args.Argument) (*TransactionList, error)
+	GetAllTransactions(arguments ...args.Argument) ([]Transaction, error)
+	RequestDeposit(arguments ...args.Argument) error
+	RequestWithdrawal(arguments ...args.Argument) error
+	Transfer(arguments ...args.Argument) error
+}
+
+// Exchange holds all the calls of CryptoMarket. It is implemented by Client,
+// and lets the code using the sdk substitute it with fakes or simulators.
+type Exchange interface {
+	MarketData
+	Trading
+	Wallet
+}
+
+// Client implements Exchange.
+var _ Exchange = (*Client)(nil)
@@ -0,0 +1,47 @@
+package conn
+
+import (
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/requests"
+)
+
+// fakeTrading implements the calls of Trading used by the orders, the
+// rest panic as the embedded interface is nil.
+type fakeTrading struct {
+	Trading
+	cancelled []string
+}
+
+func (f *fakeTrading) CancelOrder(arguments ...args.Argument) (*Order, error) {
+	req := requests.NewEmptyReq()
+	for _, argument := range arguments {
+		argument(req)
+	}
+	id := req.GetArguments()["id"]
+	f.cancelled = append(f.cancelled, id)
+	return &Order{Id: id, Status: "cancelled"}, nil
+}
+
+func TestOrderWithFakeClient(t *testing.T) {
+	fake := &fakeTrading{}
+	order := &Order{Id: "M1", Status: "active"}
+	order.SetClient(fake)
+	closed, err := order.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed.Status != "cancelled" || len(fake.cancelled) != 1 {
+		t.Errorf("the order should be cancelled through the fake, got %v", closed)
+	}
+
+	oList := &OrderList{Data: []Order{{Id: "M2"}, {Id: "M3"}}}
+	oList.SetClient(fake)
+	if err := oList.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.cancelled) != 3 {
+		t.Errorf("every order of the list should be cancelled, got %v", fake.cancelled)
+	}
+}
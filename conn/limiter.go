@@ -0,0 +1,40 @@
+package conn
+
+import (
+	"sync"
+	"time"
+)
+
+// A Limiter spaces the calls made to CryptoMarket, as too many requests
+// and the ip is blocked. It is safe to share a Limiter between goroutines.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter builds a Limiter that lets one call pass every interval.
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{interval: interval}
+}
+
+// NewDefaultLimiter builds a Limiter that waits DELAY seconds between calls.
+func NewDefaultLimiter() *Limiter {
+	return NewLimiter(time.Duration(DELAY * float64(time.Second)))
+}
+
+// Wait blocks until the next call can be made. A nil Limiter does not wait.
+func (l *Limiter) Wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	wait := l.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	l.next = now.Add(wait + l.interval)
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
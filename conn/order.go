@@ -3,6 +3,7 @@ package conn
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"strconv"
 
 	"github.com/cryptomkt/cryptomkt-go/args"
@@ -25,7 +26,7 @@ type OrderResponse struct {
 }
 
 type OrderList struct {
-	client     *Client
+	client     Trading
 	caller     string
 	market     string
 	Status     string
@@ -35,7 +36,7 @@ type OrderList struct {
 }
 
 type Order struct {
-	client            *Client
+	client            Trading
 	Id                string
 	Status            string
 	Type              string
@@ -55,6 +56,12 @@ type Amount struct {
 	Executed  string
 }
 
+// SetClient sets the client used by the order to close or refresh itself.
+// Useful to attach orders to a fake or simulated exchange.
+func (o *Order) SetClient(client Trading) {
+	o.client = client
+}
+
 // Close closes the calling order, and changes the order to reflect
 // the new state of the order, after being closed.
 // Calls CancelOrder with the asociated client of the order.
@@ -81,6 +88,50 @@ func (o *Order) Refresh() (*Order, error) {
 	return oRefreshed, nil
 }
 
+// Replace changes the price and the amount of the calling order. As there is
+// no way to amend an order in CryptoMarket, the order is cancelled, the cancel
+// is confirmed with GetOrderStatus, and a new order is created at the new price.
+// If part of the order was executed since the calling order was fetched, that
+// quantity is discounted from the new amount, so the exposure is not doubled.
+// Returns the cancelled order and the new one, which is nil if nothing is left
+// to place.
+// https://developers.cryptomkt.com/es/#cancelar-una-orden
+func (o *Order) Replace(newPrice, newAmount string) (*Order, *Order, error) {
+	amount, err := strconv.ParseFloat(newAmount, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Replace order %s failed: invalid amount %s", o.Id, newAmount)
+	}
+	// the cancel may fail because the order got executed meanwhile,
+	// so the status is checked anyway.
+	_, cancelErr := o.client.CancelOrder(args.Id(o.Id))
+	oCancelled, err := o.client.GetOrderStatus(args.Id(o.Id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Replace order %s failed confirming the cancel: %s", o.Id, err)
+	}
+	oCancelled.client = o.client
+	if oCancelled.Status != "cancelled" && oCancelled.Status != "executed" {
+		if cancelErr != nil {
+			return oCancelled, nil, fmt.Errorf("Replace order %s failed: %s", o.Id, cancelErr)
+		}
+		return oCancelled, nil, fmt.Errorf("Replace order %s failed: cancel not confirmed, status is %s", o.Id, oCancelled.Status)
+	}
+	executedInBetween := oCancelled.Amount.executed() - o.Amount.executed()
+	rest := math.Round((amount-executedInBetween)*1e8) / 1e8
+	if rest <= 0 {
+		return oCancelled, nil, nil
+	}
+	oNew, err := o.client.CreateOrder(
+		args.Amount(strconv.FormatFloat(rest, 'f', -1, 64)),
+		args.Market(o.Market),
+		args.Price(newPrice),
+		args.Type(o.Type),
+	)
+	if err != nil {
+		return oCancelled, nil, fmt.Errorf("Replace order %s failed creating the new order: %s", o.Id, err)
+	}
+	return oCancelled, oNew, nil
+}
+
 // Close closes every order in the order list.
 func (oList *OrderList) Close() error {
 	for i, order := range oList.Data {
@@ -155,6 +206,13 @@ func (o *OrderList) GetNext() (*OrderList, error) {
 	return oList, nil
 }
 
+// SetClient sets the client used by the list and its orders to get other
+// pages, close or refresh them.
+func (oList *OrderList) SetClient(client Trading) {
+	oList.client = client
+	oList.setClientInOrders()
+}
+
 func (oList *OrderList) setClientInOrders() {
 	for i, _ := range oList.Data {
 		oList.Data[i].client = oList.client
@@ -166,7 +224,7 @@ func (oList *OrderList) String() string {
 	b.WriteString("OrderList{")
 
 	b.WriteString("\n\tclientAddr:")
-	b.WriteString(oList.client.String())
+	b.WriteString(fmt.Sprint(oList.client))
 
 	b.WriteString("\n\tcaller:")
 	b.WriteString(oList.caller)
@@ -191,7 +249,7 @@ func (order *Order) String() string {
 	b.WriteString("Order{")
 
 	b.WriteString("\n\tclientAddr:")
-	b.WriteString(order.client.String())
+	b.WriteString(fmt.Sprint(order.client))
 
 	b.WriteString("\n\tId:")
 	b.WriteString(order.Id)
@@ -239,3 +297,15 @@ func (amount *Amount) String() string {
 		amount.Executed +
 		"}"
 }
+
+// executed returns the executed quantity of an order. If the server does not
+// give it, it is the difference between the original and remaining amounts.
+func (amount *Amount) executed() float64 {
+	if amount.Executed != "" {
+		executed, _ := strconv.ParseFloat(amount.Executed, 64)
+		return executed
+	}
+	original, _ := strconv.ParseFloat(amount.Original, 64)
+	remaining, _ := strconv.ParseFloat(amount.Remaining, 64)
+	return original - remaining
+}
@@ -2,7 +2,11 @@ package conn
 
 import (
 	"encoding/json"
+	"errors"
 	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/requests"
 )
 
 func TestUnmarshalingOrder(t *testing.T) {
@@ -53,3 +57,95 @@ func TestUnmarshalingOrder(t *testing.T) {
 		t.Errorf("updated at %v", order)
 	}
 }
+
+func TestAmountExecuted(t *testing.T) {
+	amount := Amount{Original: "1.25", Remaining: "1"}
+	if amount.executed() != 0.25 {
+		t.Errorf("executed should be 0.25, got %v", amount.executed())
+	}
+	amount.Executed = "0.5"
+	if amount.executed() != 0.5 {
+		t.Errorf("executed should be 0.5, got %v", amount.executed())
+	}
+}
+
+// replaceTrading is an exchange of a single order, to test Replace.
+type replaceTrading struct {
+	Trading
+	order     Order
+	cancelErr error
+	created   []map[string]string
+}
+
+func argumentsOf(arguments ...args.Argument) map[string]string {
+	req := requests.NewEmptyReq()
+	for _, argument := range arguments {
+		argument(req)
+	}
+	return req.GetArguments()
+}
+
+func (f *replaceTrading) CancelOrder(arguments ...args.Argument) (*Order, error) {
+	if f.cancelErr != nil {
+		return nil, f.cancelErr
+	}
+	if f.order.Status != "active" {
+		return nil, errors.New("order_not_active")
+	}
+	f.order.Status = "cancelled"
+	order := f.order
+	return &order, nil
+}
+
+func (f *replaceTrading) GetOrderStatus(arguments ...args.Argument) (*Order, error) {
+	order := f.order
+	return &order, nil
+}
+
+func (f *replaceTrading) CreateOrder(arguments ...args.Argument) (*Order, error) {
+	argsMap := argumentsOf(arguments...)
+	f.created = append(f.created, argsMap)
+	return &Order{Id: "M2", Status: "active", Price: argsMap["price"], Amount: Amount{Original: argsMap["amount"]}}, nil
+}
+
+func TestReplace(t *testing.T) {
+	cases := []struct {
+		name      string
+		executed  string // executed when the order was read
+		server    Order
+		cancelErr error
+		amount    string
+		// placed is the amount of the new order, "" for none
+		placed string
+		fails  bool
+	}{
+		{"no fill", "0", Order{Status: "active", Amount: Amount{Original: "1", Executed: "0"}}, nil, "0.8", "0.8", false},
+		{"fill between the read and the cancel", "0.2", Order{Status: "active", Amount: Amount{Original: "1", Executed: "0.5"}}, nil, "0.8", "0.5", false},
+		{"cancel failing with the order active", "0", Order{Status: "active", Amount: Amount{Original: "1", Executed: "0"}}, errors.New("server error"), "0.8", "", true},
+		{"order executed before the replace", "0.2", Order{Status: "executed", Amount: Amount{Original: "1", Executed: "1"}}, nil, "0.8", "", false},
+		{"rest below zero", "0", Order{Status: "active", Amount: Amount{Original: "1", Executed: "0.6"}}, nil, "0.5", "", false},
+	}
+	for _, c := range cases {
+		c.server.Id, c.server.Market, c.server.Type = "M1", "ETHCLP", "buy"
+		fake := &replaceTrading{order: c.server, cancelErr: c.cancelErr}
+		order := &Order{Id: "M1", Status: "active", Market: "ETHCLP", Type: "buy", Price: "1000", Amount: Amount{Original: "1", Executed: c.executed}}
+		order.SetClient(fake)
+		cancelled, placed, err := order.Replace("900", c.amount)
+		if (err != nil) != c.fails {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if cancelled == nil || (!c.fails && cancelled.Status == "active") {
+			t.Errorf("%s: expected the old order closed, got %+v", c.name, cancelled)
+		}
+		if c.placed == "" {
+			if placed != nil || len(fake.created) != 0 {
+				t.Errorf("%s: expected no new order, got %v", c.name, fake.created)
+			}
+			continue
+		}
+		if placed == nil || len(fake.created) != 1 || fake.created[0]["amount"] != c.placed || fake.created[0]["price"] != "900" || fake.created[0]["type"] != "buy" {
+			t.Errorf("%s: expected a new order of %s, got %v", c.name, c.placed, fake.created)
+		}
+	}
+}
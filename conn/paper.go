@@ -0,0 +1,571 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+)
+
+// paperTimeLayout is the layout of the dates of the simulated orders,
+// the same used by CryptoMarket.
+const paperTimeLayout = "2006-01-02T15:04:05.999999"
+
+// errNotEnoughBalance is the error given by CryptoMarket when the balance
+// does not cover an order, reproduced by the paper trading mode.
+var errNotEnoughBalance = errors.New("error from the server side: not_enough_balance")
+
+// paperWallet is the simulated balance of a currency.
+type paperWallet struct {
+	available float64
+	balance   float64
+}
+
+// paperExchange keeps the simulated state of a client in paper trading mode.
+type paperExchange struct {
+	mu           sync.Mutex
+	wallets      map[string]*paperWallet
+	orders       map[string]*Order
+	seq          int
+	makerFee     float64
+	takerFee     float64
+	bankAccounts map[string]string
+	// consumed is the amount taken from each entry of the books, by market
+	// and type of book, and by price and timestamp of the entry.
+	consumed map[string]map[string]float64
+	// getBook gets the book entries of a market, of the given type.
+	getBook func(market, bookType string) ([]BookData, error)
+}
+
+// NewPaperClient builds a client in paper trading mode, starting with the
+// given balances per currency, as {"CLP": 100000}.
+//
+// A paper client reads the market data from CryptoMarket, but CreateOrder,
+// CancelOrder, CreateInstant, Transfer, RequestDeposit and RequestWithdrawal
+// are simulated, and never reach the server. Orders are executed against the
+// actual book given by GetBook: the part of an order that crosses the book is
+// executed at once, and the rest is kept active and matched again against the
+// book each time GetBalance, GetActiveOrders, GetExecutedOrders or
+// GetOrderStatus are called. An entry of the book is not used again by the
+// simulation while it stays in the book. GetBalance, GetWallets,
+// GetActiveOrders, GetExecutedOrders and GetOrderStatus return the simulated
+// state.
+func NewPaperClient(apiKey, apiSecret string, balances map[string]float64) *Client {
+	client := NewClient(apiKey, apiSecret)
+	paper := &paperExchange{
+		wallets:      make(map[string]*paperWallet),
+		orders:       make(map[string]*Order),
+		bankAccounts: make(map[string]string),
+		consumed:     make(map[string]map[string]float64),
+	}
+	for currency, amount := range balances {
+		paper.wallets[currency] = &paperWallet{available: amount, balance: amount}
+	}
+	paper.getBook = func(market, bookType string) ([]BookData, error) {
+		book, err := client.GetBook(args.Market(market), args.Type(bookType), args.Limit(100))
+		if err != nil {
+			return nil, err
+		}
+		return book.Data, nil
+	}
+	client.paper = paper
+	return client
+}
+
+// IsPaper tells if the client is in paper trading mode.
+func (client *Client) IsPaper() bool {
+	return client.paper != nil
+}
+
+// SetPaperFees sets the maker and taker fees applied to the simulated
+// executions of a paper client, as fractions like Account.Rate, e.g. 0.0039.
+func (client *Client) SetPaperFees(maker, taker float64) error {
+	if client.paper == nil {
+		return errors.New("client is not in paper trading mode")
+	}
+	client.paper.mu.Lock()
+	defer client.paper.mu.Unlock()
+	client.paper.makerFee = maker
+	client.paper.takerFee = taker
+	return nil
+}
+
+// SetPaperBankAccount registers a bank account of the given currency, to be
+// used in the simulated deposits and withdrawals of a paper client.
+func (client *Client) SetPaperBankAccount(id, currency string) error {
+	if client.paper == nil {
+		return errors.New("client is not in paper trading mode")
+	}
+	client.paper.mu.Lock()
+	defer client.paper.mu.Unlock()
+	client.paper.bankAccounts[id] = currency
+	return nil
+}
+
+// splitMarket splits a market in its base and quote currencies, as
+// "ETHCLP" in "ETH" and "CLP". Quote currencies are three letters long.
+func splitMarket(market string) (string, string, error) {
+	if len(market) < 6 {
+		return "", "", fmt.Errorf("invalid market %s", market)
+	}
+	return market[:len(market)-3], market[len(market)-3:], nil
+}
+
+func formatPaperFloat(val float64) string {
+	return strconv.FormatFloat(math.Round(val*1e8)/1e8, 'f', -1, 64)
+}
+
+func (paper *paperExchange) wallet(currency string) *paperWallet {
+	w, ok := paper.wallets[currency]
+	if !ok {
+		w = &paperWallet{}
+		paper.wallets[currency] = w
+	}
+	return w
+}
+
+// lock reserves an amount of a currency, failing if it is not available.
+func (paper *paperExchange) lock(currency string, amount float64) error {
+	w := paper.wallet(currency)
+	if w.available < amount {
+		return errNotEnoughBalance
+	}
+	w.available -= amount
+	return nil
+}
+
+// paperCreateOrder simulates CreateOrder.
+func (client *Client) paperCreateOrder(arguments ...args.Argument) (*Order, error) {
+	req, err := makeReq([]string{"amount", "market", "price", "type"}, arguments...)
+	if err != nil {
+		return nil, fmt.Errorf("error making the request: Error in CreateOrder: %s", err)
+	}
+	argsMap := req.GetArguments()
+	if argsMap["type"] != "buy" && argsMap["type"] != "sell" {
+		return nil, errors.New("error from the server side: invalid_type")
+	}
+	amount, err := strconv.ParseFloat(argsMap["amount"], 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("error from the server side: invalid_amount")
+	}
+	price, err := strconv.ParseFloat(argsMap["price"], 64)
+	if err != nil || price <= 0 {
+		return nil, errors.New("error from the server side: invalid_price")
+	}
+	base, quote, err := splitMarket(argsMap["market"])
+	if err != nil {
+		return nil, fmt.Errorf("error from the server side: %s", err)
+	}
+
+	paper := client.paper
+	// the book is read before taking the lock, as it comes from the network
+	book, bookErr := paper.getBook(argsMap["market"], bookSide(argsMap["type"]))
+	paper.mu.Lock()
+	defer paper.mu.Unlock()
+	if argsMap["type"] == "buy" {
+		err = paper.lock(quote, amount*price)
+	} else {
+		err = paper.lock(base, amount)
+	}
+	if err != nil {
+		return nil, err
+	}
+	paper.seq++
+	now := time.Now().UTC().Format(paperTimeLayout)
+	order := &Order{
+		client: client,
+		Id:     "P" + strconv.Itoa(paper.seq),
+		Status: "active",
+		Type:   argsMap["type"],
+		Price:  argsMap["price"],
+		Amount: Amount{
+			Original:  argsMap["amount"],
+			Remaining: argsMap["amount"],
+			Executed:  "0",
+		},
+		Market:    argsMap["market"],
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	paper.orders[order.Id] = order
+	// if the book can not be read, the order stays active and is matched
+	// in the next read of the simulated state.
+	if bookErr == nil {
+		paper.match(order, paper.takerFee, book)
+	}
+	copied := *order
+	return &copied, nil
+}
+
+// bookSide returns the type of the book an order of the given type is
+// matched against.
+func bookSide(orderType string) string {
+	if orderType == "sell" {
+		return "buy"
+	}
+	return "sell"
+}
+
+// match executes an active order against the current book of its market,
+// of the opposite side, applying the given fee to the executions.
+func (paper *paperExchange) match(order *Order, fee float64, book []BookData) {
+	bookType := bookSide(order.Type)
+	limit, _ := strconv.ParseFloat(order.Price, 64)
+	remaining, _ := strconv.ParseFloat(order.Amount.Remaining, 64)
+	executed, notional := paper.fillFromBook(order.Market+bookType, order.Type, limit, remaining, book)
+	if executed > 0 {
+		paper.execute(order, executed, notional, fee)
+	}
+}
+
+// activeBooks reads the books needed to match the active orders of a
+// market, all markets if market is empty, by market and type of book. The
+// lock is not held while reading them, as they come from the network.
+func (paper *paperExchange) activeBooks(market string) (map[string][]BookData, error) {
+	needed := make(map[string][2]string)
+	paper.mu.Lock()
+	for _, order := range paper.orders {
+		if order.Status == "active" && (market == "" || order.Market == market) {
+			bookType := bookSide(order.Type)
+			needed[order.Market+bookType] = [2]string{order.Market, bookType}
+		}
+	}
+	paper.mu.Unlock()
+	books := make(map[string][]BookData)
+	for key, book := range needed {
+		data, err := paper.getBook(book[0], book[1])
+		if err != nil {
+			return nil, fmt.Errorf("error getting the book to simulate the order: %s", err)
+		}
+		books[key] = data
+	}
+	return books, nil
+}
+
+// fillFromBook returns the amount of an order that can be executed against
+// the entries of the book of the opposite side, and its cost. The liquidity
+// taken from each entry is remembered, so the same entry is not used twice
+// while it stays in the book.
+func (paper *paperExchange) fillFromBook(bookKey, orderType string, limit, amount float64, book []BookData) (float64, float64) {
+	entries := make([]BookData, len(book))
+	copy(entries, book)
+	sort.SliceStable(entries, func(i, j int) bool {
+		pi, _ := strconv.ParseFloat(entries[i].Price, 64)
+		pj, _ := strconv.ParseFloat(entries[j].Price, 64)
+		if orderType == "buy" {
+			return pi < pj
+		}
+		return pi > pj
+	})
+	// entries that left the book are forgotten
+	consumed := make(map[string]float64)
+	for _, entry := range entries {
+		key := entry.Price + "@" + entry.Timestamp
+		if taken, ok := paper.consumed[bookKey][key]; ok {
+			consumed[key] = taken
+		}
+	}
+	paper.consumed[bookKey] = consumed
+
+	var executed, notional float64
+	for _, entry := range entries {
+		price, _ := strconv.ParseFloat(entry.Price, 64)
+		if (orderType == "buy" && price > limit) || (orderType == "sell" && price < limit) {
+			break
+		}
+		key := entry.Price + "@" + entry.Timestamp
+		entryAmount, _ := strconv.ParseFloat(entry.Amount, 64)
+		fill := math.Min(entryAmount-consumed[key], amount-executed)
+		if fill <= 0 {
+			continue
+		}
+		consumed[key] += fill
+		executed += fill
+		notional += fill * price
+		if amount-executed <= 0 {
+			break
+		}
+	}
+	return executed, notional
+}
+
+// execute applies an execution of an order to the simulated balances. The
+// fee is charged in the currency received.
+func (paper *paperExchange) execute(order *Order, executed, notional, fee float64) {
+	base, quote, _ := splitMarket(order.Market)
+	limit, _ := strconv.ParseFloat(order.Price, 64)
+	if order.Type == "buy" {
+		w := paper.wallet(quote)
+		// the difference between the locked price and the executed one is freed.
+		w.available += executed*limit - notional
+		w.balance -= notional
+		w = paper.wallet(base)
+		w.available += executed * (1 - fee)
+		w.balance += executed * (1 - fee)
+	} else {
+		w := paper.wallet(base)
+		w.balance -= executed
+		w = paper.wallet(quote)
+		w.available += notional * (1 - fee)
+		w.balance += notional * (1 - fee)
+	}
+
+	previous := order.Amount.executed()
+	previousPrice, _ := strconv.ParseFloat(order.ExecutionPrice, 64)
+	remaining, _ := strconv.ParseFloat(order.Amount.Remaining, 64)
+	total := previous + executed
+	avg := (previous*previousPrice + notional) / total
+	now := time.Now().UTC().Format(paperTimeLayout)
+	order.Amount.Remaining = formatPaperFloat(remaining - executed)
+	order.Amount.Executed = formatPaperFloat(total)
+	order.ExecutionPrice = formatPaperFloat(avg)
+	order.AvgExecutionPrice = int(math.Round(avg))
+	order.UpdatedAt = now
+	if remaining-executed <= 1e-8 {
+		order.Status = "executed"
+		order.ExecutedAt = now
+	}
+}
+
+// matchActive matches the active orders of a market, all markets if market
+// is empty, against the books read by activeBooks. Active orders are makers.
+func (paper *paperExchange) matchActive(market string, books map[string][]BookData) {
+	for _, order := range paper.orders {
+		if order.Status != "active" || (market != "" && order.Market != market) {
+			continue
+		}
+		// orders created after the books were read wait for the next match
+		if book, ok := books[order.Market+bookSide(order.Type)]; ok {
+			paper.match(order, paper.makerFee, book)
+		}
+	}
+}
+
+// paperCancelOrder simulates CancelOrder.
+func (client *Client) paperCancelOrder(arguments ...args.Argument) (*Order, error) {
+	req, err := makeReq([]string{"id"}, arguments...)
+	if err != nil {
+		return nil, fmt.Errorf("error making the request: Error in CancelOrder: %s", err)
+	}
+	paper := client.paper
+	paper.mu.Lock()
+	defer paper.mu.Unlock()
+	order, ok := paper.orders[req.GetArguments()["id"]]
+	if !ok {
+		return nil, errors.New("error from the server side: invalid_scope")
+	}
+	if order.Status != "active" {
+		return nil, errors.New("error from the server side: order_not_active")
+	}
+	base, quote, _ := splitMarket(order.Market)
+	remaining, _ := strconv.ParseFloat(order.Amount.Remaining, 64)
+	if order.Type == "buy" {
+		price, _ := strconv.ParseFloat(order.Price, 64)
+		paper.wallet(quote).available += remaining * price
+	} else {
+		paper.wallet(base).available += remaining
+	}
+	order.Status = "cancelled"
+	order.UpdatedAt = time.Now().UTC().Format(paperTimeLayout)
+	copied := *order
+	return &copied, nil
+}
+
+// paperGetOrderStatus simulates GetOrderStatus.
+func (client *Client) paperGetOrderStatus(arguments ...args.Argument) (*Order, error) {
+	req, err := makeReq([]string{"id"}, arguments...)
+	if err != nil {
+		return nil, fmt.Errorf("error making the request: Error in GetOrderStatus: %s", err)
+	}
+	paper := client.paper
+	paper.mu.Lock()
+	order, ok := paper.orders[req.GetArguments()["id"]]
+	if !ok {
+		paper.mu.Unlock()
+		return nil, errors.New("error from the server side: invalid_scope")
+	}
+	active, market, bookType := order.Status == "active", order.Market, bookSide(order.Type)
+	paper.mu.Unlock()
+	var book []BookData
+	if active {
+		if book, err = paper.getBook(market, bookType); err != nil {
+			return nil, fmt.Errorf("error getting the book to simulate the order: %s", err)
+		}
+	}
+	paper.mu.Lock()
+	defer paper.mu.Unlock()
+	if active && order.Status == "active" {
+		paper.match(order, paper.makerFee, book)
+	}
+	copied := *order
+	return &copied, nil
+}
+
+// paperGetOrders simulates GetActiveOrders and GetExecutedOrders. All the
+// orders are given in one page.
+func (client *Client) paperGetOrders(caller string, arguments ...args.Argument) (*OrderList, error) {
+	req, err := makeReq([]string{"market"}, arguments...)
+	if err != nil {
+		return nil, fmt.Errorf("Error in %s: %s", caller, err)
+	}
+	market := req.GetArguments()["market"]
+	paper := client.paper
+	books, err := paper.activeBooks(market)
+	if err != nil {
+		return nil, err
+	}
+	paper.mu.Lock()
+	defer paper.mu.Unlock()
+	paper.matchActive(market, books)
+	orderList := OrderList{
+		client:     client,
+		caller:     caller,
+		market:     market,
+		pagination: Pagination{Limit: 100},
+	}
+	for _, order := range paper.orders {
+		if order.Market != market {
+			continue
+		}
+		if caller == "active_orders" && order.Status == "active" {
+			orderList.Data = append(orderList.Data, *order)
+		}
+		// cancelled orders partially executed are executed orders too
+		if caller == "executed_orders" && order.Status != "active" && order.Amount.executed() > 0 {
+			orderList.Data = append(orderList.Data, *order)
+		}
+	}
+	// newest first, as given by CryptoMarket
+	sort.Slice(orderList.Data, func(i, j int) bool {
+		seqI, _ := strconv.Atoi(orderList.Data[i].Id[1:])
+		seqJ, _ := strconv.Atoi(orderList.Data[j].Id[1:])
+		return seqI > seqJ
+	})
+	orderList.setClientInOrders()
+	return &orderList, nil
+}
+
+// paperGetBalance simulates GetBalance.
+func (client *Client) paperGetBalance() ([]Balance, error) {
+	paper := client.paper
+	books, err := paper.activeBooks("")
+	if err != nil {
+		return nil, err
+	}
+	paper.mu.Lock()
+	defer paper.mu.Unlock()
+	paper.matchActive("", books)
+	balances := make([]Balance, 0, len(paper.wallets))
+	for currency, w := range paper.wallets {
+		balances = append(balances, Balance{
+			Wallet:    currency,
+			Available: formatPaperFloat(w.available),
+			Balance:   formatPaperFloat(w.balance),
+		})
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Wallet < balances[j].Wallet
+	})
+	return balances, nil
+}
+
+// paperCreateInstant simulates CreateInstant, using GetInstant to know the
+// amounts obtained and required.
+func (client *Client) paperCreateInstant(arguments ...args.Argument) error {
+	instant, err := client.GetInstant(arguments...)
+	if err != nil {
+		return err
+	}
+	req, _ := makeReq(nil, arguments...)
+	argsMap := req.GetArguments()
+	base, quote, err := splitMarket(argsMap["market"])
+	if err != nil {
+		return fmt.Errorf("error from the server side: %s", err)
+	}
+	paid, received := quote, base
+	if argsMap["type"] == "sell" {
+		paid, received = base, quote
+	}
+	paper := client.paper
+	paper.mu.Lock()
+	defer paper.mu.Unlock()
+	if err := paper.lock(paid, instant.Required); err != nil {
+		return err
+	}
+	paper.wallet(paid).balance -= instant.Required
+	obtained := instant.Obtained * (1 - paper.takerFee)
+	paper.wallet(received).available += obtained
+	paper.wallet(received).balance += obtained
+	return nil
+}
+
+// paperTransfer simulates Transfer, the amount leaves the wallet.
+func (client *Client) paperTransfer(arguments ...args.Argument) error {
+	req, err := makeReq([]string{"address", "amount", "currency"}, arguments...)
+	if err != nil {
+		return fmt.Errorf("error making the request: Error in Transfer: %s", err)
+	}
+	argsMap := req.GetArguments()
+	return client.paper.withdraw(argsMap["currency"], argsMap["amount"])
+}
+
+// paperRequestDeposit simulates RequestDeposit, crediting the amount to the
+// currency of the bank account at once.
+func (client *Client) paperRequestDeposit(arguments ...args.Argument) error {
+	req, err := makeReq([]string{"amount", "bank_account"}, arguments...)
+	if err != nil {
+		return fmt.Errorf("error making the request: Error in RequestDeposit: %s", err)
+	}
+	argsMap := req.GetArguments()
+	amount, err := strconv.ParseFloat(argsMap["amount"], 64)
+	if err != nil || amount <= 0 {
+		return errors.New("error from the server side: invalid_amount")
+	}
+	paper := client.paper
+	paper.mu.Lock()
+	defer paper.mu.Unlock()
+	currency, ok := paper.bankAccounts[argsMap["bank_account"]]
+	if !ok {
+		return errors.New("error from the server side: Bank account does not exist")
+	}
+	w := paper.wallet(currency)
+	w.available += amount
+	w.balance += amount
+	return nil
+}
+
+// paperRequestWithdrawal simulates RequestWithdrawal.
+func (client *Client) paperRequestWithdrawal(arguments ...args.Argument) error {
+	req, err := makeReq([]string{"amount", "bank_account"}, arguments...)
+	if err != nil {
+		return fmt.Errorf("error making the request: Error in RequestWithdrawal: %s", err)
+	}
+	argsMap := req.GetArguments()
+	client.paper.mu.Lock()
+	currency, ok := client.paper.bankAccounts[argsMap["bank_account"]]
+	client.paper.mu.Unlock()
+	if !ok {
+		return errors.New("error from the server side: Bank account does not exist")
+	}
+	return client.paper.withdraw(currency, argsMap["amount"])
+}
+
+// withdraw takes an amount out of a wallet.
+func (paper *paperExchange) withdraw(currency, amountStr string) error {
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil || amount <= 0 {
+		return errors.New("error from the server side: invalid_amount")
+	}
+	paper.mu.Lock()
+	defer paper.mu.Unlock()
+	if err := paper.lock(currency, amount); err != nil {
+		return err
+	}
+	paper.wallet(currency).balance -= amount
+	return nil
+}
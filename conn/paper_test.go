@@ -0,0 +1,163 @@
+package conn
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+)
+
+// newTestPaperClient builds a paper client over a fixed book.
+func newTestPaperClient(balances map[string]float64, books map[string][]BookData) *Client {
+	client := NewPaperClient("NoKey", "NoSecret", balances)
+	client.paper.getBook = func(market, bookType string) ([]BookData, error) {
+		return books[bookType], nil
+	}
+	return client
+}
+
+func findBalance(balances []Balance, wallet string) Balance {
+	for _, balance := range balances {
+		if balance.Wallet == wallet {
+			return balance
+		}
+	}
+	return Balance{}
+}
+
+func TestPaperOrderFlow(t *testing.T) {
+	books := map[string][]BookData{
+		"sell": {{Price: "1010", Amount: "1"}, {Price: "1000", Amount: "0.5"}},
+		"buy":  {{Price: "990", Amount: "2"}},
+	}
+	client := newTestPaperClient(map[string]float64{"CLP": 10000}, books)
+	order, err := client.CreateOrder(
+		args.Amount("2"),
+		args.Market("ETHCLP"),
+		args.Price("1005"),
+		args.Type("buy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "active" || order.Amount.Executed != "0.5" || order.ExecutionPrice != "1000" {
+		t.Errorf("should execute 0.5 at 1000, got %v", order)
+	}
+	balances, _ := client.GetBalance()
+	if clp := findBalance(balances, "CLP"); clp.Available != "7992.5" || clp.Balance != "9500" {
+		t.Errorf("unexpected CLP balance %v", clp)
+	}
+	if eth := findBalance(balances, "ETH"); eth.Available != "0.5" {
+		t.Errorf("unexpected ETH balance %v", eth)
+	}
+
+	// the book moves and the rest of the order gets executed
+	books["sell"] = []BookData{{Price: "1002", Amount: "3"}}
+	active, err := client.GetActiveOrders(args.Market("ETHCLP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active.Data) != 0 {
+		t.Errorf("the order should be executed, got %v", active.Data)
+	}
+	executed, _ := client.GetExecutedOrders(args.Market("ETHCLP"))
+	if len(executed.Data) != 1 || executed.Data[0].Status != "executed" {
+		t.Fatalf("the order should be executed, got %v", executed.Data)
+	}
+	balances, _ = client.GetBalance()
+	if clp := findBalance(balances, "CLP"); clp.Available != "7997" || clp.Balance != "7997" {
+		t.Errorf("unexpected CLP balance %v", clp)
+	}
+}
+
+func TestPaperCancelAndBalance(t *testing.T) {
+	books := map[string][]BookData{"buy": {{Price: "990", Amount: "2"}}}
+	client := newTestPaperClient(map[string]float64{"ETH": 1}, books)
+	_, err := client.CreateOrder(
+		args.Amount("2"),
+		args.Market("ETHCLP"),
+		args.Price("1000"),
+		args.Type("sell"))
+	if err == nil || !strings.Contains(err.Error(), "not_enough_balance") {
+		t.Errorf("should fail with not_enough_balance, got %v", err)
+	}
+	order, err := client.CreateOrder(
+		args.Amount("1"),
+		args.Market("ETHCLP"),
+		args.Price("1000"),
+		args.Type("sell"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balances, _ := client.GetBalance()
+	if eth := findBalance(balances, "ETH"); eth.Available != "0" || eth.Balance != "1" {
+		t.Errorf("the ETH should be locked, got %v", eth)
+	}
+	order, err = order.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "cancelled" {
+		t.Errorf("order should be cancelled, got %s", order.Status)
+	}
+	balances, _ = client.GetBalance()
+	if eth := findBalance(balances, "ETH"); eth.Available != "1" {
+		t.Errorf("the ETH should be released, got %v", eth)
+	}
+}
+
+func TestPaperTransfers(t *testing.T) {
+	client := newTestPaperClient(map[string]float64{"XLM": 10}, nil)
+	client.SetPaperBankAccount("123", "CLP")
+	if err := client.RequestDeposit(args.Amount("5000"), args.BankAccount("123")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RequestWithdrawal(args.Amount("1000"), args.BankAccount("321")); err == nil {
+		t.Errorf("should fail with an unknown bank account")
+	}
+	if err := client.Transfer(args.Address("GDMX"), args.Amount("4"), args.Currency("XLM")); err != nil {
+		t.Fatal(err)
+	}
+	balances, _ := client.GetBalance()
+	if clp := findBalance(balances, "CLP"); clp.Balance != "5000" {
+		t.Errorf("unexpected CLP balance %v", clp)
+	}
+	if xlm := findBalance(balances, "XLM"); xlm.Balance != "6" {
+		t.Errorf("unexpected XLM balance %v", xlm)
+	}
+}
+
+func TestPaperInvalidType(t *testing.T) {
+	client := newTestPaperClient(map[string]float64{"ETH": 1}, nil)
+	_, err := client.CreateOrder(args.Amount("1"), args.Market("ETHCLP"), args.Price("1000"), args.Type("sel"))
+	if err == nil || len(client.paper.orders) != 0 {
+		t.Errorf("expected an invalid type, got %v", err)
+	}
+}
+
+func TestPaperBookOutsideLock(t *testing.T) {
+	client := newTestPaperClient(map[string]float64{"CLP": 10000}, nil)
+	order, err := client.CreateOrder(args.Amount("1"), args.Market("ETHCLP"), args.Price("1000"), args.Type("buy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reading, release := make(chan struct{}), make(chan struct{})
+	client.paper.getBook = func(market, bookType string) ([]BookData, error) {
+		close(reading)
+		<-release
+		return nil, nil
+	}
+	done := make(chan error)
+	go func() {
+		_, err := client.GetOrderStatus(args.Id(order.Id))
+		done <- err
+	}()
+	<-reading
+	// the other calls go on while the book is read
+	if err := client.SetPaperFees(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -18,7 +18,7 @@ type PricesResponse struct {
 type Prices struct {
 	args       map[string]string
 	pagination Pagination
-	client     *Client
+	client     MarketData
 	Data       DataPrices
 }
 
@@ -64,6 +64,11 @@ func (p *Prices) GetNext() (*Prices, error) {
 		args.Limit(p.pagination.Limit))
 }
 
+// SetClient sets the client used by the prices to get other pages.
+func (p *Prices) SetClient(client MarketData) {
+	p.client = client
+}
+
 // GetPage returns the page you have
 func (p *Prices) GetPage() int {
 	return p.pagination.Page
@@ -18,7 +18,7 @@ type TradesResponse struct {
 type Trades struct {
 	args       map[string]string
 	pagination Pagination
-	client     *Client
+	client     MarketData
 	Data       []TradeData
 }
 
@@ -71,6 +71,11 @@ func (t *Trades) GetNext() (*Trades, error) {
 	return t.client.GetTrades(newArgs...)
 }
 
+// SetClient sets the client used by the trades to get other pages.
+func (t *Trades) SetClient(client MarketData) {
+	t.client = client
+}
+
 // GetPage returns the actual page of the request.
 func (t *Trades) GetPage() int {
 	return t.pagination.Page
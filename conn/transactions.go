@@ -31,7 +31,7 @@ type Transaction struct {
 
 type TransactionList struct {
 	currency   string
-	client     *Client
+	client     Wallet
 	pagination Pagination
 	Data       []Transaction
 }
@@ -68,6 +68,11 @@ func (tList *TransactionList) GetNext() (*TransactionList, error) {
 	return tList, nil
 }
 
+// SetClient sets the client used by the list to get other pages.
+func (tList *TransactionList) SetClient(client Wallet) {
+	tList.client = client
+}
+
 // GetPage returns the actual page.
 func (tList *TransactionList) GetPage() int {
 	return tList.pagination.Page
@@ -0,0 +1,507 @@
+package conntest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// Errors given by the engine, with the messages CryptoMarket answers with.
+var (
+	ErrNotEnoughBalance = errors.New("not_enough_balance")
+	ErrInvalidMarket    = errors.New("invalid_market")
+	ErrInvalidType      = errors.New("invalid_type")
+	ErrInvalidAmount    = errors.New("invalid_amount")
+	ErrInvalidPrice     = errors.New("invalid_price")
+	ErrOrderNotFound    = errors.New("invalid_scope")
+	ErrOrderNotActive   = errors.New("order_not_active")
+)
+
+// timeLayout is the layout of the dates given by CryptoMarket.
+const timeLayout = "2006-01-02T15:04:05.000000"
+
+// restingOrder is an order waiting in a book.
+type restingOrder struct {
+	id        string
+	own       bool // the order belongs to the account
+	orderType string
+	price     float64
+	remaining float64
+	seq       int
+	timestamp string
+}
+
+// orderBook keeps the resting orders of a market in price-time priority,
+// the best price and the oldest order first.
+type orderBook struct {
+	buy  []*restingOrder
+	sell []*restingOrder
+}
+
+func (b *orderBook) side(orderType string) *[]*restingOrder {
+	if orderType == "buy" {
+		return &b.buy
+	}
+	return &b.sell
+}
+
+func (b *orderBook) insert(o *restingOrder) {
+	side := b.side(o.orderType)
+	i := sort.Search(len(*side), func(i int) bool {
+		other := (*side)[i]
+		if other.price != o.price {
+			if o.orderType == "buy" {
+				return other.price < o.price
+			}
+			return other.price > o.price
+		}
+		return other.seq > o.seq
+	})
+	*side = append(*side, nil)
+	copy((*side)[i+1:], (*side)[i:])
+	(*side)[i] = o
+}
+
+func (b *orderBook) remove(o *restingOrder) {
+	side := b.side(o.orderType)
+	for i, other := range *side {
+		if other == o {
+			*side = append((*side)[:i], (*side)[i+1:]...)
+			return
+		}
+	}
+}
+
+// wallet is the balance of a currency of the account.
+type wallet struct {
+	available float64
+	balance   float64
+}
+
+// An Engine simulates the markets of CryptoMarket over a set of fixtures.
+// Each market has a matching engine with price-time priority, where the
+// orders of the account meet the orders of the rest of the market. The
+// engine locks the balance of the open orders of the account, charges the
+// maker and taker fees of Account.Rate in the currency received, and keeps
+// the books, balances, orders, trades and tickers of the fixtures updated.
+//
+// The entries of the books of the fixtures are taken as orders of other
+// participants, and the active orders of the fixtures as orders of the
+// account, whose balances are expected to be already locked.
+//
+// An Engine is not safe for concurrent use, the Server serializes its calls.
+type Engine struct {
+	fixtures *Fixtures
+	makerFee float64
+	takerFee float64
+	books    map[string]*orderBook
+	wallets  map[string]*wallet
+	// currencies keeps the order of the wallets in the balances.
+	currencies []string
+	// orders are the positions of the orders of the account in the fixtures.
+	orders   map[string]int
+	resting  map[string]*restingOrder
+	notional map[string]float64
+	seq      int
+	now      func() time.Time
+}
+
+// NewEngine builds an engine over the given fixtures, which are modified
+// as the orders are matched.
+func NewEngine(fixtures *Fixtures) (*Engine, error) {
+	e := &Engine{
+		fixtures: fixtures,
+		books:    make(map[string]*orderBook),
+		wallets:  make(map[string]*wallet),
+		orders:   make(map[string]int),
+		resting:  make(map[string]*restingOrder),
+		notional: make(map[string]float64),
+		seq:      1000,
+		now:      time.Now,
+	}
+	if fixtures.Books == nil {
+		fixtures.Books = make(map[string]map[string][]conn.BookData)
+	}
+	if fixtures.Trades == nil {
+		fixtures.Trades = make(map[string][]conn.TradeData)
+	}
+	var err error
+	if e.makerFee, err = parseRate(fixtures.Account.Rate.MarketMaker); err != nil {
+		return nil, err
+	}
+	if e.takerFee, err = parseRate(fixtures.Account.Rate.MarketTaker); err != nil {
+		return nil, err
+	}
+	for _, b := range fixtures.Balances {
+		w := &wallet{}
+		if w.available, err = strconv.ParseFloat(b.Available, 64); err != nil {
+			return nil, fmt.Errorf("invalid available balance of %s: %s", b.Wallet, err)
+		}
+		if w.balance, err = strconv.ParseFloat(b.Balance, 64); err != nil {
+			return nil, fmt.Errorf("invalid balance of %s: %s", b.Wallet, err)
+		}
+		e.wallets[b.Wallet] = w
+		e.currencies = append(e.currencies, b.Wallet)
+	}
+	for _, market := range fixtures.Markets {
+		book := &orderBook{}
+		e.books[market] = book
+		for _, orderType := range []string{"buy", "sell"} {
+			for _, entry := range fixtures.Books[market][orderType] {
+				price, err := strconv.ParseFloat(entry.Price, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid price in the %s book of %s: %s", orderType, market, err)
+				}
+				amount, err := strconv.ParseFloat(entry.Amount, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid amount in the %s book of %s: %s", orderType, market, err)
+				}
+				e.seq++
+				book.insert(&restingOrder{
+					id:        "X" + strconv.Itoa(e.seq),
+					orderType: orderType,
+					price:     price,
+					remaining: amount,
+					seq:       e.seq,
+					timestamp: entry.Timestamp,
+				})
+			}
+		}
+	}
+	for i, order := range fixtures.Orders {
+		e.orders[order.Id] = i
+		executed, _ := strconv.ParseFloat(order.Amount.Executed, 64)
+		executionPrice, _ := strconv.ParseFloat(order.ExecutionPrice, 64)
+		e.notional[order.Id] = executed * executionPrice
+		if order.Status != "active" {
+			continue
+		}
+		book, ok := e.books[order.Market]
+		if !ok {
+			return nil, fmt.Errorf("order %s in unknown market %s", order.Id, order.Market)
+		}
+		price, _ := strconv.ParseFloat(order.Price, 64)
+		remaining, _ := strconv.ParseFloat(order.Amount.Remaining, 64)
+		e.seq++
+		o := &restingOrder{
+			id:        order.Id,
+			own:       true,
+			orderType: order.Type,
+			price:     price,
+			remaining: remaining,
+			seq:       e.seq,
+			timestamp: order.CreatedAt,
+		}
+		book.insert(o)
+		e.resting[order.Id] = o
+	}
+	e.syncBooks()
+	return e, nil
+}
+
+// parseRate parses a fee of Account.Rate, an empty fee is no fee.
+func parseRate(rate string) (float64, error) {
+	if rate == "" {
+		return 0, nil
+	}
+	fee, err := strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %s", rate, err)
+	}
+	return fee, nil
+}
+
+// splitMarket splits a market in its base and quote currencies, the quote
+// currency being the last three letters.
+func splitMarket(market string) (string, string) {
+	return market[:len(market)-3], market[len(market)-3:]
+}
+
+func formatAmount(val float64) string {
+	return strconv.FormatFloat(math.Round(val*1e8)/1e8, 'f', -1, 64)
+}
+
+func (e *Engine) wallet(currency string) *wallet {
+	w, ok := e.wallets[currency]
+	if !ok {
+		w = &wallet{}
+		e.wallets[currency] = w
+		e.currencies = append(e.currencies, currency)
+	}
+	return w
+}
+
+func validate(book *orderBook, orderType string, price, amount float64) error {
+	if book == nil {
+		return ErrInvalidMarket
+	}
+	if orderType != "buy" && orderType != "sell" {
+		return ErrInvalidType
+	}
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if price <= 0 {
+		return ErrInvalidPrice
+	}
+	return nil
+}
+
+// CreateOrder places a limit order of the account. The part of the order
+// that crosses the book is executed at once as taker, the rest stays in the
+// book. Returns the order as given by CryptoMarket.
+func (e *Engine) CreateOrder(market, orderType string, price, amount float64) (conn.Order, error) {
+	book := e.books[market]
+	if err := validate(book, orderType, price, amount); err != nil {
+		return conn.Order{}, err
+	}
+	base, quote := splitMarket(market)
+	if orderType == "buy" {
+		w := e.wallet(quote)
+		if w.available < price*amount {
+			return conn.Order{}, ErrNotEnoughBalance
+		}
+		w.available -= price * amount
+	} else {
+		w := e.wallet(base)
+		if w.available < amount {
+			return conn.Order{}, ErrNotEnoughBalance
+		}
+		w.available -= amount
+	}
+	e.seq++
+	now := e.now().UTC().Format(timeLayout)
+	order := conn.Order{
+		Id:     "M" + strconv.Itoa(e.seq),
+		Status: "active",
+		Type:   orderType,
+		Price:  formatAmount(price),
+		Amount: conn.Amount{
+			Original:  formatAmount(amount),
+			Remaining: formatAmount(amount),
+			Executed:  "0",
+		},
+		Market:    market,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	e.fixtures.Orders = append(e.fixtures.Orders, order)
+	e.orders[order.Id] = len(e.fixtures.Orders) - 1
+	incoming := &restingOrder{
+		id:        order.Id,
+		own:       true,
+		orderType: orderType,
+		price:     price,
+		remaining: amount,
+		seq:       e.seq,
+		timestamp: now,
+	}
+	e.match(market, incoming)
+	if incoming.remaining > 0 {
+		book.insert(incoming)
+		e.resting[order.Id] = incoming
+	}
+	e.syncBooks()
+	return e.fixtures.Orders[e.orders[order.Id]], nil
+}
+
+// PlaceExternal places a limit order of another participant of the market,
+// which may execute orders of the account as maker.
+func (e *Engine) PlaceExternal(market, orderType string, price, amount float64) error {
+	book := e.books[market]
+	if err := validate(book, orderType, price, amount); err != nil {
+		return err
+	}
+	e.seq++
+	incoming := &restingOrder{
+		id:        "X" + strconv.Itoa(e.seq),
+		orderType: orderType,
+		price:     price,
+		remaining: amount,
+		seq:       e.seq,
+		timestamp: e.now().UTC().Format(timeLayout),
+	}
+	e.match(market, incoming)
+	if incoming.remaining > 0 {
+		book.insert(incoming)
+	}
+	e.syncBooks()
+	return nil
+}
+
+// CancelOrder cancels an active order of the account, releasing its
+// locked balance.
+func (e *Engine) CancelOrder(id string) (conn.Order, error) {
+	i, ok := e.orders[id]
+	if !ok {
+		return conn.Order{}, ErrOrderNotFound
+	}
+	order := &e.fixtures.Orders[i]
+	o, ok := e.resting[id]
+	if !ok || order.Status != "active" {
+		return conn.Order{}, ErrOrderNotActive
+	}
+	e.books[order.Market].remove(o)
+	e.cancel(o)
+	e.syncBooks()
+	return *order, nil
+}
+
+// cancel cancels an order of the account taken out of the book, releasing
+// its locked balance.
+func (e *Engine) cancel(o *restingOrder) {
+	order := &e.fixtures.Orders[e.orders[o.id]]
+	delete(e.resting, o.id)
+	base, quote := splitMarket(order.Market)
+	if o.orderType == "buy" {
+		e.wallet(quote).available += o.remaining * o.price
+	} else {
+		e.wallet(base).available += o.remaining
+	}
+	order.Status = "cancelled"
+	order.UpdatedAt = e.now().UTC().Format(timeLayout)
+}
+
+// match executes an incoming order against the opposite side of the book,
+// while the prices cross. Orders of the account do not match each other:
+// the resting one is cancelled instead, so the book is never left crossed.
+func (e *Engine) match(market string, incoming *restingOrder) {
+	book := e.books[market]
+	opposite := book.side("buy")
+	if incoming.orderType == "buy" {
+		opposite = book.side("sell")
+	}
+	for i := 0; i < len(*opposite) && incoming.remaining > 0; {
+		resting := (*opposite)[i]
+		if (incoming.orderType == "buy" && resting.price > incoming.price) ||
+			(incoming.orderType == "sell" && resting.price < incoming.price) {
+			break
+		}
+		if resting.own && incoming.own {
+			*opposite = append((*opposite)[:i], (*opposite)[i+1:]...)
+			e.cancel(resting)
+			continue
+		}
+		amount := math.Min(resting.remaining, incoming.remaining)
+		e.fill(market, resting, incoming, amount)
+		if resting.remaining <= 1e-9 {
+			*opposite = append((*opposite)[:i], (*opposite)[i+1:]...)
+			delete(e.resting, resting.id)
+			continue
+		}
+		i++
+	}
+}
+
+// fill executes an amount between a resting order, the maker, and an
+// incoming one, the taker, at the price of the resting order.
+func (e *Engine) fill(market string, maker, taker *restingOrder, amount float64) {
+	price := maker.price
+	maker.remaining -= amount
+	taker.remaining -= amount
+	if maker.own {
+		e.settle(maker, amount, price, e.makerFee)
+	}
+	if taker.own {
+		e.settle(taker, amount, price, e.takerFee)
+	}
+	now := e.now().UTC().Format(timeLayout)
+	e.seq++
+	trade := conn.TradeData{
+		MarketTaker: taker.orderType,
+		Price:       formatAmount(price),
+		Amount:      formatAmount(amount),
+		Tid:         strconv.Itoa(e.seq),
+		Timestamp:   now,
+		Market:      market,
+	}
+	e.fixtures.Trades[market] = append([]conn.TradeData{trade}, e.fixtures.Trades[market]...)
+	for i := range e.fixtures.Tickers {
+		if e.fixtures.Tickers[i].Market == market {
+			e.fixtures.Tickers[i].LastPrice = trade.Price
+			e.fixtures.Tickers[i].Timestamp = now
+		}
+	}
+}
+
+// settle applies an execution of an order of the account to its balances
+// and to the order given by the fixtures.
+func (e *Engine) settle(o *restingOrder, amount, price, fee float64) {
+	i := e.orders[o.id]
+	order := &e.fixtures.Orders[i]
+	base, quote := splitMarket(order.Market)
+	if o.orderType == "buy" {
+		w := e.wallet(quote)
+		// the difference between the locked price and the executed one is freed
+		w.available += amount * (o.price - price)
+		w.balance -= amount * price
+		w = e.wallet(base)
+		w.available += amount * (1 - fee)
+		w.balance += amount * (1 - fee)
+	} else {
+		e.wallet(base).balance -= amount
+		w := e.wallet(quote)
+		w.available += amount * price * (1 - fee)
+		w.balance += amount * price * (1 - fee)
+	}
+	original, _ := strconv.ParseFloat(order.Amount.Original, 64)
+	executed, _ := strconv.ParseFloat(order.Amount.Executed, 64)
+	executed += amount
+	e.notional[o.id] += amount * price
+	avg := e.notional[o.id] / executed
+	now := e.now().UTC().Format(timeLayout)
+	order.Amount.Executed = formatAmount(executed)
+	order.Amount.Remaining = formatAmount(original - executed)
+	order.ExecutionPrice = formatAmount(avg)
+	order.AvgExecutionPrice = int(math.Round(avg))
+	order.UpdatedAt = now
+	if o.remaining <= 1e-9 {
+		order.Status = "executed"
+		order.ExecutedAt = now
+	}
+}
+
+// syncBooks writes the state of the engine in the fixtures.
+func (e *Engine) syncBooks() {
+	for market, book := range e.books {
+		books := map[string][]conn.BookData{}
+		for _, orderType := range []string{"buy", "sell"} {
+			entries := []conn.BookData{}
+			for _, o := range *book.side(orderType) {
+				entries = append(entries, conn.BookData{
+					Price:     formatAmount(o.price),
+					Amount:    formatAmount(o.remaining),
+					Timestamp: o.timestamp,
+				})
+			}
+			books[orderType] = entries
+		}
+		e.fixtures.Books[market] = books
+		for i := range e.fixtures.Tickers {
+			if e.fixtures.Tickers[i].Market != market {
+				continue
+			}
+			if len(book.buy) > 0 {
+				e.fixtures.Tickers[i].Bid = formatAmount(book.buy[0].price)
+			}
+			if len(book.sell) > 0 {
+				e.fixtures.Tickers[i].Ask = formatAmount(book.sell[0].price)
+			}
+		}
+	}
+	balances := make([]conn.Balance, 0, len(e.currencies))
+	for _, currency := range e.currencies {
+		w := e.wallets[currency]
+		balances = append(balances, conn.Balance{
+			Wallet:    currency,
+			Available: formatAmount(w.available),
+			Balance:   formatAmount(w.balance),
+		})
+	}
+	e.fixtures.Balances = balances
+}
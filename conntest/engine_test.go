@@ -0,0 +1,173 @@
+package conntest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+func balanceOf(t *testing.T, client *conn.Client, wallet string) conn.Balance {
+	balances, err := client.GetBalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, balance := range balances {
+		if balance.Wallet == wallet {
+			return balance
+		}
+	}
+	return conn.Balance{}
+}
+
+func TestSimulatedTakerOrder(t *testing.T) {
+	server, err := NewSimulatedServer("key", "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := server.Client()
+
+	order, err := client.CreateOrder(
+		args.Amount("0.8"),
+		args.Market("ETHCLP"),
+		args.Price("150200"),
+		args.Type("buy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "executed" || order.ExecutionPrice != "150137.5" {
+		t.Errorf("order should be executed at 150137.5, got %v", order)
+	}
+	if clp := balanceOf(t, client, "CLP"); clp.Available != "879890" || clp.Balance != "879890" {
+		t.Errorf("unexpected CLP balance %v", clp)
+	}
+	// the taker fee is charged in the ETH received
+	if eth := balanceOf(t, client, "ETH"); eth.Available != "2.79456" || eth.Balance != "3.29456" {
+		t.Errorf("unexpected ETH balance %v", eth)
+	}
+	book, _ := client.GetBook(args.Market("ETHCLP"), args.Type("sell"))
+	if book.Data[0].Price != "150200" || book.Data[0].Amount != "0.2" {
+		t.Errorf("the book should be consumed, got %v", book.Data[0])
+	}
+	trades, _ := client.GetTrades(args.Market("ETHCLP"))
+	if trades.Data[0].Price != "150200" || trades.Data[0].MarketTaker != "buy" {
+		t.Errorf("the executions should be the last trades, got %v", trades.Data[0])
+	}
+	_, err = client.CreateOrder(
+		args.Amount("100"),
+		args.Market("ETHCLP"),
+		args.Price("150000"),
+		args.Type("buy"))
+	if err == nil || !strings.Contains(err.Error(), "not_enough_balance") {
+		t.Errorf("should fail with not_enough_balance, got %v", err)
+	}
+}
+
+func TestSimulatedMakerOrder(t *testing.T) {
+	server, err := NewSimulatedServer("key", "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := server.Client()
+
+	order, err := client.CreateOrder(
+		args.Amount("1"),
+		args.Market("ETHCLP"),
+		args.Price("150050"),
+		args.Type("buy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "active" {
+		t.Fatalf("order should rest in the book, got %v", order)
+	}
+	if clp := balanceOf(t, client, "CLP"); clp.Available != "849950" || clp.Balance != "1000000" {
+		t.Errorf("the CLP of the order should be locked, got %v", clp)
+	}
+	if err := server.PlaceExternalOrder("ETHCLP", "sell", 150000, 0.4); err != nil {
+		t.Fatal(err)
+	}
+	order, err = order.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "active" || order.Amount.Executed != "0.4" || order.Amount.Remaining != "0.6" {
+		t.Errorf("order should be partially executed, got %v", order)
+	}
+	// the maker fee is charged in the ETH received
+	if eth := balanceOf(t, client, "ETH"); eth.Balance != "2.89844" {
+		t.Errorf("unexpected ETH balance %v", eth)
+	}
+	order, err = order.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "cancelled" {
+		t.Errorf("order should be cancelled, got %v", order.Status)
+	}
+	if clp := balanceOf(t, client, "CLP"); clp.Available != "939980" || clp.Balance != "939980" {
+		t.Errorf("the CLP should be released, got %v", clp)
+	}
+	executed, _ := client.GetExecutedOrders(args.Market("ETHCLP"))
+	if len(executed.Data) != 1 {
+		t.Errorf("a cancelled order is not executed, got %v", executed.Data)
+	}
+}
+
+func TestEnginePriceTimePriority(t *testing.T) {
+	engine, err := NewEngine(DefaultFixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := engine.CreateOrder("ETHCLP", "buy", 150000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the older order at the same price is executed first
+	engine.PlaceExternal("ETHCLP", "sell", 150000, 0.5)
+	if got := engine.fixtures.Orders[engine.orders[order.Id]]; got.Amount.Executed != "0" {
+		t.Errorf("the order should wait its turn, got %v", got.Amount)
+	}
+	engine.PlaceExternal("ETHCLP", "sell", 149000, 0.2)
+	if got := engine.fixtures.Orders[engine.orders[order.Id]]; got.Amount.Executed != "0.2" || got.ExecutionPrice != "150000" {
+		t.Errorf("the order should be executed at its price, got %v", got)
+	}
+	if _, err := engine.CancelOrder("M1"); err != ErrOrderNotFound {
+		t.Errorf("should not find the order, got %v", err)
+	}
+}
+
+func TestEngineSelfTrade(t *testing.T) {
+	fixtures := DefaultFixtures()
+	fixtures.Books["ETHCLP"] = nil
+	engine, err := NewEngine(fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sell, err := engine.CreateOrder("ETHCLP", "sell", 150000, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buy, err := engine.CreateOrder("ETHCLP", "buy", 150000, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buy.Status != "active" || buy.Amount.Executed != "0" {
+		t.Errorf("the order should not match its own, got %v", buy)
+	}
+	if got := fixtures.Orders[engine.orders[sell.Id]]; got.Status != "cancelled" {
+		t.Errorf("the resting order should be cancelled, got %v", got)
+	}
+	book := fixtures.Books["ETHCLP"]
+	if book["buy"][0].Price != "150000" || book["sell"][0].Price != "160000" {
+		t.Errorf("the book should not be crossed, got %v", book)
+	}
+	for _, balance := range fixtures.Balances {
+		if balance.Wallet == "ETH" && balance.Available != "2" {
+			t.Errorf("the ETH of the cancelled order should be released, got %v", balance)
+		}
+	}
+}
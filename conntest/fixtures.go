@@ -0,0 +1,145 @@
+package conntest
+
+import (
+	"strconv"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// Fixtures is the data served by a Server. The server modifies its fixtures
+// as orders are created or cancelled.
+type Fixtures struct {
+	Markets []string
+	Tickers []conn.Ticker
+	// Books are the entries of the order books by market and by type,
+	// "buy" or "sell", the best price first.
+	Books map[string]map[string][]conn.BookData
+	// Trades are the trades by market, the newest first.
+	Trades map[string][]conn.TradeData
+	// Prices are the candles by market and by timeframe.
+	Prices       map[string]map[string]conn.DataPrices
+	Account      conn.Account
+	Balances     []conn.Balance
+	Orders       []conn.Order
+	Transactions map[string][]conn.Transaction
+}
+
+// DefaultFixtures returns a small deterministic set of fixtures for the
+// ETHCLP, BTCCLP and XLMCLP markets. The books and the trades of ETHCLP are
+// long enough to have several pages.
+func DefaultFixtures() *Fixtures {
+	timestamp := "2020-01-01T12:00:00.000000"
+	fixtures := &Fixtures{
+		Markets: []string{"ETHCLP", "BTCCLP", "XLMCLP"},
+		Tickers: []conn.Ticker{
+			{High: "152000", Volume: "120.5", Low: "148000", Ask: "150100", Bid: "150000", LastPrice: "150050", Market: "ETHCLP", Timestamp: timestamp},
+			{High: "6100000", Volume: "10.2", Low: "5900000", Ask: "6010000", Bid: "6000000", LastPrice: "6005000", Market: "BTCCLP", Timestamp: timestamp},
+			{High: "52", Volume: "90000", Low: "48", Ask: "51", Bid: "50", LastPrice: "50", Market: "XLMCLP", Timestamp: timestamp},
+		},
+		Books: map[string]map[string][]conn.BookData{
+			"ETHCLP": {
+				"buy":  ladder(150000, -100, 30, "0.5", timestamp),
+				"sell": ladder(150100, 100, 30, "0.5", timestamp),
+			},
+			"BTCCLP": {
+				"buy":  ladder(6000000, -5000, 10, "0.1", timestamp),
+				"sell": ladder(6010000, 5000, 10, "0.1", timestamp),
+			},
+			"XLMCLP": {
+				"buy":  ladder(50, -1, 10, "1000", timestamp),
+				"sell": ladder(51, 1, 10, "1000", timestamp),
+			},
+		},
+		Trades: map[string][]conn.TradeData{},
+		Prices: map[string]map[string]conn.DataPrices{
+			"ETHCLP": {"60": {Ask: candles(150100, 5), Bid: candles(150000, 5)}},
+		},
+		Account: conn.Account{
+			Name:  "John Doe",
+			Email: "john.doe@example.com",
+			Rate:  conn.Rate{MarketMaker: "0.0039", MarketTaker: "0.0068"},
+			BankAccounts: []conn.BankAccount{
+				{Id: 1, Bank: "Banco de Chile", Description: "CLP account", Country: "CL", Number: "1234567"},
+			},
+		},
+		Balances: []conn.Balance{
+			{Wallet: "CLP", Available: "1000000", Balance: "1000000"},
+			{Wallet: "ETH", Available: "2", Balance: "2.5"},
+			{Wallet: "BTC", Available: "0.1", Balance: "0.1"},
+			{Wallet: "XLM", Available: "1000", Balance: "1000"},
+		},
+		Orders: []conn.Order{
+			{
+				Id: "M100", Status: "active", Type: "sell", Price: "160000", Market: "ETHCLP",
+				Amount:    conn.Amount{Original: "0.5", Remaining: "0.5", Executed: "0"},
+				CreatedAt: timestamp, UpdatedAt: timestamp,
+			},
+			{
+				Id: "M99", Status: "executed", Type: "buy", Price: "140000", Market: "ETHCLP",
+				Amount:         conn.Amount{Original: "1", Remaining: "0", Executed: "1"},
+				ExecutionPrice: "140000", AvgExecutionPrice: 140000,
+				CreatedAt: "2020-01-01T10:00:00.000000", UpdatedAt: "2020-01-01T11:00:00.000000", ExecutedAt: "2020-01-01T11:00:00.000000",
+			},
+		},
+		Transactions: map[string][]conn.Transaction{
+			"CLP": {
+				{Id: "1002", Type: 2, Amount: "-140000", FeePercent: "0", FeeAmount: "0", Balance: "1000000", Date: "2020-01-01T11:00:00.000000"},
+				{Id: "1001", Type: 1, Amount: "1140000", FeePercent: "0", FeeAmount: "0", Balance: "1140000", Date: "2020-01-01T09:00:00.000000"},
+			},
+			"ETH": {
+				{Id: "1003", Type: 1, Amount: "1.5", FeePercent: "0", FeeAmount: "0", Balance: "2.5", Date: "2020-01-01T11:00:00.000000"},
+			},
+		},
+	}
+	var trades []conn.TradeData
+	for i := 0; i < 30; i++ {
+		trades = append(trades, conn.TradeData{
+			MarketTaker: []string{"buy", "sell"}[i%2],
+			Price:       strconv.Itoa(150050 - i*10),
+			Amount:      "0.1",
+			Tid:         strconv.Itoa(5000 - i),
+			Timestamp:   "2020-01-01T" + twoDigits(11-i/3) + ":" + twoDigits(59-i) + ":00.000000",
+			Market:      "ETHCLP",
+		})
+	}
+	fixtures.Trades["ETHCLP"] = trades
+	return fixtures
+}
+
+// ladder builds n book entries starting at price, separated by step.
+func ladder(price, step, n int, amount, timestamp string) []conn.BookData {
+	entries := make([]conn.BookData, n)
+	for i := range entries {
+		entries[i] = conn.BookData{
+			Price:     strconv.Itoa(price + i*step),
+			Amount:    amount,
+			Timestamp: timestamp,
+		}
+	}
+	return entries
+}
+
+// candles builds n hourly candles around price, the newest first.
+func candles(price, n int) []conn.Candle {
+	result := make([]conn.Candle, n)
+	for i := range result {
+		result[i] = conn.Candle{
+			CandleId:   1000 - i,
+			OpenPrice:  strconv.Itoa(price - 100),
+			HightPrice: strconv.Itoa(price + 200),
+			ClosePrice: strconv.Itoa(price),
+			LowPrice:   strconv.Itoa(price - 200),
+			VolumeSum:  "10",
+			CandleDate: "2020-01-01 " + twoDigits(12-i) + ":00:00",
+			TickCount:  "25",
+		}
+	}
+	return result
+}
+
+func twoDigits(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
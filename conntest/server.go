@@ -0,0 +1,634 @@
+// Package conntest provides an in-process fake of CryptoMarket, to test code
+// using the sdk without network access nor a real account.
+//
+// The Server implements the v1 REST endpoints over net/http/httptest, checks
+// the X-MKT-* authentication headers of the private endpoints, serves a set
+// of Fixtures and lets the tests inject faults, as rate limiting, server
+// errors or malformed json. A simulated Server goes further, matching the
+// orders of the account in an Engine.
+package conntest
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// A Fault is an error the server can be asked to give instead of the
+// expected response of an endpoint.
+type Fault int
+
+const (
+	// RateLimited answers with a 429 status, as when too many requests are made.
+	RateLimited Fault = iota + 1
+	// ServerError answers with a 500 status.
+	ServerError
+	// MalformedJSON answers with a 200 status and a body that is not json.
+	MalformedJSON
+)
+
+// timestampWindow is how old or how far in the future the X-MKT-TIMESTAMP
+// header can be.
+const timestampWindow = 60 * time.Second
+
+// privateEndpoints are the endpoints that need authentication headers.
+var privateEndpoints = map[string]bool{
+	"account":               true,
+	"balance":               true,
+	"transactions":          true,
+	"transfer":              true,
+	"orders/active":         true,
+	"orders/executed":       true,
+	"orders/status":         true,
+	"orders/create":         true,
+	"orders/cancel":         true,
+	"orders/instant/create": true,
+	"request/deposit":       true,
+	"request/withdrawal":    true,
+}
+
+// postEndpoints are the endpoints called with the post method.
+var postEndpoints = map[string]bool{
+	"transfer":              true,
+	"orders/create":         true,
+	"orders/cancel":         true,
+	"orders/instant/create": true,
+	"request/deposit":       true,
+	"request/withdrawal":    true,
+}
+
+type injectedFault struct {
+	fault Fault
+	// times left to give the fault, negative means forever.
+	times int
+}
+
+// A Server is a fake CryptoMarket running in process.
+type Server struct {
+	// URL is the base uri of the server, to be given to Client.SetBaseUri.
+	URL string
+
+	apiKey    string
+	apiSecret string
+	server    *httptest.Server
+
+	routes map[string]http.HandlerFunc
+
+	mu       sync.Mutex
+	fixtures *Fixtures
+	engine   *Engine
+	faults   map[string]*injectedFault
+	calls    map[string]int
+	orderSeq int
+}
+
+// NewServer starts a server serving the given fixtures, or DefaultFixtures
+// if nil. The private endpoints accept only the given keys. The server must
+// be closed with Close.
+func NewServer(apiKey, apiSecret string, fixtures *Fixtures) *Server {
+	if fixtures == nil {
+		fixtures = DefaultFixtures()
+	}
+	s := &Server{
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		fixtures:  fixtures,
+		faults:    make(map[string]*injectedFault),
+		calls:     make(map[string]int),
+		orderSeq:  1000,
+	}
+	s.routes = s.handlers()
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL + "/"
+	return s
+}
+
+// NewSimulatedServer starts a server backed by an Engine over the given
+// fixtures, or DefaultFixtures if nil. Orders created through orders/create
+// are matched against the books, locking and settling the balances of the
+// account, instead of just being stored.
+func NewSimulatedServer(apiKey, apiSecret string, fixtures *Fixtures) (*Server, error) {
+	if fixtures == nil {
+		fixtures = DefaultFixtures()
+	}
+	engine, err := NewEngine(fixtures)
+	if err != nil {
+		return nil, fmt.Errorf("error building the engine: %s", err)
+	}
+	s := NewServer(apiKey, apiSecret, fixtures)
+	s.engine = engine
+	return s, nil
+}
+
+// PlaceExternalOrder places an order of another participant of a market in
+// a simulated server, which may execute the orders of the account.
+func (s *Server) PlaceExternalOrder(market, orderType string, price, amount float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.engine == nil {
+		return fmt.Errorf("the server is not simulated")
+	}
+	return s.engine.PlaceExternal(market, orderType, price, amount)
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client builds a client with the keys of the server, connected to it.
+func (s *Server) Client() *conn.Client {
+	client := conn.NewClient(s.apiKey, s.apiSecret)
+	client.SetBaseUri(s.URL)
+	return client
+}
+
+// Fixtures gives access to the fixtures of the server. They must not be
+// modified while requests are being served.
+func (s *Server) Fixtures() *Fixtures {
+	return s.fixtures
+}
+
+// InjectFault makes the next times calls to the endpoint, as "orders/create",
+// fail with the given fault. If times is zero or negative, the fault is given
+// until ClearFaults is called.
+func (s *Server) InjectFault(endpoint string, fault Fault, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if times <= 0 {
+		times = -1
+	}
+	s.faults[endpoint] = &injectedFault{fault: fault, times: times}
+}
+
+// ClearFaults removes all the injected faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = make(map[string]*injectedFault)
+}
+
+// Calls returns the number of requests received by an endpoint.
+func (s *Server) Calls(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// handle serves all the requests to the server.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/v1/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[endpoint]++
+
+	if f, ok := s.faults[endpoint]; ok && f.times != 0 {
+		if f.times > 0 {
+			f.times--
+		}
+		writeFault(w, f.fault)
+		return
+	}
+	if postEndpoints[endpoint] != (r.Method == http.MethodPost) {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_method")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if privateEndpoints[endpoint] {
+		if err := s.authenticate(r, endpoint); err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+	}
+	handler, ok := s.routes[endpoint]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	handler(w, r)
+}
+
+func (s *Server) handlers() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"market":                s.handleMarket,
+		"ticker":                s.handleTicker,
+		"book":                  s.handleBook,
+		"trades":                s.handleTrades,
+		"prices":                s.handlePrices,
+		"account":               s.handleAccount,
+		"balance":               s.handleBalance,
+		"transactions":          s.handleTransactions,
+		"orders/active":         s.handleOrders("active"),
+		"orders/executed":       s.handleOrders("executed"),
+		"orders/status":         s.handleOrderStatus,
+		"orders/create":         s.handleCreateOrder,
+		"orders/cancel":         s.handleCancelOrder,
+		"orders/instant/create": s.handleCreateInstant,
+		"transfer":              s.handleTransfer,
+		"request/deposit":       s.handleBankRequest,
+		"request/withdrawal":    s.handleBankRequest,
+	}
+}
+
+// authenticate checks the X-MKT-* headers of a request, as described in
+// https://developers.cryptomkt.com/#api-key
+func (s *Server) authenticate(r *http.Request, endpoint string) error {
+	if r.Header.Get("X-MKT-APIKEY") != s.apiKey {
+		return fmt.Errorf("invalid_api_key")
+	}
+	timestamp := r.Header.Get("X-MKT-TIMESTAMP")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid_timestamp")
+	}
+	if diff := time.Since(time.Unix(seconds, 0)); diff > timestampWindow || diff < -timestampWindow {
+		return fmt.Errorf("invalid_timestamp")
+	}
+	// the body of the signature are the values of the form, sorted by key
+	var body strings.Builder
+	if r.Method == http.MethodPost {
+		keys := make([]string, 0, len(r.PostForm))
+		for k := range r.PostForm {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			body.WriteString(r.PostForm.Get(k))
+		}
+	}
+	h := hmac.New(sha512.New384, []byte(s.apiSecret))
+	h.Write([]byte(timestamp + "/v1/" + endpoint + body.String()))
+	signature, err := hex.DecodeString(r.Header.Get("X-MKT-SIGNATURE"))
+	if err != nil || !hmac.Equal(signature, h.Sum(nil)) {
+		return fmt.Errorf("invalid_signature")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"status":  "error",
+		"message": message,
+	})
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+func writePage(w http.ResponseWriter, data interface{}, pagination map[string]interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "success",
+		"pagination": pagination,
+		"data":       data,
+	})
+}
+
+func writeFault(w http.ResponseWriter, fault Fault) {
+	switch fault {
+	case RateLimited:
+		writeError(w, http.StatusTooManyRequests, "too_many_requests")
+	case MalformedJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status": "success", "data": [`))
+	default:
+		writeError(w, http.StatusInternalServerError, "internal_server_error")
+	}
+}
+
+// paginate reads the page and limit arguments, and returns the bounds of
+// the page in a list of the given length, and the pagination to answer.
+func paginate(r *http.Request, length int) (int, int, map[string]interface{}, error) {
+	page, limit := 0, 20
+	var err error
+	if v := r.Form.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 0 {
+			return 0, 0, nil, fmt.Errorf("invalid_page")
+		}
+	}
+	if v := r.Form.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 20 || limit > 100 {
+			return 0, 0, nil, fmt.Errorf("invalid_limit")
+		}
+	}
+	start := page * limit
+	if start > length {
+		start = length
+	}
+	end := start + limit
+	if end > length {
+		end = length
+	}
+	pagination := map[string]interface{}{
+		"previous": nil,
+		"next":     nil,
+		"limit":    limit,
+		"page":     page,
+	}
+	if page > 0 {
+		pagination["previous"] = page - 1
+	}
+	if end < length {
+		pagination["next"] = page + 1
+	}
+	return start, end, pagination, nil
+}
+
+// required checks the presence of the arguments of a request.
+func required(w http.ResponseWriter, r *http.Request, names ...string) bool {
+	for _, name := range names {
+		if r.Form.Get(name) == "" {
+			writeError(w, http.StatusBadRequest, "missing_"+name)
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) handleMarket(w http.ResponseWriter, r *http.Request) {
+	writeData(w, s.fixtures.Markets)
+}
+
+func (s *Server) handleTicker(w http.ResponseWriter, r *http.Request) {
+	market := r.Form.Get("market")
+	tickers := make([]conn.Ticker, 0, len(s.fixtures.Tickers))
+	for _, ticker := range s.fixtures.Tickers {
+		if market == "" || ticker.Market == market {
+			tickers = append(tickers, ticker)
+		}
+	}
+	if len(tickers) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_market")
+		return
+	}
+	writeData(w, toWireTickers(tickers))
+}
+
+func (s *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "market", "type") {
+		return
+	}
+	books, ok := s.fixtures.Books[r.Form.Get("market")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_market")
+		return
+	}
+	entries := books[r.Form.Get("type")]
+	start, end, pagination, err := paginate(r, len(entries))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writePage(w, toWireBook(entries[start:end]), pagination)
+}
+
+func (s *Server) handleTrades(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "market") {
+		return
+	}
+	startDate, endDate := r.Form.Get("start"), r.Form.Get("end")
+	var trades []conn.TradeData
+	for _, trade := range s.fixtures.Trades[r.Form.Get("market")] {
+		date := trade.Timestamp
+		if len(date) > 10 {
+			date = date[:10]
+		}
+		if (startDate != "" && date < startDate) || (endDate != "" && date > endDate) {
+			continue
+		}
+		trades = append(trades, trade)
+	}
+	start, end, pagination, err := paginate(r, len(trades))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writePage(w, toWireTrades(trades[start:end]), pagination)
+}
+
+func (s *Server) handlePrices(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "market", "timeframe") {
+		return
+	}
+	prices := s.fixtures.Prices[r.Form.Get("market")][r.Form.Get("timeframe")]
+	length := len(prices.Ask)
+	if len(prices.Bid) > length {
+		length = len(prices.Bid)
+	}
+	start, end, pagination, err := paginate(r, length)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	clip := func(candles []conn.Candle) []conn.Candle {
+		if start > len(candles) {
+			return nil
+		}
+		if end > len(candles) {
+			return candles[start:]
+		}
+		return candles[start:end]
+	}
+	writePage(w, wirePrices{
+		Ask: toWireCandles(clip(prices.Ask)),
+		Bid: toWireCandles(clip(prices.Bid)),
+	}, pagination)
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	writeData(w, toWireAccount(s.fixtures.Account))
+}
+
+func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
+	writeData(w, toWireBalances(s.fixtures.Balances))
+}
+
+func (s *Server) handleTransactions(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "currency") {
+		return
+	}
+	transactions := s.fixtures.Transactions[r.Form.Get("currency")]
+	start, end, pagination, err := paginate(r, len(transactions))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writePage(w, toWireTransactions(transactions[start:end]), pagination)
+}
+
+// handleOrders serves the active or executed orders of a market.
+func (s *Server) handleOrders(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !required(w, r, "market") {
+			return
+		}
+		var orders []conn.Order
+		for _, order := range s.fixtures.Orders {
+			if order.Market == r.Form.Get("market") && order.Status == status {
+				orders = append(orders, order)
+			}
+		}
+		start, end, pagination, err := paginate(r, len(orders))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writePage(w, toWireOrders(orders[start:end]), pagination)
+	}
+}
+
+// findOrder returns the index of an order in the fixtures, -1 if missing.
+func (s *Server) findOrder(id string) int {
+	for i, order := range s.fixtures.Orders {
+		if order.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) handleOrderStatus(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "id") {
+		return
+	}
+	i := s.findOrder(r.Form.Get("id"))
+	if i < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_scope")
+		return
+	}
+	writeData(w, toWireOrder(s.fixtures.Orders[i]))
+}
+
+func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "amount", "market", "price", "type") {
+		return
+	}
+	if amount, err := strconv.ParseFloat(r.Form.Get("amount"), 64); err != nil || amount <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_amount")
+		return
+	}
+	if price, err := strconv.ParseFloat(r.Form.Get("price"), 64); err != nil || price <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_price")
+		return
+	}
+	if s.engine != nil {
+		price, _ := strconv.ParseFloat(r.Form.Get("price"), 64)
+		amount, _ := strconv.ParseFloat(r.Form.Get("amount"), 64)
+		order, err := s.engine.CreateOrder(r.Form.Get("market"), r.Form.Get("type"), price, amount)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeData(w, toWireOrder(order))
+		return
+	}
+	if _, ok := s.fixtures.Books[r.Form.Get("market")]; !ok {
+		writeError(w, http.StatusBadRequest, "invalid_market")
+		return
+	}
+	s.orderSeq++
+	now := time.Now().UTC().Format("2006-01-02T15:04:05.000000")
+	order := conn.Order{
+		Id:     "M" + strconv.Itoa(s.orderSeq),
+		Status: "active",
+		Type:   r.Form.Get("type"),
+		Price:  r.Form.Get("price"),
+		Amount: conn.Amount{
+			Original:  r.Form.Get("amount"),
+			Remaining: r.Form.Get("amount"),
+			Executed:  "0",
+		},
+		Market:    r.Form.Get("market"),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.fixtures.Orders = append(s.fixtures.Orders, order)
+	writeData(w, toWireOrder(order))
+}
+
+func (s *Server) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "id") {
+		return
+	}
+	if s.engine != nil {
+		order, err := s.engine.CancelOrder(r.Form.Get("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeData(w, toWireOrder(order))
+		return
+	}
+	i := s.findOrder(r.Form.Get("id"))
+	if i < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_scope")
+		return
+	}
+	if s.fixtures.Orders[i].Status != "active" {
+		writeError(w, http.StatusBadRequest, "order_not_active")
+		return
+	}
+	s.fixtures.Orders[i].Status = "cancelled"
+	s.fixtures.Orders[i].UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05.000000")
+	writeData(w, toWireOrder(s.fixtures.Orders[i]))
+}
+
+func (s *Server) handleCreateInstant(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "market", "type", "amount") {
+		return
+	}
+	writeSuccess(w)
+}
+
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "address", "amount", "currency") {
+		return
+	}
+	writeSuccess(w)
+}
+
+// handleBankRequest serves deposits and withdrawals, which need a bank
+// account of the account.
+func (s *Server) handleBankRequest(w http.ResponseWriter, r *http.Request) {
+	if !required(w, r, "amount", "bank_account") {
+		return
+	}
+	for _, bankAccount := range s.fixtures.Account.BankAccounts {
+		if strconv.Itoa(bankAccount.Id) == r.Form.Get("bank_account") {
+			writeSuccess(w)
+			return
+		}
+	}
+	writeError(w, http.StatusBadRequest, "Bank account does not exist")
+}
+
+// writeSuccess answers the endpoints that only give a status.
+func writeSuccess(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+	})
+}
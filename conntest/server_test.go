@@ -0,0 +1,146 @@
+package conntest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+func TestPublicEndpoints(t *testing.T) {
+	server := NewServer("key", "secret", nil)
+	defer server.Close()
+	client := server.Client()
+
+	markets, err := client.GetMarkets()
+	if err != nil || len(markets) != 3 {
+		t.Errorf("unexpected markets %v, %v", markets, err)
+	}
+	tickers, err := client.GetTicker(args.Market("ETHCLP"))
+	if err != nil || len(tickers) != 1 || tickers[0].LastPrice != "150050" {
+		t.Errorf("unexpected tickers %v, %v", tickers, err)
+	}
+	book, err := client.GetBook(args.Market("ETHCLP"), args.Type("buy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Data) != 20 || book.Data[0].Price != "150000" {
+		t.Errorf("unexpected first page of the book %v", book.Data)
+	}
+	next, err := book.GetNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Data) != 10 || next.GetPage() != 1 {
+		t.Errorf("unexpected second page of the book %v", next.Data)
+	}
+	if _, err := next.GetNext(); err == nil {
+		t.Errorf("there should not be a third page")
+	}
+	trades, err := client.GetTradesAllPages(args.Market("ETHCLP"))
+	if err != nil || len(trades) != 30 {
+		t.Errorf("unexpected trades %d, %v", len(trades), err)
+	}
+	prices, err := client.GetPrices(args.Market("ETHCLP"), args.Timeframe("60"))
+	if err != nil || len(prices.Data.Ask) != 5 || prices.Data.Bid[0].CandleId != 1000 {
+		t.Errorf("unexpected prices %v, %v", prices, err)
+	}
+}
+
+func TestPrivateEndpoints(t *testing.T) {
+	server := NewServer("key", "secret", nil)
+	defer server.Close()
+	client := server.Client()
+
+	account, err := client.GetAccount()
+	if err != nil || account.Rate.MarketTaker != "0.0068" {
+		t.Errorf("unexpected account %v, %v", account, err)
+	}
+	balances, err := client.GetBalance()
+	if err != nil || len(balances) != 4 {
+		t.Errorf("unexpected balances %v, %v", balances, err)
+	}
+	transactions, err := client.GetAllTransactions(args.Currency("CLP"))
+	if err != nil || len(transactions) != 2 || transactions[0].Amount != "-140000" {
+		t.Errorf("unexpected transactions %v, %v", transactions, err)
+	}
+	order, err := client.CreateOrder(
+		args.Amount("0.3"),
+		args.Market("ETHCLP"),
+		args.Price("140000"),
+		args.Type("buy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := client.GetActiveOrders(args.Market("ETHCLP"))
+	if err != nil || len(active.Data) != 2 {
+		t.Errorf("there should be two active orders, got %v, %v", active, err)
+	}
+	order, err = order.Close()
+	if err != nil || order.Status != "cancelled" {
+		t.Errorf("the order should be cancelled, got %v, %v", order, err)
+	}
+	executed, err := client.GetExecutedOrders(args.Market("ETHCLP"))
+	if err != nil || len(executed.Data) != 1 || executed.Data[0].ExecutionPrice != "140000" {
+		t.Errorf("unexpected executed orders %v, %v", executed, err)
+	}
+	err = client.RequestDeposit(args.Amount("1000"), args.BankAccount("2"))
+	if err == nil || !strings.Contains(err.Error(), "Bank account does not exist") {
+		t.Errorf("should fail with an unknown bank account, got %v", err)
+	}
+	if err := client.Transfer(args.Address("GD"), args.Amount("1"), args.Currency("XLM")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server := NewServer("key", "secret", nil)
+	defer server.Close()
+	client := conn.NewClient("key", "wrongSecret")
+	client.SetBaseUri(server.URL)
+	_, err := client.GetBalance()
+	if err == nil || !strings.Contains(err.Error(), "invalid_signature") {
+		t.Errorf("should fail with an invalid signature, got %v", err)
+	}
+	_, err = client.CreateOrder(
+		args.Amount("0.3"),
+		args.Market("ETHCLP"),
+		args.Price("140000"),
+		args.Type("buy"))
+	if err == nil || !strings.Contains(err.Error(), "invalid_signature") {
+		t.Errorf("should fail with an invalid signature, got %v", err)
+	}
+	// the public endpoints need no keys
+	if _, err := client.GetBook(args.Market("ETHCLP"), args.Type("sell")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	server := NewServer("key", "secret", nil)
+	defer server.Close()
+	client := server.Client()
+
+	server.InjectFault("balance", RateLimited, 1)
+	if _, err := client.GetBalance(); err == nil || !strings.Contains(err.Error(), "too_many_requests") {
+		t.Errorf("should be rate limited, got %v", err)
+	}
+	if _, err := client.GetBalance(); err != nil {
+		t.Errorf("the fault should be given once, got %v", err)
+	}
+	server.InjectFault("ticker", ServerError, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetTicker(); err == nil {
+			t.Errorf("should fail with a server error")
+		}
+	}
+	server.ClearFaults()
+	server.InjectFault("market", MalformedJSON, 1)
+	if markets, _ := client.GetMarkets(); len(markets) != 0 {
+		t.Errorf("malformed json should give no markets, got %v", markets)
+	}
+	if server.Calls("market") != 1 || server.Calls("ticker") != 2 {
+		t.Errorf("unexpected calls count")
+	}
+}
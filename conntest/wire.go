@@ -0,0 +1,199 @@
+package conntest
+
+import (
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// The structs in this file reproduce the json given by CryptoMarket, so the
+// server answers with the same keys as the real api.
+
+type wireTicker struct {
+	High      string `json:"high"`
+	Volume    string `json:"volume"`
+	Low       string `json:"low"`
+	Ask       string `json:"ask"`
+	Timestamp string `json:"timestamp"`
+	Bid       string `json:"bid"`
+	LastPrice string `json:"last_price"`
+	Market    string `json:"market"`
+}
+
+type wireBookData struct {
+	Price     string `json:"price"`
+	Amount    string `json:"amount"`
+	Timestamp string `json:"timestamp"`
+}
+
+type wireTrade struct {
+	MarketTaker string `json:"market_taker"`
+	Price       string `json:"price"`
+	Amount      string `json:"amount"`
+	Tid         string `json:"tid"`
+	Timestamp   string `json:"timestamp"`
+	Market      string `json:"market"`
+}
+
+type wireCandle struct {
+	CandleId   int    `json:"candle_id"`
+	OpenPrice  string `json:"open_price"`
+	HightPrice string `json:"hight_price"`
+	ClosePrice string `json:"close_price"`
+	LowPrice   string `json:"low_price"`
+	VolumeSum  string `json:"volume_sum"`
+	CandleDate string `json:"candle_date"`
+	TickCount  string `json:"tick_count"`
+}
+
+type wirePrices struct {
+	Ask []wireCandle `json:"ask"`
+	Bid []wireCandle `json:"bid"`
+}
+
+type wireBalance struct {
+	Wallet    string `json:"wallet"`
+	Available string `json:"available"`
+	Balance   string `json:"balance"`
+}
+
+type wireRate struct {
+	MarketMaker string `json:"market_maker"`
+	MarketTaker string `json:"market_taker"`
+}
+
+type wireBankAccount struct {
+	Id          int    `json:"id"`
+	Bank        string `json:"bank"`
+	Description string `json:"description"`
+	Country     string `json:"country"`
+	Number      string `json:"number"`
+}
+
+type wireAccount struct {
+	Name         string            `json:"name"`
+	Email        string            `json:"email"`
+	Rate         wireRate          `json:"rate"`
+	BankAccounts []wireBankAccount `json:"bank_accounts"`
+}
+
+type wireAmount struct {
+	Original  string `json:"original"`
+	Remaining string `json:"remaining"`
+	Executed  string `json:"executed,omitempty"`
+}
+
+type wireOrder struct {
+	Id                string     `json:"id"`
+	Status            string     `json:"status"`
+	Type              string     `json:"type"`
+	Price             string     `json:"price"`
+	Amount            wireAmount `json:"amount"`
+	ExecutionPrice    *string    `json:"execution_price"`
+	AvgExecutionPrice int        `json:"avg_execution_price"`
+	Market            string     `json:"market"`
+	CreatedAt         string     `json:"created_at"`
+	UpdatedAt         string     `json:"updated_at"`
+	ExecutedAt        string     `json:"executed_at,omitempty"`
+}
+
+type wireTransaction struct {
+	Id         string `json:"id"`
+	Type       int    `json:"type"`
+	Amount     string `json:"amount"`
+	FeePercent string `json:"fee_percent"`
+	FeeAmount  string `json:"fee_amount"`
+	Balance    string `json:"balance"`
+	Date       string `json:"date"`
+	Hash       string `json:"hash,omitempty"`
+	Address    string `json:"address,omitempty"`
+	Memo       string `json:"memo,omitempty"`
+}
+
+func toWireTickers(tickers []conn.Ticker) []wireTicker {
+	result := make([]wireTicker, len(tickers))
+	for i, t := range tickers {
+		result[i] = wireTicker(t)
+	}
+	return result
+}
+
+func toWireBook(entries []conn.BookData) []wireBookData {
+	result := make([]wireBookData, len(entries))
+	for i, e := range entries {
+		result[i] = wireBookData(e)
+	}
+	return result
+}
+
+func toWireTrades(trades []conn.TradeData) []wireTrade {
+	result := make([]wireTrade, len(trades))
+	for i, t := range trades {
+		result[i] = wireTrade(t)
+	}
+	return result
+}
+
+func toWireCandles(candles []conn.Candle) []wireCandle {
+	result := make([]wireCandle, len(candles))
+	for i, c := range candles {
+		result[i] = wireCandle(c)
+	}
+	return result
+}
+
+func toWireBalances(balances []conn.Balance) []wireBalance {
+	result := make([]wireBalance, len(balances))
+	for i, b := range balances {
+		result[i] = wireBalance(b)
+	}
+	return result
+}
+
+func toWireAccount(account conn.Account) wireAccount {
+	result := wireAccount{
+		Name:         account.Name,
+		Email:        account.Email,
+		Rate:         wireRate(account.Rate),
+		BankAccounts: make([]wireBankAccount, len(account.BankAccounts)),
+	}
+	for i, b := range account.BankAccounts {
+		result.BankAccounts[i] = wireBankAccount(b)
+	}
+	return result
+}
+
+func toWireOrder(order conn.Order) wireOrder {
+	result := wireOrder{
+		Id:                order.Id,
+		Status:            order.Status,
+		Type:              order.Type,
+		Price:             order.Price,
+		Amount:            wireAmount(order.Amount),
+		AvgExecutionPrice: order.AvgExecutionPrice,
+		Market:            order.Market,
+		CreatedAt:         order.CreatedAt,
+		UpdatedAt:         order.UpdatedAt,
+		ExecutedAt:        order.ExecutedAt,
+	}
+	// CryptoMarket gives a null execution price while nothing is executed
+	if order.ExecutionPrice != "" {
+		executionPrice := order.ExecutionPrice
+		result.ExecutionPrice = &executionPrice
+	}
+	return result
+}
+
+func toWireOrders(orders []conn.Order) []wireOrder {
+	result := make([]wireOrder, len(orders))
+	for i, o := range orders {
+		result[i] = toWireOrder(o)
+	}
+	return result
+}
+
+func toWireTransactions(transactions []conn.Transaction) []wireTransaction {
+	result := make([]wireTransaction, len(transactions))
+	for i, t := range transactions {
+		result[i] = wireTransaction(t)
+	}
+	return result
+}
@@ -0,0 +1,296 @@
+// Package dashboard shows live markets and an account in a terminal: the
+// tickers of the markets followed, the depth of the order book and the
+// recent trades of the selected market, the open orders, which can be
+// cancelled from the keyboard, and the balances of the wallets.
+//
+// The data is polled from CryptoMarket, spacing the calls with a
+// conn.Limiter. It can also be pushed, as from a stream, with SetTicker,
+// SetBook, AddTrades, SetOrders and SetBalances.
+//
+//	board := dashboard.New(client, "ETHCLP", "BTCCLP")
+//	err := board.Run(os.Stdin, os.Stdout)
+package dashboard
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// Source is the part of the exchange read by a dashboard.
+type Source interface {
+	GetTicker(arguments ...args.Argument) ([]conn.Ticker, error)
+	GetBook(arguments ...args.Argument) (*conn.Book, error)
+	GetTrades(arguments ...args.Argument) (*conn.Trades, error)
+	GetActiveOrders(arguments ...args.Argument) (*conn.OrderList, error)
+	CancelOrder(arguments ...args.Argument) (*conn.Order, error)
+	GetBalance() ([]conn.Balance, error)
+}
+
+// book is the two sides of the book of a market.
+type book struct {
+	buy, sell []conn.BookData
+}
+
+// A Dashboard holds the data shown and the state of the screen.
+type Dashboard struct {
+	// Depth is the number of levels of each side of the book shown, 10 by
+	// default.
+	Depth int
+	// TradesShown is the number of recent trades shown, 10 by default.
+	TradesShown int
+	// Interval is the time between polls, 10 seconds by default. A poll
+	// makes a call per market and five more, so it takes longer with a
+	// slow limiter.
+	Interval time.Duration
+	// Terminal is the terminal of the input of Run, put in raw mode while
+	// the dashboard is shown, when the input is not the terminal itself.
+	Terminal *os.File
+
+	source  Source
+	markets []string
+	limiter *conn.Limiter
+	now     func() time.Time
+
+	mu       sync.Mutex
+	tickers  map[string]conn.Ticker
+	books    map[string]book
+	trades   map[string][]conn.TradeData
+	orders   []conn.Order
+	balances []conn.Balance
+	updated  time.Time
+	status   string
+	err      error
+	selected int
+	cursor   int
+	// confirm is the question waiting for a yes, and action what a yes
+	// does, run in the background while busy.
+	confirm string
+	action  func() error
+	busy    bool
+	actions sync.WaitGroup
+	// cancelled are the orders cancelled from the dashboard, left out of
+	// the orders polled after, as a poll made during the cancel may still
+	// list them.
+	cancelled map[string]bool
+	// redraw is signalled when the screen changes other than by a key.
+	redraw chan struct{}
+}
+
+// New builds a dashboard of the markets given, the first one selected.
+func New(source Source, markets ...string) *Dashboard {
+	return &Dashboard{
+		Depth:       10,
+		TradesShown: 10,
+		Interval:    10 * time.Second,
+		source:      source,
+		markets:     markets,
+		limiter:     conn.NewDefaultLimiter(),
+		now:         time.Now,
+		tickers:     make(map[string]conn.Ticker),
+		books:       make(map[string]book),
+		trades:      make(map[string][]conn.TradeData),
+		cancelled:   make(map[string]bool),
+		redraw:      make(chan struct{}, 1),
+	}
+}
+
+// SetLimiter sets the limiter spacing the calls to CryptoMarket, by default
+// one waiting DELAY seconds.
+func (d *Dashboard) SetLimiter(limiter *conn.Limiter) {
+	d.limiter = limiter
+}
+
+// Selected returns the market selected, or "" without markets.
+func (d *Dashboard) Selected() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current()
+}
+
+// current returns the market selected. It is called with mu held.
+func (d *Dashboard) current() string {
+	if len(d.markets) == 0 {
+		return ""
+	}
+	return d.markets[d.selected]
+}
+
+// SetTicker sets the ticker of a market.
+func (d *Dashboard) SetTicker(ticker conn.Ticker) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tickers[ticker.Market] = ticker
+	d.updated = d.now()
+}
+
+// SetBook sets the book of a market, the best prices first.
+func (d *Dashboard) SetBook(market string, buy, sell []conn.BookData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.books[market] = book{buy, sell}
+	d.updated = d.now()
+}
+
+// AddTrades adds trades of a market, keeping the most recent.
+func (d *Dashboard) AddTrades(market string, trades ...conn.TradeData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen := make(map[string]bool)
+	var all []conn.TradeData
+	for _, trade := range append(trades, d.trades[market]...) {
+		if !seen[trade.Tid] {
+			seen[trade.Tid] = true
+			all = append(all, trade)
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Timestamp > all[j].Timestamp
+	})
+	if len(all) > d.TradesShown {
+		all = all[:d.TradesShown]
+	}
+	d.trades[market] = all
+	d.updated = d.now()
+}
+
+// SetOrders sets the open orders, but those cancelled from the dashboard.
+func (d *Dashboard) SetOrders(orders []conn.Order) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	kept := orders[:0:0]
+	for _, order := range orders {
+		if !d.cancelled[order.Id] {
+			kept = append(kept, order)
+		}
+	}
+	d.orders = kept
+	if d.cursor >= len(kept) {
+		d.cursor = len(kept) - 1
+	}
+	if d.cursor < 0 {
+		d.cursor = 0
+	}
+	d.updated = d.now()
+}
+
+// SetBalances sets the balances of the wallets.
+func (d *Dashboard) SetBalances(balances []conn.Balance) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.balances = balances
+	d.updated = d.now()
+}
+
+// changed asks Run to redraw the screen.
+func (d *Dashboard) changed() {
+	select {
+	case d.redraw <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dashboard) wait() {
+	if d.limiter != nil {
+		d.limiter.Wait()
+	}
+}
+
+// Refresh polls the data shown: the tickers, the book and the trades of the
+// market selected, the open orders of every market and the balances. The
+// errors are shown in the status line, and the first one returned.
+func (d *Dashboard) Refresh() error {
+	market := d.Selected()
+	var errs []string
+	fail := func(what string, err error) {
+		errs = append(errs, fmt.Sprintf("error getting the %s: %s", what, err))
+	}
+
+	d.wait()
+	if tickers, err := d.source.GetTicker(); err != nil {
+		fail("tickers", err)
+	} else {
+		for _, ticker := range tickers {
+			if d.follows(ticker.Market) {
+				d.SetTicker(ticker)
+			}
+		}
+	}
+
+	var sides [2][]conn.BookData
+	for i, side := range []string{"buy", "sell"} {
+		d.wait()
+		b, err := d.source.GetBook(args.Market(market), args.Type(side), args.Page(0), args.Limit(bookLimit(d.Depth)))
+		if err != nil {
+			fail("book of "+market, err)
+			continue
+		}
+		sides[i] = b.Data
+	}
+	d.SetBook(market, sides[0], sides[1])
+
+	d.wait()
+	if trades, err := d.source.GetTrades(args.Market(market), args.Page(0), args.Limit(bookLimit(d.TradesShown))); err != nil {
+		fail("trades of "+market, err)
+	} else {
+		d.AddTrades(market, trades.Data...)
+	}
+
+	var orders []conn.Order
+	ordersOk := true
+	for _, m := range d.markets {
+		d.wait()
+		list, err := d.source.GetActiveOrders(args.Market(m), args.Page(0), args.Limit(100))
+		if err != nil {
+			fail("orders of "+m, err)
+			ordersOk = false
+			continue
+		}
+		orders = append(orders, list.Data...)
+	}
+	if ordersOk {
+		d.SetOrders(orders)
+	}
+
+	d.wait()
+	if balances, err := d.source.GetBalance(); err != nil {
+		fail("balance", err)
+	} else {
+		d.SetBalances(balances)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = nil
+	if len(errs) > 0 {
+		d.err = fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return d.err
+}
+
+// bookLimit returns the page size to read n entries, as the API takes
+// between 20 and 100.
+func bookLimit(n int) int {
+	if n < 20 {
+		return 20
+	}
+	if n > 100 {
+		return 100
+	}
+	return n
+}
+
+func (d *Dashboard) follows(market string) bool {
+	for _, m := range d.markets {
+		if m == market {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package dashboard
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+func newDashboard(server *conntest.Server) *Dashboard {
+	d := New(server.Client(), "ETHCLP", "BTCCLP")
+	d.SetLimiter(conn.NewLimiter(0))
+	return d
+}
+
+func TestRefresh(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	d := newDashboard(server)
+	if err := d.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	screen := d.Render(0, 0)
+	for _, expected := range []string{
+		"* ETHCLP",
+		"6010000",
+		"##          0.5       150000 | 150100       0.5          ##\n",
+		"################          0.5       149100 | 151000       0.5          ################\n",
+		"> M100",
+		"ETH                       2                2.5",
+	} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("expected %q in the screen:\n%s", expected, screen)
+		}
+	}
+	if strings.Contains(screen, "XLMCLP") {
+		t.Errorf("expected only the markets followed")
+	}
+	if n := strings.Count(screen, " | "); n != d.Depth+1 {
+		t.Errorf("expected %d levels of depth, got %d", d.Depth, n-1)
+	}
+
+	server.InjectFault("balance", conntest.ServerError, 1)
+	if err := d.Refresh(); err == nil || !strings.Contains(d.Render(0, 0), "error getting the balance") {
+		t.Errorf("expected the error shown, got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	d := newDashboard(server)
+	d.Refresh()
+
+	d.HandleKey("c")
+	if screen := d.Render(0, 0); !strings.Contains(screen, "Cancel the sell order M100 of 0.5 at 160000 in ETHCLP? (y/n)") {
+		t.Errorf("expected a confirmation, got:\n%s", screen)
+	}
+	d.HandleKey("n")
+	if server.Calls("orders/cancel") != 0 {
+		t.Errorf("expected no cancel")
+	}
+	d.HandleKey("c")
+	d.HandleKey("y")
+	d.actions.Wait()
+	if server.Calls("orders/cancel") != 1 {
+		t.Errorf("expected the order cancelled")
+	}
+	if screen := d.Render(0, 0); !strings.Contains(screen, "cancelled M100") || !strings.Contains(screen, "  none") {
+		t.Errorf("expected the order gone, got:\n%s", screen)
+	}
+	// nothing left to cancel
+	d.HandleKey("C")
+	d.HandleKey("y")
+	if d.actions.Wait(); server.Calls("orders/cancel") != 1 {
+		t.Errorf("expected no other cancel")
+	}
+}
+
+// slowCancel is a source whose cancels wait to be let go.
+type slowCancel struct {
+	Source
+	next chan struct{}
+}
+
+func (s *slowCancel) CancelOrder(arguments ...args.Argument) (*conn.Order, error) {
+	<-s.next
+	return s.Source.CancelOrder(arguments...)
+}
+
+func TestCancelInBackground(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	source := &slowCancel{Source: server.Client(), next: make(chan struct{})}
+	d := New(source, "ETHCLP")
+	d.SetLimiter(conn.NewLimiter(0))
+	d.Refresh()
+	orders := append([]conn.Order(nil), d.orders...)
+	d.SetOrders(append(orders, conn.Order{Id: "M101", Market: "ETHCLP"}))
+
+	d.HandleKey("C")
+	d.HandleKey("y")
+	<-d.redraw
+	if screen := d.Render(0, 0); !strings.Contains(screen, "cancelling 1 of 2") {
+		t.Errorf("expected the progress shown, got:\n%s", screen)
+	}
+	if d.HandleKey("c"); !strings.Contains(d.Render(0, 0), "wait for the cancel to finish") {
+		t.Errorf("expected a second cancel refused while busy")
+	}
+	source.next <- struct{}{}
+	// a poll made during the cancel still lists the order
+	d.SetOrders(orders)
+	close(source.next)
+	d.actions.Wait()
+	d.SetOrders(orders)
+	if screen := d.Render(0, 0); strings.Contains(screen, "M100") || !strings.Contains(screen, "error cancelling M101") {
+		t.Errorf("expected M100 cancelled and M101 failed, got:\n%s", screen)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	keys := parseKeys([]byte("\x1b[A\x1b[Bq\x03\x1bOC\tc"))
+	expected := []Key{KeyUp, KeyDown, "q", KeyCtrlC, KeyRight, KeyTab, "c"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+
+	d := New(nil, "ETHCLP", "BTCCLP", "XLMCLP")
+	d.SetOrders([]conn.Order{{Id: "1"}, {Id: "2"}})
+	for _, key := range []Key{KeyLeft, KeyDown, KeyDown} {
+		if !d.HandleKey(key) {
+			t.Fatalf("unexpected quit on %s", key)
+		}
+	}
+	if d.Selected() != "XLMCLP" || d.cursor != 1 {
+		t.Errorf("expected XLMCLP and the second order, got %s and %d", d.Selected(), d.cursor)
+	}
+	if d.HandleKey("q") {
+		t.Errorf("expected q to quit")
+	}
+}
+
+func TestTrades(t *testing.T) {
+	d := New(nil, "ETHCLP")
+	d.TradesShown = 2
+	d.AddTrades("ETHCLP", conn.TradeData{Tid: "1", Timestamp: "2020-01-01T10:00:00"}, conn.TradeData{Tid: "2", Timestamp: "2020-01-01T11:00:00"})
+	d.AddTrades("ETHCLP", conn.TradeData{Tid: "2", Timestamp: "2020-01-01T11:00:00"}, conn.TradeData{Tid: "3", Timestamp: "2020-01-01T12:00:00"})
+	trades := d.trades["ETHCLP"]
+	if len(trades) != 2 || trades[0].Tid != "3" || trades[1].Tid != "2" {
+		t.Errorf("expected the two newest trades, got %+v", trades)
+	}
+}
+
+func TestRenderSize(t *testing.T) {
+	d := New(nil, "ETHCLP")
+	lines := strings.Split(d.Render(40, 10), "\n")
+	if len(lines) != 10 {
+		t.Errorf("expected 10 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if utf8.RuneCountInString(line) > 40 {
+			t.Errorf("line too long: %q", line)
+		}
+	}
+	if lines[8] != truncate(help, 40) {
+		t.Errorf("expected the help before the status, got %q", lines[8])
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	d := newDashboard(server)
+	var out bytes.Buffer
+	if err := d.Run(strings.NewReader("lq"), &out); err != nil {
+		t.Fatal(err)
+	}
+	screen := out.String()
+	if !strings.HasPrefix(screen, enterScreen) || !strings.HasSuffix(screen, leaveScreen) || !strings.Contains(screen, "* BTCCLP") {
+		t.Errorf("unexpected output %q", screen)
+	}
+}
+
+func TestNoMarkets(t *testing.T) {
+	d := New(nil)
+	for _, key := range []Key{KeyLeft, KeyRight, "C"} {
+		d.HandleKey(key)
+	}
+	if d.Selected() != "" || !strings.Contains(d.Render(80, 40), "OPEN ORDERS") {
+		t.Errorf("expected no market selected, got %q", d.Selected())
+	}
+	var out bytes.Buffer
+	if err := d.Run(strings.NewReader("q"), &out); err == nil || out.Len() != 0 {
+		t.Errorf("expected an error before drawing, got %v and %q", err, out.String())
+	}
+}
+
+func TestRunLeavesInput(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	d := newDashboard(server)
+	in := bufio.NewReader(strings.NewReader("l\x1b[Cq\nmarkets\n"))
+	if err := d.Run(in, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := in.ReadString(0); rest != "\nmarkets\n" {
+		t.Errorf("expected the input after q left, got %q", rest)
+	}
+	if d.Selected() != "ETHCLP" {
+		t.Errorf("expected the selection moved twice, got %s", d.Selected())
+	}
+}
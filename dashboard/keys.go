@@ -0,0 +1,176 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+)
+
+// A Key is a key pressed: a character, or the name of a special key.
+type Key string
+
+// The special keys.
+const (
+	KeyUp    Key = "up"
+	KeyDown  Key = "down"
+	KeyLeft  Key = "left"
+	KeyRight Key = "right"
+	KeyTab   Key = "tab"
+	KeyEnter Key = "enter"
+	KeyEsc   Key = "esc"
+	KeyCtrlC Key = "ctrl-c"
+)
+
+// help is the line listing the keys.
+const help = "←/→ market  ↑/↓ order  c cancel  C cancel all in market  r refresh  q quit"
+
+// parseKeys splits the bytes read from a terminal in keys.
+func parseKeys(data []byte) []Key {
+	var keys []Key
+	for i := 0; i < len(data); i++ {
+		switch b := data[i]; {
+		case b == 0x1b && i+2 < len(data) && (data[i+1] == '[' || data[i+1] == 'O'):
+			switch data[i+2] {
+			case 'A':
+				keys = append(keys, KeyUp)
+			case 'B':
+				keys = append(keys, KeyDown)
+			case 'C':
+				keys = append(keys, KeyRight)
+			case 'D':
+				keys = append(keys, KeyLeft)
+			}
+			i += 2
+		case b == 0x1b:
+			keys = append(keys, KeyEsc)
+		case b == 3:
+			keys = append(keys, KeyCtrlC)
+		case b == '\t':
+			keys = append(keys, KeyTab)
+		case b == '\r' || b == '\n':
+			keys = append(keys, KeyEnter)
+		case b >= 0x20 && b < 0x7f:
+			keys = append(keys, Key(string(b)))
+		}
+	}
+	return keys
+}
+
+// HandleKey acts on a key pressed, and returns false when the dashboard
+// should quit. The cancels are confirmed with y first, and run in the
+// background, one at a time, showing their progress in the status line.
+func (d *Dashboard) HandleKey(key Key) bool {
+	d.mu.Lock()
+	if d.confirm != "" {
+		action := d.action
+		d.confirm, d.action = "", nil
+		if key != "y" && key != "Y" {
+			d.status = "cancelled"
+			d.mu.Unlock()
+			return true
+		}
+		d.busy, d.err = true, nil
+		d.actions.Add(1)
+		d.mu.Unlock()
+		go func() {
+			defer d.actions.Done()
+			err := action()
+			d.mu.Lock()
+			d.busy, d.err = false, err
+			d.mu.Unlock()
+			d.changed()
+		}()
+		return true
+	}
+	defer d.mu.Unlock()
+	switch key {
+	case "q", "Q", KeyCtrlC, KeyEsc:
+		return false
+	case KeyRight, KeyTab, "l":
+		if len(d.markets) > 0 {
+			d.selected = (d.selected + 1) % len(d.markets)
+		}
+	case KeyLeft, "h":
+		if len(d.markets) > 0 {
+			d.selected = (d.selected + len(d.markets) - 1) % len(d.markets)
+		}
+	case KeyDown, "j":
+		if d.cursor < len(d.orders)-1 {
+			d.cursor++
+		}
+	case KeyUp, "k":
+		if d.cursor > 0 {
+			d.cursor--
+		}
+	case "c", "C":
+		if d.busy {
+			d.status = "wait for the cancel to finish"
+			break
+		}
+		if key == "C" {
+			d.confirmMarket()
+			break
+		}
+		if len(d.orders) == 0 {
+			break
+		}
+		order := d.orders[d.cursor]
+		d.confirm = fmt.Sprintf("Cancel the %s order %s of %s at %s in %s? (y/n)", order.Type, order.Id, order.Amount.Remaining, order.Price, order.Market)
+		d.action = func() error { return d.cancel(order.Id) }
+	case "r":
+		d.status = "refreshing"
+	}
+	return true
+}
+
+// confirmMarket asks to cancel the orders of the market selected.
+func (d *Dashboard) confirmMarket() {
+	market := d.current()
+	var ids []string
+	for _, order := range d.orders {
+		if order.Market == market {
+			ids = append(ids, order.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	d.confirm = fmt.Sprintf("Cancel the %d orders in %s? (y/n)", len(ids), market)
+	d.action = func() error { return d.cancel(ids...) }
+}
+
+// cancel cancels orders, and removes them from those shown. They are left
+// out of the orders set from then on, unless their cancel fails.
+func (d *Dashboard) cancel(ids ...string) error {
+	d.mu.Lock()
+	for _, id := range ids {
+		d.cancelled[id] = true
+	}
+	d.mu.Unlock()
+	var errs, done []string
+	for i, id := range ids {
+		d.mu.Lock()
+		d.status = fmt.Sprintf("cancelling %d of %d", i+1, len(ids))
+		d.mu.Unlock()
+		d.changed()
+		d.wait()
+		if _, err := d.source.CancelOrder(args.Id(id)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", id, err))
+			d.mu.Lock()
+			delete(d.cancelled, id)
+			d.mu.Unlock()
+			continue
+		}
+		done = append(done, id)
+	}
+	d.mu.Lock()
+	orders := d.orders
+	d.status = fmt.Sprintf("cancelled %s", strings.Join(done, ", "))
+	d.mu.Unlock()
+	d.SetOrders(orders)
+	if len(errs) > 0 {
+		return fmt.Errorf("error cancelling %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package dashboard
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// barWidth is the width of the bars of the depth view.
+const barWidth = 16
+
+// Render draws the dashboard as lines of at most width characters, and at
+// most height lines, the help and the status last.
+func (d *Dashboard) Render(width, height int) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	market := d.current()
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+
+	updated := "never"
+	if !d.updated.IsZero() {
+		updated = d.updated.Format("15:04:05")
+	}
+	add("CryptoMarket  %s  updated %s", market, updated)
+	add("")
+
+	add("  %-8s %14s %14s %14s %14s %14s %14s", "MARKET", "BID", "ASK", "LAST", "LOW", "HIGH", "VOLUME")
+	for _, m := range d.markets {
+		mark := " "
+		if m == market {
+			mark = "*"
+		}
+		t := d.tickers[m]
+		add("%s %-8s %14s %14s %14s %14s %14s %14s", mark, m, t.Bid, t.Ask, t.LastPrice, t.Low, t.High, t.Volume)
+	}
+	add("")
+
+	add("DEPTH %s", market)
+	lines = append(lines, d.depth(market)...)
+	add("")
+
+	add("TRADES %s", market)
+	add("  %-26s %-5s %14s %14s", "TIME", "SIDE", "PRICE", "AMOUNT")
+	for _, trade := range d.trades[market] {
+		add("  %-26s %-5s %14s %14s", trade.Timestamp, trade.MarketTaker, trade.Price, trade.Amount)
+	}
+	add("")
+
+	add("OPEN ORDERS")
+	add("  %-12s %-8s %-5s %14s %14s %14s  %s", "ID", "MARKET", "TYPE", "PRICE", "REMAINING", "ORIGINAL", "CREATED")
+	for i, order := range d.orders {
+		mark := " "
+		if i == d.cursor {
+			mark = ">"
+		}
+		add("%s %-12s %-8s %-5s %14s %14s %14s  %s", mark, order.Id, order.Market, order.Type, order.Price, order.Amount.Remaining, order.Amount.Original, order.CreatedAt)
+	}
+	if len(d.orders) == 0 {
+		add("  none")
+	}
+	add("")
+
+	add("BALANCES")
+	add("  %-8s %18s %18s", "WALLET", "AVAILABLE", "BALANCE")
+	for _, balance := range d.balances {
+		if isZero(balance.Balance) {
+			continue
+		}
+		add("  %-8s %18s %18s", balance.Wallet, balance.Available, balance.Balance)
+	}
+
+	status := d.status
+	switch {
+	case d.confirm != "":
+		status = d.confirm
+	case d.err != nil:
+		status = d.err.Error()
+	}
+	footer := []string{"", help, status}
+	if height > 0 && len(lines)+len(footer) > height {
+		keep := height - len(footer)
+		if keep < 0 {
+			keep = 0
+		}
+		lines = lines[:keep]
+	}
+	lines = append(lines, footer...)
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(truncate(line, width), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// depth draws the bids and the asks side by side, with bars of the amount
+// accumulated from the best price.
+func (d *Dashboard) depth(market string) []string {
+	b := d.books[market]
+	buy, sell := b.buy, b.sell
+	if len(buy) > d.Depth {
+		buy = buy[:d.Depth]
+	}
+	if len(sell) > d.Depth {
+		sell = sell[:d.Depth]
+	}
+	buyTotals, sellTotals := cumulative(buy), cumulative(sell)
+	max := 0.0
+	for _, totals := range [][]float64{buyTotals, sellTotals} {
+		if len(totals) > 0 && totals[len(totals)-1] > max {
+			max = totals[len(totals)-1]
+		}
+	}
+	lines := []string{fmt.Sprintf("  %*s %12s %12s | %-12s %-12s %s", barWidth, "", "AMOUNT", "BID", "ASK", "AMOUNT", "")}
+	rows := len(buy)
+	if len(sell) > rows {
+		rows = len(sell)
+	}
+	for i := 0; i < rows; i++ {
+		var left, right string
+		if i < len(buy) {
+			left = fmt.Sprintf("%*s %12s %12s", barWidth, bar(buyTotals[i], max), buy[i].Amount, buy[i].Price)
+		} else {
+			left = fmt.Sprintf("%*s %12s %12s", barWidth, "", "", "")
+		}
+		if i < len(sell) {
+			right = fmt.Sprintf("%-12s %-12s %s", sell[i].Price, sell[i].Amount, bar(sellTotals[i], max))
+		}
+		lines = append(lines, "  "+left+" | "+right)
+	}
+	return lines
+}
+
+func cumulative(entries []conn.BookData) []float64 {
+	totals := make([]float64, len(entries))
+	total := 0.0
+	for i, entry := range entries {
+		amount, _ := strconv.ParseFloat(entry.Amount, 64)
+		total += amount
+		totals[i] = total
+	}
+	return totals
+}
+
+func bar(val, max float64) string {
+	if max <= 0 {
+		return ""
+	}
+	return strings.Repeat("#", int(math.Round(val/max*barWidth)))
+}
+
+func isZero(val string) bool {
+	f, err := strconv.ParseFloat(val, 64)
+	return err == nil && f == 0
+}
+
+// truncate cuts a line to width characters, if width is positive.
+func truncate(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
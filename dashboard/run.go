@@ -0,0 +1,146 @@
+package dashboard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/internal/term"
+)
+
+// The escape sequences used to draw on the terminal.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen = "\x1b[H\x1b[2J"
+)
+
+// Run shows the dashboard on out until q is pressed or in is closed,
+// polling every Interval and redrawing after each poll and each key. When
+// in, or else Terminal, is a terminal it is put in raw mode, so the keys
+// act as they are pressed, and restored when the dashboard quits. A
+// dashboard without markets returns an error without drawing.
+//
+// The keys are read one at a time, and nothing is read after q, so a
+// *bufio.Reader given as in can be read again once the dashboard quits.
+func (d *Dashboard) Run(in io.Reader, out io.Writer) error {
+	if len(d.markets) == 0 {
+		return errors.New("no markets to show")
+	}
+	terminal := d.Terminal
+	if file, ok := in.(*os.File); ok {
+		terminal = file
+	}
+	if terminal != nil {
+		if restore, err := term.MakeRaw(terminal); err == nil {
+			defer restore()
+		}
+	}
+	fmt.Fprint(out, enterScreen)
+	defer fmt.Fprint(out, leaveScreen)
+
+	reader, ok := in.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(in)
+	}
+	keys := make(chan Key)
+	// next asks for the key after the one handled, and done stops the
+	// reading when Run returns
+	next := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(keys)
+		for {
+			key, err := readKey(reader)
+			if err != nil {
+				return
+			}
+			if key == "" {
+				continue
+			}
+			select {
+			case keys <- key:
+			case <-done:
+				return
+			}
+			select {
+			case <-next:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// the cancels running are finished before quitting
+	defer d.actions.Wait()
+
+	var refreshing int32
+	refresh := func() {
+		if !atomic.CompareAndSwapInt32(&refreshing, 0, 1) {
+			return
+		}
+		go func() {
+			d.Refresh()
+			atomic.StoreInt32(&refreshing, 0)
+			d.changed()
+		}()
+	}
+	draw := func() {
+		width, height := 0, 0
+		if file, ok := out.(*os.File); ok {
+			width, height, _ = term.Size(file)
+		}
+		screen := d.Render(width, height)
+		fmt.Fprint(out, clearScreen+strings.Replace(screen, "\n", "\r\n", -1))
+	}
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	refresh()
+	draw()
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok || !d.HandleKey(key) {
+				return nil
+			}
+			switch key {
+			case "r", KeyLeft, KeyRight, KeyTab, "h", "l":
+				refresh()
+			}
+			draw()
+			next <- struct{}{}
+		case <-d.redraw:
+			draw()
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
+
+// readKey reads a key, with the rest of its escape sequence when it was
+// read with it. It returns "" for the bytes that are not keys.
+func readKey(reader *bufio.Reader) (Key, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	data := []byte{b}
+	if b == 0x1b && reader.Buffered() >= 2 {
+		if rest, _ := reader.Peek(2); rest[0] == '[' || rest[0] == 'O' {
+			reader.Discard(2)
+			data = append(data, rest...)
+		}
+	}
+	keys := parseKeys(data)
+	if len(keys) == 0 {
+		return "", nil
+	}
+	return keys[0], nil
+}
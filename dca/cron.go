@@ -0,0 +1,180 @@
+package dca
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule is a cron-like schedule of five fields, minute, hour, day of
+// the month, month and day of the week:
+//
+//	"0 9 * * 1"     every monday at 9:00
+//	"30 8 1,15 * *" the 1st and the 15th of each month at 8:30
+//	"0 */6 * * *"   every 6 hours
+//
+// Each field takes "*", numbers, ranges as "1-5", lists as "1,3" and steps
+// as "*/2" or "0-30/10". Sunday is 0 or 7. As in cron, when both the day of
+// the month and the day of the week are restricted, that is neither starts
+// with "*", a day matching either one is scheduled. The descriptors @hourly, @daily, @weekly and @monthly
+// are accepted too.
+//
+// The times are those of the location of the times given to Next.
+type Schedule struct {
+	spec                     string
+	minute, hour, dom, month uint64
+	dow                      uint64
+	// domAny and dowAny tell if the days are not restricted
+	domAny, dowAny bool
+}
+
+var descriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseSchedule parses a schedule, as "0 9 * * 1".
+func ParseSchedule(spec string) (*Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if expanded, ok := descriptors[expr]; ok {
+		expr = expanded
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	s := &Schedule{spec: spec}
+	bounds := []struct {
+		field    *uint64
+		min, max int
+		name     string
+	}{
+		{&s.minute, 0, 59, "minute"},
+		{&s.hour, 0, 23, "hour"},
+		{&s.dom, 1, 31, "day of the month"},
+		{&s.month, 1, 12, "month"},
+		{&s.dow, 0, 7, "day of the week"},
+	}
+	for i, b := range bounds {
+		bits, err := parseField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in schedule %q: %s", b.name, spec, err)
+		}
+		*b.field = bits
+	}
+	// sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// MustParseSchedule is like ParseSchedule but panics on an invalid schedule.
+func MustParseSchedule(spec string) *Schedule {
+	s, err := ParseSchedule(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parseField parses a field in a set of bits, one for each value.
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if low, err = strconv.Atoi(part[:i]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part[:i])
+			}
+			if high, err = strconv.Atoi(part[i+1:]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part[i+1:])
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			low, high = value, value
+			if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q out of the range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// String returns the schedule as it was parsed.
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time of the schedule after t, in the location of
+// t, or the zero time if there is none in the next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Between returns the times of the schedule after from and up to to, both
+// in the location of from.
+func (s *Schedule) Between(from, to time.Time) []time.Time {
+	var times []time.Time
+	for t := s.Next(from); !t.IsZero() && !t.After(to); t = s.Next(t) {
+		times = append(times, t)
+	}
+	return times
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if !s.domAny && !s.dowAny {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,59 @@
+package dca
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a wednesday
+	from := time.Date(2020, 1, 1, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"0 9 * * 1", time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)},
+		{"30 8 1,15 * *", time.Date(2020, 1, 15, 8, 30, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2020, 1, 1, 10, 40, 0, 0, time.UTC)},
+		{"0 0 * 3-5 *", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2020, 1, 5, 12, 0, 0, 0, time.UTC)},
+		// the 10th or a friday, whichever comes first
+		{"0 0 10 * 5", time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},
+		// an odd day that is a monday, as */2 does not restrict the days
+		{"0 0 */2 * 1", time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%s: %s", test.spec, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(test.next) {
+			t.Errorf("%s: expected %v, got %v", test.spec, test.next, next)
+		}
+	}
+	// a time of the schedule is not its own next
+	schedule := MustParseSchedule("0 9 * * 1")
+	monday := time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)
+	if next := schedule.Next(monday); !next.Equal(monday.AddDate(0, 0, 7)) {
+		t.Errorf("expected the next monday, got %v", next)
+	}
+	if times := schedule.Between(from, time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)); len(times) != 4 {
+		t.Errorf("expected the 4 mondays of january, got %v", times)
+	}
+	if next := MustParseSchedule("0 0 30 2 *").Next(from); !next.IsZero() {
+		t.Errorf("a schedule that never happens should give the zero time, got %v", next)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@yearly"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+}
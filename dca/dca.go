@@ -0,0 +1,420 @@
+// Package dca buys fixed amounts of a currency on a schedule, as in
+// dollar-cost averaging, keeping the history of the buys between restarts.
+package dca
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+)
+
+// Exchange is the part of the client needed to buy.
+type Exchange interface {
+	execution.BookGetter
+	execution.OrderCreator
+	GetOrderStatus(arguments ...args.Argument) (*conn.Order, error)
+	CreateInstant(arguments ...args.Argument) error
+	GetBalance() ([]conn.Balance, error)
+}
+
+// A Method is how the buys of a plan are made.
+type Method string
+
+const (
+	// Instant buys in the Instant Exchange, with CreateInstant.
+	Instant Method = "instant"
+	// Limit places a limit order at the best ask, less the discount of the
+	// plan.
+	Limit Method = "limit"
+)
+
+// A Policy tells what to do with the runs missed, as when the scheduler was
+// not running at their time.
+type Policy int
+
+const (
+	// SkipMissed records the missed runs as skipped.
+	SkipMissed Policy = iota
+	// CatchUpLast makes the last of the missed runs, skipping the others.
+	CatchUpLast
+	// CatchUpAll makes every missed run.
+	CatchUpAll
+)
+
+// A Plan is a recurring buy of a fixed amount of the quote currency of a
+// market, as 100000 CLP of ETHCLP each week.
+type Plan struct {
+	// Name identifies the plan in the history, the market by default.
+	Name   string
+	Market string
+	// Spend is the amount of the quote currency of each buy.
+	Spend    float64
+	Schedule *Schedule
+	Method   Method
+	// Discount is how much under the best ask limit orders are placed, as a
+	// fraction, e.g. 0.001. Zero places them at the best ask.
+	Discount float64
+}
+
+// A Scheduler makes the buys of its plans at the times of their schedules.
+// Each run is recorded in the history, so a restarted scheduler knows the
+// runs missed while it was stopped, and handles them by its policy.
+//
+// A run is missed when it is made more than Grace after its time. A run
+// that fails is recorded and not tried again. The limit order of a run is
+// recorded as placed, and settled in a later step, when it is no longer
+// active.
+type Scheduler struct {
+	Policy Policy
+	// Grace is how late a run can be made without being missed, one hour
+	// by default.
+	Grace time.Duration
+	// Location is where the schedules are evaluated, time.Local by default.
+	Location *time.Location
+
+	exchange Exchange
+	history  History
+	limiter  *conn.Limiter
+	now      func() time.Time
+
+	mu    sync.Mutex
+	plans []Plan
+	// since is when each plan was added, the runs of a plan that never
+	// ran are counted from then.
+	since map[string]time.Time
+}
+
+// NewScheduler builds a scheduler buying in exchange, recording the runs in
+// history.
+func NewScheduler(exchange Exchange, history History) *Scheduler {
+	return &Scheduler{
+		Grace:    time.Hour,
+		Location: time.Local,
+		exchange: exchange,
+		history:  history,
+		limiter:  conn.NewDefaultLimiter(),
+		now:      time.Now,
+		since:    make(map[string]time.Time),
+	}
+}
+
+// SetLimiter sets the limiter spacing the calls to CryptoMarket.
+func (s *Scheduler) SetLimiter(limiter *conn.Limiter) {
+	s.limiter = limiter
+}
+
+// AddPlan adds a plan to the scheduler.
+func (s *Scheduler) AddPlan(plan Plan) error {
+	if plan.Name == "" {
+		plan.Name = plan.Market
+	}
+	if len(plan.Market) < 6 {
+		return fmt.Errorf("invalid market %q", plan.Market)
+	}
+	if plan.Spend <= 0 {
+		return fmt.Errorf("invalid spend %v of plan %s", plan.Spend, plan.Name)
+	}
+	if plan.Schedule == nil {
+		return fmt.Errorf("plan %s has no schedule", plan.Name)
+	}
+	if plan.Method == "" {
+		plan.Method = Instant
+	}
+	if plan.Method != Instant && plan.Method != Limit {
+		return fmt.Errorf("invalid method %q of plan %s", plan.Method, plan.Name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.since[plan.Name]; ok {
+		return fmt.Errorf("plan %s already added", plan.Name)
+	}
+	s.plans = append(s.plans, plan)
+	s.since[plan.Name] = s.now()
+	return nil
+}
+
+// Plans returns the plans of the scheduler.
+func (s *Scheduler) Plans() []Plan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plans := make([]Plan, len(s.plans))
+	copy(plans, s.plans)
+	return plans
+}
+
+// Next returns the time of the next run of the plans, or the zero time if
+// there is none.
+func (s *Scheduler) Next() time.Time {
+	now := s.now().In(s.Location)
+	var next time.Time
+	for _, plan := range s.Plans() {
+		t := plan.Schedule.Next(now)
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// Step settles the placed runs whose order is no longer active, makes the
+// runs of the plans due since their last run, and returns them as recorded
+// in the history. It fails only when the history can not be read or
+// written, the failed buys are recorded as such.
+func (s *Scheduler) Step() ([]Execution, error) {
+	now := s.now().In(s.Location)
+	var executions []Execution
+	for _, plan := range s.Plans() {
+		settled, err := s.settlePlaced(plan, now)
+		executions = append(executions, settled...)
+		if err != nil {
+			return executions, err
+		}
+		s.mu.Lock()
+		from := s.since[plan.Name]
+		s.mu.Unlock()
+		last, err := s.history.Last(plan.Name)
+		if err != nil {
+			return executions, fmt.Errorf("error reading the history of %s: %s", plan.Name, err)
+		}
+		if last != nil {
+			from = last.Scheduled
+		}
+		due := plan.Schedule.Between(from.In(s.Location), now)
+		for i, scheduled := range due {
+			run := Execution{
+				Plan:      plan.Name,
+				Market:    plan.Market,
+				Scheduled: scheduled,
+				Time:      now,
+				Method:    plan.Method,
+				Spend:     plan.Spend,
+			}
+			if s.skip(due, i, now) {
+				run.Status = Skipped
+				run.Reason = "missed"
+			} else {
+				s.buy(plan, &run)
+			}
+			if err := s.history.Add(run); err != nil {
+				return executions, fmt.Errorf("error recording the run of %s: %s", plan.Name, err)
+			}
+			executions = append(executions, run)
+		}
+	}
+	return executions, nil
+}
+
+// settlePlaced records the end of the placed runs of a plan whose order is
+// no longer active. An order whose status can not be read is tried again
+// in the next step.
+func (s *Scheduler) settlePlaced(plan Plan, now time.Time) ([]Execution, error) {
+	placed, err := s.history.Placed(plan.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the history of %s: %s", plan.Name, err)
+	}
+	var executions []Execution
+	for _, run := range placed {
+		s.limiter.Wait()
+		order, err := s.exchange.GetOrderStatus(args.Id(run.OrderId))
+		if err != nil || order.Status == "active" {
+			continue
+		}
+		run.Time = now
+		settle(&run, order)
+		if err := s.history.Add(run); err != nil {
+			return executions, fmt.Errorf("error recording the run of %s: %s", plan.Name, err)
+		}
+		executions = append(executions, run)
+	}
+	return executions, nil
+}
+
+// skip tells if the run i of the due ones is skipped by the policy.
+func (s *Scheduler) skip(due []time.Time, i int, now time.Time) bool {
+	if now.Sub(due[i]) <= s.Grace {
+		return false
+	}
+	switch s.Policy {
+	case CatchUpAll:
+		return false
+	case CatchUpLast:
+		// only the last due run is made, missed or not
+		return i+1 < len(due)
+	}
+	return true
+}
+
+// buy makes the buy of a run, checking the balance first.
+func (s *Scheduler) buy(plan Plan, run *Execution) {
+	run.Status = Failed
+	quote := plan.Market[len(plan.Market)-3:]
+	s.limiter.Wait()
+	available, err := s.available(quote)
+	if err != nil {
+		run.Reason = err.Error()
+		return
+	}
+	if available < plan.Spend {
+		run.Status = Skipped
+		run.Reason = fmt.Sprintf("not enough %s: %s available", quote, execution.FormatFloat(available))
+		return
+	}
+	s.limiter.Wait()
+	book, err := s.exchange.GetBook(args.Market(plan.Market), args.Type("sell"), args.Limit(100))
+	if err != nil {
+		run.Reason = fmt.Sprintf("error getting the book: %s", err)
+		return
+	}
+	var order *conn.Order
+	switch plan.Method {
+	case Instant:
+		run.Amount, run.Price, err = spendOnBook(book.Data, plan.Spend)
+		if err == nil {
+			s.limiter.Wait()
+			err = s.exchange.CreateInstant(
+				args.Market(plan.Market),
+				args.Type("buy"),
+				args.Amount(execution.FormatFloat(run.Amount)))
+		}
+	case Limit:
+		run.Price, err = bestPrice(book.Data)
+		if err == nil {
+			run.Price = round8(run.Price * (1 - plan.Discount))
+			run.Amount = floor8(plan.Spend / run.Price)
+			s.limiter.Wait()
+			order, err = s.exchange.CreateOrder(
+				args.Amount(execution.FormatFloat(run.Amount)),
+				args.Market(plan.Market),
+				args.Price(execution.FormatFloat(run.Price)),
+				args.Type("buy"))
+		}
+	}
+	if err != nil {
+		run.Reason = err.Error()
+		return
+	}
+	if order != nil {
+		run.OrderId = order.Id
+		settle(run, order)
+		return
+	}
+	run.Status = Executed
+}
+
+// settle sets the status of a run from its order: placed while the order
+// is active, executed with the amount bought once it is not, or failed
+// when it was cancelled with nothing bought.
+func settle(run *Execution, order *conn.Order) {
+	if order.Status == "active" {
+		run.Status = Placed
+		return
+	}
+	executed, _ := strconv.ParseFloat(order.Amount.Executed, 64)
+	if executed <= 0 {
+		run.Status = Failed
+		run.Reason = fmt.Sprintf("order %s %s", order.Id, order.Status)
+		return
+	}
+	run.Status = Executed
+	run.Amount = executed
+}
+
+// available returns the available balance of a currency.
+func (s *Scheduler) available(currency string) (float64, error) {
+	balances, err := s.exchange.GetBalance()
+	if err != nil {
+		return 0, fmt.Errorf("error getting the balance: %s", err)
+	}
+	for _, balance := range balances {
+		if strings.EqualFold(balance.Wallet, currency) {
+			available, err := strconv.ParseFloat(balance.Available, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid balance %q: %s", balance.Available, err)
+			}
+			return available, nil
+		}
+	}
+	return 0, nil
+}
+
+// spendOnBook returns the amount bought spending the given amount on the
+// sell book, and its average price.
+func spendOnBook(book []conn.BookData, spend float64) (float64, float64, error) {
+	if len(book) == 0 {
+		return 0, 0, errors.New("the sell book is empty")
+	}
+	amount, rest := 0.0, spend
+	for _, entry := range book {
+		price, err := strconv.ParseFloat(entry.Price, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid book price %q: %s", entry.Price, err)
+		}
+		entryAmount, err := strconv.ParseFloat(entry.Amount, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid book amount %q: %s", entry.Amount, err)
+		}
+		if entryAmount*price >= rest {
+			amount += rest / price
+			rest = 0
+			break
+		}
+		amount += entryAmount
+		rest -= entryAmount * price
+	}
+	if rest > 0 {
+		return 0, 0, fmt.Errorf("not enough volume in the book to spend %s", execution.FormatFloat(spend))
+	}
+	amount = floor8(amount)
+	if amount == 0 {
+		return 0, 0, fmt.Errorf("spending %s buys nothing", execution.FormatFloat(spend))
+	}
+	return amount, spend / amount, nil
+}
+
+func bestPrice(book []conn.BookData) (float64, error) {
+	if len(book) == 0 {
+		return 0, errors.New("the sell book is empty")
+	}
+	price, err := strconv.ParseFloat(book[0].Price, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid book price %q: %s", book[0].Price, err)
+	}
+	return price, nil
+}
+
+// Run makes the runs of the plans at their times until done is closed.
+func (s *Scheduler) Run(done <-chan struct{}) error {
+	for {
+		if _, err := s.Step(); err != nil {
+			return err
+		}
+		next := s.Next()
+		if next.IsZero() {
+			return errors.New("no run left in the schedules")
+		}
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-done:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+func round8(val float64) float64 {
+	return math.Round(val*1e8) / 1e8
+}
+
+// floor8 rounds down to 8 decimals, so a buy never spends more than planned.
+func floor8(val float64) float64 {
+	return math.Floor(round8(val*1e8)) / 1e8
+}
@@ -0,0 +1,231 @@
+package dca
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+// newScheduler builds a scheduler buying with a paper client against a fake
+// server, at a fixed time.
+func newScheduler(t *testing.T, client *conn.Client, path string, now *time.Time) *Scheduler {
+	history, err := OpenHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(client, history)
+	s.SetLimiter(conn.NewLimiter(0))
+	s.Location = time.UTC
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func available(t *testing.T, client *conn.Client, currency string) float64 {
+	balances, err := client.GetBalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, balance := range balances {
+		if balance.Wallet == currency {
+			amount, _ := strconv.ParseFloat(balance.Available, 64)
+			return amount
+		}
+	}
+	return 0
+}
+
+func statuses(executions []Execution) []string {
+	var result []string
+	for _, execution := range executions {
+		result = append(result, execution.Scheduled.Format("01-02")+" "+execution.Status)
+	}
+	return result
+}
+
+func TestScheduler(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	client := conn.NewPaperClient("key", "secret", map[string]float64{"CLP": 1000000})
+	client.SetBaseUri(server.URL)
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	// a monday morning
+	now := time.Date(2020, 1, 6, 8, 0, 0, 0, time.UTC)
+	s := newScheduler(t, client, path, &now)
+	plan := Plan{Market: "ETHCLP", Spend: 100000, Schedule: MustParseSchedule("0 9 * * 1")}
+	if err := s.AddPlan(plan); err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(); !next.Equal(time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected next run %v", next)
+	}
+	if executions, err := s.Step(); err != nil || len(executions) != 0 {
+		t.Errorf("nothing should run before 9, got %v, %v", executions, err)
+	}
+	now = now.Add(70 * time.Minute)
+	executions, err := s.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 1 || executions[0].Status != Executed {
+		t.Fatalf("expected a buy, got %+v", executions)
+	}
+	// half an ETH at 150100 and the rest at 150200
+	if executions[0].Amount != 0.66611185 {
+		t.Errorf("unexpected amount bought %v", executions[0].Amount)
+	}
+	if clp := available(t, client, "CLP"); clp < 900000 || clp > 900001 {
+		t.Errorf("expected 100000 CLP spent, left %v", clp)
+	}
+	if eth := available(t, client, "ETH"); eth <= 0.66 {
+		t.Errorf("expected the ETH bought, got %v", eth)
+	}
+	if executions, _ := s.Step(); len(executions) != 0 {
+		t.Errorf("a run should be made once, got %v", executions)
+	}
+
+	// restarted three weeks later, the runs of the 13th and the 20th were
+	// missed
+	now = time.Date(2020, 1, 27, 9, 5, 0, 0, time.UTC)
+	tests := []struct {
+		policy   Policy
+		expected []string
+	}{
+		{SkipMissed, []string{"01-13 skipped", "01-20 skipped", "01-27 executed"}},
+		{CatchUpLast, []string{"01-13 skipped", "01-20 skipped", "01-27 executed"}},
+		{CatchUpAll, []string{"01-13 executed", "01-20 executed", "01-27 executed"}},
+	}
+	for _, test := range tests {
+		copyPath := filepath.Join(t.TempDir(), "history.jsonl")
+		history, _ := OpenHistory(path)
+		copied, _ := OpenHistory(copyPath)
+		for _, execution := range history.Executions("") {
+			copied.Add(execution)
+		}
+		restarted := newScheduler(t, client, copyPath, &now)
+		restarted.Policy = test.policy
+		restarted.AddPlan(plan)
+		executions, err := restarted.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := statuses(executions); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("policy %d: expected %v, got %v", test.policy, test.expected, got)
+		}
+		reopened, _ := OpenHistory(copyPath)
+		if len(reopened.Executions("ETHCLP")) != 4 {
+			t.Errorf("expected the runs kept in the history, got %v", reopened.Executions(""))
+		}
+	}
+
+	// the catch up of the last missed run, made outside its grace
+	now = time.Date(2020, 1, 27, 12, 0, 0, 0, time.UTC)
+	lastPath := filepath.Join(t.TempDir(), "history.jsonl")
+	last := newScheduler(t, client, lastPath, &now)
+	last.Policy = CatchUpLast
+	last.history.Add(Execution{Plan: "ETHCLP", Scheduled: time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC), Status: Executed})
+	last.AddPlan(plan)
+	executions, _ = last.Step()
+	if got := statuses(executions); !reflect.DeepEqual(got, []string{"01-13 skipped", "01-20 skipped", "01-27 executed"}) {
+		t.Errorf("expected the last missed run made, got %v", got)
+	}
+}
+
+func TestSchedulerLimitAndBalance(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	client := conn.NewPaperClient("key", "secret", map[string]float64{"CLP": 1000000})
+	client.SetBaseUri(server.URL)
+	now := time.Date(2020, 1, 6, 8, 0, 0, 0, time.UTC)
+	s := newScheduler(t, client, filepath.Join(t.TempDir(), "history.jsonl"), &now)
+	daily := MustParseSchedule("@daily")
+	if err := s.AddPlan(Plan{Market: "ETHCLP", Spend: 148600, Schedule: daily, Method: Limit, Discount: 0.01}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddPlan(Plan{Name: "big", Market: "BTCCLP", Spend: 2000000, Schedule: daily}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddPlan(Plan{Market: "ETHCLP", Spend: 1, Schedule: daily}); err == nil {
+		t.Errorf("a plan with a repeated name should fail")
+	}
+	now = now.Add(16*time.Hour + 30*time.Minute)
+	executions, err := s.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 2 {
+		t.Fatalf("expected a run of each plan, got %+v", executions)
+	}
+	limit, big := executions[0], executions[1]
+	if limit.Status != Placed || limit.Price != 148599 || limit.Amount != 1.00000672 || limit.OrderId == "" {
+		t.Errorf("expected a limit order placed 1%% under 150100, got %+v", limit)
+	}
+	if big.Status != Skipped || big.Reason == "" {
+		t.Errorf("a buy over the balance should be skipped, got %+v", big)
+	}
+	if orders, _ := client.GetActiveOrdersAllPages(args.Market("ETHCLP")); len(orders) != 1 {
+		t.Errorf("expected the limit order active, got %v", orders)
+	}
+
+	// the order still active is not settled
+	now = now.Add(time.Hour)
+	if executions, err := s.Step(); err != nil || len(executions) != 0 {
+		t.Fatalf("expected no run, got %+v, %v", executions, err)
+	}
+	if _, err := client.CancelOrder(args.Id(limit.OrderId)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	executions, err = s.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 1 || executions[0].Status != Failed || executions[0].OrderId != limit.OrderId || !executions[0].Scheduled.Equal(limit.Scheduled) {
+		t.Fatalf("expected the cancelled order settled as failed, got %+v", executions)
+	}
+	if executions, err := s.Step(); err != nil || len(executions) != 0 {
+		t.Errorf("a run should be settled once, got %+v, %v", executions, err)
+	}
+}
+
+func TestSchedulerLimitExecuted(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	client := conn.NewPaperClient("key", "secret", map[string]float64{"CLP": 1000000})
+	client.SetBaseUri(server.URL)
+	now := time.Date(2020, 1, 6, 8, 0, 0, 0, time.UTC)
+	s := newScheduler(t, client, filepath.Join(t.TempDir(), "history.jsonl"), &now)
+	// at the best ask the order is executed as it is placed
+	if err := s.AddPlan(Plan{Market: "ETHCLP", Spend: 15010, Schedule: MustParseSchedule("@daily"), Method: Limit}); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(16*time.Hour + 30*time.Minute)
+	executions, err := s.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 1 || executions[0].Status != Executed || executions[0].Amount != 0.1 || executions[0].OrderId == "" {
+		t.Errorf("expected the limit order executed, got %+v", executions)
+	}
+}
+
+func TestAddPlanErrors(t *testing.T) {
+	s := NewScheduler(nil, nil)
+	daily := MustParseSchedule("@daily")
+	plans := []Plan{
+		{Market: "ETH", Spend: 1, Schedule: daily},
+		{Market: "ETHCLP", Spend: 0, Schedule: daily},
+		{Market: "ETHCLP", Spend: 1},
+		{Market: "ETHCLP", Spend: 1, Schedule: daily, Method: "market"},
+	}
+	for _, plan := range plans {
+		if err := s.AddPlan(plan); err == nil {
+			t.Errorf("plan %+v should be invalid", plan)
+		}
+	}
+}
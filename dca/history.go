@@ -0,0 +1,164 @@
+package dca
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The status of an execution in the history.
+const (
+	Executed = "executed"
+	Skipped  = "skipped"
+	Failed   = "failed"
+	// Placed is a run whose limit order is active. It is settled by a later
+	// execution of the same order, executed or failed.
+	Placed = "placed"
+)
+
+// An Execution is a run of a plan, as kept in the history. Every run of the
+// schedule is kept, including those skipped and those failed.
+type Execution struct {
+	Plan   string `json:"plan"`
+	Market string `json:"market"`
+	// Scheduled is the time of the run in the schedule.
+	Scheduled time.Time `json:"scheduled"`
+	// Time is when the run was made.
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	Method Method    `json:"method"`
+	// Spend is the amount of the quote currency of the buy.
+	Spend float64 `json:"spend"`
+	// Amount and Price are the amount of the base currency bought and its
+	// price, estimated from the book for instant buys.
+	Amount  float64 `json:"amount,omitempty"`
+	Price   float64 `json:"price,omitempty"`
+	OrderId string  `json:"order_id,omitempty"`
+	// Reason tells why the run was skipped or failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// A History keeps the executions of the plans between restarts.
+type History interface {
+	// Last returns the last execution of a plan, or nil if it never ran.
+	Last(plan string) (*Execution, error)
+	// Placed returns the placed executions of a plan not yet settled.
+	Placed(plan string) ([]Execution, error)
+	// Add records an execution.
+	Add(execution Execution) error
+}
+
+// A FileHistory keeps the executions in a file, one JSON object per line,
+// appending each new execution.
+type FileHistory struct {
+	path string
+
+	mu         sync.Mutex
+	executions []Execution
+}
+
+// OpenHistory opens the history kept in the file at path, which is created
+// by the first execution added if it does not exist.
+func OpenHistory(path string) (*FileHistory, error) {
+	h := &FileHistory{path: path}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening the history: %s", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var execution Execution
+		if err := json.Unmarshal(scanner.Bytes(), &execution); err != nil {
+			return nil, fmt.Errorf("error reading the history, line %d: %s", line, err)
+		}
+		h.executions = append(h.executions, execution)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the history: %s", err)
+	}
+	return h, nil
+}
+
+// Executions returns the executions of a plan, or of all the plans when
+// plan is empty, in the order they were added.
+func (h *FileHistory) Executions(plan string) []Execution {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var executions []Execution
+	for _, execution := range h.executions {
+		if plan == "" || execution.Plan == plan {
+			executions = append(executions, execution)
+		}
+	}
+	return executions
+}
+
+// Last returns the last execution of a plan, or nil if it never ran: the
+// one of the latest scheduled time, and the last added of that time, as a
+// settlement is added after newer runs.
+func (h *FileHistory) Last(plan string) (*Execution, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var last *Execution
+	for i := range h.executions {
+		execution := h.executions[i]
+		if execution.Plan == plan && (last == nil || !execution.Scheduled.Before(last.Scheduled)) {
+			last = &execution
+		}
+	}
+	return last, nil
+}
+
+// Placed returns the placed executions of a plan not yet settled.
+func (h *FileHistory) Placed(plan string) ([]Execution, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// the last status of each order
+	status := make(map[string]string)
+	for _, execution := range h.executions {
+		if execution.Plan == plan && execution.OrderId != "" {
+			status[execution.OrderId] = execution.Status
+		}
+	}
+	var placed []Execution
+	for _, execution := range h.executions {
+		if execution.Plan == plan && execution.Status == Placed && status[execution.OrderId] == Placed {
+			placed = append(placed, execution)
+			delete(status, execution.OrderId)
+		}
+	}
+	return placed, nil
+}
+
+// Add appends an execution to the file.
+func (h *FileHistory) Add(execution Execution) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data, err := json.Marshal(execution)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing the history: %s", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing the history: %s", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing the history: %s", err)
+	}
+	h.executions = append(h.executions, execution)
+	return nil
+}
@@ -0,0 +1,224 @@
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// OrderManager is the part of the client needed to follow and cancel the
+// orders it creates.
+type OrderManager interface {
+	OrderCreator
+	GetOrderStatus(arguments ...args.Argument) (*conn.Order, error)
+	CancelOrder(arguments ...args.Argument) (*conn.Order, error)
+}
+
+// Progress is the aggregated state of a parent order executed in children.
+type Progress struct {
+	Executed  float64
+	Remaining float64
+	// AvgPrice is the average execution price weighted by the executed amounts.
+	AvgPrice float64
+	Children int
+	Done     bool
+}
+
+// Iceberg executes a big limit order showing only a small part of it in the
+// book. The parent amount is split in child limit orders of the visible
+// amount, a new child is placed each time the previous one is fully executed.
+type Iceberg struct {
+	Market  string
+	Type    string
+	Price   string
+	Total   float64
+	Visible float64
+
+	orders OrderManager
+
+	mu        sync.Mutex
+	child     *conn.Order
+	children  int
+	executed  float64 // executed by the finished children
+	notional  float64 // executed amount times price of the finished children
+	cancelled bool
+}
+
+// NewIceberg builds an iceberg order of the given total amount, showing at
+// most visible amount at a time.
+func NewIceberg(market, orderType, price string, total, visible float64, orders OrderManager) *Iceberg {
+	return &Iceberg{
+		Market:  market,
+		Type:    orderType,
+		Price:   price,
+		Total:   total,
+		Visible: visible,
+		orders:  orders,
+	}
+}
+
+// Start places the first child order.
+func (ib *Iceberg) Start() error {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if ib.child != nil || ib.children > 0 {
+		return errors.New("iceberg already started")
+	}
+	if ib.Total <= 0 || ib.Visible <= 0 {
+		return errors.New("total and visible amounts must be greater than 0")
+	}
+	return ib.replenish()
+}
+
+// Poll refreshes the current child order. When the child is fully executed
+// its execution is accounted and the next child is placed. A child that
+// could not be placed is placed again by the next Poll.
+func (ib *Iceberg) Poll() error {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if ib.children == 0 || ib.cancelled {
+		return nil
+	}
+	if ib.child == nil {
+		// the last replenish failed, or there is nothing left to place
+		return ib.replenish()
+	}
+	child, err := ib.orders.GetOrderStatus(args.Id(ib.child.Id))
+	if err != nil {
+		return fmt.Errorf("error refreshing child order %s: %s", ib.child.Id, err)
+	}
+	ib.child = child
+	remaining, err := strconv.ParseFloat(child.Amount.Remaining, 64)
+	if err != nil && child.Status != "executed" {
+		return fmt.Errorf("invalid remaining amount %q in order %s", child.Amount.Remaining, child.Id)
+	}
+	if child.Status == "cancelled" {
+		ib.finishChild()
+		ib.cancelled = true
+		return fmt.Errorf("child order %s was cancelled outside the iceberg", child.Id)
+	}
+	if remaining > 0 && child.Status != "executed" {
+		return nil
+	}
+	ib.finishChild()
+	return ib.replenish()
+}
+
+// Run polls the iceberg every interval until it is done, cancelled, an
+// error is raised or the done channel is closed.
+func (ib *Iceberg) Run(interval time.Duration, done <-chan struct{}) error {
+	for !ib.Progress().Done {
+		select {
+		case <-done:
+			return nil
+		case <-time.After(interval):
+		}
+		if err := ib.Poll(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel cancels the child in the book, and stops placing new children. If
+// the cancel fails the iceberg goes on, as the child is still in the book.
+func (ib *Iceberg) Cancel() error {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if ib.child == nil {
+		ib.cancelled = true
+		return nil
+	}
+	child, err := ib.orders.CancelOrder(args.Id(ib.child.Id))
+	if err != nil {
+		return fmt.Errorf("error cancelling child order %s: %s", ib.child.Id, err)
+	}
+	ib.child = child
+	ib.finishChild()
+	ib.cancelled = true
+	return nil
+}
+
+// Progress returns the aggregated execution of the iceberg.
+func (ib *Iceberg) Progress() Progress {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	executed, notional := ib.executed, ib.notional
+	if ib.child != nil {
+		amount, price := childExecution(ib.child)
+		executed += amount
+		notional += amount * price
+	}
+	progress := Progress{
+		Executed:  round8(executed),
+		Remaining: round8(ib.Total - executed),
+		Children:  ib.children,
+	}
+	progress.Done = ib.cancelled || (ib.children > 0 && ib.child == nil && progress.Remaining <= 0)
+	if executed > 0 {
+		progress.AvgPrice = notional / executed
+	}
+	return progress
+}
+
+// finishChild accounts the execution of the current child and forgets it.
+func (ib *Iceberg) finishChild() {
+	amount, price := childExecution(ib.child)
+	ib.executed += amount
+	ib.notional += amount * price
+	ib.child = nil
+}
+
+// replenish places the next child, if there is amount left to execute.
+func (ib *Iceberg) replenish() error {
+	remaining := round8(ib.Total - ib.executed)
+	if remaining <= 0 || ib.cancelled {
+		return nil
+	}
+	amount := math.Min(ib.Visible, remaining)
+	child, err := ib.orders.CreateOrder(
+		args.Amount(FormatFloat(amount)),
+		args.Market(ib.Market),
+		args.Price(ib.Price),
+		args.Type(ib.Type),
+	)
+	if err != nil {
+		return fmt.Errorf("error placing child order: %s", err)
+	}
+	ib.child = child
+	ib.children++
+	return nil
+}
+
+// childExecution returns the executed amount of an order, and the price it
+// was executed at.
+func childExecution(order *conn.Order) (float64, float64) {
+	var executed float64
+	if order.Amount.Executed != "" {
+		executed, _ = strconv.ParseFloat(order.Amount.Executed, 64)
+	} else {
+		original, _ := strconv.ParseFloat(order.Amount.Original, 64)
+		remaining, _ := strconv.ParseFloat(order.Amount.Remaining, 64)
+		executed = original - remaining
+	}
+	price, err := strconv.ParseFloat(order.ExecutionPrice, 64)
+	if err != nil || price == 0 {
+		price = float64(order.AvgExecutionPrice)
+	}
+	if price == 0 {
+		price, _ = strconv.ParseFloat(order.Price, 64)
+	}
+	return executed, price
+}
+
+// round8 rounds an amount to 8 decimals, the precision of the crypto
+// currencies, to avoid carrying float errors into the requests.
+func round8(val float64) float64 {
+	return math.Round(val*1e8) / 1e8
+}
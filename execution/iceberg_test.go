@@ -0,0 +1,157 @@
+package execution
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// fakeOrders keeps the orders created, letting the tests execute them.
+type fakeOrders struct {
+	orders map[string]*conn.Order
+	last   string
+	// failCreate and failCancel are the number of calls to fail
+	failCreate, failCancel int
+}
+
+func newFakeOrders() *fakeOrders {
+	return &fakeOrders{orders: make(map[string]*conn.Order)}
+}
+
+func (f *fakeOrders) CreateOrder(arguments ...args.Argument) (*conn.Order, error) {
+	if f.failCreate > 0 {
+		f.failCreate--
+		return nil, errors.New("server error")
+	}
+	argsMap := toMap(arguments...)
+	f.last = "M" + strconv.Itoa(len(f.orders)+1)
+	order := &conn.Order{
+		Id:     f.last,
+		Status: "active",
+		Type:   argsMap["type"],
+		Price:  argsMap["price"],
+		Market: argsMap["market"],
+		Amount: conn.Amount{Original: argsMap["amount"], Remaining: argsMap["amount"], Executed: "0"},
+	}
+	f.orders[order.Id] = order
+	copied := *order
+	return &copied, nil
+}
+
+func (f *fakeOrders) GetOrderStatus(arguments ...args.Argument) (*conn.Order, error) {
+	copied := *f.orders[toMap(arguments...)["id"]]
+	return &copied, nil
+}
+
+func (f *fakeOrders) CancelOrder(arguments ...args.Argument) (*conn.Order, error) {
+	if f.failCancel > 0 {
+		f.failCancel--
+		return nil, errors.New("server error")
+	}
+	order := f.orders[toMap(arguments...)["id"]]
+	order.Status = "cancelled"
+	copied := *order
+	return &copied, nil
+}
+
+// fill executes an amount of an order at a given price.
+func (f *fakeOrders) fill(id string, amount float64, price string) {
+	order := f.orders[id]
+	remaining, _ := strconv.ParseFloat(order.Amount.Remaining, 64)
+	executed, _ := strconv.ParseFloat(order.Amount.Executed, 64)
+	order.Amount.Remaining = FormatFloat(round8(remaining - amount))
+	order.Amount.Executed = FormatFloat(round8(executed + amount))
+	order.ExecutionPrice = price
+	if remaining-amount <= 0 {
+		order.Status = "executed"
+	}
+}
+
+func TestIceberg(t *testing.T) {
+	orders := newFakeOrders()
+	ib := NewIceberg("ETHCLP", "buy", "1000", 2.5, 1, orders)
+	if err := ib.Start(); err != nil {
+		t.Fatal(err)
+	}
+	orders.fill(orders.last, 0.4, "1000")
+	if err := ib.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if p := ib.Progress(); p.Executed != 0.4 || p.Children != 1 {
+		t.Errorf("partial fill should not replenish, got %+v", p)
+	}
+	orders.fill(orders.last, 0.6, "1000")
+	ib.Poll()
+	orders.fill(orders.last, 1, "990")
+	ib.Poll()
+	if p := ib.Progress(); p.Children != 3 || p.Executed != 2 || p.Remaining != 0.5 {
+		t.Errorf("expected 3 children and 2 executed, got %+v", p)
+	}
+	if orders.orders[orders.last].Amount.Original != "0.5" {
+		t.Errorf("last child should show the remaining 0.5, got %s", orders.orders[orders.last].Amount.Original)
+	}
+	orders.fill(orders.last, 0.5, "980")
+	ib.Poll()
+	p := ib.Progress()
+	if !p.Done || p.Executed != 2.5 || p.Remaining != 0 {
+		t.Errorf("iceberg should be done, got %+v", p)
+	}
+	if want := (1000 + 990 + 0.5*980) / 2.5; p.AvgPrice != want {
+		t.Errorf("average price should be %v, got %v", want, p.AvgPrice)
+	}
+}
+
+func TestIcebergCancel(t *testing.T) {
+	orders := newFakeOrders()
+	ib := NewIceberg("ETHCLP", "sell", "1000", 3, 1, orders)
+	if err := ib.Start(); err != nil {
+		t.Fatal(err)
+	}
+	orders.fill(orders.last, 0.25, "1000")
+	if err := ib.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if orders.orders[orders.last].Status != "cancelled" {
+		t.Errorf("child should be cancelled")
+	}
+	p := ib.Progress()
+	if !p.Done || p.Executed != 0.25 || p.Remaining != 2.75 {
+		t.Errorf("unexpected progress after cancel %+v", p)
+	}
+	if err := ib.Poll(); err != nil || len(orders.orders) != 1 {
+		t.Errorf("a cancelled iceberg should not place children")
+	}
+}
+
+func TestIcebergErrors(t *testing.T) {
+	orders := newFakeOrders()
+	ib := NewIceberg("ETHCLP", "buy", "1000", 2, 1, orders)
+	ib.Start()
+	orders.fill(orders.last, 1, "1000")
+	orders.failCreate = 1
+	if err := ib.Poll(); err == nil {
+		t.Fatal("expected the replenish to fail")
+	}
+	if p := ib.Progress(); p.Done || p.Remaining != 1 {
+		t.Errorf("expected the iceberg pending, got %+v", p)
+	}
+	if err := ib.Poll(); err != nil || len(orders.orders) != 2 {
+		t.Fatalf("expected the child placed again, got %v", err)
+	}
+
+	orders.failCancel = 1
+	if err := ib.Cancel(); err == nil {
+		t.Fatal("expected the cancel to fail")
+	}
+	if p := ib.Progress(); p.Done || orders.orders[orders.last].Status != "active" {
+		t.Errorf("expected the iceberg going on, got %+v", p)
+	}
+	orders.fill(orders.last, 1, "1000")
+	ib.Poll()
+	if p := ib.Progress(); !p.Done || p.Executed != 2 {
+		t.Errorf("expected the iceberg done, got %+v", p)
+	}
+}
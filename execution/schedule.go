@@ -0,0 +1,400 @@
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// timeLayouts are the layouts used by CryptoMarket in its dates.
+var timeLayouts = []string{
+	"2006-01-02T15:04:05.999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+}
+
+// ParseTime parses a timestamp as given by CryptoMarket, in UTC.
+func ParseTime(val string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", val)
+}
+
+// TradesGetter is the part of the client needed to read the trades of a market.
+type TradesGetter interface {
+	GetTrades(arguments ...args.Argument) (*conn.Trades, error)
+}
+
+// A Slice is a part of a parent order, to be executed at a given time.
+type Slice struct {
+	Time   time.Time
+	Amount float64
+}
+
+// TWAPSchedule splits the total amount in equal slices spread evenly over
+// the window starting at start.
+func TWAPSchedule(start time.Time, window time.Duration, slices int, total float64) ([]Slice, error) {
+	if slices <= 0 {
+		return nil, errors.New("the number of slices must be greater than 0")
+	}
+	weights := make([]float64, slices)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return weightedSchedule(start, window, total, weights), nil
+}
+
+// A VolumeProfile is the share of the daily volume traded in each bucket of
+// the day, buckets are of equal length and start at 00:00 UTC.
+type VolumeProfile []float64
+
+// VolumeProfileFromTrades builds a volume profile of the given number of
+// buckets from historical trades, as given by GetTrades.
+func VolumeProfileFromTrades(trades []conn.TradeData, buckets int) (VolumeProfile, error) {
+	if buckets <= 0 {
+		return nil, errors.New("the number of buckets must be greater than 0")
+	}
+	profile := make(VolumeProfile, buckets)
+	for _, trade := range trades {
+		t, err := ParseTime(trade.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := strconv.ParseFloat(trade.Amount, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trade amount %q: %s", trade.Amount, err)
+		}
+		profile[profile.bucket(t)] += amount
+	}
+	return profile.normalize()
+}
+
+// VolumeProfileFromCandles builds a volume profile of the given number of
+// buckets from historical candles, as given by GetPrices.
+func VolumeProfileFromCandles(candles []conn.Candle, buckets int) (VolumeProfile, error) {
+	if buckets <= 0 {
+		return nil, errors.New("the number of buckets must be greater than 0")
+	}
+	profile := make(VolumeProfile, buckets)
+	for _, candle := range candles {
+		t, err := ParseTime(candle.CandleDate)
+		if err != nil {
+			return nil, err
+		}
+		volume, err := strconv.ParseFloat(candle.VolumeSum, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid candle volume %q: %s", candle.VolumeSum, err)
+		}
+		profile[profile.bucket(t)] += volume
+	}
+	return profile.normalize()
+}
+
+// bucket returns the bucket of the profile a time belongs to.
+func (vp VolumeProfile) bucket(t time.Time) int {
+	t = t.UTC()
+	sinceMidnight := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	return int(sinceMidnight * time.Duration(len(vp)) / (24 * time.Hour))
+}
+
+func (vp VolumeProfile) normalize() (VolumeProfile, error) {
+	var total float64
+	for _, v := range vp {
+		total += v
+	}
+	if total == 0 {
+		return nil, errors.New("no volume to build a profile")
+	}
+	for i := range vp {
+		vp[i] /= total
+	}
+	return vp, nil
+}
+
+// VWAPSchedule splits the total amount in slices spread evenly over the
+// window starting at start, each slice weighted by the volume expected at
+// its time of the day.
+func VWAPSchedule(start time.Time, window time.Duration, slices int, total float64, profile VolumeProfile) ([]Slice, error) {
+	if slices <= 0 {
+		return nil, errors.New("the number of slices must be greater than 0")
+	}
+	if len(profile) == 0 {
+		return nil, errors.New("the volume profile is empty")
+	}
+	weights := make([]float64, slices)
+	for i := range weights {
+		weights[i] = profile[profile.bucket(start.Add(window*time.Duration(i)/time.Duration(slices)))]
+	}
+	return weightedSchedule(start, window, total, weights), nil
+}
+
+func weightedSchedule(start time.Time, window time.Duration, total float64, weights []float64) []Slice {
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	schedule := make([]Slice, len(weights))
+	var assigned float64
+	for i, w := range weights {
+		schedule[i].Time = start.Add(window * time.Duration(i) / time.Duration(len(weights)))
+		if i == len(weights)-1 {
+			// the last slice takes the rounding errors
+			schedule[i].Amount = round8(total - assigned)
+			break
+		}
+		if sum == 0 {
+			schedule[i].Amount = round8(total / float64(len(weights)))
+		} else {
+			schedule[i].Amount = round8(total * w / sum)
+		}
+		assigned += schedule[i].Amount
+	}
+	return schedule
+}
+
+// MarketVWAP returns the volume weighted average price of the trades of a
+// market between start and end. It waits for the limiter, if not nil,
+// before reading each page of trades.
+func MarketVWAP(trades TradesGetter, market string, start, end time.Time, limiter *conn.Limiter) (float64, error) {
+	var notional, volume float64
+	for page := 0; ; page++ {
+		limiter.Wait()
+		tPage, err := trades.GetTrades(
+			args.Market(market),
+			args.Start(start.UTC().Format("2006-01-02")),
+			args.End(end.UTC().AddDate(0, 0, 1).Format("2006-01-02")),
+			args.Page(page),
+			args.Limit(100))
+		if err != nil {
+			return 0, fmt.Errorf("error getting trades: %s", err)
+		}
+		for _, trade := range tPage.Data {
+			t, err := ParseTime(trade.Timestamp)
+			if err != nil {
+				return 0, err
+			}
+			if t.Before(start) || t.After(end) {
+				continue
+			}
+			price, _ := strconv.ParseFloat(trade.Price, 64)
+			amount, _ := strconv.ParseFloat(trade.Amount, 64)
+			notional += price * amount
+			volume += amount
+		}
+		if len(tPage.Data) < 100 {
+			break
+		}
+	}
+	if volume == 0 {
+		return 0, fmt.Errorf("no trades in %s between %v and %v", market, start, end)
+	}
+	return notional / volume, nil
+}
+
+// A ScheduledExecution executes a parent order in slices at the times given
+// by its schedule. Each slice is placed as a limit order priced against the
+// current book to be filled at once.
+type ScheduledExecution struct {
+	Market   string
+	Type     string
+	Schedule []Slice
+	// Benchmark, if set, computes the price the execution is compared to.
+	// When nil, the benchmark is the average of the best book prices seen
+	// when each slice was priced.
+	Benchmark func(start, end time.Time) (float64, error)
+
+	orders  OrderManager
+	book    BookGetter
+	limiter *conn.Limiter
+	now     func() time.Time
+	after   func(time.Duration) <-chan time.Time
+}
+
+// NewScheduledExecution builds an execution of the given schedule. Every
+// call made to CryptoMarket waits for the limiter first, if not nil.
+func NewScheduledExecution(market, orderType string, schedule []Slice, orders OrderManager, book BookGetter, limiter *conn.Limiter) *ScheduledExecution {
+	return &ScheduledExecution{
+		Market:   market,
+		Type:     orderType,
+		Schedule: schedule,
+		orders:   orders,
+		book:     book,
+		limiter:  limiter,
+		now:      time.Now,
+		after:    time.After,
+	}
+}
+
+// NewTWAP builds a scheduled execution in equal slices over the window.
+func NewTWAP(market, orderType string, total float64, start time.Time, window time.Duration, slices int, orders OrderManager, book BookGetter, limiter *conn.Limiter) (*ScheduledExecution, error) {
+	schedule, err := TWAPSchedule(start, window, slices, total)
+	if err != nil {
+		return nil, err
+	}
+	return NewScheduledExecution(market, orderType, schedule, orders, book, limiter), nil
+}
+
+// NewVWAP builds a scheduled execution with slices weighted by the volume
+// profile, benchmarked against the VWAP of the market trades in the window.
+func NewVWAP(market, orderType string, total float64, start time.Time, window time.Duration, slices int, profile VolumeProfile, orders OrderManager, book BookGetter, trades TradesGetter, limiter *conn.Limiter) (*ScheduledExecution, error) {
+	schedule, err := VWAPSchedule(start, window, slices, total, profile)
+	if err != nil {
+		return nil, err
+	}
+	se := NewScheduledExecution(market, orderType, schedule, orders, book, limiter)
+	se.Benchmark = func(start, end time.Time) (float64, error) {
+		return MarketVWAP(trades, market, start, end, limiter)
+	}
+	return se, nil
+}
+
+// A SliceReport is the execution of a slice.
+type SliceReport struct {
+	Slice
+	// Price is the limit price the slice was placed at.
+	Price float64
+	// BestPrice is the best price of the book when the slice was priced.
+	BestPrice float64
+	Executed  float64
+	AvgPrice  float64
+	// Cancelled tells the order was still in the book at the end of the
+	// execution, and was cancelled.
+	Cancelled bool
+	Order     *conn.Order
+	Err       error
+}
+
+// An ExecutionReport compares the execution of a scheduled order with its
+// benchmark.
+type ExecutionReport struct {
+	Market    string
+	Type      string
+	Slices    []SliceReport
+	Executed  float64
+	AvgPrice  float64
+	Benchmark float64
+	// Slippage is the cost of the execution against the benchmark in basis
+	// points, positive when the execution was worse than the benchmark.
+	Slippage float64
+}
+
+// Run executes the schedule, waiting for the time of each slice. Slices that
+// fail are reported and skipped. Closing done stops the execution before
+// the next slice, and the report of the slices placed so far is returned.
+// The orders of the slices not fully executed at the end are cancelled.
+func (se *ScheduledExecution) Run(done <-chan struct{}) (*ExecutionReport, error) {
+	if len(se.Schedule) == 0 {
+		return nil, errors.New("empty schedule")
+	}
+	report := &ExecutionReport{Market: se.Market, Type: se.Type}
+	var start time.Time
+	var bestSum float64
+	var priced int
+slices:
+	for _, slice := range se.Schedule {
+		select {
+		case <-done:
+			break slices
+		default:
+		}
+		if wait := slice.Time.Sub(se.now()); wait > 0 {
+			select {
+			case <-done:
+				break slices
+			case <-se.after(wait):
+			}
+		}
+		if start.IsZero() {
+			start = se.now()
+		}
+		sr := se.runSlice(slice)
+		report.Slices = append(report.Slices, sr)
+		if sr.BestPrice != 0 {
+			bestSum += sr.BestPrice
+			priced++
+		}
+	}
+	end := se.now()
+	// refresh the orders to know how they were executed
+	var notional float64
+	for i := range report.Slices {
+		sr := &report.Slices[i]
+		if sr.Order == nil {
+			continue
+		}
+		se.limiter.Wait()
+		order, err := se.orders.GetOrderStatus(args.Id(sr.Order.Id))
+		if err != nil {
+			sr.Err = fmt.Errorf("error refreshing order %s: %s", sr.Order.Id, err)
+			continue
+		}
+		if order.Status == "active" {
+			se.limiter.Wait()
+			cancelled, err := se.orders.CancelOrder(args.Id(order.Id))
+			if err != nil {
+				sr.Err = fmt.Errorf("error cancelling order %s: %s", order.Id, err)
+			} else {
+				order, sr.Cancelled = cancelled, true
+			}
+		}
+		sr.Order = order
+		sr.Executed, sr.AvgPrice = childExecution(order)
+		report.Executed += sr.Executed
+		notional += sr.Executed * sr.AvgPrice
+	}
+	report.Executed = round8(report.Executed)
+	if report.Executed > 0 {
+		report.AvgPrice = notional / report.Executed
+	}
+	if se.Benchmark != nil {
+		benchmark, err := se.Benchmark(start, end)
+		if err != nil {
+			return report, fmt.Errorf("error computing the benchmark: %s", err)
+		}
+		report.Benchmark = benchmark
+	} else if priced > 0 {
+		report.Benchmark = bestSum / float64(priced)
+	}
+	if report.Benchmark != 0 && report.AvgPrice != 0 {
+		report.Slippage = (report.AvgPrice - report.Benchmark) / report.Benchmark * 1e4
+		if se.Type == "sell" {
+			report.Slippage = -report.Slippage
+		}
+	}
+	return report, nil
+}
+
+// runSlice prices a slice against the book and places it.
+func (se *ScheduledExecution) runSlice(slice Slice) SliceReport {
+	sr := SliceReport{Slice: slice}
+	se.limiter.Wait()
+	price, best, err := walkBook(se.book, se.Market, se.Type, slice.Amount)
+	if err != nil {
+		sr.Err = err
+		return sr
+	}
+	sr.Price, sr.BestPrice = price, best
+	se.limiter.Wait()
+	order, err := se.orders.CreateOrder(
+		args.Amount(FormatFloat(slice.Amount)),
+		args.Market(se.Market),
+		args.Price(FormatFloat(price)),
+		args.Type(se.Type),
+	)
+	if err != nil {
+		sr.Err = fmt.Errorf("error placing slice: %s", err)
+		return sr
+	}
+	sr.Order = order
+	return sr
+}
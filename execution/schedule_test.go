@@ -0,0 +1,226 @@
+package execution
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// bookOrders is a fakeOrders serving a fixed book.
+type bookOrders struct {
+	*fakeOrders
+	book []conn.BookData
+}
+
+func (b *bookOrders) GetBook(arguments ...args.Argument) (*conn.Book, error) {
+	return &conn.Book{Data: b.book}, nil
+}
+
+func TestTWAPSchedule(t *testing.T) {
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	schedule, err := TWAPSchedule(start, time.Hour, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule) != 3 {
+		t.Fatalf("should have 3 slices, got %d", len(schedule))
+	}
+	var total float64
+	for i, slice := range schedule {
+		if want := start.Add(time.Duration(i) * 20 * time.Minute); !slice.Time.Equal(want) {
+			t.Errorf("slice %d should be at %v, got %v", i, want, slice.Time)
+		}
+		total += slice.Amount
+	}
+	if round8(total) != 1 {
+		t.Errorf("slices should add up to 1, got %v", total)
+	}
+}
+
+func TestVWAPSchedule(t *testing.T) {
+	trades := []conn.TradeData{
+		{Amount: "1", Timestamp: "2020-01-01T01:30:00.000000"},
+		{Amount: "3", Timestamp: "2020-01-02T13:10:00.000000"},
+	}
+	profile, err := VolumeProfileFromTrades(trades, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile[0] != 0.25 || profile[1] != 0.75 {
+		t.Errorf("unexpected profile %v", profile)
+	}
+	start := time.Date(2020, 1, 1, 6, 0, 0, 0, time.UTC)
+	schedule, err := VWAPSchedule(start, 12*time.Hour, 2, 4, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule[0].Amount != 1 || schedule[1].Amount != 3 {
+		t.Errorf("slices should follow the profile, got %v", schedule)
+	}
+}
+
+func TestInvalidSchedules(t *testing.T) {
+	start := time.Date(2020, 1, 1, 6, 0, 0, 0, time.UTC)
+	if _, err := TWAPSchedule(start, time.Hour, -1, 1); err == nil {
+		t.Error("negative slices should fail")
+	}
+	if _, err := VWAPSchedule(start, time.Hour, 0, 1, VolumeProfile{1}); err == nil {
+		t.Error("zero slices should fail")
+	}
+	if _, err := VWAPSchedule(start, time.Hour, 4, 1, nil); err == nil {
+		t.Error("an empty profile should fail")
+	}
+	if _, err := NewTWAP("ETHCLP", "buy", 1, start, time.Hour, 0, nil, nil, nil); err == nil {
+		t.Error("NewTWAP should fail without slices")
+	}
+	if _, err := NewVWAP("ETHCLP", "buy", 1, start, time.Hour, 4, nil, nil, nil, nil, nil); err == nil {
+		t.Error("NewVWAP should fail without a profile")
+	}
+}
+
+// pagedTrades serves trades in pages of 100, recording when each was asked.
+type pagedTrades struct {
+	trades []conn.TradeData
+	calls  []time.Time
+}
+
+func (p *pagedTrades) GetTrades(arguments ...args.Argument) (*conn.Trades, error) {
+	p.calls = append(p.calls, time.Now())
+	page, _ := strconv.Atoi(toMap(arguments...)["page"])
+	from, to := page*100, (page+1)*100
+	if from > len(p.trades) {
+		from = len(p.trades)
+	}
+	if to > len(p.trades) {
+		to = len(p.trades)
+	}
+	return &conn.Trades{Data: p.trades[from:to]}, nil
+}
+
+func TestMarketVWAPWaitsForEveryPage(t *testing.T) {
+	trades := &pagedTrades{}
+	for i := 0; i < 150; i++ {
+		price := "100"
+		if i >= 100 {
+			price = "200"
+		}
+		trades.trades = append(trades.trades, conn.TradeData{Price: price, Amount: "1", Timestamp: "2020-01-01T01:30:00.000000"})
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	vwap, err := MarketVWAP(trades, "ETHCLP", start, start.Add(time.Hour*2), conn.NewLimiter(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if round8(vwap) != round8(100*100.0/150+200*50.0/150) {
+		t.Errorf("unexpected vwap %v", vwap)
+	}
+	if len(trades.calls) != 2 {
+		t.Fatalf("should read 2 pages, read %d", len(trades.calls))
+	}
+	if gap := trades.calls[1].Sub(trades.calls[0]); gap < 20*time.Millisecond {
+		t.Errorf("should wait for the limiter between pages, waited %v", gap)
+	}
+}
+
+func TestScheduledExecutionRun(t *testing.T) {
+	exchange := &bookOrders{
+		fakeOrders: newFakeOrders(),
+		book: []conn.BookData{
+			{Price: "1000", Amount: "0.5"},
+			{Price: "1010", Amount: "5"},
+		},
+	}
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	se, err := NewTWAP("ETHCLP", "buy", 2, now, time.Hour, 2, exchange, exchange, conn.NewLimiter(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slept time.Duration
+	se.now = func() time.Time { return now.Add(slept) }
+	se.after = func(d time.Duration) <-chan time.Time {
+		slept += d
+		// everything placed so far gets executed at the limit price
+		for id, order := range exchange.orders {
+			if order.Status == "active" {
+				exchange.fill(id, 1, order.Price)
+			}
+		}
+		return fired()
+	}
+	report, err := se.Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slept != 30*time.Minute {
+		t.Errorf("should wait for the second slice, waited %v", slept)
+	}
+	if len(report.Slices) != 2 || report.Slices[0].Price != 1010 {
+		t.Fatalf("unexpected slices %+v", report.Slices)
+	}
+	if report.Executed != 1 {
+		t.Errorf("only the first slice was executed, got %v", report.Executed)
+	}
+	if report.Benchmark != 1000 || report.AvgPrice != 1010 {
+		t.Errorf("unexpected benchmark %v and average %v", report.Benchmark, report.AvgPrice)
+	}
+	if report.Slippage != 100 {
+		t.Errorf("slippage should be 100 bps, got %v", report.Slippage)
+	}
+	if report.Slices[0].Cancelled || !report.Slices[1].Cancelled || exchange.orders["M2"].Status != "cancelled" {
+		t.Errorf("expected the unfilled slice cancelled, got %+v", report.Slices)
+	}
+}
+
+// fired returns a channel of a timer that already fired.
+func fired() <-chan time.Time {
+	c := make(chan time.Time, 1)
+	c <- time.Time{}
+	return c
+}
+
+func TestScheduledExecutionStop(t *testing.T) {
+	exchange := &bookOrders{
+		fakeOrders: newFakeOrders(),
+		book:       []conn.BookData{{Price: "1000", Amount: "5"}},
+	}
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	// no limiter, and the book fails for the first slice
+	se, err := NewTWAP("ETHCLP", "buy", 3, now, time.Hour, 3, exchange, exchange, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se.now = func() time.Time { return now }
+	done := make(chan struct{})
+	waits := 0
+	se.after = func(d time.Duration) <-chan time.Time {
+		waits++
+		if waits == 1 {
+			exchange.book = []conn.BookData{{Price: "1100", Amount: "5"}}
+			return fired()
+		}
+		// stopped while waiting for the third slice
+		close(done)
+		return nil
+	}
+	exchange.book = nil
+	report, err := se.Run(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Slices) != 2 || len(exchange.orders) != 1 || report.Slices[0].Err == nil {
+		t.Fatalf("expected a failed slice and a placed one, got %+v", report.Slices)
+	}
+	if report.Benchmark != 1100 {
+		t.Errorf("expected the benchmark of the priced slice, got %v", report.Benchmark)
+	}
+
+	if _, err := VolumeProfileFromTrades(nil, 0); err == nil {
+		t.Errorf("expected an error without buckets")
+	}
+	if _, err := VolumeProfileFromCandles(nil, 0); err == nil {
+		t.Errorf("expected an error without buckets")
+	}
+}
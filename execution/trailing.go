@@ -0,0 +1,275 @@
+// Package execution implements order execution primitives built on top of
+// the conn client: trailing stops, iceberg orders and scheduled TWAP and VWAP
+// executions. They only depend on the calls they make to CryptoMarket, so
+// they can be driven by synthetic data in tests.
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// OrderCreator is the part of the client needed to place orders.
+type OrderCreator interface {
+	CreateOrder(arguments ...args.Argument) (*conn.Order, error)
+}
+
+// BookGetter is the part of the client needed to read the order book.
+type BookGetter interface {
+	GetBook(arguments ...args.Argument) (*conn.Book, error)
+}
+
+// TrailingStop follows the best price of a market seen since its activation,
+// and places an order when the price retraces more than the trailing distance.
+//
+// A "sell" trailing stop protects a long position: it tracks the highest price
+// and triggers when the price falls below the best price minus the distance.
+// A "buy" trailing stop does the opposite, tracking the lowest price.
+type TrailingStop struct {
+	// Market is the pair of the order, as "ETHCLP".
+	Market string
+	// Type is the side of the order placed when triggered, "buy" or "sell".
+	Type string
+	// Amount of the order placed when triggered.
+	Amount string
+	// Offset is the fixed trailing distance, in units of the quote currency.
+	Offset float64
+	// Percent is the trailing distance as a percentage of the best price,
+	// used when Offset is zero.
+	Percent float64
+	// ActivationPrice is the price that has to be reached before the stop
+	// starts trailing. Zero activates the stop with the first price.
+	ActivationPrice float64
+	// Aggressive places the order at a limit price that fills the whole
+	// amount against the order book, instead of at the trigger price.
+	Aggressive bool
+
+	orders OrderCreator
+	book   BookGetter
+
+	mu        sync.Mutex
+	active    bool
+	triggered bool
+	best      float64
+	order     *conn.Order
+}
+
+// NewTrailingStop builds a trailing stop that places its order with the given
+// OrderCreator. The BookGetter is only used by aggressive stops and can be nil
+// otherwise. A *conn.Client satisfies both interfaces.
+func NewTrailingStop(market, orderType, amount string, orders OrderCreator, book BookGetter) *TrailingStop {
+	return &TrailingStop{
+		Market: market,
+		Type:   orderType,
+		Amount: amount,
+		orders: orders,
+		book:   book,
+	}
+}
+
+// OnTicker feeds the trailing stop with a ticker of its market. Sell stops
+// follow the bid and buy stops follow the ask, the prices they would trade at.
+func (ts *TrailingStop) OnTicker(ticker conn.Ticker) (*conn.Order, error) {
+	if ticker.Market != "" && ticker.Market != ts.Market {
+		return nil, nil
+	}
+	field := ticker.Bid
+	if ts.Type == "buy" {
+		field = ticker.Ask
+	}
+	price, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticker price %q: %s", field, err)
+	}
+	return ts.Update(price)
+}
+
+// OnTrade feeds the trailing stop with a trade of its market.
+func (ts *TrailingStop) OnTrade(trade conn.TradeData) (*conn.Order, error) {
+	if trade.Market != "" && trade.Market != ts.Market {
+		return nil, nil
+	}
+	price, err := strconv.ParseFloat(trade.Price, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trade price %q: %s", trade.Price, err)
+	}
+	return ts.Update(price)
+}
+
+// Update feeds the trailing stop with a new price. When the price retraces
+// past the stop, the order is placed and returned, otherwise (nil, nil) is
+// returned. Once the order is placed, further updates are ignored; if placing
+// it fails, the error is returned and the next update tries again.
+func (ts *TrailingStop) Update(price float64) (*conn.Order, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.triggered {
+		return nil, nil
+	}
+	if !(ts.Type == "buy" || ts.Type == "sell") {
+		return nil, errors.New("type must be either \"buy\" or \"sell\"")
+	}
+	if ts.Offset < 0 || ts.Percent < 0 || (ts.Offset == 0 && ts.Percent == 0) {
+		return nil, errors.New("the trailing distance, Offset or Percent, must be positive")
+	}
+	if !ts.active {
+		if ts.ActivationPrice != 0 && !ts.better(price, ts.ActivationPrice) && price != ts.ActivationPrice {
+			return nil, nil
+		}
+		ts.active = true
+		ts.best = price
+	}
+	if ts.better(price, ts.best) {
+		ts.best = price
+	}
+	stop := ts.stopPrice()
+	if (ts.Type == "sell" && price > stop) || (ts.Type == "buy" && price < stop) {
+		return nil, nil
+	}
+	// the stop stays armed if the order fails, so the next update retries it
+	order, err := ts.place(stop)
+	if err != nil {
+		return nil, fmt.Errorf("trailing stop triggered at %v failed: %s", stop, err)
+	}
+	ts.triggered = true
+	ts.order = order
+	return order, nil
+}
+
+// Active tells if the trailing stop reached its activation price.
+func (ts *TrailingStop) Active() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.active
+}
+
+// Triggered tells if the trailing stop already placed its order.
+func (ts *TrailingStop) Triggered() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.triggered
+}
+
+// Best returns the best price seen since the activation.
+func (ts *TrailingStop) Best() float64 {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.best
+}
+
+// StopPrice returns the current trigger price, zero if not active.
+func (ts *TrailingStop) StopPrice() float64 {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if !ts.active {
+		return 0
+	}
+	return ts.stopPrice()
+}
+
+// Order returns the order placed by the stop, nil if not triggered.
+func (ts *TrailingStop) Order() *conn.Order {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.order
+}
+
+// better tells if a is a better price than b for the side being tracked.
+func (ts *TrailingStop) better(a, b float64) bool {
+	if ts.Type == "sell" {
+		return a > b
+	}
+	return a < b
+}
+
+func (ts *TrailingStop) stopPrice() float64 {
+	distance := ts.Offset
+	if distance == 0 {
+		distance = ts.best * ts.Percent / 100
+	}
+	if ts.Type == "sell" {
+		return ts.best - distance
+	}
+	return ts.best + distance
+}
+
+// place creates the order of the stop, at the trigger price or, if the stop
+// is aggressive, at the price that fills the amount against the book.
+func (ts *TrailingStop) place(stop float64) (*conn.Order, error) {
+	price := stop
+	if ts.Aggressive {
+		amount, err := strconv.ParseFloat(ts.Amount, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount %q: %s", ts.Amount, err)
+		}
+		price, err = AggressivePrice(ts.book, ts.Market, ts.Type, amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ts.orders.CreateOrder(
+		args.Amount(ts.Amount),
+		args.Market(ts.Market),
+		args.Price(FormatFloat(price)),
+		args.Type(ts.Type),
+	)
+}
+
+// AggressivePrice walks the order book of the opposite side, and returns the
+// worst price needed to fill the given amount in a market with an order of
+// the given type. If the book does not have enough volume, the last price of
+// the book is returned.
+func AggressivePrice(book BookGetter, market, orderType string, amount float64) (float64, error) {
+	price, _, err := walkBook(book, market, orderType, amount)
+	return price, err
+}
+
+// walkBook returns the worst price needed to fill the amount, as
+// AggressivePrice does, along with the best price of the book.
+func walkBook(book BookGetter, market, orderType string, amount float64) (float64, float64, error) {
+	if book == nil {
+		return 0, 0, errors.New("no order book available")
+	}
+	// to buy, the book of the sellers is needed, and the other way around.
+	bookType := "buy"
+	if orderType == "buy" {
+		bookType = "sell"
+	}
+	b, err := book.GetBook(args.Market(market), args.Type(bookType), args.Limit(100))
+	if err != nil {
+		return 0, 0, fmt.Errorf("error getting the book: %s", err)
+	}
+	if len(b.Data) == 0 {
+		return 0, 0, fmt.Errorf("empty %s book in %s", bookType, market)
+	}
+	var price, best float64
+	for i, entry := range b.Data {
+		price, err = strconv.ParseFloat(entry.Price, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid book price %q: %s", entry.Price, err)
+		}
+		if i == 0 {
+			best = price
+		}
+		entryAmount, err := strconv.ParseFloat(entry.Amount, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid book amount %q: %s", entry.Amount, err)
+		}
+		amount -= entryAmount
+		if amount <= 0 {
+			break
+		}
+	}
+	return price, best, nil
+}
+
+// FormatFloat formats a number as expected by the arguments of the requests,
+// without thousand separator and with the minimal number of decimals.
+func FormatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
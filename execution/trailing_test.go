@@ -0,0 +1,159 @@
+package execution
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/requests"
+)
+
+// fakeExchange records the orders created and serves a fixed book.
+type fakeExchange struct {
+	created []map[string]string
+	book    []conn.BookData
+	// fail is the number of orders to fail before creating them
+	fail int
+}
+
+func toMap(arguments ...args.Argument) map[string]string {
+	req := requests.NewEmptyReq()
+	for _, argument := range arguments {
+		argument(req)
+	}
+	return req.GetArguments()
+}
+
+func (f *fakeExchange) CreateOrder(arguments ...args.Argument) (*conn.Order, error) {
+	if f.fail > 0 {
+		f.fail--
+		return nil, errors.New("server error")
+	}
+	argsMap := toMap(arguments...)
+	f.created = append(f.created, argsMap)
+	return &conn.Order{
+		Id:     "M" + argsMap["price"],
+		Status: "active",
+		Type:   argsMap["type"],
+		Price:  argsMap["price"],
+		Market: argsMap["market"],
+		Amount: conn.Amount{Original: argsMap["amount"], Remaining: argsMap["amount"]},
+	}, nil
+}
+
+func (f *fakeExchange) GetBook(arguments ...args.Argument) (*conn.Book, error) {
+	return &conn.Book{Data: f.book}, nil
+}
+
+func TestTrailingStopSellOffset(t *testing.T) {
+	exchange := &fakeExchange{}
+	ts := NewTrailingStop("ETHCLP", "sell", "1", exchange, nil)
+	ts.Offset = 100
+	for _, price := range []float64{1000, 1050, 1200, 1150, 1101} {
+		order, err := ts.Update(price)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order != nil {
+			t.Fatalf("triggered too soon at %v", price)
+		}
+	}
+	if ts.Best() != 1200 {
+		t.Errorf("best price should be 1200, got %v", ts.Best())
+	}
+	order, err := ts.Update(1100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order == nil {
+		t.Fatal("should trigger at 1100")
+	}
+	if len(exchange.created) != 1 {
+		t.Fatalf("one order should be created, got %d", len(exchange.created))
+	}
+	if got := exchange.created[0]; got["price"] != "1100" || got["type"] != "sell" || got["amount"] != "1" {
+		t.Errorf("unexpected order arguments %v", got)
+	}
+	if order, _ := ts.Update(900); order != nil {
+		t.Errorf("should not trigger twice")
+	}
+}
+
+func TestTrailingStopBuyPercent(t *testing.T) {
+	exchange := &fakeExchange{}
+	ts := NewTrailingStop("ETHCLP", "buy", "2", exchange, nil)
+	ts.Percent = 10
+	ts.ActivationPrice = 900
+	feed := []conn.Ticker{
+		{Market: "ETHCLP", Ask: "1000", Bid: "990"},
+		{Market: "ETHCLP", Ask: "950", Bid: "940"},
+		{Market: "ETHCLP", Ask: "800", Bid: "790"},
+		{Market: "BTCCLP", Ask: "1", Bid: "1"},
+		{Market: "ETHCLP", Ask: "870", Bid: "860"},
+	}
+	for _, ticker := range feed {
+		if order, err := ts.OnTicker(ticker); err != nil || order != nil {
+			t.Fatalf("should not trigger with ask %s: %v %v", ticker.Ask, order, err)
+		}
+	}
+	if !ts.Active() {
+		t.Fatal("should be active after crossing the activation price")
+	}
+	if ts.StopPrice() != 880 {
+		t.Errorf("stop price should be 880, got %v", ts.StopPrice())
+	}
+	order, err := ts.OnTrade(conn.TradeData{Market: "ETHCLP", Price: "880"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order == nil || order.Price != "880" || order.Type != "buy" {
+		t.Errorf("should place a buy at 880, got %v", order)
+	}
+}
+
+func TestTrailingStopAggressive(t *testing.T) {
+	exchange := &fakeExchange{
+		book: []conn.BookData{
+			{Price: "1090", Amount: "0.5"},
+			{Price: "1080", Amount: "0.4"},
+			{Price: "1050", Amount: "3"},
+		},
+	}
+	ts := NewTrailingStop("ETHCLP", "sell", "1", exchange, exchange)
+	ts.Offset = 10
+	ts.Aggressive = true
+	ts.Update(1100)
+	order, err := ts.Update(1090)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order == nil || order.Price != "1050" {
+		t.Errorf("should sell sweeping the book down to 1050, got %v", order)
+	}
+}
+
+func TestTrailingStopRetry(t *testing.T) {
+	exchange := &fakeExchange{fail: 1}
+	ts := NewTrailingStop("ETHCLP", "sell", "1", exchange, nil)
+	ts.Offset = 100
+	ts.Update(1200)
+	if order, err := ts.Update(1100); order != nil || err == nil {
+		t.Fatalf("expected the order to fail, got %v and %v", order, err)
+	}
+	if ts.Triggered() {
+		t.Errorf("expected the stop still armed after a failure")
+	}
+	order, err := ts.Update(1090)
+	if err != nil || order == nil {
+		t.Fatalf("expected the order placed on the next update, got %v and %v", order, err)
+	}
+	if !ts.Triggered() || len(exchange.created) != 1 || exchange.created[0]["price"] != "1100" {
+		t.Errorf("unexpected orders %v", exchange.created)
+	}
+
+	ts = NewTrailingStop("ETHCLP", "sell", "1", exchange, nil)
+	if _, err := ts.Update(1200); err == nil {
+		t.Errorf("expected an error without a trailing distance")
+	}
+}
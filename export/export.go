@@ -0,0 +1,194 @@
+// Package export writes the data of CryptoMarket as CSV, JSON Lines or TSV,
+// with the same columns in the same order on every run. The writers stream
+// the rows, so the pages of a list can be written as they are read.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// A Format is a file format of the exports.
+type Format int
+
+const (
+	// CSV separates the columns by commas, with a header.
+	CSV Format = iota
+	// JSONLines writes an object per line, with the columns as keys.
+	JSONLines
+	// TSV separates the columns by tabs, with a header, and is written to
+	// be opened in Excel: with a byte order mark, windows line endings and
+	// the values that would be taken as formulas escaped.
+	TSV
+	// Table aligns the columns with spaces, to be read in a terminal. The
+	// rows are written when the writer is flushed.
+	Table
+)
+
+// ParseFormat parses the name of a format, "csv", "jsonl", "tsv" or
+// "table".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "csv":
+		return CSV, nil
+	case "jsonl", "jsonlines", "ndjson":
+		return JSONLines, nil
+	case "tsv", "excel":
+		return TSV, nil
+	case "table":
+		return Table, nil
+	}
+	return 0, fmt.Errorf("unknown export format %q", name)
+}
+
+func (f Format) String() string {
+	switch f {
+	case CSV:
+		return "csv"
+	case JSONLines:
+		return "jsonl"
+	case TSV:
+		return "tsv"
+	case Table:
+		return "table"
+	}
+	return fmt.Sprintf("format(%d)", int(f))
+}
+
+// A Writer writes rows of the given columns. The header, if the format has
+// one, is written with the first row, or by Flush if there are no rows.
+type Writer struct {
+	format  Format
+	columns []string
+
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	table  *tabwriter.Writer
+	header bool
+}
+
+// NewWriter builds a writer of rows of columns to w.
+func NewWriter(w io.Writer, format Format, columns []string) *Writer {
+	writer := &Writer{format: format, columns: columns, buf: bufio.NewWriter(w)}
+	switch format {
+	case CSV:
+		writer.csv = csv.NewWriter(writer.buf)
+	case Table:
+		writer.table = tabwriter.NewWriter(writer.buf, 0, 0, 2, ' ', 0)
+	}
+	return writer
+}
+
+// Columns returns the columns of the writer.
+func (w *Writer) Columns() []string {
+	return w.columns
+}
+
+// Write writes a row, with a value for each column.
+func (w *Writer) Write(row []string) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("a row of %d values for %d columns", len(row), len(w.columns))
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	switch w.format {
+	case CSV:
+		return w.csv.Write(row)
+	case JSONLines:
+		return w.writeJSON(row)
+	case TSV:
+		return w.writeTSV(row, true)
+	case Table:
+		return w.writeTable(row)
+	}
+	return fmt.Errorf("unknown export format %d", int(w.format))
+}
+
+func (w *Writer) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	switch w.format {
+	case CSV:
+		return w.csv.Write(w.columns)
+	case TSV:
+		// the byte order mark tells Excel the file is UTF-8
+		if _, err := w.buf.WriteString("\ufeff"); err != nil {
+			return err
+		}
+		return w.writeTSV(w.columns, false)
+	case Table:
+		return w.writeTable(w.columns)
+	}
+	return nil
+}
+
+func (w *Writer) writeJSON(row []string) error {
+	w.buf.WriteByte('{')
+	for i, column := range w.columns {
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		value, _ := json.Marshal(row[i])
+		w.buf.Write(key)
+		w.buf.WriteByte(':')
+		w.buf.Write(value)
+	}
+	_, err := w.buf.WriteString("}\n")
+	return err
+}
+
+// tsvReplacer removes the separators from the values, as TSV has no quotes.
+var tsvReplacer = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+
+func (w *Writer) writeTSV(row []string, escape bool) error {
+	for i, value := range row {
+		if i > 0 {
+			w.buf.WriteByte('\t')
+		}
+		value = tsvReplacer.Replace(value)
+		// Excel runs the values starting with = or @ as formulas
+		if escape && (strings.HasPrefix(value, "=") || strings.HasPrefix(value, "@")) {
+			value = "'" + value
+		}
+		w.buf.WriteString(value)
+	}
+	_, err := w.buf.WriteString("\r\n")
+	return err
+}
+
+func (w *Writer) writeTable(row []string) error {
+	values := make([]string, len(row))
+	for i, value := range row {
+		values[i] = tsvReplacer.Replace(value)
+	}
+	_, err := io.WriteString(w.table, strings.Join(values, "\t")+"\n")
+	return err
+}
+
+// Flush writes the buffered rows, and the header if no row was written.
+func (w *Writer) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if w.table != nil {
+		if err := w.table.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.buf.Flush()
+}
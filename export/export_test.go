@@ -0,0 +1,107 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+var balances = []conn.Balance{
+	{Wallet: "CLP", Available: "1000000", Balance: "1000000"},
+	{Wallet: "ETH", Available: "2", Balance: "2.5"},
+}
+
+func TestFormats(t *testing.T) {
+	cases := []struct {
+		format   Format
+		expected string
+	}{
+		{CSV, "wallet,available,balance\nCLP,1000000,1000000\nETH,2,2.5\n"},
+		{JSONLines, `{"wallet":"CLP","available":"1000000","balance":"1000000"}` + "\n" +
+			`{"wallet":"ETH","available":"2","balance":"2.5"}` + "\n"},
+		{TSV, "\ufeffwallet\tavailable\tbalance\r\nCLP\t1000000\t1000000\r\nETH\t2\t2.5\r\n"},
+		{Table, "wallet  available  balance\nCLP     1000000    1000000\nETH     2          2.5\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := NewBalanceWriter(&buf, c.format)
+		// a row at a time, as from a paginator
+		for _, balance := range balances {
+			if err := w.Write(balance); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.format, c.expected, buf.String())
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewBalanceWriter(&buf, CSV)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "wallet,available,balance\n" {
+		t.Errorf("expected only the header, got %q", buf.String())
+	}
+}
+
+func TestTSVEscapes(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewTransactionWriter(&buf, TSV, "ETH")
+	w.Write(conn.Transaction{Id: "T1", Type: 1, Amount: "-0.5", Memo: "=HYPERLINK(\"x\")\tnote\nmore"})
+	w.Flush()
+	expected := "\ufeffcurrency\tid\ttype\tdate\tamount\tfee_percent\tfee_amount\tbalance\thash\taddress\tmemo\r\n" +
+		"ETH\tT1\t1\t\t-0.5\t\t\t\t\t\t'=HYPERLINK(\"x\") note more\r\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestAllPages(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	orders, err := server.Client().GetExecutedOrdersAllPages(args.Market("ETHCLP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewOrderWriter(&buf, CSV)
+	if err := w.Write(orders...); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != len(orders)+1 || len(orders) == 0 {
+		t.Fatalf("expected a line per order and the header, got %q", buf.String())
+	}
+	if !bytes.HasPrefix(lines[1], []byte(orders[0].Id+",ETHCLP,")) {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, expected := range map[string]Format{"csv": CSV, "JSONL": JSONLines, "tsv": TSV, "table": Table} {
+		if format, err := ParseFormat(name); err != nil || format != expected {
+			t.Errorf("%s: expected %s, got %s, %v", name, expected, format, err)
+		}
+	}
+	if _, err := ParseFormat("parquet"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestRowLength(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{}, CSV, []string{"a", "b"})
+	if err := w.Write([]string{"1"}); err == nil {
+		t.Errorf("expected an error for a short row")
+	}
+}
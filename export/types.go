@@ -0,0 +1,186 @@
+package export
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// The columns of each type, in the order they are written.
+var (
+	TickerColumns      = []string{"market", "timestamp", "bid", "ask", "last_price", "low", "high", "volume"}
+	TradeColumns       = []string{"market", "tid", "timestamp", "market_taker", "price", "amount"}
+	CandleColumns      = []string{"market", "side", "candle_id", "candle_date", "open_price", "hight_price", "low_price", "close_price", "volume_sum", "tick_count"}
+	BookColumns        = []string{"market", "side", "price", "amount", "timestamp"}
+	OrderColumns       = []string{"id", "market", "type", "status", "price", "amount_original", "amount_remaining", "amount_executed", "execution_price", "avg_execution_price", "created_at", "updated_at", "executed_at"}
+	TransactionColumns = []string{"currency", "id", "type", "date", "amount", "fee_percent", "fee_amount", "balance", "hash", "address", "memo"}
+	BalanceColumns     = []string{"wallet", "available", "balance"}
+)
+
+// TickerWriter writes tickers.
+type TickerWriter struct {
+	*Writer
+}
+
+// NewTickerWriter builds a writer of tickers to w.
+func NewTickerWriter(w io.Writer, format Format) *TickerWriter {
+	return &TickerWriter{NewWriter(w, format, TickerColumns)}
+}
+
+// Write writes tickers.
+func (tw *TickerWriter) Write(tickers ...conn.Ticker) error {
+	for _, t := range tickers {
+		if err := tw.Writer.Write([]string{t.Market, t.Timestamp, t.Bid, t.Ask, t.LastPrice, t.Low, t.High, t.Volume}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TradeWriter writes trades.
+type TradeWriter struct {
+	*Writer
+}
+
+// NewTradeWriter builds a writer of trades to w.
+func NewTradeWriter(w io.Writer, format Format) *TradeWriter {
+	return &TradeWriter{NewWriter(w, format, TradeColumns)}
+}
+
+// Write writes trades.
+func (tw *TradeWriter) Write(trades ...conn.TradeData) error {
+	for _, t := range trades {
+		if err := tw.Writer.Write([]string{t.Market, t.Tid, t.Timestamp, t.MarketTaker, t.Price, t.Amount}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CandleWriter writes the candles of a side of a market, as the candles do
+// not carry them.
+type CandleWriter struct {
+	*Writer
+	market, side string
+}
+
+// NewCandleWriter builds a writer of the candles of market and side, "ask"
+// or "bid", to w.
+func NewCandleWriter(w io.Writer, format Format, market, side string) *CandleWriter {
+	return &CandleWriter{NewWriter(w, format, CandleColumns), market, side}
+}
+
+// SetSide sets the side of the next candles written, to write both sides
+// of a market in the same file.
+func (cw *CandleWriter) SetSide(side string) {
+	cw.side = side
+}
+
+// Write writes candles.
+func (cw *CandleWriter) Write(candles ...conn.Candle) error {
+	for _, c := range candles {
+		row := []string{cw.market, cw.side, strconv.Itoa(c.CandleId), c.CandleDate, c.OpenPrice, c.HightPrice, c.LowPrice, c.ClosePrice, c.VolumeSum, c.TickCount}
+		if err := cw.Writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BookWriter writes the entries of a side of the book of a market.
+type BookWriter struct {
+	*Writer
+	market, side string
+}
+
+// NewBookWriter builds a writer of the book entries of market and side,
+// "buy" or "sell", to w.
+func NewBookWriter(w io.Writer, format Format, market, side string) *BookWriter {
+	return &BookWriter{NewWriter(w, format, BookColumns), market, side}
+}
+
+// Write writes book entries.
+func (bw *BookWriter) Write(entries ...conn.BookData) error {
+	for _, e := range entries {
+		if err := bw.Writer.Write([]string{bw.market, bw.side, e.Price, e.Amount, e.Timestamp}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OrderWriter writes orders.
+type OrderWriter struct {
+	*Writer
+}
+
+// NewOrderWriter builds a writer of orders to w.
+func NewOrderWriter(w io.Writer, format Format) *OrderWriter {
+	return &OrderWriter{NewWriter(w, format, OrderColumns)}
+}
+
+// Write writes orders.
+func (ow *OrderWriter) Write(orders ...conn.Order) error {
+	for _, o := range orders {
+		avg := ""
+		if o.AvgExecutionPrice != 0 {
+			avg = strconv.Itoa(o.AvgExecutionPrice)
+		}
+		row := []string{o.Id, o.Market, o.Type, o.Status, o.Price, o.Amount.Original, o.Amount.Remaining, o.Amount.Executed, o.ExecutionPrice, avg, o.CreatedAt, o.UpdatedAt, o.ExecutedAt}
+		if err := ow.Writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TransactionWriter writes the transactions of a currency, as the
+// transactions do not carry it.
+type TransactionWriter struct {
+	*Writer
+	currency string
+}
+
+// NewTransactionWriter builds a writer of the transactions of currency to
+// w.
+func NewTransactionWriter(w io.Writer, format Format, currency string) *TransactionWriter {
+	return &TransactionWriter{NewWriter(w, format, TransactionColumns), currency}
+}
+
+// SetCurrency sets the currency of the next transactions written, to write
+// those of several currencies in the same file.
+func (tw *TransactionWriter) SetCurrency(currency string) {
+	tw.currency = currency
+}
+
+// Write writes transactions.
+func (tw *TransactionWriter) Write(transactions ...conn.Transaction) error {
+	for _, t := range transactions {
+		row := []string{tw.currency, t.Id, strconv.Itoa(t.Type), t.Date, t.Amount, t.FeePercent, t.FeeAmount, t.Balance, t.Hash, t.Address, t.Memo}
+		if err := tw.Writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BalanceWriter writes balances.
+type BalanceWriter struct {
+	*Writer
+}
+
+// NewBalanceWriter builds a writer of balances to w.
+func NewBalanceWriter(w io.Writer, format Format) *BalanceWriter {
+	return &BalanceWriter{NewWriter(w, format, BalanceColumns)}
+}
+
+// Write writes balances.
+func (bw *BalanceWriter) Write(balances ...conn.Balance) error {
+	for _, b := range balances {
+		if err := bw.Writer.Write([]string{b.Wallet, b.Available, b.Balance}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
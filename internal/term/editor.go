@@ -0,0 +1,216 @@
+package term
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInterrupted is returned by ReadLine when ctrl-c is pressed.
+var ErrInterrupted = errors.New("interrupted")
+
+// An Editor reads lines, with history and completion when reading from a
+// terminal.
+type Editor struct {
+	Prompt string
+	// Terminal is the terminal of the input, put in raw mode while a line
+	// is edited. Without it the lines are read as they come.
+	Terminal *os.File
+	// Complete returns the candidates for word, the word being typed,
+	// after the words before it in the line.
+	Complete func(words []string, word string) []string
+	// History holds the lines read, the oldest first.
+	History []string
+
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewEditor returns an editor reading from in and echoing to out.
+func NewEditor(in *bufio.Reader, out io.Writer) *Editor {
+	return &Editor{in: in, out: out}
+}
+
+// ReadLine reads a line, and adds it to the history when it is not empty.
+// It returns io.EOF at the end of the input, or on ctrl-d with an empty
+// line.
+func (e *Editor) ReadLine() (string, error) {
+	var line string
+	var err error
+	restore, rawErr := func() (func() error, error) {
+		if e.Terminal == nil {
+			return nil, ErrNotSupported
+		}
+		return MakeRaw(e.Terminal)
+	}()
+	if rawErr == nil {
+		line, err = e.edit()
+		restore()
+	} else {
+		fmt.Fprint(e.out, e.Prompt)
+		line, err = e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		line = strings.TrimRight(line, "\r\n")
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(line) != "" && (len(e.History) == 0 || e.History[len(e.History)-1] != line) {
+		e.History = append(e.History, line)
+	}
+	return line, nil
+}
+
+// edit reads a line from a terminal in raw mode.
+func (e *Editor) edit() (string, error) {
+	var line []rune
+	pos := 0
+	// entry is the line of the history shown, len(History) for the new one
+	entry := len(e.History)
+	var current []rune
+	draw := func() {
+		fmt.Fprintf(e.out, "\r\x1b[K%s%s", e.Prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	show := func(n int) {
+		if entry == len(e.History) {
+			current = line
+		}
+		entry = n
+		if entry == len(e.History) {
+			line = current
+		} else {
+			line = []rune(e.History[entry])
+		}
+		pos = len(line)
+	}
+	draw()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			fmt.Fprint(e.out, "\r\n")
+			if err == io.EOF && len(line) > 0 {
+				return string(line), nil
+			}
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case 3: // ctrl-c
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", ErrInterrupted
+		case 4: // ctrl-d
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case 1: // ctrl-a
+			pos = 0
+		case 5: // ctrl-e
+			pos = len(line)
+		case 21: // ctrl-u
+			line, pos = line[pos:], 0
+		case 127, 8: // backspace
+			if pos > 0 {
+				line = append(line[:pos-1:pos-1], line[pos:]...)
+				pos--
+			}
+		case '\t':
+			line, pos = e.complete(line, pos)
+		case 0x1b:
+			switch e.escape() {
+			case 'A':
+				if entry > 0 {
+					show(entry - 1)
+				}
+			case 'B':
+				if entry < len(e.History) {
+					show(entry + 1)
+				}
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			}
+		default:
+			if r >= 0x20 && r != utf8.RuneError {
+				line = append(line[:pos:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+		draw()
+	}
+}
+
+// escape reads the rest of an escape sequence, and returns its final
+// letter.
+func (e *Editor) escape() byte {
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return 0
+	}
+	for {
+		b, err = e.in.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if b >= 0x40 && b <= 0x7e {
+			return b
+		}
+	}
+}
+
+// complete completes the word before pos, up to the longest prefix of the
+// candidates, and lists them when there are several.
+func (e *Editor) complete(line []rune, pos int) ([]rune, int) {
+	if e.Complete == nil {
+		return line, pos
+	}
+	before := string(line[:pos])
+	start := strings.LastIndexAny(before, " \t") + 1
+	word := before[start:]
+	candidates := e.Complete(strings.Fields(before[:start]), word)
+	if len(candidates) == 0 {
+		return line, pos
+	}
+	sort.Strings(candidates)
+	completed := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		completed += " "
+	} else if len(completed) <= len(word) {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+		return line, pos
+	}
+	added := []rune(before[:start] + completed)
+	return append(added, line[pos:]...), len(added)
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,77 @@
+package term
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newEditor(input string) (*Editor, *bytes.Buffer) {
+	var out bytes.Buffer
+	e := NewEditor(bufio.NewReader(strings.NewReader(input)), &out)
+	e.Complete = func(words []string, word string) []string {
+		var candidates []string
+		for _, candidate := range []string{"orders", "order", "ticker"} {
+			if len(words) == 0 && strings.HasPrefix(candidate, word) {
+				candidates = append(candidates, candidate)
+			}
+		}
+		return candidates
+	}
+	return e, &out
+}
+
+func TestEdit(t *testing.T) {
+	e, out := newEditor("tic\tETX\x7fH\x1b[D\x1b[D\x1b[DX\r" + "o\t\tsx\x1b[Dy\x01\x1b[C\x1b[3~\n" + "\x1b[A\x1b[A\x1b[B!\r" + "abc\x03" + "\x04")
+	var lines []string
+	for {
+		line, err := e.edit()
+		if err == io.EOF {
+			break
+		}
+		if err == ErrInterrupted {
+			lines = append(lines, "^C")
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+		e.History = append(e.History, line)
+	}
+	expected := []string{"ticker XETH", "ordersyx", "ordersyx!", "^C"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+	if !strings.Contains(out.String(), "\r\norder  orders\r\n") {
+		t.Errorf("expected the candidates listed, got %q", out.String())
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	e, out := newEditor("balance\n\nbalance\r\nticker")
+	e.Prompt = "> "
+	var lines []string
+	for {
+		line, err := e.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		lines = append(lines, line)
+	}
+	if expected := []string{"balance", "", "balance", "ticker"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+	if expected := []string{"balance", "ticker"}; !reflect.DeepEqual(e.History, expected) {
+		t.Errorf("expected the history %q, got %q", expected, e.History)
+	}
+	if out.String() != "> > > > > " {
+		t.Errorf("expected the prompts, got %q", out.String())
+	}
+}
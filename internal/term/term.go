@@ -0,0 +1,9 @@
+// Package term controls terminals, for the dashboard and the shell of the
+// command line tool.
+package term
+
+import "errors"
+
+// ErrNotSupported is returned on the systems where terminals can not be
+// controlled.
+var ErrNotSupported = errors.New("terminal control is not supported on this system")
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package term
+
+import "os"
+
+// MakeRaw is not supported, the keys are read once enter is pressed.
+func MakeRaw(f *os.File) (func() error, error) {
+	return nil, ErrNotSupported
+}
+
+// Size is not supported.
+func Size(f *os.File) (int, int, error) {
+	return 0, 0, ErrNotSupported
+}
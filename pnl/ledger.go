@@ -0,0 +1,377 @@
+// Package pnl computes the realized profit and loss of executed orders,
+// matching the sells with the lots bought by FIFO, LIFO or average cost.
+package pnl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+)
+
+// A Method is how the sells are matched with the lots bought.
+type Method int
+
+const (
+	// FIFO sells the oldest lots first.
+	FIFO Method = iota
+	// LIFO sells the newest lots first.
+	LIFO
+	// Average keeps a single lot per market at the average cost.
+	Average
+)
+
+func (m Method) String() string {
+	switch m {
+	case FIFO:
+		return "fifo"
+	case LIFO:
+		return "lifo"
+	case Average:
+		return "average"
+	}
+	return "method(" + strconv.Itoa(int(m)) + ")"
+}
+
+// A Lot is an amount of the base currency of a market still held, bought by
+// an order.
+type Lot struct {
+	Market  string
+	OrderId string
+	Time    time.Time
+	Amount  float64
+	// Price is the cost of a unit, fees included.
+	Price float64
+}
+
+// A Realization is the result of a sell, matched with the lots it sold.
+type Realization struct {
+	Market  string
+	OrderId string
+	Time    time.Time
+	Amount  float64
+	// Proceeds is what was received, net of the fee.
+	Proceeds float64
+	// Cost is the cost of the lots sold.
+	Cost float64
+	// Fee is the fee of the sell, in the quote currency.
+	Fee float64
+	PnL float64
+	// Unmatched is the amount sold without a lot bought, as when the orders
+	// of the purchase are missing, counted at no cost.
+	Unmatched float64
+}
+
+// A Ledger follows the lots of executed orders and the PnL of the sells.
+// Orders are kept by id, so adding an order twice, as when the history is
+// read again, counts it once. The result only depends on the orders added,
+// whatever the order they are added in.
+type Ledger struct {
+	// Location is where the days of the reports start, UTC by default.
+	Location *time.Location
+	// Taker tells if an order was executed as a taker, to charge its fee.
+	// By default an order is a taker when it was executed at a better price
+	// than its limit, or within a second of its creation.
+	Taker func(order conn.Order) bool
+
+	mu       sync.Mutex
+	method   Method
+	maker    float64
+	taker    float64
+	orders   map[string]conn.Order
+	computed bool
+	lots     map[string][]Lot
+	realized []Realization
+}
+
+// NewLedger builds a ledger matching the sells by method, without fees.
+func NewLedger(method Method) *Ledger {
+	return &Ledger{
+		Location: time.UTC,
+		Taker:    IsTaker,
+		method:   method,
+		orders:   make(map[string]conn.Order),
+	}
+}
+
+// SetFees sets the maker and taker fees, as fractions. The fees are charged
+// in the currency received, as CryptoMarket does.
+func (l *Ledger) SetFees(maker, taker float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maker, l.taker = maker, taker
+	l.computed = false
+}
+
+// SetFeesFromRate sets the fees of an account, as given by GetAccount.
+func (l *Ledger) SetFeesFromRate(rate conn.Rate) error {
+	maker, taker, err := rate.Fees()
+	if err != nil {
+		return err
+	}
+	l.SetFees(maker, taker)
+	return nil
+}
+
+// Add adds executed orders to the ledger. Orders with nothing executed are
+// ignored, and cancelled orders count for what they executed.
+func (l *Ledger) Add(orders ...conn.Order) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, order := range orders {
+		if _, err := fill(order); err != nil {
+			return err
+		}
+		l.orders[order.Id] = order
+	}
+	l.computed = false
+	return nil
+}
+
+// AddFromExchange adds the executed orders of the given markets.
+func (l *Ledger) AddFromExchange(trading conn.Trading, markets ...string) error {
+	for _, market := range markets {
+		orders, err := trading.GetExecutedOrdersAllPages(args.Market(market))
+		if err != nil {
+			return fmt.Errorf("error getting the executed orders of %s: %s", market, err)
+		}
+		if err := l.Add(orders...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Orders returns the orders of the ledger, in the order they are matched.
+func (l *Ledger) Orders() []conn.Order {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fills := l.fills()
+	orders := make([]conn.Order, len(fills))
+	for i, f := range fills {
+		orders[i] = f.order
+	}
+	return orders
+}
+
+// Lots returns the lots held in a market, the oldest first.
+func (l *Ledger) Lots(market string) []Lot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.compute()
+	lots := make([]Lot, len(l.lots[market]))
+	copy(lots, l.lots[market])
+	return lots
+}
+
+// Realizations returns the sells with their PnL, oldest first.
+func (l *Ledger) Realizations() []Realization {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.compute()
+	realized := make([]Realization, len(l.realized))
+	copy(realized, l.realized)
+	return realized
+}
+
+// orderFill is an order with its execution parsed.
+type orderFill struct {
+	order  conn.Order
+	time   time.Time
+	amount float64
+	price  float64
+}
+
+// fill parses the execution of an order.
+func fill(order conn.Order) (orderFill, error) {
+	f := orderFill{order: order}
+	if order.Amount.Executed == "" {
+		return f, nil
+	}
+	var err error
+	if f.amount, err = strconv.ParseFloat(order.Amount.Executed, 64); err != nil {
+		return f, fmt.Errorf("invalid executed amount %q of order %s", order.Amount.Executed, order.Id)
+	}
+	if f.amount <= 0 {
+		return f, nil
+	}
+	f.price = executionPrice(order)
+	if f.price <= 0 {
+		return f, fmt.Errorf("order %s has no price", order.Id)
+	}
+	when := order.ExecutedAt
+	if when == "" {
+		when = order.UpdatedAt
+	}
+	if f.time, err = execution.ParseTime(when); err != nil {
+		return f, fmt.Errorf("invalid execution time of order %s: %s", order.Id, err)
+	}
+	return f, nil
+}
+
+// executionPrice returns the average price an order was executed at,
+// falling back on its limit price.
+func executionPrice(order conn.Order) float64 {
+	if price, err := strconv.ParseFloat(order.ExecutionPrice, 64); err == nil && price > 0 {
+		return price
+	}
+	if order.AvgExecutionPrice > 0 {
+		return float64(order.AvgExecutionPrice)
+	}
+	price, _ := strconv.ParseFloat(order.Price, 64)
+	return price
+}
+
+// IsTaker is the default of Ledger.Taker: an order is a taker when it was
+// executed at a better price than its limit, or within a second of its
+// creation.
+func IsTaker(order conn.Order) bool {
+	limit, err := strconv.ParseFloat(order.Price, 64)
+	if err == nil {
+		price := executionPrice(order)
+		if (order.Type == "buy" && price < limit) || (order.Type == "sell" && price > limit) {
+			return true
+		}
+	}
+	created, err := execution.ParseTime(order.CreatedAt)
+	if err != nil {
+		return false
+	}
+	executed := order.ExecutedAt
+	if executed == "" {
+		executed = order.UpdatedAt
+	}
+	at, err := execution.ParseTime(executed)
+	return err == nil && at.Sub(created) <= time.Second
+}
+
+// fills returns the executed orders, by execution time and id.
+func (l *Ledger) fills() []orderFill {
+	var fills []orderFill
+	for _, order := range l.orders {
+		f, _ := fill(order)
+		if f.amount > 0 {
+			fills = append(fills, f)
+		}
+	}
+	sort.Slice(fills, func(i, j int) bool {
+		if !fills[i].time.Equal(fills[j].time) {
+			return fills[i].time.Before(fills[j].time)
+		}
+		return fills[i].order.Id < fills[j].order.Id
+	})
+	return fills
+}
+
+// compute matches the sells with the lots, from the start.
+func (l *Ledger) compute() {
+	if l.computed {
+		return
+	}
+	l.lots = make(map[string][]Lot)
+	l.realized = nil
+	for _, f := range l.fills() {
+		fee := l.maker
+		if l.Taker != nil && l.Taker(f.order) {
+			fee = l.taker
+		}
+		market := f.order.Market
+		if f.order.Type == "buy" {
+			// the fee is paid in the base currency received
+			received := f.amount * (1 - fee)
+			l.buy(Lot{Market: market, OrderId: f.order.Id, Time: f.time, Amount: received, Price: f.amount * f.price / received})
+			continue
+		}
+		gross := f.amount * f.price
+		r := Realization{
+			Market:   market,
+			OrderId:  f.order.Id,
+			Time:     f.time,
+			Amount:   f.amount,
+			Fee:      gross * fee,
+			Proceeds: gross * (1 - fee),
+		}
+		r.Cost, r.Unmatched = l.sell(market, f.amount)
+		r.PnL = r.Proceeds - r.Cost
+		l.realized = append(l.realized, r)
+	}
+	l.computed = true
+}
+
+func (l *Ledger) buy(lot Lot) {
+	lots := l.lots[lot.Market]
+	if l.method == Average && len(lots) > 0 {
+		held := lots[0]
+		amount := held.Amount + lot.Amount
+		held.Price = (held.Amount*held.Price + lot.Amount*lot.Price) / amount
+		held.Amount = amount
+		held.OrderId, held.Time = lot.OrderId, lot.Time
+		lots[0] = held
+		return
+	}
+	l.lots[lot.Market] = append(lots, lot)
+}
+
+// sell takes the amount from the lots, returning its cost and the amount
+// that had no lot.
+func (l *Ledger) sell(market string, amount float64) (float64, float64) {
+	lots := l.lots[market]
+	var cost float64
+	for amount > 1e-12 && len(lots) > 0 {
+		i := 0
+		if l.method == LIFO {
+			i = len(lots) - 1
+		}
+		taken := math.Min(amount, lots[i].Amount)
+		cost += taken * lots[i].Price
+		amount -= taken
+		lots[i].Amount -= taken
+		if lots[i].Amount <= 1e-12 {
+			lots = append(lots[:i], lots[i+1:]...)
+		}
+	}
+	l.lots[market] = lots
+	if amount <= 1e-12 {
+		amount = 0
+	}
+	return cost, amount
+}
+
+// SaveOrders writes orders to a file as JSON, to compute the PnL again
+// later without the exchange.
+func SaveOrders(path string, orders []conn.Order) error {
+	data, err := json.MarshalIndent(orders, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error saving the orders: %s", err)
+	}
+	return nil
+}
+
+// LoadOrders reads orders saved by SaveOrders, or an executed orders
+// response of the API.
+func LoadOrders(path string) ([]conn.Order, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the orders: %s", err)
+	}
+	var orders []conn.Order
+	if err := json.Unmarshal(data, &orders); err == nil {
+		return orders, nil
+	}
+	var resp conn.OrderListResp
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("error loading the orders: %s", err)
+	}
+	return resp.Data, nil
+}
@@ -0,0 +1,141 @@
+package pnl
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+// executed builds an order executed an hour after its creation, at its
+// price.
+func executed(id, orderType, price, amount, day string) conn.Order {
+	return conn.Order{
+		Id:             id,
+		Status:         "executed",
+		Type:           orderType,
+		Price:          price,
+		ExecutionPrice: price,
+		Market:         "ETHCLP",
+		Amount:         conn.Amount{Original: amount, Remaining: "0", Executed: amount},
+		CreatedAt:      day + "T09:00:00.000000",
+		UpdatedAt:      day + "T10:00:00.000000",
+		ExecutedAt:     day + "T10:00:00.000000",
+	}
+}
+
+var orders = []conn.Order{
+	executed("S1", "sell", "300", "1.5", "2020-02-01"),
+	executed("B1", "buy", "100", "1", "2020-01-01"),
+	executed("B2", "buy", "200", "1", "2020-01-02"),
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		method Method
+		cost   float64
+		lots   []Lot
+	}{
+		{FIFO, 200, []Lot{{Market: "ETHCLP", OrderId: "B2", Amount: 0.5, Price: 200}}},
+		{LIFO, 250, []Lot{{Market: "ETHCLP", OrderId: "B1", Amount: 0.5, Price: 100}}},
+		{Average, 225, []Lot{{Market: "ETHCLP", OrderId: "B2", Amount: 0.5, Price: 150}}},
+	}
+	for _, test := range tests {
+		ledger := NewLedger(test.method)
+		if err := ledger.Add(orders...); err != nil {
+			t.Fatal(err)
+		}
+		realized := ledger.Realizations()
+		if len(realized) != 1 || realized[0].Cost != test.cost || realized[0].PnL != 450-test.cost {
+			t.Errorf("%s: expected a cost of %v, got %+v", test.method, test.cost, realized)
+		}
+		lots := ledger.Lots("ETHCLP")
+		for i := range lots {
+			lots[i].Time = test.lots[i].Time
+		}
+		if !reflect.DeepEqual(lots, test.lots) {
+			t.Errorf("%s: expected the lots %+v, got %+v", test.method, test.lots, lots)
+		}
+	}
+}
+
+func TestFees(t *testing.T) {
+	ledger := NewLedger(FIFO)
+	if err := ledger.SetFeesFromRate(conn.Rate{MarketMaker: "0.01", MarketTaker: "0.02"}); err != nil {
+		t.Fatal(err)
+	}
+	// executed at once, a taker
+	sell := executed("S1", "sell", "300", "1.5", "2020-02-01")
+	sell.CreatedAt = sell.ExecutedAt
+	ledger.Add(orders[1], orders[2], sell)
+	r := ledger.Realizations()[0]
+	// 0.99 ETH received for 100, and 0.51 of the 0.99 received for 200
+	cost := 100 + 0.51*200/0.99
+	if !near(r.Fee, 9) || !near(r.Proceeds, 441) || !near(r.Cost, cost) || !near(r.PnL, 441-cost) {
+		t.Errorf("unexpected realization %+v", r)
+	}
+}
+
+func TestReport(t *testing.T) {
+	ledger := NewLedger(FIFO)
+	ledger.Add(orders...)
+	ledger.Add(executed("S2", "sell", "400", "0.5", "2021-03-01"))
+	// a sell without a lot, and an order added again
+	ledger.Add(executed("S3", "sell", "100", "1", "2021-03-05"), orders[0])
+	yearly := ledger.Report(Year)
+	expected := []Summary{
+		{Period: "2020", Market: "ETHCLP", Sells: 1, Amount: 1.5, Proceeds: 450, Cost: 200, PnL: 250},
+		{Period: "2021", Market: "ETHCLP", Sells: 2, Amount: 1.5, Proceeds: 300, Cost: 100, PnL: 200, Unmatched: 1},
+	}
+	if !reflect.DeepEqual(yearly, expected) {
+		t.Errorf("expected %+v, got %+v", expected, yearly)
+	}
+	if daily := ledger.Report(Day); len(daily) != 3 || daily[2].Period != "2021-03-05" {
+		t.Errorf("expected a summary by day, got %+v", daily)
+	}
+	if total := Total(ledger.Report(Month)); total != 450 {
+		t.Errorf("expected a total of 450, got %v", total)
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, yearly); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[0] != "period,market,sells,amount,proceeds,cost,fees,pnl,unmatched" || lines[1] != "2020,ETHCLP,1,1.5,450,200,0,250,0" {
+		t.Errorf("unexpected csv %q", buf.String())
+	}
+}
+
+func TestSavedOrders(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	ledger := NewLedger(FIFO)
+	if err := ledger.AddFromExchange(server.Client(), "ETHCLP"); err != nil {
+		t.Fatal(err)
+	}
+	if lots := ledger.Lots("ETHCLP"); len(lots) != 1 || lots[0].Amount != 1 || lots[0].Price != 140000 {
+		t.Errorf("expected the buy of the fixtures, got %+v", lots)
+	}
+	path := filepath.Join(t.TempDir(), "orders.json")
+	if err := SaveOrders(path, ledger.Orders()); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOrders(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := NewLedger(FIFO)
+	again.Add(loaded...)
+	if !reflect.DeepEqual(again.Lots("ETHCLP"), ledger.Lots("ETHCLP")) {
+		t.Errorf("the saved orders should give the same lots, got %+v", again.Lots("ETHCLP"))
+	}
+}
@@ -0,0 +1,125 @@
+package pnl
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A Period is the length of the periods of a report.
+type Period int
+
+// The periods of a report.
+const (
+	Day Period = iota
+	Month
+	Year
+)
+
+// key returns the period of a time, as "2020-01-02", "2020-01" or "2020".
+func (p Period) key(t time.Time) string {
+	switch p {
+	case Day:
+		return t.Format("2006-01-02")
+	case Month:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006")
+}
+
+// A Summary is the realized PnL of a market in a period.
+type Summary struct {
+	// Period is the day, month or year, as "2020-01-02", "2020-01" or "2020".
+	Period string `json:"period"`
+	Market string `json:"market"`
+	Sells  int    `json:"sells"`
+	// Amount is the amount of the base currency sold.
+	Amount    float64 `json:"amount"`
+	Proceeds  float64 `json:"proceeds"`
+	Cost      float64 `json:"cost"`
+	Fees      float64 `json:"fees"`
+	PnL       float64 `json:"pnl"`
+	Unmatched float64 `json:"unmatched"`
+}
+
+// Report returns the realized PnL by period and market, sorted by period
+// and market.
+func (l *Ledger) Report(period Period) []Summary {
+	l.mu.Lock()
+	location := l.Location
+	l.mu.Unlock()
+	byKey := make(map[[2]string]*Summary)
+	var summaries []*Summary
+	for _, r := range l.Realizations() {
+		key := [2]string{period.key(r.Time.In(location)), r.Market}
+		s, ok := byKey[key]
+		if !ok {
+			s = &Summary{Period: key[0], Market: key[1]}
+			byKey[key] = s
+			summaries = append(summaries, s)
+		}
+		s.Sells++
+		s.Amount += r.Amount
+		s.Proceeds += r.Proceeds
+		s.Cost += r.Cost
+		s.Fees += r.Fee
+		s.PnL += r.PnL
+		s.Unmatched += r.Unmatched
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Period != summaries[j].Period {
+			return summaries[i].Period < summaries[j].Period
+		}
+		return summaries[i].Market < summaries[j].Market
+	})
+	report := make([]Summary, len(summaries))
+	for i, s := range summaries {
+		report[i] = *s
+	}
+	return report
+}
+
+// Total returns the sum of the PnL of a report.
+func Total(report []Summary) float64 {
+	var total float64
+	for _, s := range report {
+		total += s.PnL
+	}
+	return total
+}
+
+// SummaryColumns are the columns of the CSV of a report.
+var SummaryColumns = []string{"period", "market", "sells", "amount", "proceeds", "cost", "fees", "pnl", "unmatched"}
+
+// WriteCSV writes a report as CSV, with a header of SummaryColumns.
+func WriteCSV(w io.Writer, report []Summary) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(SummaryColumns); err != nil {
+		return err
+	}
+	for _, s := range report {
+		record := []string{
+			s.Period,
+			s.Market,
+			strconv.Itoa(s.Sells),
+			formatFloat(s.Amount),
+			formatFloat(s.Proceeds),
+			formatFloat(s.Cost),
+			formatFloat(s.Fees),
+			formatFloat(s.PnL),
+			formatFloat(s.Unmatched),
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(math.Round(val*1e8)/1e8, 'f', -1, 64)
+}
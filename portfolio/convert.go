@@ -0,0 +1,145 @@
+package portfolio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// A Basis is the price of the tickers used to value a currency.
+type Basis int
+
+const (
+	// Bid values what is held at the price it can be sold for, the bid when
+	// selling the base currency and the ask when buying it.
+	Bid Basis = iota
+	// Mid values at the middle of the bid and the ask.
+	Mid
+	// Last values at the last price traded.
+	Last
+)
+
+func (b Basis) String() string {
+	switch b {
+	case Bid:
+		return "bid"
+	case Mid:
+		return "mid"
+	case Last:
+		return "last"
+	}
+	return "basis(" + strconv.Itoa(int(b)) + ")"
+}
+
+// A Converter converts amounts between currencies with the prices of a set
+// of tickers. When no market joins two currencies directly, the conversion
+// goes through the fewest intermediate markets, as BTC to ETH through
+// BTCCLP and ETHCLP.
+type Converter struct {
+	basis Basis
+	// edges are the conversions from each currency to the others, one for
+	// each market and way.
+	edges map[string][]edge
+}
+
+// edge converts a currency to another at a rate, through a market.
+type edge struct {
+	to     string
+	market string
+	rate   float64
+}
+
+// NewConverter builds a converter from the tickers of the markets. The
+// markets are split as base and quote, the quote being the last 3 letters,
+// and the tickers without a usable price are ignored.
+func NewConverter(tickers []conn.Ticker, basis Basis) *Converter {
+	c := &Converter{basis: basis, edges: make(map[string][]edge)}
+	for _, ticker := range tickers {
+		if len(ticker.Market) < 4 {
+			continue
+		}
+		base := ticker.Market[:len(ticker.Market)-3]
+		quote := ticker.Market[len(ticker.Market)-3:]
+		sell, buy, ok := prices(ticker, basis)
+		if !ok {
+			continue
+		}
+		// selling a base gives sell in the quote, buying one costs buy
+		c.edges[base] = append(c.edges[base], edge{to: quote, market: ticker.Market, rate: sell})
+		c.edges[quote] = append(c.edges[quote], edge{to: base, market: ticker.Market, rate: 1 / buy})
+	}
+	return c
+}
+
+// prices returns the price a base currency is sold for and bought at.
+func prices(ticker conn.Ticker, basis Basis) (float64, float64, bool) {
+	bid, bidErr := strconv.ParseFloat(ticker.Bid, 64)
+	ask, askErr := strconv.ParseFloat(ticker.Ask, 64)
+	last, lastErr := strconv.ParseFloat(ticker.LastPrice, 64)
+	switch basis {
+	case Bid:
+		if bidErr == nil && askErr == nil && bid > 0 && ask > 0 {
+			return bid, ask, true
+		}
+	case Mid:
+		if bidErr == nil && askErr == nil && bid > 0 && ask > 0 {
+			return (bid + ask) / 2, (bid + ask) / 2, true
+		}
+	}
+	// the last price when the book prices are missing
+	if lastErr == nil && last > 0 {
+		return last, last, true
+	}
+	return 0, 0, false
+}
+
+// Basis returns the prices the converter uses.
+func (c *Converter) Basis() Basis {
+	return c.basis
+}
+
+// Rate returns the amount of currency to obtained for one unit of from,
+// and the markets it goes through.
+func (c *Converter) Rate(from, to string) (float64, []string, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return 1, nil, nil
+	}
+	// a breadth first search, for the route of fewest markets
+	type step struct {
+		rate  float64
+		route []string
+	}
+	visited := map[string]step{from: {rate: 1}}
+	queue := []string{from}
+	for len(queue) > 0 {
+		currency := queue[0]
+		queue = queue[1:]
+		current := visited[currency]
+		for _, e := range c.edges[currency] {
+			if _, ok := visited[e.to]; ok {
+				continue
+			}
+			route := make([]string, len(current.route), len(current.route)+1)
+			copy(route, current.route)
+			next := step{rate: current.rate * e.rate, route: append(route, e.market)}
+			if e.to == to {
+				return next.rate, next.route, nil
+			}
+			visited[e.to] = next
+			queue = append(queue, e.to)
+		}
+	}
+	return 0, nil, fmt.Errorf("no market to convert %s to %s", from, to)
+}
+
+// Convert converts an amount of currency from to currency to.
+func (c *Converter) Convert(amount float64, from, to string) (float64, error) {
+	rate, _, err := c.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * rate, nil
+}
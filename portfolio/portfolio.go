@@ -0,0 +1,172 @@
+// Package portfolio values the wallets of an account in a reference
+// currency, as CLP, with the prices of the tickers.
+package portfolio
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// Source is the part of the client needed to value a portfolio.
+type Source interface {
+	GetBalance() ([]conn.Balance, error)
+	GetTicker(arguments ...args.Argument) ([]conn.Ticker, error)
+}
+
+// A Holding is a wallet valued in the reference currency.
+type Holding struct {
+	Currency  string
+	Available float64
+	Balance   float64
+	// Price is the value of one unit, and Value the value of the balance.
+	Price float64
+	Value float64
+	// Allocation is the part of the total value held, in percent.
+	Allocation float64
+	// Route are the markets the currency is converted through, empty for
+	// the reference currency.
+	Route []string
+	// Cost is the cost of the balance, when known, and UnrealizedPnL the
+	// value over it.
+	Cost          float64
+	HasCost       bool
+	UnrealizedPnL float64
+}
+
+// A Valuation is the value of the wallets at a time.
+type Valuation struct {
+	Currency string
+	Basis    Basis
+	Time     time.Time
+	// Holdings are sorted by value, the largest first.
+	Holdings []Holding
+	Total    float64
+	// Cost and UnrealizedPnL are the sums over the holdings with a cost.
+	Cost          float64
+	UnrealizedPnL float64
+	// Unvalued are the currencies with a balance but no route to the
+	// reference currency, left out of the total.
+	Unvalued []string
+}
+
+// Holding returns the holding of a currency, if any.
+func (v *Valuation) Holding(currency string) (Holding, bool) {
+	for _, holding := range v.Holdings {
+		if holding.Currency == currency {
+			return holding, true
+		}
+	}
+	return Holding{}, false
+}
+
+// A Portfolio values the wallets of an account in a reference currency.
+type Portfolio struct {
+	// Currency is the reference currency, as CLP, ARS, BRL or EUR.
+	Currency string
+	// Basis is the price used, Bid by default.
+	Basis Basis
+
+	source Source
+	now    func() time.Time
+
+	mu    sync.Mutex
+	costs map[string]float64
+}
+
+// New builds a portfolio valued in currency, reading the balances and the
+// tickers from source.
+func New(currency string, source Source) *Portfolio {
+	return &Portfolio{
+		Currency: strings.ToUpper(currency),
+		source:   source,
+		now:      time.Now,
+		costs:    make(map[string]float64),
+	}
+}
+
+// SetCost sets what was paid for the balance of a currency, in the
+// reference currency, used for its unrealized PnL.
+func (p *Portfolio) SetCost(currency string, cost float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.costs[strings.ToUpper(currency)] = cost
+}
+
+// SetCosts sets the costs of several currencies, as SetCost.
+func (p *Portfolio) SetCosts(costs map[string]float64) {
+	for currency, cost := range costs {
+		p.SetCost(currency, cost)
+	}
+}
+
+// Value reads the balances and the tickers, and values every wallet with a
+// balance.
+func (p *Portfolio) Value() (*Valuation, error) {
+	balances, err := p.source.GetBalance()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the balance: %s", err)
+	}
+	tickers, err := p.source.GetTicker()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the tickers: %s", err)
+	}
+	return p.value(balances, NewConverter(tickers, p.Basis))
+}
+
+func (p *Portfolio) value(balances []conn.Balance, converter *Converter) (*Valuation, error) {
+	valuation := &Valuation{Currency: p.Currency, Basis: p.Basis, Time: p.now()}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range balances {
+		balance, err := strconv.ParseFloat(b.Balance, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid balance %q of %s: %s", b.Balance, b.Wallet, err)
+		}
+		available, err := strconv.ParseFloat(b.Available, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid available balance %q of %s: %s", b.Available, b.Wallet, err)
+		}
+		if balance == 0 {
+			continue
+		}
+		currency := strings.ToUpper(b.Wallet)
+		price, route, err := converter.Rate(currency, p.Currency)
+		if err != nil {
+			valuation.Unvalued = append(valuation.Unvalued, currency)
+			continue
+		}
+		holding := Holding{
+			Currency:  currency,
+			Available: available,
+			Balance:   balance,
+			Price:     price,
+			Value:     balance * price,
+			Route:     route,
+		}
+		if cost, ok := p.costs[currency]; ok {
+			holding.Cost, holding.HasCost = cost, true
+			holding.UnrealizedPnL = holding.Value - cost
+			valuation.Cost += cost
+			valuation.UnrealizedPnL += holding.UnrealizedPnL
+		}
+		valuation.Total += holding.Value
+		valuation.Holdings = append(valuation.Holdings, holding)
+	}
+	for i := range valuation.Holdings {
+		if valuation.Total > 0 {
+			valuation.Holdings[i].Allocation = valuation.Holdings[i].Value / valuation.Total * 100
+		}
+	}
+	sort.SliceStable(valuation.Holdings, func(i, j int) bool {
+		return valuation.Holdings[i].Value > valuation.Holdings[j].Value
+	})
+	sort.Strings(valuation.Unvalued)
+	return valuation, nil
+}
@@ -0,0 +1,82 @@
+package portfolio
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6*math.Max(1, math.Abs(b))
+}
+
+func TestConverter(t *testing.T) {
+	tickers := []conn.Ticker{
+		{Market: "ETHCLP", Bid: "150000", Ask: "150100", LastPrice: "150050"},
+		{Market: "ETHARS", Bid: "50000", Ask: "50100", LastPrice: "50050"},
+		{Market: "BTCARS", Bid: "2000000", Ask: "2010000", LastPrice: "2005000"},
+		// no book, valued at the last price
+		{Market: "XLMARS", LastPrice: "10"},
+	}
+	c := NewConverter(tickers, Bid)
+	rate, route, err := c.Rate("BTC", "CLP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sold for ARS, which buy ETH, sold for CLP
+	if !near(rate, 2000000.0/50100*150000) {
+		t.Errorf("unexpected rate %v", rate)
+	}
+	if !reflect.DeepEqual(route, []string{"BTCARS", "ETHARS", "ETHCLP"}) {
+		t.Errorf("unexpected route %v", route)
+	}
+	if rate, _, _ := c.Rate("CLP", "ETH"); !near(rate, 1/150100.0) {
+		t.Errorf("buying ETH should be at the ask, got %v", rate)
+	}
+	if amount, err := c.Convert(100, "XLM", "ARS"); err != nil || amount != 1000 {
+		t.Errorf("expected 1000 ARS, got %v, %v", amount, err)
+	}
+	if _, _, err := c.Rate("EUR", "CLP"); err == nil {
+		t.Errorf("a currency without markets should fail")
+	}
+	if rate, _, _ := NewConverter(tickers, Mid).Rate("ETH", "CLP"); rate != 150050 {
+		t.Errorf("expected the mid, got %v", rate)
+	}
+}
+
+func TestValue(t *testing.T) {
+	fixtures := conntest.DefaultFixtures()
+	fixtures.Balances = append(fixtures.Balances, conn.Balance{Wallet: "EUR", Available: "10", Balance: "10"})
+	server := conntest.NewServer("key", "secret", fixtures)
+	defer server.Close()
+	p := New("clp", server.Client())
+	p.SetCost("ETH", 300000)
+	valuation, err := p.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1000000 CLP, 2.5 ETH at 150000, 0.1 BTC at 6000000 and 1000 XLM at 50
+	if valuation.Total != 2025000 {
+		t.Errorf("expected a total of 2025000, got %v", valuation.Total)
+	}
+	var currencies []string
+	for _, holding := range valuation.Holdings {
+		currencies = append(currencies, holding.Currency)
+	}
+	if !reflect.DeepEqual(currencies, []string{"CLP", "BTC", "ETH", "XLM"}) {
+		t.Errorf("expected the holdings by value, got %v", currencies)
+	}
+	eth, _ := valuation.Holding("ETH")
+	if eth.Value != 375000 || !near(eth.Allocation, 375000.0/2025000*100) || eth.UnrealizedPnL != 75000 {
+		t.Errorf("unexpected ETH holding %+v", eth)
+	}
+	if valuation.UnrealizedPnL != 75000 || valuation.Cost != 300000 {
+		t.Errorf("expected the PnL of ETH only, got %v over %v", valuation.UnrealizedPnL, valuation.Cost)
+	}
+	if !reflect.DeepEqual(valuation.Unvalued, []string{"EUR"}) {
+		t.Errorf("EUR has no market, got %v", valuation.Unvalued)
+	}
+}
@@ -0,0 +1,276 @@
+// Package reconcile checks the balances of an account against the history
+// of its transactions, to find the movements missing from the books.
+//
+// For each currency the transactions are replayed from the oldest, adding
+// Amount less FeeAmount to a running balance, and checking it against the
+// Balance reported by each transaction. A jump in the reported balances is
+// a gap, a movement not in the history, as a missing transaction or an
+// executed order. The last balance is then compared to the balance of the
+// wallet, and the part of it not available is compared to the amounts
+// locked by the active orders.
+package reconcile
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+	"github.com/cryptomkt/cryptomkt-go/store"
+)
+
+// DefaultTolerance is the difference below which two amounts are equal.
+const DefaultTolerance = 1e-8
+
+// Source is the part of the exchange read by a Reconciler.
+type Source interface {
+	GetMarkets() ([]string, error)
+	GetBalance() ([]conn.Balance, error)
+	GetAllTransactions(arguments ...args.Argument) ([]conn.Transaction, error)
+	GetActiveOrdersAllPages(arguments ...args.Argument) ([]conn.Order, error)
+}
+
+// A Gap is a change of the balance not explained by a transaction.
+type Gap struct {
+	// After is the id of the transaction before the gap, empty when the gap
+	// is before the first transaction.
+	After string
+	// Before is the id of the transaction after the gap.
+	Before string
+	Time   time.Time
+	// Expected is the balance from the replay, Reported the balance given
+	// by the transaction.
+	Expected   float64
+	Reported   float64
+	Difference float64
+}
+
+// A Result is the reconciliation of a currency.
+type Result struct {
+	Currency     string
+	Transactions int
+	Gaps         []Gap
+	// Replayed is the balance after the last transaction.
+	Replayed float64
+	// Balance and Available are those of the wallet.
+	Balance   float64
+	Available float64
+	// Difference is Balance less Replayed, moved since the last
+	// transaction by orders or by transactions missing.
+	Difference float64
+	// Pending is the part of the balance not available.
+	Pending float64
+	// Locked is the amount locked in the active orders.
+	Locked float64
+	// Unexplained is the part of Pending not locked in an order.
+	Unexplained float64
+}
+
+// Matches tells if the result has no gap and no difference.
+func (r *Result) Matches(tolerance float64) bool {
+	return len(r.Gaps) == 0 && math.Abs(r.Difference) <= tolerance && math.Abs(r.Unexplained) <= tolerance
+}
+
+func (r *Result) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d transactions, replayed %s, balance %s, difference %s",
+		r.Currency, r.Transactions, formatFloat(r.Replayed), formatFloat(r.Balance), formatFloat(r.Difference))
+	fmt.Fprintf(&b, ", pending %s, locked %s", formatFloat(r.Pending), formatFloat(r.Locked))
+	if r.Unexplained != 0 {
+		fmt.Fprintf(&b, ", unexplained %s", formatFloat(r.Unexplained))
+	}
+	for _, gap := range r.Gaps {
+		fmt.Fprintf(&b, "\n  gap of %s before %s at %s", formatFloat(gap.Difference), gap.Before, gap.Time.Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// A Reconciler reconciles the balances of an account.
+type Reconciler struct {
+	// Currencies are the currencies reconciled, those of the balance when
+	// empty.
+	Currencies []string
+	// Tolerance is the difference below which two amounts are equal,
+	// DefaultTolerance by default.
+	Tolerance float64
+
+	source       Source
+	transactions func(currency string) ([]conn.Transaction, error)
+}
+
+// New builds a reconciler of the account of source.
+func New(source Source) *Reconciler {
+	r := &Reconciler{source: source, Tolerance: DefaultTolerance}
+	r.transactions = func(currency string) ([]conn.Transaction, error) {
+		return source.GetAllTransactions(args.Currency(currency))
+	}
+	return r
+}
+
+// UseStore makes the reconciler read the transactions from a store, kept
+// up to date by a store.Syncer, instead of the exchange.
+func (r *Reconciler) UseStore(s *store.Store) {
+	r.transactions = func(currency string) ([]conn.Transaction, error) {
+		return s.Transactions(currency, time.Time{}, time.Time{})
+	}
+}
+
+// Reconcile reconciles each currency, in the order of the balance.
+func (r *Reconciler) Reconcile() ([]Result, error) {
+	balances, err := r.source.GetBalance()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the balance: %s", err)
+	}
+	wallets := make(map[string]conn.Balance)
+	currencies := r.Currencies
+	for _, balance := range balances {
+		wallets[balance.Wallet] = balance
+		if len(r.Currencies) == 0 {
+			currencies = append(currencies, balance.Wallet)
+		}
+	}
+	locked, err := r.locked()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(currencies))
+	for _, currency := range currencies {
+		transactions, err := r.transactions(currency)
+		if err != nil {
+			return results, fmt.Errorf("error getting the transactions of %s: %s", currency, err)
+		}
+		result, err := r.replay(currency, transactions)
+		if err != nil {
+			return results, err
+		}
+		wallet := wallets[currency]
+		if result.Balance, err = parseAmount(wallet.Balance); err != nil {
+			return results, fmt.Errorf("invalid balance of %s: %s", currency, err)
+		}
+		if result.Available, err = parseAmount(wallet.Available); err != nil {
+			return results, fmt.Errorf("invalid available balance of %s: %s", currency, err)
+		}
+		result.Difference = round8(result.Balance - result.Replayed)
+		result.Pending = round8(result.Balance - result.Available)
+		result.Locked = round8(locked[currency])
+		result.Unexplained = round8(result.Pending - result.Locked)
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// replay replays the transactions of a currency, the oldest first. The fee
+// is taken from the amount when the reported balances show it is not
+// included in it already.
+func (r *Reconciler) replay(currency string, transactions []conn.Transaction) (*Result, error) {
+	type dated struct {
+		conn.Transaction
+		time time.Time
+	}
+	sorted := make([]dated, len(transactions))
+	for i, transaction := range transactions {
+		t, err := execution.ParseTime(transaction.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date of transaction %s: %s", transaction.Id, err)
+		}
+		sorted[i] = dated{transaction, t}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].time.Before(sorted[j].time)
+	})
+	result := &Result{Currency: currency, Transactions: len(transactions)}
+	running := 0.0
+	after := ""
+	for _, transaction := range sorted {
+		amount, err := parseAmount(transaction.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount of transaction %s: %s", transaction.Id, err)
+		}
+		fee, err := parseAmount(transaction.FeeAmount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fee of transaction %s: %s", transaction.Id, err)
+		}
+		expected := running + amount - math.Abs(fee)
+		if transaction.Balance != "" {
+			reported, err := parseAmount(transaction.Balance)
+			if err != nil {
+				return nil, fmt.Errorf("invalid balance of transaction %s: %s", transaction.Id, err)
+			}
+			// the fee may be included in the amount
+			if math.Abs(running+amount-reported) <= r.Tolerance {
+				expected = running + amount
+			}
+			if math.Abs(expected-reported) > r.Tolerance {
+				result.Gaps = append(result.Gaps, Gap{
+					After:      after,
+					Before:     transaction.Id,
+					Time:       transaction.time,
+					Expected:   round8(expected),
+					Reported:   reported,
+					Difference: round8(reported - expected),
+				})
+			}
+			expected = reported
+		}
+		running = expected
+		after = transaction.Id
+	}
+	result.Replayed = round8(running)
+	return result, nil
+}
+
+// locked returns the amounts locked by the active orders, by currency: the
+// base of the sells and the quote of the buys.
+func (r *Reconciler) locked() (map[string]float64, error) {
+	markets, err := r.source.GetMarkets()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the markets: %s", err)
+	}
+	locked := make(map[string]float64)
+	for _, market := range markets {
+		if len(market) < 6 {
+			continue
+		}
+		base, quote := market[:len(market)-3], market[len(market)-3:]
+		orders, err := r.source.GetActiveOrdersAllPages(args.Market(market))
+		if err != nil {
+			return nil, fmt.Errorf("error getting the active orders of %s: %s", market, err)
+		}
+		for _, order := range orders {
+			remaining, err := parseAmount(order.Amount.Remaining)
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount of order %s: %s", order.Id, err)
+			}
+			if order.Type == "sell" {
+				locked[base] += remaining
+				continue
+			}
+			price, err := parseAmount(order.Price)
+			if err != nil {
+				return nil, fmt.Errorf("invalid price of order %s: %s", order.Id, err)
+			}
+			locked[quote] += remaining * price
+		}
+	}
+	return locked, nil
+}
+
+func parseAmount(val string) (float64, error) {
+	if val == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(val, 64)
+}
+
+func round8(val float64) float64 {
+	return math.Round(val*1e8) / 1e8
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(round8(val), 'f', -1, 64)
+}
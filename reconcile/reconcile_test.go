@@ -0,0 +1,84 @@
+package reconcile
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+	"github.com/cryptomkt/cryptomkt-go/store"
+)
+
+func TestReconcile(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	results, err := New(server.Client()).Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected a result per wallet, got %d", len(results))
+	}
+	clp, eth, btc := results[0], results[1], results[2]
+	if clp.Currency != "CLP" || !clp.Matches(DefaultTolerance) || clp.Replayed != 1000000 {
+		t.Errorf("expected CLP to match, got %s", &clp)
+	}
+	// the buy of M99 is not a transaction
+	if len(eth.Gaps) != 1 || eth.Gaps[0].Difference != 1 || eth.Gaps[0].Before != "1003" || eth.Gaps[0].After != "" {
+		t.Errorf("expected a gap of 1 ETH, got %s", &eth)
+	}
+	// the sell of M100 locks 0.5 ETH
+	if eth.Difference != 0 || eth.Pending != 0.5 || eth.Locked != 0.5 || eth.Unexplained != 0 {
+		t.Errorf("unexpected ETH %s", &eth)
+	}
+	if btc.Transactions != 0 || btc.Difference != 0.1 || btc.Matches(DefaultTolerance) {
+		t.Errorf("expected BTC without history, got %s", &btc)
+	}
+}
+
+func TestFees(t *testing.T) {
+	fixtures := conntest.DefaultFixtures()
+	fixtures.Balances = []conn.Balance{{Wallet: "BTC", Available: "0.4", Balance: "0.5"}}
+	fixtures.Orders = nil
+	fixtures.Transactions["BTC"] = []conn.Transaction{
+		// the fee included in the amount, and out of it
+		{Id: "3", Amount: "-0.2", FeeAmount: "0.001", Balance: "0.599", Date: "2020-01-03T10:00:00"},
+		{Id: "2", Amount: "-0.2", FeeAmount: "0.001", Balance: "0.8", Date: "2020-01-02T10:00:00"},
+		{Id: "1", Amount: "1", FeeAmount: "0", Balance: "1", Date: "2020-01-01T10:00:00"},
+	}
+	server := conntest.NewServer("key", "secret", fixtures)
+	defer server.Close()
+	results, err := New(server.Client()).Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btc := results[0]
+	if len(btc.Gaps) != 0 || btc.Replayed != 0.599 || btc.Difference != -0.099 {
+		t.Errorf("unexpected BTC %s", &btc)
+	}
+	if btc.Pending != 0.1 || btc.Unexplained != 0.1 {
+		t.Errorf("expected 0.1 BTC pending without orders, got %s", &btc)
+	}
+}
+
+func TestStore(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	s, err := store.Open(filepath.Join(t.TempDir(), "data.store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.PutTransactions("CLP", server.Fixtures().Transactions["CLP"][1])
+	r := New(server.Client())
+	r.Currencies = []string{"CLP"}
+	r.UseStore(s)
+	results, err := r.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the withdrawal is missing from the store
+	if clp := results[0]; clp.Replayed != 1140000 || clp.Difference != -140000 {
+		t.Errorf("unexpected CLP %s", &clp)
+	}
+}
@@ -0,0 +1,81 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrKilled is returned for every order and transfer while the kill switch
+// is on.
+var ErrKilled = errors.New("risk: kill switch on, orders and transfers are blocked")
+
+// A NotionalError is returned for an order whose notional, its amount by
+// its price, is over the maximum of its market.
+type NotionalError struct {
+	Market   string
+	Notional float64
+	Max      float64
+}
+
+func (e *NotionalError) Error() string {
+	return fmt.Sprintf("risk: notional %s of the order in %s over the maximum of %s", format(e.Notional), e.Market, format(e.Max))
+}
+
+// An OpenOrdersError is returned for an order when the market already has
+// the maximum number of active orders.
+type OpenOrdersError struct {
+	Market string
+	Open   int
+	Max    int
+}
+
+func (e *OpenOrdersError) Error() string {
+	return fmt.Sprintf("risk: %d orders open in %s, the maximum is %d", e.Open, e.Market, e.Max)
+}
+
+// A DailyVolumeError is returned for an order that would take the volume
+// sent to a market in the day over its maximum.
+type DailyVolumeError struct {
+	Market   string
+	Volume   float64
+	Notional float64
+	Max      float64
+}
+
+func (e *DailyVolumeError) Error() string {
+	return fmt.Sprintf("risk: notional %s of the order would take the daily volume of %s from %s over the maximum of %s", format(e.Notional), e.Market, format(e.Volume), format(e.Max))
+}
+
+// A CollarError is returned for an order priced too far from the last price
+// of its market, as when a digit is typed more or less.
+type CollarError struct {
+	Market string
+	Price  float64
+	// Reference is the last price of the ticker.
+	Reference float64
+	// Deviation is the distance of the price from the reference, as a
+	// fraction of the reference.
+	Deviation float64
+	Max       float64
+}
+
+func (e *CollarError) Error() string {
+	return fmt.Sprintf("risk: price %s in %s is %.2f%% away from the last price %s, the maximum is %.2f%%", format(e.Price), e.Market, e.Deviation*100, format(e.Reference), e.Max*100)
+}
+
+// A TransferError is returned for a transfer over the maximum of its
+// currency.
+type TransferError struct {
+	Currency string
+	Amount   float64
+	Max      float64
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("risk: transfer of %s %s over the maximum of %s", format(e.Amount), e.Currency, format(e.Max))
+}
+
+func format(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
@@ -0,0 +1,394 @@
+// Package risk checks the orders and transfers before they reach
+// CryptoMarket, refusing those over the limits of the account.
+package risk
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/requests"
+)
+
+// Limits are the checks made before each order and transfer. A zero value
+// disables its check, as does a market or currency missing from a map.
+type Limits struct {
+	// MaxNotional is the largest notional of an order, in the quote
+	// currency, by market.
+	MaxNotional map[string]float64
+	// MaxOpenOrders is the largest number of active orders in a market.
+	MaxOpenOrders int
+	// MaxDailyVolume is the largest notional sent to a market in a day, by
+	// market. The orders count when they are sent, whether they are
+	// executed or not.
+	MaxDailyVolume map[string]float64
+	// Collar is the largest distance of the price of an order from the last
+	// price of its market, as a fraction, e.g. 0.05. Instant orders are
+	// checked at their average price.
+	Collar float64
+	// MaxTransfer is the largest amount of a transfer, by currency.
+	MaxTransfer map[string]float64
+}
+
+// A Manager is an exchange that checks the orders and the transfers against
+// its limits before passing them to the wrapped exchange, which gets the
+// other calls untouched. The violations are returned as the error types of
+// this package, and the call is not made.
+//
+// The kill switch cancels the active orders and blocks new orders and
+// transfers until it is reset.
+//
+// The orders returned are attached to the manager, so closing or replacing
+// them goes through its checks.
+type Manager struct {
+	conn.Exchange
+	// Location is where the days of the daily volume start, UTC by
+	// default.
+	Location *time.Location
+
+	limits Limits
+	now    func() time.Time
+
+	// gate is held for reading while an order or a transfer is checked and
+	// sent, and for writing by Kill, so none is sent once the switch is on.
+	gate sync.RWMutex
+	// opening keeps the orders from being counted and sent at once, so
+	// MaxOpenOrders holds.
+	opening sync.Mutex
+
+	mu     sync.Mutex
+	killed bool
+	day    string
+	volume map[string]float64
+}
+
+// Manager implements conn.Exchange.
+var _ conn.Exchange = (*Manager)(nil)
+
+// New builds a manager checking the calls to exchange against limits.
+func New(exchange conn.Exchange, limits Limits) *Manager {
+	return &Manager{
+		Exchange: exchange,
+		limits:   limits,
+		Location: time.UTC,
+		now:      time.Now,
+		volume:   make(map[string]float64),
+	}
+}
+
+// Limits returns the limits of the manager.
+func (m *Manager) Limits() Limits {
+	return m.limits
+}
+
+// CreateOrder checks the order against the limits and creates it.
+func (m *Manager) CreateOrder(arguments ...args.Argument) (*conn.Order, error) {
+	argsMap, err := argsMap("CreateOrder", []string{"amount", "market", "price", "type"}, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	market := argsMap["market"]
+	price, err := strconv.ParseFloat(argsMap["price"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("Error in CreateOrder: invalid price %q", argsMap["price"])
+	}
+	amount, err := strconv.ParseFloat(argsMap["amount"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("Error in CreateOrder: invalid amount %q", argsMap["amount"])
+	}
+	m.gate.RLock()
+	defer m.gate.RUnlock()
+	if m.Killed() {
+		return nil, ErrKilled
+	}
+	if m.limits.MaxOpenOrders > 0 {
+		m.opening.Lock()
+		defer m.opening.Unlock()
+	}
+	if err := m.checkOpenOrders(market); err != nil {
+		return nil, err
+	}
+	if err := m.checkCollar(market, price); err != nil {
+		return nil, err
+	}
+	notional := amount * price
+	if err := m.reserve(market, notional); err != nil {
+		return nil, err
+	}
+	order, err := m.Exchange.CreateOrder(arguments...)
+	if err != nil {
+		m.release(market, notional)
+		return nil, err
+	}
+	order.SetClient(m)
+	return order, nil
+}
+
+// CreateInstant checks the instant order against the limits, valued with
+// GetInstant, and creates it.
+func (m *Manager) CreateInstant(arguments ...args.Argument) error {
+	argsMap, err := argsMap("CreateInstant", []string{"market", "type", "amount"}, arguments...)
+	if err != nil {
+		return err
+	}
+	market := argsMap["market"]
+	amount, err := strconv.ParseFloat(argsMap["amount"], 64)
+	if err != nil || amount <= 0 {
+		return fmt.Errorf("Error in CreateInstant: invalid amount %q", argsMap["amount"])
+	}
+	m.gate.RLock()
+	defer m.gate.RUnlock()
+	if m.Killed() {
+		return ErrKilled
+	}
+	instant, err := m.Exchange.GetInstant(arguments...)
+	if err != nil {
+		return fmt.Errorf("error valuing the instant order: %s", err)
+	}
+	// the amount in the quote currency is the one required to buy, and the
+	// one obtained when selling
+	notional := instant.Obtained
+	if argsMap["type"] == "buy" {
+		notional = instant.Required
+	}
+	if err := m.checkCollar(market, notional/amount); err != nil {
+		return err
+	}
+	if err := m.reserve(market, notional); err != nil {
+		return err
+	}
+	if err := m.Exchange.CreateInstant(arguments...); err != nil {
+		m.release(market, notional)
+		return err
+	}
+	return nil
+}
+
+// Transfer checks the transfer against the limits and makes it.
+func (m *Manager) Transfer(arguments ...args.Argument) error {
+	argsMap, err := argsMap("Transfer", []string{"address", "amount", "currency"}, arguments...)
+	if err != nil {
+		return err
+	}
+	m.gate.RLock()
+	defer m.gate.RUnlock()
+	if m.Killed() {
+		return ErrKilled
+	}
+	currency := argsMap["currency"]
+	amount, err := strconv.ParseFloat(argsMap["amount"], 64)
+	if err != nil {
+		return fmt.Errorf("Error in Transfer: invalid amount %q", argsMap["amount"])
+	}
+	if max, ok := m.limits.MaxTransfer[currency]; ok && max > 0 && amount > max {
+		return &TransferError{Currency: currency, Amount: amount, Max: max}
+	}
+	return m.Exchange.Transfer(arguments...)
+}
+
+// GetActiveOrders returns the active orders, attached to the manager.
+func (m *Manager) GetActiveOrders(arguments ...args.Argument) (*conn.OrderList, error) {
+	return m.attachList(m.Exchange.GetActiveOrders(arguments...))
+}
+
+// GetActiveOrdersAllPages returns the active orders of every page, attached
+// to the manager.
+func (m *Manager) GetActiveOrdersAllPages(arguments ...args.Argument) ([]conn.Order, error) {
+	return m.attachAll(m.Exchange.GetActiveOrdersAllPages(arguments...))
+}
+
+// GetExecutedOrders returns the executed orders, attached to the manager.
+func (m *Manager) GetExecutedOrders(arguments ...args.Argument) (*conn.OrderList, error) {
+	return m.attachList(m.Exchange.GetExecutedOrders(arguments...))
+}
+
+// GetExecutedOrdersAllPages returns the executed orders of every page,
+// attached to the manager.
+func (m *Manager) GetExecutedOrdersAllPages(arguments ...args.Argument) ([]conn.Order, error) {
+	return m.attachAll(m.Exchange.GetExecutedOrdersAllPages(arguments...))
+}
+
+// GetOrderStatus returns an order, attached to the manager.
+func (m *Manager) GetOrderStatus(arguments ...args.Argument) (*conn.Order, error) {
+	return m.attach(m.Exchange.GetOrderStatus(arguments...))
+}
+
+// CancelOrder cancels an order, and returns it attached to the manager.
+func (m *Manager) CancelOrder(arguments ...args.Argument) (*conn.Order, error) {
+	return m.attach(m.Exchange.CancelOrder(arguments...))
+}
+
+func (m *Manager) attach(order *conn.Order, err error) (*conn.Order, error) {
+	if err != nil {
+		return nil, err
+	}
+	order.SetClient(m)
+	return order, nil
+}
+
+func (m *Manager) attachList(orders *conn.OrderList, err error) (*conn.OrderList, error) {
+	if err != nil {
+		return nil, err
+	}
+	orders.SetClient(m)
+	return orders, nil
+}
+
+func (m *Manager) attachAll(orders []conn.Order, err error) ([]conn.Order, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		orders[i].SetClient(m)
+	}
+	return orders, nil
+}
+
+func (m *Manager) checkOpenOrders(market string) error {
+	if m.limits.MaxOpenOrders <= 0 {
+		return nil
+	}
+	orders, err := m.Exchange.GetActiveOrdersAllPages(args.Market(market))
+	if err != nil {
+		return fmt.Errorf("error counting the open orders: %s", err)
+	}
+	if len(orders) >= m.limits.MaxOpenOrders {
+		return &OpenOrdersError{Market: market, Open: len(orders), Max: m.limits.MaxOpenOrders}
+	}
+	return nil
+}
+
+func (m *Manager) checkCollar(market string, price float64) error {
+	if m.limits.Collar <= 0 {
+		return nil
+	}
+	tickers, err := m.Exchange.GetTicker(args.Market(market))
+	if err != nil {
+		return fmt.Errorf("error getting the ticker: %s", err)
+	}
+	for _, ticker := range tickers {
+		if ticker.Market != market {
+			continue
+		}
+		reference, err := strconv.ParseFloat(ticker.LastPrice, 64)
+		if err != nil || reference <= 0 {
+			return fmt.Errorf("invalid last price %q of %s", ticker.LastPrice, market)
+		}
+		deviation := math.Abs(price-reference) / reference
+		if deviation > m.limits.Collar {
+			return &CollarError{Market: market, Price: price, Reference: reference, Deviation: deviation, Max: m.limits.Collar}
+		}
+		return nil
+	}
+	return fmt.Errorf("no ticker of %s", market)
+}
+
+// reserve adds the notional of an order to the daily volume of its market,
+// checking the notional and the daily volume.
+func (m *Manager) reserve(market string, notional float64) error {
+	if max, ok := m.limits.MaxNotional[market]; ok && max > 0 && notional > max {
+		return &NotionalError{Market: market, Notional: notional, Max: max}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollDay()
+	volume := m.volume[market]
+	if max, ok := m.limits.MaxDailyVolume[market]; ok && max > 0 && volume+notional > max {
+		return &DailyVolumeError{Market: market, Volume: volume, Notional: notional, Max: max}
+	}
+	m.volume[market] = volume + notional
+	return nil
+}
+
+// release takes back the notional of an order that failed.
+func (m *Manager) release(market string, notional float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollDay()
+	m.volume[market] = math.Max(0, m.volume[market]-notional)
+}
+
+// rollDay resets the daily volume when the day changes.
+func (m *Manager) rollDay() {
+	day := m.now().In(m.Location).Format("2006-01-02")
+	if day != m.day {
+		m.day = day
+		m.volume = make(map[string]float64)
+	}
+}
+
+// DailyVolume returns the notional sent to a market in the day.
+func (m *Manager) DailyVolume(market string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollDay()
+	return m.volume[market]
+}
+
+// Kill turns the kill switch on, blocking new orders and transfers, and
+// cancels the active orders of every market. The orders being sent are
+// waited for, so they are cancelled too. Orders that could not be
+// cancelled are reported in the error, the switch stays on anyway.
+func (m *Manager) Kill() error {
+	m.gate.Lock()
+	defer m.gate.Unlock()
+	m.mu.Lock()
+	m.killed = true
+	m.mu.Unlock()
+	markets, err := m.Exchange.GetMarkets()
+	if err != nil {
+		return fmt.Errorf("error getting the markets: %s", err)
+	}
+	var errs []string
+	for _, market := range markets {
+		orders, err := m.Exchange.GetActiveOrdersAllPages(args.Market(market))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", market, err))
+			continue
+		}
+		for _, order := range orders {
+			if _, err := m.Exchange.CancelOrder(args.Id(order.Id)); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", order.Id, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("error cancelling the orders: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Reset turns the kill switch off.
+func (m *Manager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.killed = false
+}
+
+// Killed tells if the kill switch is on.
+func (m *Manager) Killed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.killed
+}
+
+// argsMap applies the arguments, checking the required ones are given.
+func argsMap(caller string, required []string, arguments ...args.Argument) (map[string]string, error) {
+	req := requests.NewReq(required)
+	for _, argument := range arguments {
+		if err := argument(req); err != nil {
+			return nil, fmt.Errorf("Error in %s: argument error: %s", caller, err)
+		}
+	}
+	if err := req.AssertRequired(); err != nil {
+		return nil, fmt.Errorf("Error in %s: required arguments not meeted:%s", caller, err)
+	}
+	return req.GetArguments(), nil
+}
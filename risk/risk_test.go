@@ -0,0 +1,188 @@
+package risk
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+func order(price, amount string) []args.Argument {
+	return []args.Argument{args.Market("ETHCLP"), args.Type("buy"), args.Price(price), args.Amount(amount)}
+}
+
+func TestManager(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	m := New(server.Client(), Limits{
+		MaxNotional:    map[string]float64{"ETHCLP": 200000},
+		MaxOpenOrders:  4,
+		MaxDailyVolume: map[string]float64{"ETHCLP": 400000},
+		Collar:         0.05,
+	})
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	// the last price is 150050
+	var collar *CollarError
+	if _, err := m.CreateOrder(order("15005", "1")...); !errors.As(err, &collar) || collar.Reference != 150050 {
+		t.Errorf("expected a collar error, got %v", err)
+	}
+	var notional *NotionalError
+	if _, err := m.CreateOrder(order("150000", "2")...); !errors.As(err, &notional) || notional.Notional != 300000 {
+		t.Errorf("expected a notional error, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := m.CreateOrder(order("150000", "1")...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if volume := m.DailyVolume("ETHCLP"); volume != 300000 {
+		t.Errorf("expected a daily volume of 300000, got %v", volume)
+	}
+	var daily *DailyVolumeError
+	if _, err := m.CreateOrder(order("150000", "1")...); !errors.As(err, &daily) || daily.Volume != 300000 {
+		t.Errorf("expected a daily volume error, got %v", err)
+	}
+	// a new day
+	now = now.Add(24 * time.Hour)
+	if volume := m.DailyVolume("ETHCLP"); volume != 0 {
+		t.Errorf("the daily volume should restart, got %v", volume)
+	}
+	if _, err := m.CreateOrder(order("150000", "0.1")...); err != nil {
+		t.Fatal(err)
+	}
+	// the order of the fixtures and the three created are open
+	var open *OpenOrdersError
+	if _, err := m.CreateOrder(order("150000", "0.1")...); !errors.As(err, &open) || open.Open != 4 {
+		t.Errorf("expected an open orders error, got %v", err)
+	}
+	if server.Calls("orders/create") != 3 {
+		t.Errorf("only the orders within the limits should reach the server, got %d", server.Calls("orders/create"))
+	}
+}
+
+func TestManagerInstant(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	m := New(server.Client(), Limits{MaxNotional: map[string]float64{"ETHCLP": 100000}, Collar: 0.005})
+	instant := func(amount string) error {
+		return m.CreateInstant(args.Market("ETHCLP"), args.Type("buy"), args.Amount(amount))
+	}
+	if err := instant("0.5"); err != nil {
+		t.Fatal(err)
+	}
+	var notional *NotionalError
+	if err := instant("1"); !errors.As(err, &notional) {
+		t.Errorf("expected a notional error, got %v", err)
+	}
+	// 15 ETH walk the book up to 153000, on average 1% over the last price
+	m.limits.MaxNotional = nil
+	var collar *CollarError
+	if err := instant("15"); !errors.As(err, &collar) {
+		t.Errorf("expected a collar error, got %v", err)
+	}
+	if server.Calls("orders/instant/create") != 1 {
+		t.Errorf("expected a single instant order, got %d", server.Calls("orders/instant/create"))
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	m := New(server.Client(), Limits{MaxTransfer: map[string]float64{"ETH": 1}})
+	if _, err := m.CreateOrder(order("150000", "0.1")...); err != nil {
+		t.Fatal(err)
+	}
+	var transfer *TransferError
+	if err := m.Transfer(args.Address("0xabc"), args.Amount("2"), args.Currency("ETH")); !errors.As(err, &transfer) {
+		t.Errorf("expected a transfer error, got %v", err)
+	}
+	if err := m.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if orders, _ := m.GetActiveOrdersAllPages(args.Market("ETHCLP")); len(orders) != 0 {
+		t.Errorf("the kill switch should cancel every order, left %v", orders)
+	}
+	if _, err := m.CreateOrder(order("150000", "0.1")...); err != ErrKilled {
+		t.Errorf("orders should be blocked, got %v", err)
+	}
+	if err := m.CreateInstant(args.Market("ETHCLP"), args.Type("buy"), args.Amount("0.1")); err != ErrKilled {
+		t.Errorf("instant orders should be blocked, got %v", err)
+	}
+	if err := m.Transfer(args.Address("0xabc"), args.Amount("0.5"), args.Currency("ETH")); err != ErrKilled {
+		t.Errorf("transfers should be blocked, got %v", err)
+	}
+	m.Reset()
+	if err := m.Transfer(args.Address("0xabc"), args.Amount("0.5"), args.Currency("ETH")); err != nil {
+		t.Errorf("transfers should pass after a reset, got %v", err)
+	}
+}
+
+func TestManagerAttachesOrders(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	m := New(server.Client(), Limits{MaxNotional: map[string]float64{"ETHCLP": 200000}})
+	created, err := m.CreateOrder(order("150000", "1")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the replacing order goes through the checks
+	if _, _, err := created.Replace("150000", "2"); err == nil {
+		t.Errorf("expected the replacing order refused")
+	}
+	active, err := m.GetActiveOrdersAllPages(args.Market("ETHCLP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the switch is on, without cancelling the orders
+	m.mu.Lock()
+	m.killed = true
+	m.mu.Unlock()
+	for _, o := range active {
+		if _, _, err := o.Replace("150000", "0.1"); err == nil {
+			t.Errorf("an order should not be replaced while killed")
+		}
+	}
+	if server.Calls("orders/create") != 1 {
+		t.Errorf("only the first order should reach the server, got %d", server.Calls("orders/create"))
+	}
+}
+
+func TestManagerConcurrent(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	// the order of the fixtures is open
+	m := New(server.Client(), Limits{MaxOpenOrders: 4})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.CreateOrder(order("150000", "0.1")...)
+		}()
+	}
+	wg.Wait()
+	if server.Calls("orders/create") != 3 {
+		t.Errorf("expected 3 orders up to the limit, got %d", server.Calls("orders/create"))
+	}
+
+	m = New(server.Client(), Limits{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.CreateOrder(order("150000", "0.1")...)
+		}()
+	}
+	if err := m.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if orders, _ := m.GetActiveOrdersAllPages(args.Market("ETHCLP")); len(orders) != 0 {
+		t.Errorf("no order should be left after the kill, got %v", orders)
+	}
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+)
+
+// The records are kept by their natural key: the trades by market and tid,
+// the candles by market, side, timeframe and candle id, the book snapshots
+// by market, side and time, the orders by market and id and the
+// transactions by currency and id. The queries take a time range, from included to to
+// excluded, where a zero time leaves the range open on that side, and
+// return the records sorted by time.
+
+// A BookSnapshot is a side of the book of a market at a time.
+type BookSnapshot struct {
+	Market  string          `json:"market"`
+	Side    string          `json:"side"`
+	Time    time.Time       `json:"time"`
+	Entries []conn.BookData `json:"entries"`
+}
+
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// timed is a record with its time, to sort the results of a query.
+type timed struct {
+	time  time.Time
+	value interface{}
+}
+
+// query decodes the records of a bucket under prefix within the time range
+// given, sorted by time. decode returns the record and its time, or a nil
+// record to leave it out.
+func (s *Store) query(bucket, prefix string, from, to time.Time, decode func(data json.RawMessage) (interface{}, time.Time, error)) ([]interface{}, error) {
+	var results []timed
+	err := s.Scan(bucket, prefix, func(key string, data json.RawMessage) error {
+		value, t, err := decode(data)
+		if err != nil {
+			return fmt.Errorf("error decoding %s %s: %s", bucket, key, err)
+		}
+		if value != nil && inRange(t, from, to) {
+			results = append(results, timed{t, value})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the scan is in the order of the keys, which breaks the ties
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].time.Before(results[j].time)
+	})
+	values := make([]interface{}, len(results))
+	for i, result := range results {
+		values[i] = result.value
+	}
+	return values, nil
+}
+
+func tradeKey(market, tid string) string {
+	return market + "/" + tid
+}
+
+// PutTrades stores trades, updating those already stored.
+func (s *Store) PutTrades(trades ...conn.TradeData) error {
+	entries := make([]entry, len(trades))
+	for i, trade := range trades {
+		entries[i] = entry{TradesBucket, tradeKey(trade.Market, trade.Tid), trade}
+	}
+	return s.write(entries...)
+}
+
+// Trades returns the trades of a market within a time range.
+func (s *Store) Trades(market string, from, to time.Time) ([]conn.TradeData, error) {
+	values, err := s.query(TradesBucket, market+"/", from, to, func(data json.RawMessage) (interface{}, time.Time, error) {
+		var trade conn.TradeData
+		if err := json.Unmarshal(data, &trade); err != nil {
+			return nil, time.Time{}, err
+		}
+		t, err := execution.ParseTime(trade.Timestamp)
+		return trade, t, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	trades := make([]conn.TradeData, len(values))
+	for i, value := range values {
+		trades[i] = value.(conn.TradeData)
+	}
+	return trades, nil
+}
+
+func candlePrefix(market, side, timeframe string) string {
+	return market + "/" + side + "/" + timeframe + "/"
+}
+
+// PutCandles stores the candles of a side, "ask" or "bid", of a market in a
+// timeframe, in minutes as in the API, updating those already stored.
+func (s *Store) PutCandles(market, side, timeframe string, candles ...conn.Candle) error {
+	entries := make([]entry, len(candles))
+	for i, candle := range candles {
+		key := candlePrefix(market, side, timeframe) + strconv.Itoa(candle.CandleId)
+		entries[i] = entry{CandlesBucket, key, candle}
+	}
+	return s.write(entries...)
+}
+
+// Candles returns the candles of a side of a market in a timeframe, within
+// a time range.
+func (s *Store) Candles(market, side, timeframe string, from, to time.Time) ([]conn.Candle, error) {
+	values, err := s.query(CandlesBucket, candlePrefix(market, side, timeframe), from, to, func(data json.RawMessage) (interface{}, time.Time, error) {
+		var candle conn.Candle
+		if err := json.Unmarshal(data, &candle); err != nil {
+			return nil, time.Time{}, err
+		}
+		t, err := execution.ParseTime(candle.CandleDate)
+		return candle, t, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	candles := make([]conn.Candle, len(values))
+	for i, value := range values {
+		candles[i] = value.(conn.Candle)
+	}
+	return candles, nil
+}
+
+// PutBook stores a snapshot of the book, replacing one of the same side of
+// the market at the same time.
+func (s *Store) PutBook(snapshot BookSnapshot) error {
+	snapshot.Time = snapshot.Time.UTC()
+	key := snapshot.Market + "/" + snapshot.Side + "/" + snapshot.Time.Format(time.RFC3339Nano)
+	return s.write(entry{BooksBucket, key, snapshot})
+}
+
+// Books returns the snapshots of a side of the book of a market within a
+// time range.
+func (s *Store) Books(market, side string, from, to time.Time) ([]BookSnapshot, error) {
+	values, err := s.query(BooksBucket, market+"/"+side+"/", from, to, func(data json.RawMessage) (interface{}, time.Time, error) {
+		var snapshot BookSnapshot
+		err := json.Unmarshal(data, &snapshot)
+		return snapshot, snapshot.Time, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]BookSnapshot, len(values))
+	for i, value := range values {
+		snapshots[i] = value.(BookSnapshot)
+	}
+	return snapshots, nil
+}
+
+func orderKey(market, id string) string {
+	return market + "/" + id
+}
+
+// PutOrders stores orders, updating those already stored, as when an active
+// order is executed.
+func (s *Store) PutOrders(orders ...conn.Order) error {
+	entries := make([]entry, len(orders))
+	for i, order := range orders {
+		entries[i] = entry{OrdersBucket, orderKey(order.Market, order.Id), order}
+	}
+	return s.write(entries...)
+}
+
+// Orders returns the orders of a market, or of all the markets when market
+// is empty, created within a time range.
+func (s *Store) Orders(market string, from, to time.Time) ([]conn.Order, error) {
+	prefix := ""
+	if market != "" {
+		prefix = market + "/"
+	}
+	values, err := s.query(OrdersBucket, prefix, from, to, func(data json.RawMessage) (interface{}, time.Time, error) {
+		var order conn.Order
+		if err := json.Unmarshal(data, &order); err != nil {
+			return nil, time.Time{}, err
+		}
+		t, err := execution.ParseTime(order.CreatedAt)
+		return order, t, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]conn.Order, len(values))
+	for i, value := range values {
+		orders[i] = value.(conn.Order)
+	}
+	return orders, nil
+}
+
+// Order returns the order with an id, or nil if it is not stored. As the
+// orders are kept by market, it looks through those of every market.
+func (s *Store) Order(id string) (*conn.Order, error) {
+	s.mu.Lock()
+	var data json.RawMessage
+	for key, value := range s.buckets[OrdersBucket] {
+		if strings.HasSuffix(key, "/"+id) {
+			data = value
+			break
+		}
+	}
+	s.mu.Unlock()
+	if data == nil {
+		return nil, nil
+	}
+	var order conn.Order
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, fmt.Errorf("error decoding %s %s: %s", OrdersBucket, id, err)
+	}
+	return &order, nil
+}
+
+// PutTransactions stores the transactions of a currency, updating those
+// already stored.
+func (s *Store) PutTransactions(currency string, transactions ...conn.Transaction) error {
+	entries := make([]entry, len(transactions))
+	for i, transaction := range transactions {
+		entries[i] = entry{TransactionsBucket, currency + "/" + transaction.Id, transaction}
+	}
+	return s.write(entries...)
+}
+
+// Transactions returns the transactions of a currency within a time range.
+func (s *Store) Transactions(currency string, from, to time.Time) ([]conn.Transaction, error) {
+	values, err := s.query(TransactionsBucket, currency+"/", from, to, func(data json.RawMessage) (interface{}, time.Time, error) {
+		var transaction conn.Transaction
+		if err := json.Unmarshal(data, &transaction); err != nil {
+			return nil, time.Time{}, err
+		}
+		t, err := execution.ParseTime(transaction.Date)
+		return transaction, t, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	transactions := make([]conn.Transaction, len(values))
+	for i, value := range values {
+		transactions[i] = value.(conn.Transaction)
+	}
+	return transactions, nil
+}
@@ -0,0 +1,433 @@
+// Package store keeps market data and account history in a local file, so
+// analyses can read them again without the API and its rate limits.
+//
+// A store is a single file of JSON lines, readable without this package: a
+// header with the version of the schema, then a record per line, each the
+// new value of a key in a bucket. Writes are appended, and the last record
+// of a key wins, so writing a value again updates it. Compact rewrites the
+// file with only the current values.
+//
+// The current values are kept in memory, so a store holds what fits in the
+// memory of the process, about the size of the file once compacted. The
+// queries decode every record of their market, or of their currency, and
+// keep those in the time range.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// formatName identifies the files of a store.
+const formatName = "cryptomkt-go/store"
+
+// The buckets of the store.
+const (
+	TradesBucket       = "trades"
+	CandlesBucket      = "candles"
+	BooksBucket        = "books"
+	OrdersBucket       = "orders"
+	TransactionsBucket = "transactions"
+	MetaBucket         = "meta"
+)
+
+// ErrClosed is returned by the calls to a closed store.
+var ErrClosed = errors.New("the store is closed")
+
+// A Migration upgrades the data of a store to its version, from the
+// version before.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(s *Store) error
+}
+
+// migrations are the migrations of the schema, in order. A store is at the
+// version of the last one.
+var migrations = []Migration{
+	{Version: 1, Name: "buckets", Up: func(s *Store) error {
+		for _, bucket := range []string{TradesBucket, CandlesBucket, BooksBucket, OrdersBucket, TransactionsBucket, MetaBucket} {
+			if s.buckets[bucket] == nil {
+				s.buckets[bucket] = map[string]json.RawMessage{}
+			}
+		}
+		return nil
+	}},
+	{Version: 2, Name: "orders by market", Up: func(s *Store) error {
+		orders := make(map[string]json.RawMessage, len(s.buckets[OrdersBucket]))
+		for id, data := range s.buckets[OrdersBucket] {
+			var order conn.Order
+			if err := json.Unmarshal(data, &order); err != nil {
+				return fmt.Errorf("error decoding order %s: %s", id, err)
+			}
+			orders[orderKey(order.Market, id)] = data
+		}
+		s.buckets[OrdersBucket] = orders
+		return nil
+	}},
+}
+
+type header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+type record struct {
+	Bucket  string          `json:"b"`
+	Key     string          `json:"k"`
+	Value   json.RawMessage `json:"v,omitempty"`
+	Deleted bool            `json:"d,omitempty"`
+}
+
+// A Store is a local store of market data and account history. It is safe
+// for concurrent use, but the file should only be opened by a process at a
+// time.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	file    appendFile
+	version int
+	buckets map[string]map[string]json.RawMessage
+	// broken is the error of a write that left a partial record in the
+	// file, returned by the writes after it.
+	broken error
+}
+
+// appendFile is the file of a store, an *os.File but in the tests.
+type appendFile interface {
+	io.WriteCloser
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Sync() error
+}
+
+// Open opens the store in the file at path, creating it if it does not
+// exist, and migrates it to the current version of the schema.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, buckets: map[string]map[string]json.RawMessage{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the store: %s", err)
+	}
+	s.file = file
+	return s, nil
+}
+
+// load reads the file. A last line cut by a crash in the middle of a write
+// is dropped.
+func (s *Store) load() error {
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening the store: %s", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(data)) > 0 {
+				// a partial record, the rest of the file is fine
+				if err := file.Truncate(offset); err != nil {
+					return fmt.Errorf("error repairing the store: %s", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading the store: %s", err)
+		}
+		offset += int64(len(data))
+		if line == 1 {
+			var h header
+			if err := json.Unmarshal(data, &h); err != nil || h.Format != formatName {
+				return fmt.Errorf("error reading the store: %s is not a store", s.path)
+			}
+			s.version = h.Version
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("error reading the store, line %d: %s", line, err)
+		}
+		s.apply(r)
+	}
+}
+
+func (s *Store) apply(r record) {
+	bucket := s.buckets[r.Bucket]
+	if bucket == nil {
+		bucket = map[string]json.RawMessage{}
+		s.buckets[r.Bucket] = bucket
+	}
+	if r.Deleted {
+		delete(bucket, r.Key)
+	} else {
+		bucket[r.Key] = r.Value
+	}
+}
+
+// migrate runs the migrations newer than the version of the file, then
+// rewrites it at the new version.
+func (s *Store) migrate() error {
+	last := migrations[len(migrations)-1].Version
+	if s.version > last {
+		return fmt.Errorf("error opening the store: version %d is newer than the supported %d", s.version, last)
+	}
+	if s.version == last {
+		return nil
+	}
+	for _, migration := range migrations {
+		if migration.Version <= s.version {
+			continue
+		}
+		if err := migration.Up(s); err != nil {
+			return fmt.Errorf("error migrating the store to version %d (%s): %s", migration.Version, migration.Name, err)
+		}
+		s.version = migration.Version
+	}
+	return s.rewrite()
+}
+
+// rewrite writes the current values to a new file, which then replaces the
+// old one.
+func (s *Store) rewrite() error {
+	temp, err := os.Create(filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp"))
+	if err != nil {
+		return fmt.Errorf("error writing the store: %s", err)
+	}
+	out := bufio.NewWriter(temp)
+	encoder := json.NewEncoder(out)
+	err = encoder.Encode(header{Format: formatName, Version: s.version})
+	for _, bucket := range sortedKeys(s.buckets) {
+		values := s.buckets[bucket]
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err == nil {
+				err = encoder.Encode(record{Bucket: bucket, Key: key, Value: values[key]})
+			}
+		}
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("error writing the store: %s", err)
+	}
+	return nil
+}
+
+func sortedKeys(buckets map[string]map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Version returns the version of the schema of the store.
+func (s *Store) Version() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+// An entry is a value to write to a key of a bucket.
+type entry struct {
+	bucket, key string
+	value       interface{}
+}
+
+// write stores entries, appending them to the file in a single write.
+func (s *Store) write(entries ...entry) error {
+	var buf bytes.Buffer
+	records := make([]record, len(entries))
+	for i, e := range entries {
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return fmt.Errorf("error encoding %s %s: %s", e.bucket, e.key, err)
+		}
+		records[i] = record{Bucket: e.bucket, Key: e.key, Value: value}
+		data, _ := json.Marshal(records[i])
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets == nil {
+		return ErrClosed
+	}
+	// the migrations only change the memory, the file is rewritten after
+	if s.file != nil {
+		if err := s.append(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	for _, r := range records {
+		s.apply(r)
+	}
+	return nil
+}
+
+// append writes records at the end of the file. A failed write is cut off,
+// so no partial record is left in the middle of the file. If that fails
+// too the store is broken, and refuses the writes after it.
+func (s *Store) append(data []byte) error {
+	if s.broken != nil {
+		return s.broken
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error writing the store: %s", err)
+	}
+	if _, err := s.file.Write(data); err != nil {
+		if truncErr := s.file.Truncate(info.Size()); truncErr != nil {
+			s.broken = fmt.Errorf("error writing the store: %s, and repairing it: %s", err, truncErr)
+			return s.broken
+		}
+		return fmt.Errorf("error writing the store: %s", err)
+	}
+	return nil
+}
+
+// Put stores value, encoded as JSON, in the key of a bucket, replacing the
+// value before.
+func (s *Store) Put(bucket, key string, value interface{}) error {
+	return s.write(entry{bucket, key, value})
+}
+
+// Get reads the value in the key of a bucket into value, and tells if
+// there was one.
+func (s *Store) Get(bucket, key string, value interface{}) (bool, error) {
+	s.mu.Lock()
+	data, ok := s.buckets[bucket][key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return true, fmt.Errorf("error decoding %s %s: %s", bucket, key, err)
+	}
+	return true, nil
+}
+
+// Delete removes the key of a bucket.
+func (s *Store) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets == nil {
+		return ErrClosed
+	}
+	r := record{Bucket: bucket, Key: key, Deleted: true}
+	if s.file != nil {
+		data, _ := json.Marshal(r)
+		if err := s.append(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	s.apply(r)
+	return nil
+}
+
+// Scan calls fn with the keys of a bucket starting with prefix and their
+// values, in the order of the keys.
+func (s *Store) Scan(bucket, prefix string, fn func(key string, value json.RawMessage) error) error {
+	s.mu.Lock()
+	var keys []string
+	var values []json.RawMessage
+	for key := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values = append(values, s.buckets[bucket][key])
+	}
+	s.mu.Unlock()
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of keys of a bucket.
+func (s *Store) Len(bucket string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets[bucket])
+}
+
+// Compact rewrites the file with only the current values, dropping those
+// replaced and deleted.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets == nil {
+		return ErrClosed
+	}
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("error writing the store: %s", err)
+	}
+	err := s.rewrite()
+	file, openErr := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if openErr != nil {
+		s.buckets = nil
+		return fmt.Errorf("error opening the store: %s", openErr)
+	}
+	s.file = file
+	return err
+}
+
+// Close writes the file to disk and closes it.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets == nil {
+		return ErrClosed
+	}
+	s.buckets = nil
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
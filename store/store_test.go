@@ -0,0 +1,287 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+func date(val string) time.Time {
+	t, err := time.Parse("2006-01-02T15:04:05", val)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func trade(tid, timestamp, price string) conn.TradeData {
+	return conn.TradeData{Market: "ETHCLP", Tid: tid, Timestamp: timestamp, Price: price, Amount: "0.1", MarketTaker: "buy"}
+}
+
+func open(t *testing.T, path string) *Store {
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestUpsertAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.store")
+	s := open(t, path)
+	err := s.PutTrades(
+		trade("2", "2020-01-01T10:00:00.000000", "150000"),
+		trade("1", "2020-01-01T09:00:00.000000", "149000"),
+		trade("3", "2020-01-02T09:00:00.000000", "151000"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same trade again, corrected
+	if err := s.PutTrades(trade("2", "2020-01-01T10:00:00.000000", "150500")); err != nil {
+		t.Fatal(err)
+	}
+	s.PutTrades(conn.TradeData{Market: "BTCCLP", Tid: "1", Timestamp: "2020-01-01T09:30:00"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, path)
+	defer s.Close()
+	trades, err := s.Trades("ETHCLP", date("2020-01-01T00:00:00"), date("2020-01-02T00:00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 2 || trades[0].Tid != "1" || trades[1].Tid != "2" || trades[1].Price != "150500" {
+		t.Errorf("unexpected trades %+v", trades)
+	}
+	trades, _ = s.Trades("ETHCLP", time.Time{}, time.Time{})
+	if len(trades) != 3 {
+		t.Errorf("expected all the trades of ETHCLP, got %+v", trades)
+	}
+	if s.Len(TradesBucket) != 4 {
+		t.Errorf("expected 4 trades stored, got %d", s.Len(TradesBucket))
+	}
+}
+
+func TestRecords(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "data.store"))
+	defer s.Close()
+
+	s.PutCandles("ETHCLP", "ask", "60",
+		conn.Candle{CandleId: 2, CandleDate: "2020-01-01 11:00:00", ClosePrice: "150"},
+		conn.Candle{CandleId: 1, CandleDate: "2020-01-01 10:00:00", ClosePrice: "140"},
+	)
+	s.PutCandles("ETHCLP", "bid", "60", conn.Candle{CandleId: 1, CandleDate: "2020-01-01 10:00:00"})
+	candles, err := s.Candles("ETHCLP", "ask", "60", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 2 || candles[0].CandleId != 1 || candles[1].ClosePrice != "150" {
+		t.Errorf("unexpected candles %+v", candles)
+	}
+
+	at := date("2020-01-01T10:00:00")
+	s.PutBook(BookSnapshot{Market: "ETHCLP", Side: "buy", Time: at, Entries: []conn.BookData{{Price: "150000", Amount: "1"}}})
+	s.PutBook(BookSnapshot{Market: "ETHCLP", Side: "buy", Time: at.Add(time.Minute)})
+	books, _ := s.Books("ETHCLP", "buy", at, at.Add(time.Minute))
+	if len(books) != 1 || !books[0].Time.Equal(at) || books[0].Entries[0].Price != "150000" {
+		t.Errorf("unexpected snapshots %+v", books)
+	}
+
+	active := conn.Order{Id: "M1", Market: "ETHCLP", Status: "active", CreatedAt: "2020-01-01T10:00:00.000000"}
+	s.PutOrders(active, conn.Order{Id: "M2", Market: "BTCCLP", CreatedAt: "2020-01-01T09:00:00.000000"})
+	active.Status = "executed"
+	s.PutOrders(active)
+	orders, _ := s.Orders("ETHCLP", time.Time{}, time.Time{})
+	if len(orders) != 1 || orders[0].Status != "executed" {
+		t.Errorf("unexpected orders %+v", orders)
+	}
+	if orders, _ := s.Orders("", time.Time{}, time.Time{}); len(orders) != 2 || orders[0].Id != "M2" {
+		t.Errorf("expected the orders of every market by time, got %+v", orders)
+	}
+	if order, _ := s.Order("M2"); order == nil || order.Market != "BTCCLP" {
+		t.Errorf("unexpected order %+v", order)
+	}
+	if order, _ := s.Order("M3"); order != nil {
+		t.Errorf("expected no order, got %+v", order)
+	}
+
+	s.PutTransactions("ETH", conn.Transaction{Id: "T1", Amount: "1", Date: "2020-01-01T10:00:00"})
+	s.PutTransactions("CLP", conn.Transaction{Id: "T1", Amount: "1000", Date: "2020-01-01T10:00:00"})
+	transactions, _ := s.Transactions("ETH", time.Time{}, time.Time{})
+	if len(transactions) != 1 || transactions[0].Amount != "1" {
+		t.Errorf("unexpected transactions %+v", transactions)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.store")
+	s := open(t, path)
+	for i := 0; i < 10; i++ {
+		s.Put(MetaBucket, "cursor", i)
+	}
+	s.Put(MetaBucket, "gone", true)
+	s.Delete(MetaBucket, "gone")
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(MetaBucket, "after", "compact")
+	s.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Errorf("expected the header and two records, got %q", data)
+	}
+	s = open(t, path)
+	defer s.Close()
+	var cursor int
+	if ok, err := s.Get(MetaBucket, "cursor", &cursor); !ok || err != nil || cursor != 9 {
+		t.Errorf("expected the last cursor, got %d, %v, %v", cursor, ok, err)
+	}
+	if ok, _ := s.Get(MetaBucket, "gone", &cursor); ok {
+		t.Errorf("expected the deleted key to be gone")
+	}
+}
+
+func TestPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.store")
+	s := open(t, path)
+	s.Put(MetaBucket, "a", 1)
+	s.Close()
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"b":"meta","k":"b","v":`)
+	file.Close()
+
+	s = open(t, path)
+	s.Put(MetaBucket, "c", 3)
+	s.Close()
+	s = open(t, path)
+	defer s.Close()
+	if s.Len(MetaBucket) != 2 {
+		t.Errorf("expected the partial record dropped, got %d keys", s.Len(MetaBucket))
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.store")
+	s := open(t, path)
+	if s.Version() != 2 {
+		t.Errorf("expected version 2, got %d", s.Version())
+	}
+	s.PutTrades(trade("1", "2020-01-01T09:00:00", "149000"))
+	s.Close()
+
+	defer func(previous []Migration) { migrations = previous }(migrations)
+	migrations = append(migrations, Migration{Version: 3, Name: "prices as numbers", Up: func(s *Store) error {
+		return s.Scan(TradesBucket, "", func(key string, value json.RawMessage) error {
+			var trade conn.TradeData
+			json.Unmarshal(value, &trade)
+			trade.Price += ".0"
+			return s.Put(TradesBucket, key, trade)
+		})
+	}})
+	s = open(t, path)
+	if s.Version() != 3 {
+		t.Errorf("expected version 3, got %d", s.Version())
+	}
+	trades, _ := s.Trades("ETHCLP", time.Time{}, time.Time{})
+	if len(trades) != 1 || trades[0].Price != "149000.0" {
+		t.Errorf("expected the migrated trade, got %+v", trades)
+	}
+	s.Close()
+
+	// migrations run once
+	s = open(t, path)
+	defer s.Close()
+	trades, _ = s.Trades("ETHCLP", time.Time{}, time.Time{})
+	if trades[0].Price != "149000.0" {
+		t.Errorf("expected the migration to run once, got %+v", trades)
+	}
+
+	migrations = migrations[:2]
+	if _, err := Open(path); err == nil {
+		t.Errorf("expected an error opening a newer store")
+	}
+}
+
+func TestOrdersByMarket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.store")
+	// a store of version 1 keeps the orders by id
+	ioutil.WriteFile(path, []byte(`{"format":"cryptomkt-go/store","version":1}
+{"b":"orders","k":"M1","v":{"id":"M1","market":"ETHCLP","created_at":"2020-01-01T09:00:00.000000"}}
+`), 0644)
+	s := open(t, path)
+	defer s.Close()
+	if s.Version() != 2 {
+		t.Errorf("expected version 2, got %d", s.Version())
+	}
+	s.PutOrders(conn.Order{Id: "M2", Market: "BTCCLP", CreatedAt: "2020-01-01T10:00:00.000000"})
+	var keys []string
+	s.Scan(OrdersBucket, "", func(key string, value json.RawMessage) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if strings.Join(keys, " ") != "BTCCLP/M2 ETHCLP/M1" {
+		t.Errorf("expected the orders keyed by market, got %v", keys)
+	}
+	if orders, _ := s.Orders("ETHCLP", time.Time{}, time.Time{}); len(orders) != 1 || orders[0].Id != "M1" {
+		t.Errorf("expected the migrated order, got %v", orders)
+	}
+	if order, _ := s.Order("M1"); order == nil || order.Market != "ETHCLP" {
+		t.Errorf("expected the migrated order by id, got %v", order)
+	}
+}
+
+// failingFile writes only half of the data it is given, and fails.
+type failingFile struct {
+	*os.File
+}
+
+func (f failingFile) Write(data []byte) (int, error) {
+	n, _ := f.File.Write(data[:len(data)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.store")
+	s := open(t, path)
+	s.Put(MetaBucket, "a", 1)
+	file := s.file
+	s.file = failingFile{file.(*os.File)}
+	if err := s.Put(MetaBucket, "b", 2); err == nil {
+		t.Errorf("expected the write to fail")
+	}
+	if err := s.Delete(MetaBucket, "a"); err == nil {
+		t.Errorf("expected the delete to fail")
+	}
+	s.file = file
+	s.Put(MetaBucket, "c", 3)
+	s.Close()
+
+	s = open(t, path)
+	defer s.Close()
+	var a, c int
+	if ok, _ := s.Get(MetaBucket, "a", &a); !ok || a != 1 {
+		t.Errorf("expected the value not deleted, got %v", a)
+	}
+	if ok, _ := s.Get(MetaBucket, "c", &c); !ok || c != 3 || s.Len(MetaBucket) != 2 {
+		t.Errorf("expected the records around the failed ones, got %d keys", s.Len(MetaBucket))
+	}
+}
+
+func TestNotAStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	ioutil.WriteFile(path, []byte("[1, 2, 3]\n"), 0644)
+	if _, err := Open(path); err == nil {
+		t.Errorf("expected an error opening a file that is not a store")
+	}
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+)
+
+// syncPageSize is the number of records asked in each page, the most
+// allowed by the API.
+const syncPageSize = 100
+
+// lastSyncKey is the key of the time of the last sync in the meta bucket.
+const lastSyncKey = "sync/last"
+
+// SyncSource is the part of the exchange read by a Syncer.
+type SyncSource interface {
+	GetMarkets() ([]string, error)
+	GetBalance() ([]conn.Balance, error)
+	GetExecutedOrders(arguments ...args.Argument) (*conn.OrderList, error)
+	GetTransactions(arguments ...args.Argument) (*conn.TransactionList, error)
+}
+
+// A ChangeKind is what a sync found about a record.
+type ChangeKind string
+
+// The kinds of changes.
+const (
+	Added   ChangeKind = "added"
+	Changed ChangeKind = "changed"
+	Deleted ChangeKind = "deleted"
+)
+
+// A Change is a record added, changed or deleted by a sync.
+type Change struct {
+	Kind ChangeKind
+	// Bucket is OrdersBucket or TransactionsBucket.
+	Bucket string
+	// Scope is the market of an order or the currency of a transaction.
+	Scope string
+	Id    string
+}
+
+// A SyncReport tells what a sync did.
+type SyncReport struct {
+	Time    time.Time
+	Changes []Change
+	// Pages is the number of pages read.
+	Pages int
+}
+
+// Count returns the number of changes of a kind.
+func (r *SyncReport) Count(kind ChangeKind) int {
+	n := 0
+	for _, change := range r.Changes {
+		if change.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *SyncReport) String() string {
+	return fmt.Sprintf("%d added, %d changed, %d deleted in %d pages", r.Count(Added), r.Count(Changed), r.Count(Deleted), r.Pages)
+}
+
+// A Syncer keeps the executed orders and the transactions of an account up
+// to date in a store. Each sync reads the pages of each market and currency
+// from the newest, and stops at the first page with a record already
+// stored unchanged, so only the new pages are read. The records of the
+// period read that the exchange no longer lists are deleted from the store.
+type Syncer struct {
+	// Markets are the markets of the orders synced, all those of the
+	// exchange when empty.
+	Markets []string
+	// Currencies are the currencies of the transactions synced, those of
+	// the balance when empty.
+	Currencies []string
+	// Full makes the syncs read the whole history, to find the changes and
+	// deletions of old records.
+	Full bool
+
+	store    *Store
+	exchange SyncSource
+	limiter  *conn.Limiter
+	now      func() time.Time
+}
+
+// NewSyncer builds a syncer of the history of exchange to store.
+func NewSyncer(store *Store, exchange SyncSource) *Syncer {
+	return &Syncer{store: store, exchange: exchange, now: time.Now}
+}
+
+// SetLimiter sets the limiter spacing the calls to CryptoMarket.
+func (s *Syncer) SetLimiter(limiter *conn.Limiter) {
+	s.limiter = limiter
+}
+
+func (s *Syncer) wait() {
+	if s.limiter != nil {
+		s.limiter.Wait()
+	}
+}
+
+// Sync brings the store up to date with the exchange.
+func (s *Syncer) Sync() (*SyncReport, error) {
+	report := &SyncReport{Time: s.now()}
+	markets := s.Markets
+	if len(markets) == 0 {
+		s.wait()
+		var err error
+		if markets, err = s.exchange.GetMarkets(); err != nil {
+			return report, fmt.Errorf("error getting the markets: %s", err)
+		}
+	}
+	currencies := s.Currencies
+	if len(currencies) == 0 {
+		s.wait()
+		balances, err := s.exchange.GetBalance()
+		if err != nil {
+			return report, fmt.Errorf("error getting the balance: %s", err)
+		}
+		for _, balance := range balances {
+			currencies = append(currencies, balance.Wallet)
+		}
+	}
+	for _, market := range markets {
+		if err := s.syncOrders(market, report); err != nil {
+			return report, err
+		}
+	}
+	for _, currency := range currencies {
+		if err := s.syncTransactions(currency, report); err != nil {
+			return report, err
+		}
+	}
+	if err := s.store.Put(MetaBucket, lastSyncKey, report.Time); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// LastSync returns the time of the last sync completed, zero if none.
+func (s *Store) LastSync() (time.Time, error) {
+	var t time.Time
+	_, err := s.Get(MetaBucket, lastSyncKey, &t)
+	return t, err
+}
+
+// fetched is a record read from the exchange.
+type fetched struct {
+	key, id string
+	time    time.Time
+	value   interface{}
+}
+
+// A page reads a page of records, and tells if it was the last.
+type page func(number int) ([]fetched, bool, error)
+
+func orderTime(order conn.Order) (time.Time, error) {
+	if order.ExecutedAt != "" {
+		return execution.ParseTime(order.ExecutedAt)
+	}
+	return execution.ParseTime(order.CreatedAt)
+}
+
+func (s *Syncer) syncOrders(market string, report *SyncReport) error {
+	return s.sync(OrdersBucket, market, report, func(number int) ([]fetched, bool, error) {
+		s.wait()
+		list, err := s.exchange.GetExecutedOrders(args.Market(market), args.Page(number), args.Limit(syncPageSize))
+		if err != nil {
+			return nil, false, fmt.Errorf("error getting the executed orders of %s: %s", market, err)
+		}
+		records := make([]fetched, len(list.Data))
+		for i, order := range list.Data {
+			t, err := orderTime(order)
+			if err != nil {
+				return nil, false, fmt.Errorf("error reading the order %s: %s", order.Id, err)
+			}
+			records[i] = fetched{orderKey(market, order.Id), order.Id, t, order}
+		}
+		return records, len(list.Data) < syncPageSize, nil
+	}, func(data json.RawMessage) (string, time.Time, bool, error) {
+		var order conn.Order
+		if err := json.Unmarshal(data, &order); err != nil {
+			return "", time.Time{}, false, err
+		}
+		// the active orders are not listed, any other may be
+		if order.Status == "active" {
+			return "", time.Time{}, false, nil
+		}
+		t, err := orderTime(order)
+		return order.Id, t, true, err
+	})
+}
+
+func (s *Syncer) syncTransactions(currency string, report *SyncReport) error {
+	return s.sync(TransactionsBucket, currency, report, func(number int) ([]fetched, bool, error) {
+		s.wait()
+		list, err := s.exchange.GetTransactions(args.Currency(currency), args.Page(number), args.Limit(syncPageSize))
+		if err != nil {
+			return nil, false, fmt.Errorf("error getting the transactions of %s: %s", currency, err)
+		}
+		records := make([]fetched, len(list.Data))
+		for i, transaction := range list.Data {
+			t, err := execution.ParseTime(transaction.Date)
+			if err != nil {
+				return nil, false, fmt.Errorf("error reading the transaction %s: %s", transaction.Id, err)
+			}
+			records[i] = fetched{currency + "/" + transaction.Id, transaction.Id, t, transaction}
+		}
+		return records, len(list.Data) < syncPageSize, nil
+	}, func(data json.RawMessage) (string, time.Time, bool, error) {
+		var transaction conn.Transaction
+		if err := json.Unmarshal(data, &transaction); err != nil {
+			return "", time.Time{}, false, err
+		}
+		t, err := execution.ParseTime(transaction.Date)
+		return transaction.Id, t, true, err
+	})
+}
+
+// sync reads the pages of a scope and updates the bucket. stored decodes a
+// record of the bucket, and tells if it is in the scope.
+func (s *Syncer) sync(bucket, scope string, report *SyncReport, read page, stored func(data json.RawMessage) (string, time.Time, bool, error)) error {
+	seen := make(map[string]bool)
+	var oldest time.Time
+	complete := false
+	for number := 0; ; number++ {
+		records, last, err := read(number)
+		if err != nil {
+			return err
+		}
+		report.Pages++
+		overlap := false
+		var entries []entry
+		for _, r := range records {
+			seen[r.key] = true
+			if oldest.IsZero() || r.time.Before(oldest) {
+				oldest = r.time
+			}
+			data, err := json.Marshal(r.value)
+			if err != nil {
+				return err
+			}
+			s.store.mu.Lock()
+			before, ok := s.store.buckets[bucket][r.key]
+			s.store.mu.Unlock()
+			switch {
+			case !ok:
+				report.Changes = append(report.Changes, Change{Added, bucket, scope, r.id})
+			case string(before) != string(data):
+				report.Changes = append(report.Changes, Change{Changed, bucket, scope, r.id})
+			default:
+				overlap = true
+				continue
+			}
+			entries = append(entries, entry{bucket, r.key, r.value})
+		}
+		if err := s.store.write(entries...); err != nil {
+			return err
+		}
+		if last {
+			complete = true
+			break
+		}
+		if overlap && !s.Full {
+			break
+		}
+	}
+	// the records of the period read that were not listed are gone. When
+	// the reading stopped early, the records as old as the oldest read may
+	// be in the next page, and are kept.
+	var deleted []Change
+	err := s.store.Scan(bucket, scope+"/", func(key string, data json.RawMessage) error {
+		if seen[key] {
+			return nil
+		}
+		id, t, inScope, err := stored(data)
+		if err != nil {
+			return fmt.Errorf("error decoding %s %s: %s", bucket, key, err)
+		}
+		if inScope && (complete || t.After(oldest)) {
+			deleted = append(deleted, Change{Deleted, bucket, scope, id})
+			if err := s.store.Delete(bucket, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	report.Changes = append(report.Changes, deleted...)
+	return err
+}
+
+// Run syncs every period until done is closed, and calls notify, if not
+// nil, with the result of each sync. The errors do not stop it, as the
+// next sync may succeed.
+func (s *Syncer) Run(period time.Duration, done <-chan struct{}, notify func(*SyncReport, error)) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		report, err := s.Sync()
+		if notify != nil {
+			notify(report, err)
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		// a tick may be ready as well once done is closed
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+// executed returns an order executed i minutes after the start of 2021.
+func executed(i int) conn.Order {
+	at := time.Date(2021, 1, 1, 0, i, 0, 0, time.UTC).Format("2006-01-02T15:04:05.000000")
+	return conn.Order{
+		Id: "E" + strconv.Itoa(i), Status: "executed", Type: "buy", Price: "150000", Market: "ETHCLP",
+		Amount:         conn.Amount{Original: "0.1", Remaining: "0", Executed: "0.1"},
+		ExecutionPrice: "150000", CreatedAt: at, UpdatedAt: at, ExecutedAt: at,
+	}
+}
+
+func newSyncServer() *conntest.Server {
+	fixtures := conntest.DefaultFixtures()
+	// the orders are listed the newest first
+	var orders []conn.Order
+	for i := 250; i > 0; i-- {
+		orders = append(orders, executed(i))
+	}
+	fixtures.Orders = append(orders, fixtures.Orders...)
+	return conntest.NewServer("key", "secret", fixtures)
+}
+
+func TestSync(t *testing.T) {
+	server := newSyncServer()
+	defer server.Close()
+	s := open(t, filepath.Join(t.TempDir(), "data.store"))
+	defer s.Close()
+	syncer := NewSyncer(s, server.Client())
+	syncer.Markets = []string{"ETHCLP"}
+	syncer.Currencies = []string{"CLP"}
+
+	report, err := syncer.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(Added) != 253 || report.Pages != 4 {
+		t.Errorf("expected all the history read, got %s", report)
+	}
+	if last, _ := s.LastSync(); !last.Equal(report.Time) {
+		t.Errorf("expected the time of the sync recorded, got %s", last)
+	}
+	orders, _ := s.Orders("ETHCLP", time.Time{}, time.Time{})
+	if len(orders) != 251 || orders[0].Id != "M99" || orders[250].Id != "E250" {
+		t.Errorf("unexpected orders stored, %d", len(orders))
+	}
+
+	// only the first page is read when nothing changed
+	calls := server.Calls("orders/executed")
+	report, err = syncer.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 0 || server.Calls("orders/executed") != calls+1 {
+		t.Errorf("expected a single page and no change, got %s", report)
+	}
+
+	fixtures := server.Fixtures()
+	changed := fixtures.Orders[2]
+	changed.Price = "151000"
+	fixtures.Orders[2] = changed
+	fixtures.Orders = append([]conn.Order{executed(251)}, append(fixtures.Orders[:4], fixtures.Orders[5:]...)...)
+	report, err = syncer.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Added, OrdersBucket, "ETHCLP", "E251"},
+		{Changed, OrdersBucket, "ETHCLP", changed.Id},
+		{Deleted, OrdersBucket, "ETHCLP", "E246"},
+	}
+	if len(report.Changes) != 3 || report.Changes[0] != expected[0] || report.Changes[1] != expected[1] || report.Changes[2] != expected[2] {
+		t.Errorf("expected %v, got %v", expected, report.Changes)
+	}
+	if order, _ := s.Order(changed.Id); order == nil || order.Price != "151000" {
+		t.Errorf("expected the order updated, got %+v", order)
+	}
+
+	// a cancelled order with a partial fill is reconciled as well
+	cancelled := executed(252)
+	cancelled.Status = "cancelled"
+	cancelled.Amount.Remaining, cancelled.Amount.Executed = "0.05", "0.05"
+	s.PutOrders(cancelled)
+	report, err = syncer.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(Deleted) != 1 || report.Changes[0].Id != cancelled.Id {
+		t.Errorf("expected the cancelled order not listed deleted, got %v", report.Changes)
+	}
+
+	// an old order gone is only found by a full sync
+	fixtures.Orders = fixtures.Orders[:len(fixtures.Orders)-1]
+	if report, _ = syncer.Sync(); report.Count(Deleted) != 0 {
+		t.Errorf("expected no deletion found, got %s", report)
+	}
+	syncer.Full = true
+	report, err = syncer.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(Deleted) != 1 || report.Changes[0].Id != "M99" {
+		t.Errorf("expected M99 deleted, got %v", report.Changes)
+	}
+}
+
+func TestSyncAll(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	s := open(t, filepath.Join(t.TempDir(), "data.store"))
+	defer s.Close()
+	// the active orders are kept
+	s.PutOrders(server.Fixtures().Orders[0])
+	report, err := NewSyncer(s, server.Client()).Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// M99 and the transactions of CLP and ETH
+	if report.Count(Added) != 4 || report.Count(Deleted) != 0 {
+		t.Errorf("unexpected sync %s %v", report, report.Changes)
+	}
+	if s.Len(OrdersBucket) != 2 {
+		t.Errorf("expected 2 orders, got %d", s.Len(OrdersBucket))
+	}
+}
+
+func TestSyncError(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	s := open(t, filepath.Join(t.TempDir(), "data.store"))
+	defer s.Close()
+	server.InjectFault("transactions", conntest.ServerError, 1)
+	syncer := NewSyncer(s, server.Client())
+	syncer.Markets = []string{"ETHCLP"}
+	if _, err := syncer.Sync(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if last, _ := s.LastSync(); !last.IsZero() {
+		t.Errorf("expected no sync recorded, got %s", last)
+	}
+
+	done := make(chan struct{})
+	var results []error
+	syncer.Run(time.Millisecond, done, func(report *SyncReport, err error) {
+		results = append(results, err)
+		if len(results) == 2 {
+			close(done)
+		}
+	})
+	if len(results) != 2 || results[0] != nil || results[1] != nil {
+		t.Errorf("unexpected results %v", results)
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	s := open(t, filepath.Join(t.TempDir(), "data.store"))
+	defer s.Close()
+	syncer := NewSyncer(s, server.Client())
+	syncer.Markets = []string{"ETHCLP"}
+	// done is closed with a tick ready, which must not start another sync
+	for i := 0; i < 20; i++ {
+		done := make(chan struct{})
+		syncs := 0
+		syncer.Run(time.Millisecond, done, func(report *SyncReport, err error) {
+			syncs++
+			time.Sleep(3 * time.Millisecond)
+			close(done)
+		})
+		if syncs != 1 {
+			t.Fatalf("expected one sync, got %d", syncs)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+// Package strategy runs trading strategies, the same code replaying
+// historical data, against a paper trading client or against CryptoMarket.
+//
+// A Runtime feeds a Strategy with tickers, trades, candles and the updates
+// of its orders, and gives it a conn.Trading to place them. In paper and
+// live modes the runtime polls the market, spaces the calls with a
+// conn.Limiter, follows the orders created by the strategy and, when
+// stopped, lets the current callback finish and may cancel the orders left
+// in the book. In historical mode the data is replayed by a
+// backtest.Backtest.
+package strategy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/backtest"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+)
+
+// Strategy is the interface of the strategies. It is the interface replayed
+// by the backtest package, so a strategy runs unchanged in every mode.
+type Strategy = backtest.Strategy
+
+// Bar is a candle given to OnCandle, with its ask and bid prices.
+type Bar = backtest.Bar
+
+// Base implements Strategy doing nothing, to be embedded by the strategies
+// that handle only some of the events.
+type Base struct{}
+
+// OnTicker does nothing.
+func (Base) OnTicker(trading conn.Trading, ticker conn.Ticker) error { return nil }
+
+// OnTrade does nothing.
+func (Base) OnTrade(trading conn.Trading, trade conn.TradeData) error { return nil }
+
+// OnCandle does nothing.
+func (Base) OnCandle(trading conn.Trading, bar Bar) error { return nil }
+
+// OnOrderUpdate does nothing.
+func (Base) OnOrderUpdate(trading conn.Trading, order conn.Order) error { return nil }
+
+// Exchange is the part of the client used by a runtime in paper and live
+// modes.
+type Exchange interface {
+	conn.MarketData
+	conn.Trading
+}
+
+// Mode tells where a runtime gets its data and executes its orders.
+type Mode int
+
+const (
+	// Historical replays candles or trades in a backtest.
+	Historical Mode = iota
+	// Paper polls CryptoMarket and simulates the orders in a paper client.
+	Paper
+	// Live polls CryptoMarket and places the orders in it.
+	Live
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Historical:
+		return "historical"
+	case Paper:
+		return "paper"
+	case Live:
+		return "live"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// A Runtime runs a strategy in a market.
+type Runtime struct {
+	strategy Strategy
+	mode     Mode
+	market   string
+
+	// historical mode
+	backtest *backtest.Backtest
+	bars     []Bar
+	trades   []conn.TradeData
+	report   *backtest.Report
+
+	// paper and live modes
+	exchange     Exchange
+	limiter      *conn.Limiter
+	interval     time.Duration
+	timeframe    string
+	cancelOnStop bool
+	started      bool
+	seenTrades   map[string]bool
+	lastBar      time.Time
+
+	mu      sync.Mutex
+	tracked map[string]conn.Order
+	// updates are those of the orders executed when created, given to the
+	// strategy after the callback creating them
+	updates []conn.Order
+}
+
+// NewHistorical builds a runtime replaying candles in a backtest.
+func NewHistorical(strategy Strategy, bt *backtest.Backtest, bars []Bar) *Runtime {
+	return &Runtime{strategy: strategy, mode: Historical, backtest: bt, bars: bars}
+}
+
+// NewHistoricalTrades builds a runtime replaying trades in a backtest.
+func NewHistoricalTrades(strategy Strategy, bt *backtest.Backtest, trades []conn.TradeData) *Runtime {
+	return &Runtime{strategy: strategy, mode: Historical, backtest: bt, trades: trades}
+}
+
+// NewPaper builds a runtime of a market on a client in paper trading mode,
+// as built by conn.NewPaperClient.
+func NewPaper(strategy Strategy, market string, client *conn.Client) (*Runtime, error) {
+	if !client.IsPaper() {
+		return nil, errors.New("client is not in paper trading mode")
+	}
+	rt := newPolling(strategy, market, client)
+	rt.mode = Paper
+	return rt, nil
+}
+
+// NewLive builds a runtime of a market placing real orders, usually with a
+// conn.Client.
+func NewLive(strategy Strategy, market string, exchange Exchange) *Runtime {
+	rt := newPolling(strategy, market, exchange)
+	rt.mode = Live
+	return rt
+}
+
+func newPolling(strategy Strategy, market string, exchange Exchange) *Runtime {
+	return &Runtime{
+		strategy:   strategy,
+		market:     market,
+		exchange:   exchange,
+		limiter:    conn.NewDefaultLimiter(),
+		interval:   10 * time.Second,
+		seenTrades: make(map[string]bool),
+		tracked:    make(map[string]conn.Order),
+	}
+}
+
+// Mode returns the mode of the runtime.
+func (rt *Runtime) Mode() Mode {
+	return rt.mode
+}
+
+// SetLimiter sets the limiter spacing the calls to CryptoMarket, shared by
+// the polls and the calls of the strategy. By default one waiting DELAY
+// seconds.
+func (rt *Runtime) SetLimiter(limiter *conn.Limiter) {
+	rt.limiter = limiter
+}
+
+// SetInterval sets the time between polls of the market, 10 seconds by
+// default.
+func (rt *Runtime) SetInterval(interval time.Duration) {
+	rt.interval = interval
+}
+
+// SetTimeframe enables OnCandle, polling the candles of the given timeframe
+// in minutes, as "60". A candle is given once it is closed.
+func (rt *Runtime) SetTimeframe(timeframe string) {
+	rt.timeframe = timeframe
+}
+
+// SetCancelOnStop makes the runtime cancel the active orders of the
+// strategy when it stops.
+func (rt *Runtime) SetCancelOnStop(cancel bool) {
+	rt.cancelOnStop = cancel
+}
+
+// Report returns the report of a historical run, nil before it finishes.
+func (rt *Runtime) Report() *backtest.Report {
+	return rt.report
+}
+
+// Orders returns the orders of the strategy the runtime is following, the
+// active ones with their last known state.
+func (rt *Runtime) Orders() []conn.Order {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	orders := make([]conn.Order, 0, len(rt.tracked))
+	for _, order := range rt.tracked {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].CreatedAt != orders[j].CreatedAt {
+			return orders[i].CreatedAt < orders[j].CreatedAt
+		}
+		return orders[i].Id < orders[j].Id
+	})
+	return orders
+}
+
+// Run runs the strategy until done is closed, or until a callback or a
+// call to CryptoMarket fails. A historical runtime runs until the data is
+// replayed.
+func (rt *Runtime) Run(done <-chan struct{}) error {
+	if rt.mode == Historical {
+		var err error
+		if rt.trades != nil {
+			rt.report, err = rt.backtest.RunTrades(rt.strategy, rt.trades)
+		} else {
+			rt.report, err = rt.backtest.RunCandles(rt.strategy, rt.bars)
+		}
+		return err
+	}
+	err := rt.run(done)
+	if rt.cancelOnStop {
+		if cancelErr := rt.CancelAll(); err == nil {
+			err = cancelErr
+		}
+	}
+	return err
+}
+
+func (rt *Runtime) run(done <-chan struct{}) error {
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		if err := rt.Step(); err != nil {
+			return err
+		}
+		select {
+		case <-done:
+			return nil
+		case <-time.After(rt.interval):
+		}
+	}
+}
+
+// CancelAll cancels the active orders of the strategy.
+func (rt *Runtime) CancelAll() error {
+	var errs []string
+	for _, order := range rt.Orders() {
+		rt.limiter.Wait()
+		if _, err := rt.exchange.CancelOrder(args.Id(order.Id)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", order.Id, err))
+			continue
+		}
+		rt.untrack(order.Id)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error cancelling orders %v", errs)
+	}
+	return nil
+}
+
+// Step polls the market once in paper and live modes, feeding the new
+// ticker, trades, candles and order updates to the strategy. The first
+// step does not give the trades and candles made before it.
+func (rt *Runtime) Step() error {
+	if rt.mode == Historical {
+		return errors.New("a historical runtime can not be stepped")
+	}
+	trading := &runtimeTrading{rt}
+	rt.limiter.Wait()
+	tickers, err := rt.exchange.GetTicker(args.Market(rt.market))
+	if err != nil {
+		return fmt.Errorf("error getting the ticker: %s", err)
+	}
+	for _, ticker := range tickers {
+		if err := rt.strategy.OnTicker(trading, ticker); err != nil {
+			return err
+		}
+		if err := rt.flush(trading); err != nil {
+			return err
+		}
+	}
+	if err := rt.pollTrades(trading); err != nil {
+		return err
+	}
+	if rt.timeframe != "" {
+		if err := rt.pollCandles(trading); err != nil {
+			return err
+		}
+	}
+	if err := rt.pollOrders(trading); err != nil {
+		return err
+	}
+	rt.started = true
+	return nil
+}
+
+func (rt *Runtime) pollTrades(trading conn.Trading) error {
+	rt.limiter.Wait()
+	trades, err := rt.exchange.GetTrades(args.Market(rt.market), args.Limit(100))
+	if err != nil {
+		return fmt.Errorf("error getting the trades: %s", err)
+	}
+	var fresh []conn.TradeData
+	seen := make(map[string]bool)
+	for _, trade := range trades.Data {
+		seen[trade.Tid] = true
+		if rt.started && !rt.seenTrades[trade.Tid] {
+			fresh = append(fresh, trade)
+		}
+	}
+	rt.seenTrades = seen
+	if err := backtest.SortTrades(fresh); err != nil {
+		return err
+	}
+	for _, trade := range fresh {
+		if err := rt.strategy.OnTrade(trading, trade); err != nil {
+			return err
+		}
+		if err := rt.flush(trading); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rt *Runtime) pollCandles(trading conn.Trading) error {
+	rt.limiter.Wait()
+	prices, err := rt.exchange.GetPrices(args.Market(rt.market), args.Timeframe(rt.timeframe))
+	if err != nil {
+		return fmt.Errorf("error getting the candles: %s", err)
+	}
+	bars, err := backtest.BarsFromPrices(prices.Data)
+	if err != nil {
+		return err
+	}
+	if len(bars) < 2 {
+		return nil
+	}
+	// the newest candle is still open
+	closed := bars[:len(bars)-1]
+	for _, bar := range closed {
+		if !rt.started || !bar.Time.After(rt.lastBar) {
+			continue
+		}
+		if err := rt.strategy.OnCandle(trading, bar); err != nil {
+			return err
+		}
+		if err := rt.flush(trading); err != nil {
+			return err
+		}
+	}
+	rt.lastBar = closed[len(closed)-1].Time
+	return nil
+}
+
+// pollOrders refreshes the active orders of the strategy, giving it the
+// ones that changed.
+func (rt *Runtime) pollOrders(trading conn.Trading) error {
+	for _, known := range rt.Orders() {
+		rt.limiter.Wait()
+		order, err := rt.exchange.GetOrderStatus(args.Id(known.Id))
+		if err != nil {
+			return fmt.Errorf("error refreshing order %s: %s", known.Id, err)
+		}
+		if order.Status == known.Status && order.Amount.Executed == known.Amount.Executed {
+			continue
+		}
+		if order.Status == "active" {
+			rt.track(*order)
+		} else {
+			rt.untrack(order.Id)
+		}
+		if err := rt.strategy.OnOrderUpdate(trading, *order); err != nil {
+			return err
+		}
+		if err := rt.flush(trading); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush gives the strategy the updates of the orders executed when
+// created, as the backtest does, including those of the orders created
+// while handling them.
+func (rt *Runtime) flush(trading conn.Trading) error {
+	for {
+		rt.mu.Lock()
+		if len(rt.updates) == 0 {
+			rt.mu.Unlock()
+			return nil
+		}
+		update := rt.updates[0]
+		rt.updates = rt.updates[1:]
+		rt.mu.Unlock()
+		if err := rt.strategy.OnOrderUpdate(trading, update); err != nil {
+			return err
+		}
+	}
+}
+
+func (rt *Runtime) track(order conn.Order) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.tracked[order.Id] = order
+}
+
+func (rt *Runtime) untrack(id string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.tracked, id)
+}
+
+// runtimeTrading is the conn.Trading given to the strategy in paper and
+// live modes. It waits on the limiter before each call, follows the
+// orders the strategy creates, and attaches the orders it returns to
+// itself, so closing them goes through the runtime too.
+type runtimeTrading struct {
+	rt *Runtime
+}
+
+func (t *runtimeTrading) GetActiveOrders(arguments ...args.Argument) (*conn.OrderList, error) {
+	t.rt.limiter.Wait()
+	return t.attachList(t.rt.exchange.GetActiveOrders(arguments...))
+}
+
+func (t *runtimeTrading) GetActiveOrdersAllPages(arguments ...args.Argument) ([]conn.Order, error) {
+	t.rt.limiter.Wait()
+	return t.attachAll(t.rt.exchange.GetActiveOrdersAllPages(arguments...))
+}
+
+func (t *runtimeTrading) GetExecutedOrders(arguments ...args.Argument) (*conn.OrderList, error) {
+	t.rt.limiter.Wait()
+	return t.attachList(t.rt.exchange.GetExecutedOrders(arguments...))
+}
+
+func (t *runtimeTrading) GetExecutedOrdersAllPages(arguments ...args.Argument) ([]conn.Order, error) {
+	t.rt.limiter.Wait()
+	return t.attachAll(t.rt.exchange.GetExecutedOrdersAllPages(arguments...))
+}
+
+func (t *runtimeTrading) GetOrderStatus(arguments ...args.Argument) (*conn.Order, error) {
+	t.rt.limiter.Wait()
+	return t.attach(t.rt.exchange.GetOrderStatus(arguments...))
+}
+
+func (t *runtimeTrading) GetInstant(arguments ...args.Argument) (*conn.Instant, error) {
+	t.rt.limiter.Wait()
+	return t.rt.exchange.GetInstant(arguments...)
+}
+
+func (t *runtimeTrading) CreateOrder(arguments ...args.Argument) (*conn.Order, error) {
+	t.rt.limiter.Wait()
+	order, err := t.attach(t.rt.exchange.CreateOrder(arguments...))
+	if err != nil {
+		return nil, err
+	}
+	if order.Status == "active" {
+		t.rt.track(*order)
+	}
+	if executed, _ := strconv.ParseFloat(order.Amount.Executed, 64); executed > 0 || order.Status != "active" {
+		t.rt.mu.Lock()
+		t.rt.updates = append(t.rt.updates, *order)
+		t.rt.mu.Unlock()
+	}
+	return order, nil
+}
+
+func (t *runtimeTrading) CancelOrder(arguments ...args.Argument) (*conn.Order, error) {
+	t.rt.limiter.Wait()
+	order, err := t.attach(t.rt.exchange.CancelOrder(arguments...))
+	if err != nil {
+		return nil, err
+	}
+	t.rt.untrack(order.Id)
+	return order, nil
+}
+
+func (t *runtimeTrading) CreateInstant(arguments ...args.Argument) error {
+	t.rt.limiter.Wait()
+	return t.rt.exchange.CreateInstant(arguments...)
+}
+
+func (t *runtimeTrading) attach(order *conn.Order, err error) (*conn.Order, error) {
+	if err != nil {
+		return nil, err
+	}
+	order.SetClient(t)
+	return order, nil
+}
+
+func (t *runtimeTrading) attachList(orders *conn.OrderList, err error) (*conn.OrderList, error) {
+	if err != nil {
+		return nil, err
+	}
+	orders.SetClient(t)
+	return orders, nil
+}
+
+func (t *runtimeTrading) attachAll(orders []conn.Order, err error) ([]conn.Order, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		orders[i].SetClient(t)
+	}
+	return orders, nil
+}
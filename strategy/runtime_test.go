@@ -0,0 +1,245 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/backtest"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/conntest"
+)
+
+// buyOnce places a buy order on the first ticker, and counts the events.
+type buyOnce struct {
+	Base
+	price   string
+	amount  string
+	created *conn.Order
+	tickers int
+	trades  int
+	candles []Bar
+	updates []conn.Order
+	onTick  func()
+}
+
+func (b *buyOnce) OnTicker(trading conn.Trading, ticker conn.Ticker) error {
+	b.tickers++
+	if b.onTick != nil {
+		b.onTick()
+	}
+	if b.created != nil {
+		return nil
+	}
+	var err error
+	b.created, err = trading.CreateOrder(args.Market("ETHCLP"), args.Type("buy"), args.Amount(b.amount), args.Price(b.price))
+	return err
+}
+
+func (b *buyOnce) OnTrade(trading conn.Trading, trade conn.TradeData) error {
+	b.trades++
+	return nil
+}
+
+func (b *buyOnce) OnCandle(trading conn.Trading, bar Bar) error {
+	b.candles = append(b.candles, bar)
+	return nil
+}
+
+func (b *buyOnce) OnOrderUpdate(trading conn.Trading, order conn.Order) error {
+	b.updates = append(b.updates, order)
+	return nil
+}
+
+func TestLive(t *testing.T) {
+	server, err := conntest.NewSimulatedServer("key", "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	strategy := &buyOnce{price: "150050", amount: "0.2"}
+	rt := NewLive(strategy, "ETHCLP", server.Client())
+	rt.SetLimiter(conn.NewLimiter(0))
+	rt.SetTimeframe("60")
+
+	if err := rt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if strategy.created == nil || strategy.created.Status != "active" || len(rt.Orders()) != 1 {
+		t.Fatalf("expected an active order followed by the runtime, got %v", strategy.created)
+	}
+	if strategy.trades != 0 || len(strategy.candles) != 0 {
+		t.Errorf("the history should not be given, got %d trades and %d candles", strategy.trades, len(strategy.candles))
+	}
+
+	if err := server.PlaceExternalOrder("ETHCLP", "sell", 150050, 0.2); err != nil {
+		t.Fatal(err)
+	}
+	prices := server.Fixtures().Prices["ETHCLP"]["60"]
+	next := prices.Ask[0]
+	next.CandleId++
+	next.CandleDate = "2020-01-01 13:00:00"
+	prices.Ask = append([]conn.Candle{next}, prices.Ask...)
+	prices.Bid = append([]conn.Candle{next}, prices.Bid...)
+	server.Fixtures().Prices["ETHCLP"]["60"] = prices
+
+	if err := rt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if strategy.tickers != 2 || strategy.trades != 1 {
+		t.Errorf("expected 2 tickers and 1 trade, got %d and %d", strategy.tickers, strategy.trades)
+	}
+	if len(strategy.candles) != 1 || strategy.candles[0].Time.Hour() != 12 {
+		t.Errorf("expected the closed candle of 12:00, got %v", strategy.candles)
+	}
+	if len(strategy.updates) != 1 || strategy.updates[0].Status != "executed" {
+		t.Errorf("expected the execution of the order, got %v", strategy.updates)
+	}
+	if len(rt.Orders()) != 0 {
+		t.Errorf("executed orders should not be followed, got %v", rt.Orders())
+	}
+}
+
+func TestRunCancelsOnStop(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	done := make(chan struct{})
+	strategy := &buyOnce{price: "140000", amount: "0.1", onTick: func() { close(done) }}
+	rt := NewLive(strategy, "ETHCLP", server.Client())
+	rt.SetLimiter(conn.NewLimiter(0))
+	rt.SetInterval(time.Hour)
+	rt.SetCancelOnStop(true)
+	if err := rt.Run(done); err != nil {
+		t.Fatal(err)
+	}
+	order, err := server.Client().GetOrderStatus(args.Id(strategy.created.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "cancelled" || len(rt.Orders()) != 0 {
+		t.Errorf("the order should be cancelled on stop, got %v", order)
+	}
+}
+
+func TestOrdersCloseThroughRuntime(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	strategy := &buyOnce{price: "140000", amount: "0.1"}
+	rt := NewLive(strategy, "ETHCLP", server.Client())
+	rt.SetLimiter(conn.NewLimiter(0))
+	if err := rt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	listed := strategy.created.Id
+	// a second order, to be closed from the order itself
+	strategy.created = nil
+	if err := rt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.Orders()) != 2 {
+		t.Fatalf("expected two orders followed by the runtime, got %v", rt.Orders())
+	}
+
+	if _, err := strategy.created.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.Orders()) != 1 {
+		t.Errorf("closing a created order should go through the runtime, got %v", rt.Orders())
+	}
+
+	trading := &runtimeTrading{rt}
+	active, err := trading.GetActiveOrders(args.Market("ETHCLP"), args.Page(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range active.Data {
+		if order.Id != listed {
+			continue
+		}
+		if _, err := order.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rt.Orders()) != 0 {
+		t.Errorf("closing a listed order should go through the runtime, got %v", rt.Orders())
+	}
+}
+
+func TestPaper(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	if _, err := NewPaper(&buyOnce{}, "ETHCLP", server.Client()); err == nil {
+		t.Errorf("a client not in paper trading mode should be refused")
+	}
+	client := conn.NewPaperClient("key", "secret", map[string]float64{"CLP": 1000000})
+	client.SetBaseUri(server.URL)
+	strategy := &buyOnce{price: "150100", amount: "0.1"}
+	rt, err := NewPaper(strategy, "ETHCLP", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.SetLimiter(conn.NewLimiter(0))
+	if err := rt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if rt.Mode() != Paper || strategy.created == nil || strategy.created.Status != "executed" {
+		t.Errorf("expected the order executed by the paper client, got %v", strategy.created)
+	}
+	if server.Calls("orders/create") != 0 {
+		t.Errorf("a paper order should not reach the server")
+	}
+}
+
+func TestPaperExecutedOnCreation(t *testing.T) {
+	server := conntest.NewServer("key", "secret", nil)
+	defer server.Close()
+	client := conn.NewPaperClient("key", "secret", map[string]float64{"CLP": 1000000})
+	client.SetBaseUri(server.URL)
+	// the price crosses the ask, executing the order as it is created
+	strategy := &buyOnce{price: "150100", amount: "0.1"}
+	rt, err := NewPaper(strategy, "ETHCLP", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.SetLimiter(conn.NewLimiter(0))
+	if err := rt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if len(strategy.updates) != 1 || strategy.updates[0].Id != strategy.created.Id || strategy.updates[0].Status != "executed" {
+		t.Fatalf("expected the update of the order executed when created, got %v", strategy.updates)
+	}
+	if len(rt.Orders()) != 0 {
+		t.Errorf("an executed order should not be followed, got %v", rt.Orders())
+	}
+	if err := rt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if len(strategy.updates) != 1 {
+		t.Errorf("the update should be given once, got %v", strategy.updates)
+	}
+}
+
+func TestHistorical(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ohlc := func(open, high, low, close float64) backtest.OHLC {
+		return backtest.OHLC{Open: open, High: high, Low: low, Close: close}
+	}
+	bars := []Bar{
+		{Time: start, Ask: ohlc(101, 102, 100, 101), Bid: ohlc(99, 100, 98, 99)},
+		{Time: start.Add(time.Hour), Ask: ohlc(101, 101, 94, 96), Bid: ohlc(99, 95, 93, 94)},
+	}
+	strategy := &buyOnce{price: "95", amount: "1"}
+	rt := NewHistorical(strategy, backtest.New("ETHCLP", map[string]float64{"CLP": 1000}), bars)
+	if err := rt.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if strategy.tickers != 2 || len(strategy.candles) != 2 {
+		t.Errorf("expected 2 tickers and 2 candles, got %d and %d", strategy.tickers, len(strategy.candles))
+	}
+	if len(strategy.updates) != 1 || strategy.updates[0].Status != "executed" {
+		t.Errorf("expected the execution of the order, got %v", strategy.updates)
+	}
+	if report := rt.Report(); report == nil || report.Balances["ETH"] != 1 {
+		t.Errorf("unexpected report %v", report)
+	}
+}
@@ -0,0 +1,394 @@
+// Package tax builds a ledger of tax lots from the executed orders and the
+// transactions of an account, and writes the capital gains of a year in the
+// formats accountants ask for.
+package tax
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/args"
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/execution"
+	"github.com/cryptomkt/cryptomkt-go/pnl"
+)
+
+// A Kind is the kind of an entry of the ledger.
+type Kind string
+
+// The kinds of the entries of the ledger.
+const (
+	Buy  Kind = "buy"
+	Sell Kind = "sell"
+	// Deposit and Withdrawal move money from and to third parties, or from
+	// wallets not known as own.
+	Deposit    Kind = "deposit"
+	Withdrawal Kind = "withdrawal"
+	// TransferIn and TransferOut move money between own wallets, they do
+	// not change the lots.
+	TransferIn  Kind = "transfer_in"
+	TransferOut Kind = "transfer_out"
+	// Fee is the fee of a withdrawal or a transfer paid in a crypto
+	// currency, a disposal without proceeds.
+	Fee Kind = "fee"
+)
+
+// An Entry is a movement of a currency, valued in the fiat of the ledger.
+type Entry struct {
+	Time     time.Time
+	Kind     Kind
+	Currency string
+	// Amount is the amount of the currency moved, always positive.
+	Amount float64
+	// Value is the amount in the fiat of the ledger, and Fee the fee paid,
+	// both unknown for the deposits of crypto.
+	Value float64
+	Fee   float64
+	// Ref is the id of the order or of the transaction.
+	Ref string
+}
+
+// A Disposal is an amount of a crypto currency sold or paid as a fee,
+// matched with the lots it came from.
+type Disposal struct {
+	Currency string
+	Amount   float64
+	// Acquired is the date of the oldest lot disposed, and Various tells if
+	// the lots were acquired on different days.
+	Acquired time.Time
+	Various  bool
+	Disposed time.Time
+	Kind     Kind
+	// Proceeds is what was received, net of fees, Cost the cost of the lots
+	// and Gain the difference.
+	Proceeds float64
+	Cost     float64
+	Fee      float64
+	Gain     float64
+	// UnknownCost tells if part of the amount came from a deposit of
+	// unknown cost, or had no lot at all, and was counted at no cost.
+	UnknownCost bool
+	Ref         string
+}
+
+// LongTerm tells if the disposal was held for more than a year.
+func (d Disposal) LongTerm() bool {
+	return d.Disposed.After(d.Acquired.AddDate(1, 0, 0))
+}
+
+// A Ledger follows the lots of the crypto currencies of an account, bought
+// with a fiat currency, through orders, deposits and withdrawals. The
+// markets quoted in another currency are not supported.
+//
+// Withdrawals to own wallets and deposits from them are transfers, they keep
+// the lots as they are. Other deposits of crypto open a lot valued with
+// DepositPrice, or at no cost when it is not set.
+type Ledger struct {
+	// Fiat is the currency the gains are counted in, as CLP.
+	Fiat string
+	// OwnAddresses and OwnHashes recognize the transactions between own
+	// wallets, by their address or their hash.
+	OwnAddresses map[string]bool
+	OwnHashes    map[string]bool
+	// DepositPrice returns the price in fiat of a currency at a time, to
+	// value the lots deposited. Optional.
+	DepositPrice func(currency string, t time.Time) (float64, error)
+	// Taker tells if an order was a taker, pnl.IsTaker by default.
+	Taker func(order conn.Order) bool
+
+	mu           sync.Mutex
+	method       pnl.Method
+	maker, taker float64
+	orders       map[string]conn.Order
+	transactions map[string]transaction
+}
+
+type transaction struct {
+	currency string
+	conn.Transaction
+}
+
+// NewLedger builds a ledger counting the gains in fiat, disposing the lots
+// by method.
+func NewLedger(fiat string, method pnl.Method) *Ledger {
+	return &Ledger{
+		Fiat:         strings.ToUpper(fiat),
+		OwnAddresses: make(map[string]bool),
+		OwnHashes:    make(map[string]bool),
+		Taker:        pnl.IsTaker,
+		method:       method,
+		orders:       make(map[string]conn.Order),
+		transactions: make(map[string]transaction),
+	}
+}
+
+// SetFees sets the maker and taker fees of the orders, as fractions.
+func (l *Ledger) SetFees(maker, taker float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maker, l.taker = maker, taker
+}
+
+// SetFeesFromRate sets the fees of an account, as given by GetAccount.
+func (l *Ledger) SetFeesFromRate(rate conn.Rate) error {
+	maker, taker, err := rate.Fees()
+	if err != nil {
+		return err
+	}
+	l.SetFees(maker, taker)
+	return nil
+}
+
+// AddOrders adds executed orders, kept by id.
+func (l *Ledger) AddOrders(orders ...conn.Order) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, order := range orders {
+		if !strings.HasSuffix(order.Market, l.Fiat) {
+			return fmt.Errorf("order %s of %s is not quoted in %s", order.Id, order.Market, l.Fiat)
+		}
+		l.orders[order.Id] = order
+	}
+	return nil
+}
+
+// AddTransactions adds the transactions of a currency, kept by id.
+func (l *Ledger) AddTransactions(currency string, transactions ...conn.Transaction) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	currency = strings.ToUpper(currency)
+	for _, tx := range transactions {
+		if _, err := strconv.ParseFloat(tx.Amount, 64); err != nil {
+			return fmt.Errorf("invalid amount %q of transaction %s", tx.Amount, tx.Id)
+		}
+		if _, err := execution.ParseTime(tx.Date); err != nil {
+			return fmt.Errorf("invalid date of transaction %s: %s", tx.Id, err)
+		}
+		l.transactions[currency+"/"+tx.Id] = transaction{currency: currency, Transaction: tx}
+	}
+	return nil
+}
+
+// AddFromExchange adds the executed orders of the markets and the
+// transactions of the currencies.
+func (l *Ledger) AddFromExchange(exchange conn.Exchange, markets, currencies []string) error {
+	for _, market := range markets {
+		orders, err := exchange.GetExecutedOrdersAllPages(args.Market(market))
+		if err != nil {
+			return fmt.Errorf("error getting the executed orders of %s: %s", market, err)
+		}
+		if err := l.AddOrders(orders...); err != nil {
+			return err
+		}
+	}
+	for _, currency := range currencies {
+		transactions, err := exchange.GetAllTransactions(args.Currency(currency))
+		if err != nil {
+			return fmt.Errorf("error getting the transactions of %s: %s", currency, err)
+		}
+		if err := l.AddTransactions(currency, transactions...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// own tells if a transaction is between own wallets.
+func (l *Ledger) own(tx conn.Transaction) bool {
+	return (tx.Address != "" && l.OwnAddresses[tx.Address]) || (tx.Hash != "" && l.OwnHashes[tx.Hash])
+}
+
+// Entries returns the movements of the ledger, in the order they are
+// applied to the lots.
+func (l *Ledger) Entries() ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []Entry
+	for _, order := range l.orders {
+		executed, err := strconv.ParseFloat(order.Amount.Executed, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid executed amount %q of order %s", order.Amount.Executed, order.Id)
+		}
+		if executed <= 0 {
+			continue
+		}
+		when := order.ExecutedAt
+		if when == "" {
+			when = order.UpdatedAt
+		}
+		t, err := execution.ParseTime(when)
+		if err != nil {
+			return nil, fmt.Errorf("invalid execution time of order %s: %s", order.Id, err)
+		}
+		fee := l.maker
+		if l.Taker != nil && l.Taker(order) {
+			fee = l.taker
+		}
+		gross := executed * orderPrice(order)
+		entry := Entry{Time: t, Currency: order.Market[:len(order.Market)-len(l.Fiat)], Ref: order.Id}
+		if order.Type == "buy" {
+			// the fee is paid in the currency received
+			entry.Kind, entry.Amount, entry.Value, entry.Fee = Buy, executed*(1-fee), gross, gross*fee
+		} else {
+			entry.Kind, entry.Amount, entry.Value, entry.Fee = Sell, executed, gross*(1-fee), gross*fee
+		}
+		entries = append(entries, entry)
+	}
+	for _, tx := range l.transactions {
+		amount, _ := strconv.ParseFloat(tx.Amount, 64)
+		feeAmount, _ := strconv.ParseFloat(tx.FeeAmount, 64)
+		t, _ := execution.ParseTime(tx.Date)
+		entry := Entry{Time: t, Currency: tx.currency, Amount: math.Abs(amount), Ref: tx.Id}
+		switch {
+		case amount >= 0 && l.own(tx.Transaction):
+			entry.Kind = TransferIn
+		case amount >= 0:
+			entry.Kind = Deposit
+		case l.own(tx.Transaction):
+			entry.Kind = TransferOut
+		default:
+			entry.Kind = Withdrawal
+		}
+		if tx.currency == l.Fiat {
+			entry.Value, entry.Fee = entry.Amount, feeAmount
+		} else if entry.Kind == Deposit && l.DepositPrice != nil {
+			price, err := l.DepositPrice(tx.currency, t)
+			if err != nil {
+				return nil, fmt.Errorf("error valuing the deposit %s: %s", tx.Id, err)
+			}
+			entry.Value = entry.Amount * price
+		}
+		entries = append(entries, entry)
+		if feeAmount > 0 && tx.currency != l.Fiat && amount < 0 {
+			entries = append(entries, Entry{Time: t, Kind: Fee, Currency: tx.currency, Amount: feeAmount, Ref: tx.Id})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.Before(entries[j].Time)
+		}
+		// what comes in before what goes out
+		if pi, pj := priority[entries[i].Kind], priority[entries[j].Kind]; pi != pj {
+			return pi < pj
+		}
+		return entries[i].Ref < entries[j].Ref
+	})
+	return entries, nil
+}
+
+var priority = map[Kind]int{Deposit: 0, TransferIn: 0, Buy: 1, Sell: 2, TransferOut: 3, Withdrawal: 3, Fee: 4}
+
+func orderPrice(order conn.Order) float64 {
+	if price, err := strconv.ParseFloat(order.ExecutionPrice, 64); err == nil && price > 0 {
+		return price
+	}
+	if order.AvgExecutionPrice > 0 {
+		return float64(order.AvgExecutionPrice)
+	}
+	price, _ := strconv.ParseFloat(order.Price, 64)
+	return price
+}
+
+// lot is an amount of a currency held, at a cost in fiat per unit.
+type lot struct {
+	time    time.Time
+	amount  float64
+	price   float64
+	unknown bool
+}
+
+// Disposals applies the entries to the lots, and returns the sells and
+// the fees with their gains, oldest first.
+func (l *Ledger) Disposals() ([]Disposal, error) {
+	entries, err := l.Entries()
+	if err != nil {
+		return nil, err
+	}
+	lots := make(map[string][]lot)
+	var disposals []Disposal
+	for _, entry := range entries {
+		if entry.Currency == l.Fiat {
+			continue
+		}
+		switch entry.Kind {
+		case Buy, Deposit:
+			lots[entry.Currency] = l.acquire(lots[entry.Currency], lot{
+				time:    entry.Time,
+				amount:  entry.Amount,
+				price:   entry.Value / entry.Amount,
+				unknown: entry.Kind == Deposit && l.DepositPrice == nil,
+			})
+		case Sell, Fee:
+			d := Disposal{
+				Currency: entry.Currency,
+				Amount:   entry.Amount,
+				Disposed: entry.Time,
+				Kind:     entry.Kind,
+				Proceeds: entry.Value,
+				Fee:      entry.Fee,
+				Ref:      entry.Ref,
+			}
+			lots[entry.Currency] = l.dispose(lots[entry.Currency], &d)
+			d.Gain = d.Proceeds - d.Cost
+			disposals = append(disposals, d)
+		case Withdrawal:
+			// the lots leave the account without a sale
+			d := Disposal{Amount: entry.Amount}
+			lots[entry.Currency] = l.dispose(lots[entry.Currency], &d)
+		}
+	}
+	return disposals, nil
+}
+
+func (l *Ledger) acquire(lots []lot, acquired lot) []lot {
+	if l.method == pnl.Average && len(lots) > 0 {
+		held := &lots[0]
+		amount := held.amount + acquired.amount
+		held.price = (held.amount*held.price + acquired.amount*acquired.price) / amount
+		held.amount = amount
+		held.unknown = held.unknown || acquired.unknown
+		return lots
+	}
+	return append(lots, acquired)
+}
+
+// dispose takes the amount of a disposal from the lots, setting its cost
+// and its acquisition date.
+func (l *Ledger) dispose(lots []lot, d *Disposal) []lot {
+	amount := d.Amount
+	for amount > 1e-12 && len(lots) > 0 {
+		i := 0
+		if l.method == pnl.LIFO {
+			i = len(lots) - 1
+		}
+		taken := math.Min(amount, lots[i].amount)
+		d.Cost += taken * lots[i].price
+		d.UnknownCost = d.UnknownCost || lots[i].unknown
+		if d.Acquired.IsZero() || lots[i].time.Before(d.Acquired) {
+			if !d.Acquired.IsZero() && !sameDay(d.Acquired, lots[i].time) {
+				d.Various = true
+			}
+			d.Acquired = lots[i].time
+		} else if !sameDay(d.Acquired, lots[i].time) {
+			d.Various = true
+		}
+		amount -= taken
+		lots[i].amount -= taken
+		if lots[i].amount <= 1e-12 {
+			lots = append(lots[:i], lots[i+1:]...)
+		}
+	}
+	if amount > 1e-12 {
+		d.UnknownCost = true
+	}
+	return lots
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
@@ -0,0 +1,138 @@
+package tax
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// InYear returns the disposals of a year.
+func InYear(disposals []Disposal, year int) []Disposal {
+	var result []Disposal
+	for _, d := range disposals {
+		if d.Disposed.Year() == year {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// SIIColumns are the columns of the Chile SII-style report.
+var SIIColumns = []string{
+	"fecha",
+	"operacion",
+	"activo",
+	"cantidad",
+	"fecha_adquisicion",
+	"monto_enajenacion",
+	"costo_tributario",
+	"comision",
+	"mayor_valor",
+	"costo_desconocido",
+}
+
+// WriteSII writes disposals as the Chile SII-style report of the mayor
+// valor of each sale, separated by semicolons as the spreadsheets of Chile
+// expect. The dates are dd-mm-yyyy, the amounts in pesos without decimals
+// and the quantities with a decimal comma.
+func WriteSII(w io.Writer, disposals []Disposal) error {
+	out := csv.NewWriter(w)
+	out.Comma = ';'
+	if err := out.Write(SIIColumns); err != nil {
+		return err
+	}
+	operations := map[Kind]string{Sell: "venta", Fee: "comision"}
+	for _, d := range disposals {
+		acquired := d.Acquired.Format("02-01-2006")
+		if d.Acquired.IsZero() {
+			acquired = ""
+		}
+		unknown := "no"
+		if d.UnknownCost {
+			unknown = "si"
+		}
+		record := []string{
+			d.Disposed.Format("02-01-2006"),
+			operations[d.Kind],
+			d.Currency,
+			strings.Replace(formatFloat(d.Amount), ".", ",", 1),
+			acquired,
+			pesos(d.Proceeds),
+			pesos(d.Cost),
+			pesos(d.Fee),
+			pesos(d.Gain),
+			unknown,
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Form8949Columns are the columns of the report like the form 8949.
+var Form8949Columns = []string{
+	"description",
+	"date_acquired",
+	"date_sold",
+	"proceeds",
+	"cost_basis",
+	"code",
+	"adjustment",
+	"gain_or_loss",
+	"term",
+}
+
+// Write8949 writes disposals like the form 8949, a line per disposal with
+// its term, short when held a year or less. The lots acquired on several
+// days have VARIOUS as their date. The code and the adjustment are left for
+// the accountant.
+func Write8949(w io.Writer, disposals []Disposal) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(Form8949Columns); err != nil {
+		return err
+	}
+	for _, d := range disposals {
+		acquired := d.Acquired.Format("01/02/2006")
+		if d.Various {
+			acquired = "VARIOUS"
+		} else if d.Acquired.IsZero() {
+			acquired = ""
+		}
+		term := "short"
+		if d.LongTerm() {
+			term = "long"
+		}
+		record := []string{
+			formatFloat(d.Amount) + " " + d.Currency,
+			acquired,
+			d.Disposed.Format("01/02/2006"),
+			formatFloat(round2(d.Proceeds)),
+			formatFloat(round2(d.Cost)),
+			"",
+			"",
+			formatFloat(round2(d.Gain)),
+			term,
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func pesos(val float64) string {
+	return strconv.FormatFloat(math.Round(val), 'f', 0, 64)
+}
+
+func round2(val float64) float64 {
+	return math.Round(val*100) / 100
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(math.Round(val*1e8)/1e8, 'f', -1, 64)
+}
@@ -0,0 +1,131 @@
+package tax
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cryptomkt/cryptomkt-go/conn"
+	"github.com/cryptomkt/cryptomkt-go/pnl"
+)
+
+func order(id, orderType, price, amount, date string) conn.Order {
+	return conn.Order{
+		Id:             id,
+		Status:         "executed",
+		Type:           orderType,
+		Price:          price,
+		ExecutionPrice: price,
+		Market:         "ETHCLP",
+		Amount:         conn.Amount{Original: amount, Remaining: "0", Executed: amount},
+		CreatedAt:      date + "T09:00:00.000000",
+		UpdatedAt:      date + "T10:00:00.000000",
+		ExecutedAt:     date + "T10:00:00.000000",
+	}
+}
+
+func newLedger(t *testing.T) *Ledger {
+	ledger := NewLedger("CLP", pnl.FIFO)
+	ledger.OwnAddresses["0xown"] = true
+	ledger.OwnHashes["h1"] = true
+	err := ledger.AddOrders(
+		order("B1", "buy", "100000", "1", "2020-01-10"),
+		order("B2", "buy", "200000", "1", "2021-03-01"),
+		order("S1", "sell", "300000", "1.5", "2021-06-01"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ledger.AddTransactions("ETH",
+		// to an own wallet and back, paying a fee
+		conn.Transaction{Id: "T1", Type: 2, Amount: "-0.2", FeeAmount: "0.01", Address: "0xown", Date: "2020-05-01T10:00:00.000000"},
+		conn.Transaction{Id: "T2", Type: 1, Amount: "0.2", FeeAmount: "0", Hash: "h1", Date: "2020-05-02T10:00:00.000000"},
+		// from a third party
+		conn.Transaction{Id: "T3", Type: 1, Amount: "0.5", FeeAmount: "0", Date: "2021-01-01T10:00:00.000000"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.AddTransactions("CLP", conn.Transaction{Id: "T0", Type: 1, Amount: "1000000", FeeAmount: "0", Date: "2020-01-01T10:00:00.000000"})
+	return ledger
+}
+
+func TestLedger(t *testing.T) {
+	ledger := newLedger(t)
+	entries, err := ledger.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, entry := range entries {
+		kinds = append(kinds, string(entry.Kind))
+	}
+	expected := "deposit buy transfer_out fee transfer_in deposit buy sell"
+	if got := strings.Join(kinds, " "); got != expected {
+		t.Errorf("expected the entries %s, got %s", expected, got)
+	}
+	disposals, err := ledger.Disposals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disposals) != 2 {
+		t.Fatalf("expected the fee and the sell, got %+v", disposals)
+	}
+	fee := disposals[0]
+	if fee.Kind != Fee || fee.Cost != 1000 || fee.Gain != -1000 {
+		t.Errorf("unexpected fee %+v", fee)
+	}
+	// 0.99 ETH of the first buy, the deposit at no cost, and 0.01 ETH of
+	// the second buy
+	sell := disposals[1]
+	if sell.Cost != 101000 || sell.Gain != 349000 || !sell.Various || !sell.UnknownCost || !sell.LongTerm() {
+		t.Errorf("unexpected sell %+v", sell)
+	}
+	if len(InYear(disposals, 2021)) != 1 {
+		t.Errorf("expected a disposal in 2021")
+	}
+
+	ledger.DepositPrice = func(currency string, t time.Time) (float64, error) {
+		return 250000, nil
+	}
+	disposals, _ = ledger.Disposals()
+	if sell := disposals[1]; sell.Cost != 226000 || sell.UnknownCost {
+		t.Errorf("expected the deposit valued, got %+v", sell)
+	}
+}
+
+func TestReports(t *testing.T) {
+	disposals, err := newLedger(t).Disposals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sii bytes.Buffer
+	if err := WriteSII(&sii, InYear(disposals, 2021)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "fecha;operacion;activo;cantidad;fecha_adquisicion;monto_enajenacion;costo_tributario;comision;mayor_valor;costo_desconocido\n" +
+		"01-06-2021;venta;ETH;1,5;10-01-2020;450000;101000;0;349000;si\n"
+	if sii.String() != expected {
+		t.Errorf("unexpected SII report:\n%s", sii.String())
+	}
+	var form bytes.Buffer
+	if err := Write8949(&form, disposals); err != nil {
+		t.Fatal(err)
+	}
+	expected = "description,date_acquired,date_sold,proceeds,cost_basis,code,adjustment,gain_or_loss,term\n" +
+		"0.01 ETH,01/10/2020,05/01/2020,0,1000,,,-1000,short\n" +
+		"1.5 ETH,VARIOUS,06/01/2021,450000,101000,,,349000,long\n"
+	if form.String() != expected {
+		t.Errorf("unexpected 8949 report:\n%s", form.String())
+	}
+}
+
+func TestOtherQuote(t *testing.T) {
+	ledger := NewLedger("CLP", pnl.FIFO)
+	o := order("B1", "buy", "100", "1", "2020-01-10")
+	o.Market = "ETHARS"
+	if err := ledger.AddOrders(o); err == nil {
+		t.Errorf("an order quoted in ARS should be refused by a ledger in CLP")
+	}
+}